@@ -0,0 +1,413 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/lxd/shared"
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/canonical/lxd/shared/revert"
+	"github.com/canonical/lxd/shared/trust"
+	"github.com/canonical/microcluster/v2/rest"
+	"github.com/canonical/microcluster/v2/state"
+	"github.com/gorilla/mux"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+	cloudClient "github.com/canonical/microcloud/microcloud/client"
+	"github.com/canonical/microcloud/microcloud/database"
+	"github.com/canonical/microcloud/microcloud/multicast"
+	"github.com/canonical/microcloud/microcloud/service"
+)
+
+// JoinTokenTimeout is the maximum duration of joining a system after it redeemed its join token.
+const JoinTokenTimeout = 15 * time.Minute
+
+// JoinTokensCmd represents the /1.0/join-tokens API on MicroCloud.
+var JoinTokensCmd = func(sh *service.Handler) rest.Endpoint {
+	return rest.Endpoint{
+		Name: "join-tokens",
+		Path: "join-tokens",
+
+		Post: rest.EndpointAction{Handler: authHandlerMTLS(sh, joinTokensPost(sh))},
+	}
+}
+
+// JoinTokenCmd represents the /1.0/join-tokens/{id} API on MicroCloud.
+var JoinTokenCmd = func(sh *service.Handler) rest.Endpoint {
+	return rest.Endpoint{
+		Name: "join-tokens/{id}",
+		Path: "join-tokens/{id}",
+
+		Get:  rest.EndpointAction{Handler: joinTokenGet(sh), AllowUntrusted: true},
+		Post: rest.EndpointAction{Handler: joinTokenPost(sh), AllowUntrusted: true},
+	}
+}
+
+// joinTokensPost issues a new out-of-band join token and returns it in its encoded form.
+func joinTokensPost(sh *service.Handler) func(state state.State, r *http.Request) response.Response {
+	return func(state state.State, r *http.Request) response.Response {
+		req := types.JoinTokensPost{}
+
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			return response.BadRequest(err)
+		}
+
+		// The joining system verifies the certificate presented on our address against the fingerprint.
+		token, err := service.NewJoinToken(sh.Address(), state.ClusterCert().Fingerprint(), req.Expiry)
+		if err != nil {
+			return response.BadRequest(err)
+		}
+
+		err = state.Database().Transaction(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+			// Clean up the tokens which cannot be redeemed anymore.
+			err := database.DeleteExpiredJoinTokens(ctx, tx, time.Now())
+			if err != nil {
+				return err
+			}
+
+			return database.CreateJoinToken(ctx, tx, database.JoinToken{TokenID: token.ID, Secret: token.Secret, ExpiresAt: token.ExpiresAt})
+		})
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		return response.SyncResponse(true, token.String())
+	}
+}
+
+// authenticateJoinToken returns the join token with the ID of the given request
+// if the request is authenticated using the token's secret.
+// Failed attempts are tracked per source address and block the source from redeeming any join token for a while.
+func authenticateJoinToken(state state.State, sh *service.Handler, r *http.Request) (*database.JoinToken, error) {
+	// Apply delay right at the beginning before doing any validation.
+	// This limits the number of join attempts that can be made by an attacker.
+	select {
+	case <-time.After(100 * time.Millisecond):
+	case <-r.Context().Done():
+		return nil, errors.New("Request cancelled")
+	}
+
+	tokenID, err := url.PathUnescape(mux.Vars(r)["id"])
+	if err != nil {
+		return nil, err
+	}
+
+	source, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		source = r.RemoteAddr
+	}
+
+	// Reject blocked source addresses before spending any effort on deriving the HMAC.
+	err = sh.TokenAttempts.CheckSource(source)
+	if err != nil {
+		return nil, api.NewStatusError(http.StatusTooManyRequests, err.Error())
+	}
+
+	var token *database.JoinToken
+	err = state.Database().Transaction(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		token, err = database.GetJoinToken(ctx, tx, tokenID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(token.ExpiresAt) {
+		return nil, api.NewStatusError(http.StatusForbidden, "Join token expired")
+	}
+
+	h, err := service.NewSessionHMAC(token.Secret, HMACMicroCloud10, types.SessionAuth{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = trust.HMACEqual(h, r)
+	if err != nil {
+		// Only the ban is recorded instead of each failed attempt so that unauthenticated requests cannot flood the history.
+		// It's persisted in the background to not let the request wait for the database.
+		banned := sh.TokenAttempts.RegisterFailedAttempt(source)
+		if banned {
+			details := fmt.Sprintf("Banned for %s after %d failed attempts", service.FailedAttemptBan, service.AllowedFailedSourceAttempts)
+			go recordTokenEvent(state, tokenID, types.SessionEventAddressBanned, types.SessionIntent{Address: source}, details)
+		}
+
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// joinTokenGet returns the redemption state of a join token to the system redeeming it.
+// The request has to be authenticated using the token's secret.
+func joinTokenGet(sh *service.Handler) func(state state.State, r *http.Request) response.Response {
+	return func(state state.State, r *http.Request) response.Response {
+		token, err := authenticateJoinToken(state, sh, r)
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		return response.SyncResponse(true, types.JoinTokenStatus{
+			Pending: !token.RedeemedAt.IsZero() && time.Since(token.RedeemedAt) < JoinTokenTimeout,
+			Error:   token.Error,
+		})
+	}
+}
+
+// joinTokenPost receives the join intent of a system redeeming a join token.
+// The intent has to be authenticated using the token's secret.
+// Once the token is redeemed, the system joins the cluster in the background.
+// The token stays pending until the system joined and can be redeemed again if joining fails.
+// The system redeeming the token learns about the failure using joinTokenGet.
+func joinTokenPost(sh *service.Handler) func(state state.State, r *http.Request) response.Response {
+	return func(state state.State, r *http.Request) response.Response {
+		// Systems joining during a session could interfere with the ones added by the session.
+		if sh.ActiveSession() {
+			return response.SmartError(api.StatusErrorf(http.StatusConflict, "Cannot redeem join token during a trust establishment session"))
+		}
+
+		token, err := authenticateJoinToken(state, sh, r)
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		tokenID := token.TokenID
+
+		req := types.SessionJoinPost{}
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			return response.BadRequest(err)
+		}
+
+		fingerprint, err := shared.CertFingerprintStr(req.Certificate)
+		if err != nil {
+			return response.BadRequest(fmt.Errorf("Failed to get fingerprint: %w", err))
+		}
+
+		system := types.SessionIntent{Name: req.Name, Address: req.Address, Fingerprint: fingerprint}
+		err = validateIntent(r.Context(), sh, req)
+		if err != nil {
+			recordTokenEvent(state, tokenID, types.SessionEventIntentRejected, system, err.Error())
+			return response.BadRequest(err)
+		}
+
+		cloud := sh.Services()[types.MicroCloud].(*service.CloudService)
+		members, err := cloud.ClusterMembers(r.Context())
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		_, ok := members[req.Name]
+		if ok {
+			err = fmt.Errorf("System %q is already a cluster member", req.Name)
+			recordTokenEvent(state, tokenID, types.SessionEventIntentRejected, system, err.Error())
+			return response.BadRequest(err)
+		}
+
+		// Each token can only be redeemed by a single system at a time, even if the same token is redeemed concurrently.
+		// Redemptions which didn't end within the join timeout are considered stale, e.g. if the daemon got restarted.
+		now := time.Now()
+		err = state.Database().Transaction(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+			return database.RedeemJoinToken(ctx, tx, tokenID, now, now.Add(-JoinTokenTimeout))
+		})
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		recordTokenEvent(state, tokenID, types.SessionEventIntentReceived, system, "")
+
+		go func() {
+			joinErr := joinWithToken(state, sh, req, token.Secret)
+
+			err := state.Database().Transaction(context.Background(), func(ctx context.Context, tx *sql.Tx) error {
+				if joinErr != nil {
+					return database.ReleaseJoinToken(ctx, tx, tokenID, joinErr.Error())
+				}
+
+				return database.DeleteJoinToken(ctx, tx, tokenID)
+			})
+			if err != nil {
+				logger.Error("Failed to update join token after its redemption", logger.Ctx{"name": req.Name, "err": err})
+			}
+
+			if joinErr != nil {
+				logger.Error("Failed to join system using join token", logger.Ctx{"name": req.Name, "address": req.Address, "err": joinErr})
+				recordTokenEvent(state, tokenID, types.SessionEventStopped, system, joinErr.Error())
+				return
+			}
+
+			logger.Info("System joined using join token", logger.Ctx{"name": req.Name, "address": req.Address})
+			recordTokenEvent(state, tokenID, types.SessionEventJoinCompleted, system, "")
+		}()
+
+		return response.EmptySyncResponse
+	}
+}
+
+// joinWithToken confirms the join intent of the system which redeemed a join token
+// and lets it join the cluster of each of our services.
+// Storage and network configuration which cannot be derived from the cluster isn't applied to the joining system.
+func joinWithToken(state state.State, sh *service.Handler, intent types.SessionJoinPost, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), JoinTokenTimeout)
+	defer cancel()
+
+	reverter := revert.New()
+	defer reverter.Fail()
+
+	remoteCert, err := shared.ParseCert([]byte(intent.Certificate))
+	if err != nil {
+		return fmt.Errorf("Failed to parse certificate of join intent: %w", err)
+	}
+
+	cloud := sh.Services()[types.MicroCloud].(*service.CloudService)
+	cert, err := cloud.ServerCert()
+	if err != nil {
+		return fmt.Errorf("Failed to get certificate of %q: %w", types.MicroCloud, err)
+	}
+
+	services := make(map[types.ServiceType]string, len(sh.Services()))
+	for _, s := range sh.Services() {
+		version, err := s.GetVersion(ctx)
+		if err != nil {
+			return err
+		}
+
+		services[s.Type()] = version
+	}
+
+	joinIntent := types.SessionJoinPost{
+		Version:     multicast.Version,
+		Name:        state.Name(),
+		Address:     sh.Address(),
+		Certificate: string(cert.PublicKey()),
+		Services:    services,
+	}
+
+	h, err := service.NewSessionHMAC(secret, HMACMicroCloud10, types.SessionAuth{})
+	if err != nil {
+		return fmt.Errorf("Failed to create a new HMAC instance using argon2: %w", err)
+	}
+
+	header, err := trust.HMACAuthorizationHeader(h, joinIntent)
+	if err != nil {
+		return fmt.Errorf("Failed to create HMAC for join intent: %w", err)
+	}
+
+	conf := cloudClient.AuthConfig{
+		HMAC: header,
+		// We already know the certificate of the joiner for TLS verification.
+		TLSServerCertificate: remoteCert,
+	}
+
+	// The joiner only accepts our confirmation once it waits for it after its token got redeemed.
+	confirmCtx, cancelConfirm := context.WithTimeout(ctx, time.Minute)
+	defer cancelConfirm()
+
+	for {
+		_, err = cloud.RequestJoinIntent(confirmCtx, intent.Address, conf, joinIntent)
+		if err == nil {
+			break
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-confirmCtx.Done():
+			return fmt.Errorf("Failed to confirm join intent of %q: %w", intent.Address, err)
+		}
+	}
+
+	// First let the joiner join the MicroCloud cluster so that the tokens of the other services can be sent using mTLS.
+	token, err := cloud.IssueToken(ctx, intent.Name)
+	if err != nil {
+		return fmt.Errorf("Failed to issue %s token for peer %q: %w", types.MicroCloud, intent.Name, err)
+	}
+
+	err = cloud.RequestJoin(ctx, intent.Name, remoteCert, types.ServicesPut{
+		Tokens:  []types.ServiceToken{{Service: types.MicroCloud, JoinToken: token}},
+		Address: intent.Address,
+	})
+	if err != nil {
+		return fmt.Errorf("System %q failed to join the %s cluster: %w", intent.Name, types.MicroCloud, err)
+	}
+
+	err = waitForMember(ctx, cloud, intent.Name)
+	if err != nil {
+		return err
+	}
+
+	cfg := types.ServicesPut{Address: intent.Address}
+	for _, s := range sh.Services() {
+		if s.Type() == types.MicroCloud {
+			continue
+		}
+
+		token, err := s.IssueToken(ctx, intent.Name)
+		if err != nil {
+			return fmt.Errorf("Failed to issue %s token for peer %q: %w", s.Type(), intent.Name, err)
+		}
+
+		reverter.Add(func() {
+			err := s.DeleteToken(context.Background(), intent.Name, "")
+			if err != nil {
+				logger.Error("Failed to clean up join token", logger.Ctx{"service": s.Type(), "error": err})
+			}
+		})
+
+		if s.Type() == types.LXD {
+			cfg.LXDConfig, err = s.(*service.LXDService).TokenJoinConfig(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		cfg.Tokens = append(cfg.Tokens, types.ServiceToken{Service: s.Type(), JoinToken: token})
+	}
+
+	if len(cfg.Tokens) == 0 {
+		reverter.Success()
+		return nil
+	}
+
+	// The joiner is now part of the MicroCloud cluster so it presents the cluster certificate.
+	err = cloud.RequestJoin(ctx, intent.Name, nil, cfg)
+	if err != nil {
+		return fmt.Errorf("System %q failed to join the cluster: %w", intent.Name, err)
+	}
+
+	reverter.Success()
+
+	return nil
+}
+
+// waitForMember waits until the system with the given name appears in the MicroCloud cluster.
+func waitForMember(ctx context.Context, cloud *service.CloudService, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	for {
+		members, err := cloud.ClusterMembers(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, ok := members[name]
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return fmt.Errorf("Timed out waiting for cluster member %q to appear", name)
+		}
+	}
+}
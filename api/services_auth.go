@@ -3,10 +3,12 @@ package api
 import (
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 
 	"github.com/canonical/lxd/lxd/response"
 	"github.com/canonical/lxd/lxd/util"
+	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/lxd/shared/logger"
 	"github.com/canonical/lxd/shared/trust"
 	"github.com/canonical/microcluster/v2/state"
@@ -52,15 +54,27 @@ func authHandlerMTLS(sh *service.Handler, f endpointHandler) endpointHandler {
 // authHandlerHMAC ensures a request has been authenticated using the HMAC in the Authorization header.
 func authHandlerHMAC(sh *service.Handler, f endpointHandler) endpointHandler {
 	return func(s state.State, r *http.Request) response.Response {
+		// Failed attempts are tracked per source address so that a single system cannot stop the session for everyone.
+		source, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			source = r.RemoteAddr
+		}
+
 		sessionFunc := func(session *service.Session) error {
-			h, err := trust.NewHMACArgon2([]byte(session.Passphrase()), nil, trust.NewDefaultHMACConf(HMACMicroCloud10))
+			// Reject blocked source addresses before spending any effort on deriving the HMAC.
+			err := session.CheckSource(source)
+			if err != nil {
+				return api.NewStatusError(http.StatusTooManyRequests, err.Error())
+			}
+
+			h, err := service.NewSessionHMAC(session.Passphrase(), HMACMicroCloud10, session.Auth())
 			if err != nil {
 				return err
 			}
 
 			err = trust.HMACEqual(h, r)
 			if err != nil {
-				attemptErr := session.RegisterFailedAttempt()
+				attemptErr := session.RegisterFailedAttempt(source)
 				if attemptErr != nil {
 					errorCause := errors.New("Stopping session after too many failed attempts")
 
@@ -82,7 +96,7 @@ func authHandlerHMAC(sh *service.Handler, f endpointHandler) endpointHandler {
 		}
 
 		// Run a r/w transaction against the session as we might stop it due to too many failed attempts.
-		err := sh.SessionTransaction(false, sessionFunc)
+		err = sh.SessionTransaction(false, sessionFunc)
 		if err != nil {
 			return response.SmartError(err)
 		}
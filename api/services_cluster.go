@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -38,16 +39,10 @@ func removeClusterMember(state state.State, r *http.Request) response.Response {
 		return response.BadRequest(err)
 	}
 
-	supportedServices := map[types.ServiceType]string{
-		types.MicroOVN:  MicroOVNDir,
-		types.MicroCeph: MicroCephDir,
-		types.LXD:       LXDDir,
-	}
-
-	existingServices := []types.ServiceType{types.MicroCloud}
-	for serviceType, stateDir := range supportedServices {
-		if service.Exists(serviceType, stateDir) {
-			existingServices = append(existingServices, serviceType)
+	existingServices := []types.ServiceType{}
+	for _, def := range service.Definitions() {
+		if def.Installed() {
+			existingServices = append(existingServices, def.Type)
 		}
 	}
 
@@ -61,7 +56,7 @@ func removeClusterMember(state state.State, r *http.Request) response.Response {
 		return response.SmartError(err)
 	}
 
-	ceph := sh.Services[types.MicroCeph]
+	ceph := sh.Services()[types.MicroCeph]
 	if ceph != nil {
 		// If we got a 503 error back, that means the service is installed, but hasn't been set up yet, so there are no cluster members to remove.
 		cluster, err := ceph.ClusterMembers(r.Context())
@@ -90,13 +85,13 @@ func removeClusterMember(state state.State, r *http.Request) response.Response {
 		}
 	}
 
-	// Remove the node from services in the following order:
+	// Remove the node from services in the reverse order they are set up in:
 	// 1. Remove from LXD first as it may have storage & networks that depend on the others for cleanup.
 	// 2. Remove from MicroCeph and MicroOVN next, concurrently.
 	// 3. Remove from MicroCloud last so that if there were any errors causing the other services to fail, MicroCloud will still know about the node.
 	var memberExists bool
-	err = sh.RunConcurrent(types.LXD, types.MicroCloud, func(s service.Service) error {
-		existingMembers, err := s.ClusterMembers(r.Context())
+	err = sh.RunOrdered(r.Context(), true, func(ctx context.Context, s service.Service) error {
+		existingMembers, err := s.ClusterMembers(ctx)
 		if err != nil && !api.StatusErrorCheck(err, http.StatusServiceUnavailable) {
 			return err
 		}
@@ -123,7 +118,7 @@ func removeClusterMember(state state.State, r *http.Request) response.Response {
 				return err
 			}
 
-			disks, err := cephClient.GetDisks(r.Context(), c)
+			disks, err := cephClient.GetDisks(ctx, c)
 			if err != nil {
 				return err
 			}
@@ -135,7 +130,7 @@ func removeClusterMember(state state.State, r *http.Request) response.Response {
 				}
 			}
 
-			pools, err := cephClient.GetPools(r.Context(), c)
+			pools, err := cephClient.GetPools(ctx, c)
 			if err != nil {
 				return err
 			}
@@ -152,13 +147,13 @@ func removeClusterMember(state state.State, r *http.Request) response.Response {
 				poolsToUpdate = []string{""}
 			}
 
-			err = cephClient.PoolSetReplicationFactor(r.Context(), c, &cephTypes.PoolPut{Pools: poolsToUpdate, Size: int64(diskCount)})
+			err = cephClient.PoolSetReplicationFactor(ctx, c, &cephTypes.PoolPut{Pools: poolsToUpdate, Size: int64(diskCount)})
 			if err != nil {
 				return err
 			}
 		}
 
-		return s.DeleteClusterMember(r.Context(), name, force)
+		return s.DeleteClusterMember(ctx, name, force)
 	})
 	if err != nil {
 		return response.SmartError(err)
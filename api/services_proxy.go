@@ -24,23 +24,14 @@ func LXDProxy(sh *service.Handler) rest.Endpoint {
 
 // CephProxy proxies all requests from MicroCloud to MicroCeph.
 func CephProxy(sh *service.Handler) rest.Endpoint {
-	return proxy(sh, "microceph", "services/microceph/{rest:.*}", microHandler("microceph", MicroCephDir))
+	return proxy(sh, "microceph", "services/microceph/{rest:.*}", microHandler("microceph", service.MicroCephDir))
 }
 
 // OVNProxy proxies all requests from MicroCloud to MicroOVN.
 func OVNProxy(sh *service.Handler) rest.Endpoint {
-	return proxy(sh, "microovn", "services/microovn/{rest:.*}", microHandler("microovn", MicroOVNDir))
+	return proxy(sh, "microovn", "services/microovn/{rest:.*}", microHandler("microovn", service.MicroOVNDir))
 }
 
-// LXDDir is the path to the state directory of the LXD snap.
-const LXDDir = "/var/snap/lxd/common/lxd"
-
-// MicroCephDir is the path to the state directory of the MicroCeph snap.
-const MicroCephDir = "/var/snap/microceph/common/state"
-
-// MicroOVNDir is the path to the state directory of the MicroOVN snap.
-const MicroOVNDir = "/var/snap/microovn/common/state"
-
 // proxy returns a proxy endpoint with the given handler and access applied to all REST methods.
 func proxy(sh *service.Handler, name, path string, handler endpointHandler) rest.Endpoint {
 	return rest.Endpoint{
@@ -63,7 +54,7 @@ func lxdHandler(s state.State, r *http.Request) response.Response {
 		return response.SmartError(fmt.Errorf("Invalid path %q", r.URL.Path))
 	}
 
-	unixPath := filepath.Join(LXDDir, "unix.socket")
+	unixPath := filepath.Join(service.LXDDir, "unix.socket")
 	_, err := os.Stat(unixPath)
 	if err != nil {
 		return response.NotFound(fmt.Errorf("Failed to find LXD unix socket %q: %w", unixPath, err))
@@ -103,7 +94,7 @@ func lxdHandler(s state.State, r *http.Request) response.Response {
 	r.URL.Scheme = "http"
 	r.URL.Host = "unix.socket"
 	r.Host = r.URL.Host
-	client, err := lxd.ConnectLXDUnix(filepath.Join(LXDDir, "unix.socket"), nil)
+	client, err := lxd.ConnectLXDUnix(filepath.Join(service.LXDDir, "unix.socket"), nil)
 	if err != nil {
 		return response.SmartError(fmt.Errorf("Failed to connect to local LXD: %w", err))
 	}
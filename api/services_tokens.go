@@ -48,7 +48,7 @@ func serviceTokensPost(s state.State, r *http.Request) response.Response {
 		return response.SmartError(err)
 	}
 
-	token, err := sh.Services[types.ServiceType(serviceType)].IssueToken(r.Context(), req.JoinerName)
+	token, err := sh.Services()[types.ServiceType(serviceType)].IssueToken(r.Context(), req.JoinerName)
 	if err != nil {
 		return response.SmartError(fmt.Errorf("Failed to issue %s token for peer %q: %w", serviceType, req.JoinerName, err))
 	}
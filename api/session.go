@@ -3,16 +3,17 @@ package api
 import (
 	"context"
 	"crypto/x509"
-	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/canonical/lxd/lxd/response"
 	"github.com/canonical/lxd/shared"
+	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/lxd/shared/logger"
 	"github.com/canonical/lxd/shared/trust"
 	"github.com/canonical/lxd/shared/ws"
@@ -54,8 +55,13 @@ var SessionJoiningCmd = func(sh *service.Handler) rest.Endpoint {
 // sessionGet returns a MicroCloud join session.
 func sessionGet(sh *service.Handler, sessionRole types.SessionRole) func(state state.State, r *http.Request) response.Response {
 	return func(state state.State, r *http.Request) response.Response {
+		resumeID := r.URL.Query().Get("resume")
+		if resumeID != "" {
+			return sessionResume(sh, sessionRole, resumeID, r)
+		}
+
 		if sh.ActiveSession() {
-			return response.BadRequest(errors.New("There already is an active session"))
+			return response.SmartError(activeSessionError(sh))
 		}
 
 		sessionTimeoutStr := r.URL.Query().Get("timeout")
@@ -92,7 +98,8 @@ func sessionGet(sh *service.Handler, sessionRole types.SessionRole) func(state s
 			sessionCtx, cancel := context.WithTimeoutCause(r.Context(), sessionTimeout, errors.New("Session timeout exceeded"))
 			defer cancel()
 
-			gw := cloudClient.NewWebsocketGateway(sessionCtx, conn)
+			// The session survives losing the client's connection so that it can be resumed.
+			gw := cloudClient.NewResumableWebsocketGateway(sessionCtx, conn, service.SessionResumeGracePeriod)
 
 			if sessionRole == types.SessionInitiating {
 				err = handleInitiatingSession(state, sh, gw)
@@ -116,7 +123,108 @@ func sessionGet(sh *service.Handler, sessionRole types.SessionRole) func(state s
 	}
 }
 
-func confirmedIntents(sh *service.Handler, gw *cloudClient.WebsocketGateway) ([]types.SessionJoinPost, error) {
+// activeSessionError returns the error reported when starting a session while another one is active.
+// It tells how to resume the active session if its client got disconnected, and how to abort it.
+func activeSessionError(sh *service.Handler) error {
+	var detached bool
+	var id string
+	err := sh.SessionTransaction(true, func(session *service.Session) error {
+		detached = session.Detached()
+		id = session.ID()
+
+		return nil
+	})
+	if err != nil {
+		// The session ended in the meantime.
+		return api.StatusErrorf(http.StatusConflict, "There already is an active session")
+	}
+
+	if detached {
+		return api.StatusErrorf(http.StatusBadRequest, "There already is an active session whose client got disconnected. Resume it within %s using --resume %s or abort it using \"microcloud session abort\"", service.SessionResumeGracePeriod, id)
+	}
+
+	return api.StatusErrorf(http.StatusBadRequest, "There already is an active session. Abort it using \"microcloud session abort\"")
+}
+
+// sessionResume attaches the websocket connection of the given request to the active session
+// with the given ID and role whose client got disconnected.
+func sessionResume(sh *service.Handler, sessionRole types.SessionRole, id string, r *http.Request) response.Response {
+	err := sh.SessionTransaction(true, func(session *service.Session) error {
+		if session.ID() != id {
+			return api.StatusErrorf(http.StatusNotFound, "Session %q not found", id)
+		}
+
+		if session.Role() != sessionRole {
+			return api.StatusErrorf(http.StatusBadRequest, "Session %q has role %q", id, session.Role())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.ManualResponse(func(w http.ResponseWriter) error {
+		conn, err := ws.Upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return err
+		}
+
+		// The connection is owned by the session's websocket gateway once attached.
+		err = sh.SessionTransaction(true, func(session *service.Session) error {
+			if session.ID() != id {
+				return fmt.Errorf("Session %q not found", id)
+			}
+
+			return session.Resume(conn)
+		})
+		if err != nil {
+			// Errors after the upgrade have to be handled within the websocket.
+			gw := cloudClient.NewWebsocketGateway(r.Context(), conn)
+			controlErr := gw.WriteClose(fmt.Errorf("Failed to resume session: %w", err))
+			if controlErr != nil {
+				logger.Error("Failed to write close control message", logger.Ctx{"err": controlErr, "controlErr": err})
+			}
+
+			_ = conn.Close()
+		}
+
+		return nil
+	})
+}
+
+// confirmedIntents forwards the join intents to the client and returns the ones confirmed by the client.
+// If the session only accepts approved joiners, the intents are confirmed automatically once every approved joiner
+// reached out or the given lookup timeout passed, and the client is notified about the confirmed intents.
+// Until then the client is notified whenever one of the systems leaves the session or returns to it.
+func confirmedIntents(sh *service.Handler, gw *cloudClient.WebsocketGateway, lookupTimeout time.Duration) ([]types.SessionJoinPost, error) {
+	approvedJoiners := sh.Session.ApprovedJoiners()
+	approvedIntents := []types.SessionJoinPost{}
+
+	watchCtx, cancelWatch := context.WithCancel(gw.Context())
+	defer cancelWatch()
+
+	var timeout <-chan time.Time
+	if len(approvedJoiners) > 0 && lookupTimeout > 0 {
+		timeout = time.After(lookupTimeout)
+	}
+
+	confirmApproved := func() ([]types.SessionJoinPost, error) {
+		sh.Session.RecordProgress(func(progress *types.SessionState) {
+			progress.IntentsConfirmed = true
+			progress.ConfirmedIntents = approvedIntents
+		})
+
+		err := gw.WriteMessage(types.SessionMessageConfirmedIntents, types.SessionConfirmedIntents{
+			Intents: approvedIntents,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to send approved join intents: %w", err)
+		}
+
+		return approvedIntents, nil
+	}
+
 	for {
 		select {
 		case intent, ok := <-sh.Session.IntentCh():
@@ -125,60 +233,173 @@ func confirmedIntents(sh *service.Handler, gw *cloudClient.WebsocketGateway) ([]
 				continue
 			}
 
-			err := gw.Write(types.Session{
-				Intent: intent,
+			capabilities := intentCapabilities(gw.Context(), sh, intent)
+			err := sh.Session.SendMessage(types.SessionMessageIntent, types.SessionJoinIntent{
+				Intent:       intent,
+				Capabilities: capabilities,
 			})
 			if err != nil {
 				return nil, fmt.Errorf("Failed to forward join intent: %w", err)
 			}
 
+			// Systems which don't serve their capabilities cannot be probed.
+			if capabilities != nil {
+				watchJoiner(watchCtx, sh, intent)
+			}
+
+			// Only approved joiners can send their intent, so they can be confirmed right away.
+			if len(approvedJoiners) > 0 {
+				approvedIntents = append(approvedIntents, intent)
+				if len(approvedIntents) >= len(approvedJoiners) {
+					return confirmApproved()
+				}
+			}
+
+		case <-timeout:
+			if len(approvedIntents) == 0 {
+				return nil, errors.New("None of the approved systems reached out")
+			}
+
+			return confirmApproved()
+
 		case bytes := <-gw.Receive():
-			var session types.Session
-			err := json.Unmarshal(bytes, &session)
+			confirmed := types.SessionConfirmedIntents{}
+			err := gw.ParsePayload(bytes, types.SessionMessageConfirmedIntents, &confirmed)
 			if err != nil {
 				return nil, fmt.Errorf("Failed to read confirmed intents: %w", err)
 			}
 
-			return session.ConfirmedIntents, nil
+			sh.Session.RecordProgress(func(progress *types.SessionState) {
+				progress.IntentsConfirmed = true
+				progress.ConfirmedIntents = confirmed.Intents
+			})
+
+			return confirmed.Intents, nil
 		case <-gw.Context().Done():
 			return nil, fmt.Errorf("Exit waiting for intents: %w", context.Cause(gw.Context()))
 		}
 	}
 }
 
-func handleInitiatingSession(state state.State, sh *service.Handler, gw *cloudClient.WebsocketGateway) error {
+// intentCapabilities returns the capabilities of the system which sent the given join intent.
+// As the capabilities are only displayed to the user, nil is returned if they cannot be retrieved.
+func intentCapabilities(ctx context.Context, sh *service.Handler, intent types.SessionJoinPost) *types.Capabilities {
+	cert, err := shared.ParseCert([]byte(intent.Certificate))
+	if err != nil {
+		logger.Warn("Failed to parse certificate of join intent", logger.Ctx{"name": intent.Name, "err": err})
+		return nil
+	}
+
+	cloud := sh.Services()[types.MicroCloud].(*service.CloudService)
+	capabilities, err := cloud.RemoteCapabilities(ctx, cert, intent.Address, sh.Session.Passphrase(), sh.Session.Auth())
+	if err != nil {
+		logger.Warn("Failed to get capabilities of join intent", logger.Ctx{"name": intent.Name, "address": intent.Address, "err": err})
+		return nil
+	}
+
+	return capabilities
+}
+
+// watchJoiner probes the system which sent the given join intent until the context is cancelled
+// and notifies the client whenever it leaves the session or returns to it.
+func watchJoiner(ctx context.Context, sh *service.Handler, intent types.SessionJoinPost) {
+	cert, err := shared.ParseCert([]byte(intent.Certificate))
+	if err != nil {
+		logger.Warn("Failed to parse certificate of join intent", logger.Ctx{"name": intent.Name, "err": err})
+		return
+	}
+
+	cloud := sh.Services()[types.MicroCloud].(*service.CloudService)
+	changes := service.WatchPeer(ctx, sh.Session.Keepalive(), func(ctx context.Context) error {
+		return cloud.ProbeSession(ctx, cert, intent.Address)
+	})
+
+	go func() {
+		for alive := range changes {
+			msgType := types.SessionMessagePeerReturned
+			if !alive {
+				logger.Warn("Joining system stopped responding", logger.Ctx{"name": intent.Name, "address": intent.Address})
+				msgType = types.SessionMessagePeerLeft
+			}
+
+			err := sh.Session.SendMessage(msgType, types.SessionPeer{Name: intent.Name, Address: intent.Address})
+			if err != nil {
+				logger.Error("Failed to report liveness of joining system", logger.Ctx{"name": intent.Name, "address": intent.Address, "err": err})
+			}
+		}
+	}()
+}
+
+func handleInitiatingSession(state state.State, sh *service.Handler, gw *cloudClient.WebsocketGateway) (err error) {
 	session := types.Session{}
-	err := gw.ReceiveWithContext(gw.Context(), &session)
+	err = gw.ReceivePayload(gw.Context(), types.SessionMessageStart, &session)
 	if err != nil {
 		return fmt.Errorf("Failed to read session start message: %w", err)
 	}
 
-	err = sh.StartSession(types.SessionInitiating, session.Passphrase, gw)
+	for _, joiner := range session.ApprovedJoiners {
+		err = cloudClient.ValidateFingerprint(joiner.Fingerprint)
+		if err != nil {
+			return fmt.Errorf("Invalid fingerprint of approved system: %w", err)
+		}
+	}
+
+	err = service.ValidateSessionKeepalive(session.Keepalive)
+	if err != nil {
+		return fmt.Errorf("Invalid keepalive settings: %w", err)
+	}
+
+	err = sh.StartSession(types.SessionInitiating, session.Passphrase, session.Auth, gw)
 	if err != nil {
 		return fmt.Errorf("Failed to start session: %w", err)
 	}
 
+	sh.Session.SetApprovedJoiners(session.ApprovedJoiners)
+
 	defer func() {
+		// Record why the session ended before its state gets reset.
+		sh.Session.RecordStop(err)
+
 		err := sh.StopSession(nil)
 		if err != nil {
 			logger.Error("Failed to stop session", logger.Ctx{"err": err})
 		}
 	}()
 
+	err = sh.Session.SetKeepalive(session.Keepalive)
+	if err != nil {
+		return err
+	}
+
 	sessionPassphrase := sh.Session.Passphrase()
-	err = gw.Write(types.Session{
+	sessionAuth := sh.Session.Auth()
+	err = gw.WriteMessage(types.SessionMessageDetails, types.SessionDetails{
+		SessionID:  sh.Session.ID(),
 		Passphrase: sessionPassphrase,
+		Auth:       sessionAuth,
 	})
 	if err != nil {
 		return fmt.Errorf("Failed to send session details: %w", err)
 	}
 
-	err = sh.Session.MulticastDiscovery(state.Name(), session.Address, session.Interface)
+	allowedSubnets, err := sessionSubnets(session)
+	if err != nil {
+		return err
+	}
+
+	err = sh.Session.MulticastDiscovery(state.Name(), session.Address, sessionInterfaces(session), multicast.BackendType(session.LookupBackend), allowedSubnets)
 	if err != nil {
 		return fmt.Errorf("Failed to start multicast discovery: %w", err)
 	}
 
-	confirmedIntents, err := confirmedIntents(sh, gw)
+	if len(session.LookupSeeds) > 0 {
+		err = sh.Session.SeedDiscovery(state.Name(), session.Address, session.LookupSeeds)
+		if err != nil {
+			return fmt.Errorf("Failed to start seed discovery: %w", err)
+		}
+	}
+
+	confirmedIntents, err := confirmedIntents(sh, gw, session.LookupTimeout)
 	if err != nil {
 		return fmt.Errorf("Failed waiting for the confirmed intents: %w", err)
 	}
@@ -194,8 +415,9 @@ func handleInitiatingSession(state state.State, sh *service.Handler, gw *cloudCl
 
 		// Add system to temporary truststore.
 		sh.Session.Allow(intent.Name, *remoteCert)
+		sh.Session.RecordEvent(types.SessionEventIntentConfirmed, types.SessionIntent{Name: intent.Name, Address: intent.Address, Fingerprint: shared.CertFingerprint(remoteCert)}, "")
 
-		cloud := sh.Services[types.MicroCloud].(*service.CloudService)
+		cloud := sh.Services()[types.MicroCloud].(*service.CloudService)
 		cert, err := cloud.ServerCert()
 		if err != nil {
 			return fmt.Errorf("Failed to get certificate of %q: %w", types.MicroCloud, err)
@@ -209,7 +431,7 @@ func handleInitiatingSession(state state.State, sh *service.Handler, gw *cloudCl
 			Services:    session.Services,
 		}
 
-		h, err := trust.NewHMACArgon2([]byte(sessionPassphrase), nil, trust.NewDefaultHMACConf(HMACMicroCloud10))
+		h, err := service.NewSessionHMAC(sessionPassphrase, HMACMicroCloud10, sessionAuth)
 		if err != nil {
 			return fmt.Errorf("Failed to create a new HMAC instance using argon2: %w", err)
 		}
@@ -242,9 +464,11 @@ func handleInitiatingSession(state state.State, sh *service.Handler, gw *cloudCl
 		return fmt.Errorf("Failed to confirm join intents: %w", err)
 	}
 
-	err = gw.Write(types.Session{
-		Accepted: true,
+	sh.Session.RecordProgress(func(progress *types.SessionState) {
+		progress.Accepted = true
 	})
+
+	err = gw.WriteMessage(types.SessionMessageAccepted, nil)
 	if err != nil {
 		return fmt.Errorf("Failed to send confirmation: %w", err)
 	}
@@ -252,41 +476,91 @@ func handleInitiatingSession(state state.State, sh *service.Handler, gw *cloudCl
 	return nil
 }
 
-func handleJoiningSession(state state.State, sh *service.Handler, gw *cloudClient.WebsocketGateway) error {
+func handleJoiningSession(state state.State, sh *service.Handler, gw *cloudClient.WebsocketGateway) (err error) {
 	session := types.Session{}
-	err := gw.ReceiveWithContext(gw.Context(), &session)
+	err = gw.ReceivePayload(gw.Context(), types.SessionMessageStart, &session)
 	if err != nil {
 		return fmt.Errorf("Failed to read session start message: %w", err)
 	}
 
-	err = sh.StartSession(types.SessionJoining, session.Passphrase, gw)
+	// A join token replaces the lookup and the passphrase of the session.
+	// Its secret is used as the passphrase so that the system which issued it can confirm our join intent.
+	var joinToken *types.JoinToken
+	if session.JoinToken != "" {
+		joinToken, err = types.DecodeJoinToken(session.JoinToken)
+		if err != nil {
+			return err
+		}
+
+		if time.Now().After(joinToken.ExpiresAt) {
+			return fmt.Errorf("Join token expired at %s", joinToken.ExpiresAt.Format(time.RFC3339))
+		}
+
+		session.InitiatorAddress = joinToken.Address
+		session.InitiatorFingerprint = joinToken.Fingerprint
+		session.Passphrase = joinToken.Secret
+		session.Auth = types.SessionAuth{}
+	}
+
+	// The client can pin the initiator's fingerprint up front so that its certificate gets verified
+	// before sending the join intent.
+	pinnedFingerprint := session.InitiatorFingerprint
+	if pinnedFingerprint != "" {
+		err = cloudClient.ValidateFingerprint(pinnedFingerprint)
+		if err != nil {
+			return fmt.Errorf("Invalid initiator fingerprint: %w", err)
+		}
+	}
+
+	err = service.ValidateSessionKeepalive(session.Keepalive)
+	if err != nil {
+		return fmt.Errorf("Invalid keepalive settings: %w", err)
+	}
+
+	err = sh.StartSession(types.SessionJoining, session.Passphrase, session.Auth, gw)
 	if err != nil {
 		return fmt.Errorf("Failed to start session: %w", err)
 	}
 
 	defer func() {
+		// Record why the session ended before its state gets reset.
+		sh.Session.RecordStop(err)
+
 		err := sh.StopSession(nil)
 		if err != nil {
 			logger.Error("Failed to stop session", logger.Ctx{"err": err})
 		}
 	}()
 
+	err = sh.Session.SetKeepalive(session.Keepalive)
+	if err != nil {
+		return err
+	}
+
 	// No address selected, try to lookup system.
+	var initiator *types.SessionInitiator
 	if session.InitiatorAddress == "" {
-		lookupCtx, cancel := context.WithTimeoutCause(gw.Context(), session.LookupTimeout, fmt.Errorf("Lookup timeout exceeded"))
-		defer cancel()
-
-		discovery := multicast.NewDiscovery(session.Interface, service.CloudMulticastPort)
-		peer, err := discovery.Lookup(lookupCtx, multicast.Version)
+		initiator, err = lookupInitiator(state, sh, gw, session)
 		if err != nil {
-			return fmt.Errorf("Failed to lookup eligible system: %w", err)
+			return err
 		}
 
-		session.InitiatorAddress = peer.Address
+		session.InitiatorAddress = initiator.Address
+	} else if joinToken == nil {
+		// Without lookup, the initiator's session parameters are learned from its signed capabilities.
+		err = adoptInitiatorAuth(gw.Context(), sh, session)
+		if err != nil {
+			return err
+		}
 	}
 
+	// A resuming client doesn't have to select the system again.
+	sh.Session.RecordProgress(func(progress *types.SessionState) {
+		progress.InitiatorAddress = session.InitiatorAddress
+	})
+
 	// Get the remotes name.
-	cloud := sh.Services[types.MicroCloud].(*service.CloudService)
+	cloud := sh.Services()[types.MicroCloud].(*service.CloudService)
 	cert, err := cloud.ServerCert()
 	if err != nil {
 		return fmt.Errorf("Failed to get certificate of %q: %w", types.MicroCloud, err)
@@ -300,7 +574,7 @@ func handleJoiningSession(state state.State, sh *service.Handler, gw *cloudClien
 		Services:    session.Services,
 	}
 
-	h, err := trust.NewHMACArgon2([]byte(session.Passphrase), nil, trust.NewDefaultHMACConf(HMACMicroCloud10))
+	h, err := service.NewSessionHMAC(session.Passphrase, HMACMicroCloud10, sh.Session.Auth())
 	if err != nil {
 		return fmt.Errorf("Failed to create a new HMAC instance using argon2: %w", err)
 	}
@@ -312,17 +586,34 @@ func handleJoiningSession(state state.State, sh *service.Handler, gw *cloudClien
 
 	conf := cloudClient.AuthConfig{
 		HMAC: header,
+	}
+
+	if initiator != nil {
+		// The selected system's certificate is already known, so the signed intent is only sent to the system presenting it.
+		conf.TLSServerFingerprint = initiator.Fingerprint
+	} else if pinnedFingerprint != "" {
+		conf.TLSServerFingerprint = pinnedFingerprint
+	} else {
 		// The certificate of the initiater isn't yet known so we have to skip any TLS verification.
-		InsecureSkipVerify: true,
+		conf.InsecureSkipVerify = true
+	}
+
+	var peerCert *x509.Certificate
+	if joinToken != nil {
+		peerCert, err = cloud.RedeemJoinToken(context.Background(), session.InitiatorAddress, conf, joinToken.ID, joinIntent)
+	} else {
+		peerCert, err = cloud.RequestJoinIntent(context.Background(), session.InitiatorAddress, conf, joinIntent)
 	}
 
-	peerCert, err := cloud.RequestJoinIntent(context.Background(), session.InitiatorAddress, conf, joinIntent)
 	if err != nil {
 		// If the HMAC of the request is invalid, a generic error is returned by the API.
 		// It's likely that the user provided the wrong passphrase.
 		// Indicate this in the error by rewriting it.
 		if err.Error() == "Invalid HMAC" {
 			err = errors.New("Wrong passphrase")
+			if joinToken != nil {
+				err = errors.New("Invalid join token")
+			}
 		}
 
 		return fmt.Errorf("Failed to send our intent to join %q: %w", session.InitiatorAddress, err)
@@ -338,15 +629,50 @@ func handleJoiningSession(state state.State, sh *service.Handler, gw *cloudClien
 	session.InitiatorName = peerStatus.Name
 
 	// Notify the client we have found an eligible system.
-	err = gw.Write(types.Session{
-		InitiatorName:        session.InitiatorName,
-		InitiatorAddress:     session.InitiatorAddress,
-		InitiatorFingerprint: session.InitiatorFingerprint,
+	confirmedInitiator := types.SessionInitiator{
+		Name:        session.InitiatorName,
+		Address:     session.InitiatorAddress,
+		Fingerprint: session.InitiatorFingerprint,
+	}
+
+	sh.Session.RecordProgress(func(progress *types.SessionState) {
+		progress.Initiator = &confirmedInitiator
 	})
+
+	err = gw.WriteMessage(types.SessionMessageInitiatorConfirmed, confirmedInitiator)
 	if err != nil {
 		return fmt.Errorf("Failed to confirm the eligible system %q at %q: %w", session.InitiatorName, session.InitiatorAddress, err)
 	}
 
+	// Probe the initiator while waiting for its confirmation so that we don't wait
+	// until the session times out if it went away.
+	// Initiators which don't serve their capabilities cannot be probed.
+	// The same applies to the system which issued a join token as it doesn't take part in a session.
+	// Instead it reports if joining using the token failed.
+	var initiatorLeft <-chan bool
+	var tokenFailed <-chan error
+	if joinToken != nil {
+		watchCtx, cancel := context.WithCancel(gw.Context())
+		defer cancel()
+
+		tokenFailed, err = watchJoinToken(watchCtx, cloud, peerCert, *joinToken)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = cloud.ProbeSession(gw.Context(), peerCert, session.InitiatorAddress)
+		if err != nil {
+			logger.Warn("Cannot probe the initiator during the session", logger.Ctx{"address": session.InitiatorAddress, "err": err})
+		} else {
+			watchCtx, cancel := context.WithCancel(gw.Context())
+			defer cancel()
+
+			initiatorLeft = service.WatchPeer(watchCtx, sh.Session.Keepalive(), func(ctx context.Context) error {
+				return cloud.ProbeSession(ctx, peerCert, session.InitiatorAddress)
+			})
+		}
+	}
+
 	var ok bool
 	var confirmedIntent types.SessionJoinPost
 
@@ -357,13 +683,24 @@ func handleJoiningSession(state state.State, sh *service.Handler, gw *cloudClien
 			return errors.New("Exit waiting for join confirmation")
 		}
 
-		err = gw.Write(types.Session{
+		err = sh.Session.SendMessage(types.SessionMessageIntent, types.SessionJoinIntent{
 			Intent: confirmedIntent,
 		})
 		if err != nil {
 			return fmt.Errorf("Failed to forward join confirmation: %w", err)
 		}
 
+	case <-initiatorLeft:
+		// The channel also gets closed once the session ends.
+		if gw.Context().Err() != nil {
+			return fmt.Errorf("Exit waiting for join confirmation: %w", context.Cause(gw.Context()))
+		}
+
+		return fmt.Errorf("System %q at %q left the session: %w", session.InitiatorName, session.InitiatorAddress, cloudClient.ErrPeerUnresponsive)
+
+	case err := <-tokenFailed:
+		return fmt.Errorf("System %q at %q failed to join us using the join token: %w", session.InitiatorName, session.InitiatorAddress, err)
+
 	case <-gw.Context().Done():
 		return fmt.Errorf("Exit waiting for join confirmation: %w", context.Cause(gw.Context()))
 	}
@@ -385,11 +722,13 @@ func handleJoiningSession(state state.State, sh *service.Handler, gw *cloudClien
 	select {
 	case <-sh.Session.ExitCh():
 		errStr = ""
+	case err := <-tokenFailed:
+		errStr = fmt.Errorf("System %q at %q failed to join us using the join token: %w", session.InitiatorName, session.InitiatorAddress, err).Error()
 	case <-gw.Context().Done():
 		errStr = fmt.Errorf("Exit waiting for session to end: %w", context.Cause(gw.Context())).Error()
 	}
 
-	err = gw.Write(types.Session{
+	err = sh.Session.SendMessage(types.SessionMessageResult, types.SessionResult{
 		Error: errStr,
 	})
 	if err != nil {
@@ -398,3 +737,362 @@ func handleJoiningSession(state state.State, sh *service.Handler, gw *cloudClien
 
 	return nil
 }
+
+// lookupInitiator forwards every eligible system found during lookup to the client
+// and returns the one selected by the client.
+// Next to the lookup, probes of initiators which were given our address as a seed are answered.
+// The lookup stops after the session's lookup timeout. If systems were found until then,
+// it continues waiting for the client's selection.
+// Legacy clients cannot select a system, so the first compatible one is used for them.
+func lookupInitiator(state state.State, sh *service.Handler, gw *cloudClient.WebsocketGateway, session types.Session) (*types.SessionInitiator, error) {
+	lookupCtx, cancel := context.WithTimeoutCause(gw.Context(), session.LookupTimeout, fmt.Errorf("Lookup timeout exceeded"))
+	defer cancel()
+
+	_, v, err := sh.Session.DiscoveryAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	peers, err := lookupInterfaces(lookupCtx, session, v)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to lookup eligible systems: %w", err)
+	}
+
+	info := multicast.ServerInfo{
+		Version:    multicast.Version,
+		MinVersion: multicast.MinVersion,
+		Name:       state.Name(),
+		Address:    session.Address,
+	}
+
+	probes, err := multicast.RespondProbes(lookupCtx, service.CloudMulticastPort, info, v)
+	if err != nil {
+		// Finding the initiator using seeds is optional.
+		logger.Warn("Failed to respond to seed probes", logger.Ctx{"err": err})
+	}
+
+	cloud := sh.Services()[types.MicroCloud].(*service.CloudService)
+	resolve := func(peer multicast.ServerInfo) *eligibleSystem {
+		cert, err := cloud.RemoteCertificate(peer.Address)
+		if err != nil {
+			logger.Warn("Skipping eligible system", logger.Ctx{"name": peer.Name, "address": peer.Address, "err": err})
+			return nil
+		}
+
+		system := &eligibleSystem{
+			initiator: types.SessionInitiator{
+				Name:         peer.Name,
+				Address:      peer.Address,
+				Fingerprint:  shared.CertFingerprint(cert),
+				Interface:    peer.Interface,
+				Version:      peer.NegotiatedVersion,
+				Incompatible: peer.Incompatible,
+			},
+		}
+
+		// The session parameters are learned from the verified server info.
+		// Legacy initiators don't sign their info and use the default parameters.
+		if peer.HMAC != "" {
+			system.auth, err = service.SessionAuthFromHMAC(peer.HMAC, multicast.HMACDiscovery10)
+			if err != nil {
+				logger.Warn("Skipping eligible system", logger.Ctx{"name": peer.Name, "address": peer.Address, "err": err})
+				return nil
+			}
+		}
+
+		// Incompatible systems are forwarded so that the client can report them, but cannot be selected.
+		// Their capabilities are unknown as they might not serve them using a common version.
+		if system.initiator.Incompatible == "" {
+			// The capabilities are only displayed to the user so the system is forwarded without them if they cannot be retrieved.
+			system.initiator.Capabilities, err = cloud.RemoteCapabilities(lookupCtx, cert, peer.Address, session.Passphrase, system.auth)
+			if err != nil {
+				logger.Warn("Failed to get capabilities of eligible system", logger.Ctx{"name": peer.Name, "address": peer.Address, "err": err})
+			}
+		}
+
+		return system
+	}
+
+	selected, err := selectInitiator(lookupCtx, gw, sh.Session, resolve, peers, probes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sh.Session.SetAuth(selected.auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return &selected.initiator, nil
+}
+
+// eligibleSystem is a system found during lookup together with the session parameters learned from its server info.
+type eligibleSystem struct {
+	initiator types.SessionInitiator
+	auth      types.SessionAuth
+}
+
+// selectInitiator forwards the eligible systems resolved from the peers and probes to the client of the given session
+// and returns the one selected by the client.
+// Peers for which resolve returns nil are skipped.
+// The client of the gateway has to be known already, as legacy clients don't select a system.
+// For them the first compatible system is returned right away without forwarding any of them.
+func selectInitiator(ctx context.Context, gw *cloudClient.WebsocketGateway, session *service.Session, resolve func(peer multicast.ServerInfo) *eligibleSystem, peers <-chan multicast.ServerInfo, probes <-chan multicast.ServerInfo) (*eligibleSystem, error) {
+	legacy := gw.PeerVersion() == types.SessionLegacyVersion
+	systems := make(map[string]eligibleSystem)
+
+	// Only clients supporting the selection message are waited for.
+	var selections <-chan []byte
+	if !legacy {
+		selections = gw.Receive()
+	}
+
+	addSystem := func(peer multicast.ServerInfo) (*eligibleSystem, error) {
+		// The same initiator might be found using both lookup and seeds.
+		_, ok := systems[peer.Address]
+		if ok {
+			return nil, nil
+		}
+
+		system := resolve(peer)
+		if system == nil {
+			return nil, nil
+		}
+
+		systems[peer.Address] = *system
+
+		if legacy {
+			if system.initiator.Incompatible != "" {
+				logger.Warn("Skipping incompatible system", logger.Ctx{"name": peer.Name, "address": peer.Address, "err": system.initiator.Incompatible})
+				return nil, nil
+			}
+
+			return system, nil
+		}
+
+		err := session.SendMessage(types.SessionMessageInitiator, system.initiator)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to forward eligible system %q at %q: %w", system.initiator.Name, system.initiator.Address, err)
+		}
+
+		return nil, nil
+	}
+
+	for {
+		// Both the lookup and the seed probe responder stop after the lookup timeout.
+		// Unless the client is legacy, it can still select any of the systems found until then.
+		if peers == nil && probes == nil && (legacy || len(systems) == 0) {
+			return nil, fmt.Errorf("Failed to lookup eligible system: %w", context.Cause(ctx))
+		}
+
+		select {
+		case peer, ok := <-peers:
+			if !ok {
+				// Stop receiving from the closed channel.
+				peers = nil
+				continue
+			}
+
+			selected, err := addSystem(peer)
+			if err != nil || selected != nil {
+				return selected, err
+			}
+
+		case probe, ok := <-probes:
+			if !ok {
+				// Stop receiving from the closed channel.
+				probes = nil
+				continue
+			}
+
+			selected, err := addSystem(probe)
+			if err != nil || selected != nil {
+				return selected, err
+			}
+
+		case bytes, ok := <-selections:
+			// The connection got closed.
+			if !ok {
+				return nil, fmt.Errorf("Exit waiting for the selected system: %w", context.Cause(gw.Context()))
+			}
+
+			selection := types.SessionSelection{}
+			err := gw.ParsePayload(bytes, types.SessionMessageSelection, &selection)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read the selected system: %w", err)
+			}
+
+			system, ok := systems[selection.InitiatorAddress]
+			if !ok {
+				return nil, fmt.Errorf("Selected system at %q wasn't found during lookup", selection.InitiatorAddress)
+			}
+
+			if system.initiator.Incompatible != "" {
+				return nil, fmt.Errorf("Selected system %q at %q is incompatible: %s", system.initiator.Name, system.initiator.Address, system.initiator.Incompatible)
+			}
+
+			return &system, nil
+
+		case <-gw.Context().Done():
+			return nil, fmt.Errorf("Exit waiting for the selected system: %w", context.Cause(gw.Context()))
+		}
+	}
+}
+
+// watchJoinToken polls the redemption state of the given join token on the system which issued it and presents the given certificate
+// until the context is cancelled.
+// The returned channel receives the error reported by the system once joining using the token failed.
+func watchJoinToken(ctx context.Context, cloud *service.CloudService, cert *x509.Certificate, token types.JoinToken) (<-chan error, error) {
+	h, err := service.NewSessionHMAC(token.Secret, HMACMicroCloud10, types.SessionAuth{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create a new HMAC instance using argon2: %w", err)
+	}
+
+	// The status request doesn't have a body, so the HMAC is calculated over an empty one.
+	hmacBytes, err := h.WriteBytes([]byte{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create HMAC for join token status: %w", err)
+	}
+
+	conf := cloudClient.AuthConfig{
+		HMAC:                 h.HTTPHeader(hmacBytes),
+		TLSServerCertificate: cert,
+	}
+
+	failed := make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			status, err := cloud.JoinTokenStatus(ctx, token.Address, conf, token.ID)
+			if err != nil {
+				// The token is deleted once joining succeeded.
+				logger.Debug("Failed to get join token status", logger.Ctx{"address": token.Address, "err": err})
+				continue
+			}
+
+			if !status.Pending && status.Error != "" {
+				failed <- errors.New(status.Error)
+				return
+			}
+		}
+	}()
+
+	return failed, nil
+}
+
+// adoptInitiatorAuth sets the session parameters of the joining session to the ones of the initiator given in the session.
+// They are taken from the initiator's capabilities signed using the session passphrase.
+// Initiators which don't serve their capabilities yet use the default parameters.
+func adoptInitiatorAuth(ctx context.Context, sh *service.Handler, session types.Session) error {
+	cloud := sh.Services()[types.MicroCloud].(*service.CloudService)
+	cert, err := cloud.RemoteCertificate(session.InitiatorAddress)
+	if err != nil {
+		return err
+	}
+
+	auth, err := cloud.RemoteSessionAuth(ctx, cert, session.InitiatorAddress, session.Passphrase, sh.Session.MaxAuth())
+	if err != nil {
+		if !api.StatusErrorCheck(err, http.StatusNotFound) {
+			return fmt.Errorf("Failed to get the session parameters of %q: %w", session.InitiatorAddress, err)
+		}
+
+		logger.Warn("Initiator doesn't serve its session parameters, using the defaults", logger.Ctx{"address": session.InitiatorAddress})
+		auth = types.SessionAuth{}
+	}
+
+	return sh.Session.SetAuth(auth)
+}
+
+// sessionInterfaces returns the distinct names of the interfaces used for discovery in the given session.
+func sessionInterfaces(session types.Session) []string {
+	ifaceNames := []string{}
+	for _, ifaceName := range append([]string{session.Interface}, session.Interfaces...) {
+		if ifaceName == "" || shared.ValueInSlice(ifaceName, ifaceNames) {
+			continue
+		}
+
+		ifaceNames = append(ifaceNames, ifaceName)
+	}
+
+	return ifaceNames
+}
+
+// sessionSubnets returns the subnets from which the session's responders answer lookups.
+func sessionSubnets(session types.Session) ([]*net.IPNet, error) {
+	subnets := make([]*net.IPNet, 0, len(session.LookupSubnets))
+	for _, cidr := range session.LookupSubnets {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid lookup subnet %q: %w", cidr, err)
+		}
+
+		subnets = append(subnets, subnet)
+	}
+
+	return subnets, nil
+}
+
+// lookupInterfaces looks up peers on each of the session's interfaces until the context is cancelled
+// and streams the info of every peer found on any of them.
+// The replies are verified using the given verifier.
+// Interfaces on which the lookup cannot be started are skipped unless there isn't any other interface left.
+func lookupInterfaces(ctx context.Context, session types.Session, v *multicast.Verifier) (<-chan multicast.ServerInfo, error) {
+	ifaceNames := sessionInterfaces(session)
+	lookups := make([]<-chan multicast.ServerInfo, 0, len(ifaceNames))
+	for _, ifaceName := range ifaceNames {
+		// The initiator responds using the group of its address family which might
+		// differ from our own, so look up peers using both families.
+		discovery, err := service.NewDiscoveryBackend(multicast.BackendType(session.LookupBackend), ifaceName, multicast.IPv4, multicast.IPv6)
+		if err != nil {
+			return nil, err
+		}
+
+		peers, err := discovery.LookupAll(ctx, multicast.SupportedVersions, v)
+		if err != nil {
+			if len(ifaceNames) == 1 {
+				return nil, err
+			}
+
+			logger.Warn("Skipping lookup", logger.Ctx{"interface": ifaceName, "err": err})
+			continue
+		}
+
+		lookups = append(lookups, peers)
+	}
+
+	if len(lookups) == 0 {
+		return nil, errors.New("Failed to lookup peers on any interface")
+	}
+
+	allPeers := make(chan multicast.ServerInfo)
+
+	var wg sync.WaitGroup
+	for _, peers := range lookups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for peer := range peers {
+				select {
+				case allPeers <- peer:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(allPeers)
+	}()
+
+	return allPeers, nil
+}
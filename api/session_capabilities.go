@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/v2/rest"
+	"github.com/canonical/microcluster/v2/state"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+	"github.com/canonical/microcloud/microcloud/service"
+)
+
+// SessionCapabilitiesCmd represents the /1.0/session/capabilities API on MicroCloud.
+var SessionCapabilitiesCmd = func(sh *service.Handler) rest.Endpoint {
+	return rest.Endpoint{
+		AllowedBeforeInit: true,
+		Name:              "session/capabilities",
+		Path:              "session/capabilities",
+
+		Get: rest.EndpointAction{Handler: sessionCapabilitiesGet(sh), AllowUntrusted: true},
+	}
+}
+
+// sessionCapabilitiesGet returns the capabilities of the system signed using the passphrase of the active session.
+// The capabilities are returned to anyone during the session as the systems haven't yet established trust.
+// Receivers verify them using the passphrase.
+func sessionCapabilitiesGet(sh *service.Handler) func(state state.State, r *http.Request) response.Response {
+	return func(state state.State, r *http.Request) response.Response {
+		var capabilities *types.SessionCapabilities
+		err := sh.SessionTransaction(true, func(session *service.Session) error {
+			var err error
+			capabilities, err = session.Capabilities(func() (*types.Capabilities, error) {
+				return sh.Capabilities(r.Context())
+			})
+
+			return err
+		})
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		return response.SyncResponse(true, capabilities)
+	}
+}
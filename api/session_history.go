@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/canonical/microcluster/v2/rest"
+	"github.com/canonical/microcluster/v2/state"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+	"github.com/canonical/microcloud/microcloud/database"
+	"github.com/canonical/microcloud/microcloud/service"
+)
+
+// SessionHistoryCmd represents the /1.0/session/history API on MicroCloud.
+var SessionHistoryCmd = func(sh *service.Handler) rest.Endpoint {
+	return rest.Endpoint{
+		Name: "session/history",
+		Path: "session/history",
+
+		Get: rest.EndpointAction{Handler: authHandlerMTLS(sh, sessionHistoryGet)},
+	}
+}
+
+// sessionHistoryGet returns the recorded lifecycle events of trust establishment sessions.
+// The events can be filtered by session ID using the "session" query parameter.
+func sessionHistoryGet(state state.State, r *http.Request) response.Response {
+	sessionID := r.URL.Query().Get("session")
+
+	var events []types.SessionEvent
+	err := state.Database().Transaction(r.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		events, err = database.GetSessionEvents(ctx, tx, sessionID)
+		return err
+	})
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, events)
+}
+
+// maxSessionEvents is the number of most recent session events kept in the database.
+const maxSessionEvents = 10000
+
+// SessionEventRecorder returns a recorder persisting session events in the database of the given state.
+// It fails as long as the database isn't open, e.g. on a joining system before it joined,
+// so that the events get persisted later.
+// Only the most recent maxSessionEvents events are kept.
+func SessionEventRecorder(s state.State) service.SessionEventRecorder {
+	return func(ctx context.Context, events []types.SessionEvent) error {
+		err := s.Database().IsOpen(ctx)
+		if err != nil {
+			return err
+		}
+
+		return s.Database().Transaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+			for _, event := range events {
+				event.Member = s.Name()
+				err := database.CreateSessionEvent(ctx, tx, event)
+				if err != nil {
+					return err
+				}
+			}
+
+			return database.PruneSessionEvents(ctx, tx, maxSessionEvents)
+		})
+	}
+}
+
+// recordTokenEvent persists a lifecycle event of a join using the join token with the given ID.
+// Such joins don't have a session so the token's ID is used instead.
+func recordTokenEvent(s state.State, tokenID string, eventType types.SessionEventType, system types.SessionIntent, details string) {
+	event := types.SessionEvent{
+		SessionID:   tokenID,
+		Role:        types.SessionInitiating,
+		Type:        eventType,
+		Name:        system.Name,
+		Address:     system.Address,
+		Fingerprint: system.Fingerprint,
+		Details:     details,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := SessionEventRecorder(s)(ctx, []types.SessionEvent{event})
+	if err != nil {
+		logger.Warn("Failed to persist join token event", logger.Ctx{"token": tokenID, "type": eventType, "err": err})
+	}
+}
@@ -49,25 +49,40 @@ func sessionJoinPost(sh *service.Handler) func(state state.State, r *http.Reques
 		}
 
 		err = sh.SessionTransaction(true, func(session *service.Session) error {
+			fingerprint, err := shared.CertFingerprintStr(req.Certificate)
+			if err != nil {
+				return api.StatusErrorf(http.StatusBadRequest, "Failed to get fingerprint: %w", err)
+			}
+
+			system := types.SessionIntent{Name: req.Name, Address: req.Address, Fingerprint: fingerprint}
+
 			// Only validate the intent (services) on the initiator.
 			// The joiner has to accept the services from the initiator.
 			if session.Role() == types.SessionInitiating {
 				err = validateIntent(r.Context(), sh, req)
 				if err != nil {
+					session.RecordEvent(types.SessionEventIntentRejected, system, err.Error())
 					return api.NewStatusError(http.StatusBadRequest, err.Error())
 				}
 			}
 
-			fingerprint, err := shared.CertFingerprintStr(req.Certificate)
-			if err != nil {
-				return api.StatusErrorf(http.StatusBadRequest, "Failed to get fingerprint: %w", err)
+			// Reject systems which aren't approved up front if the session only accepts approved joiners.
+			if session.Role() == types.SessionInitiating {
+				err = session.ApproveIntent(req, fingerprint)
+				if err != nil {
+					session.RecordEvent(types.SessionEventIntentRejected, system, err.Error())
+					return api.NewStatusError(http.StatusForbidden, err.Error())
+				}
 			}
 
-			err = session.RegisterIntent(fingerprint)
+			err = session.RegisterIntent(req, fingerprint)
 			if err != nil {
+				session.RecordEvent(types.SessionEventIntentRejected, system, err.Error())
 				return api.StatusErrorf(http.StatusBadRequest, "Failed to register join intent: %w", err)
 			}
 
+			session.RecordEvent(types.SessionEventIntentReceived, system, "")
+
 			// Prevent locking in case there isn't anymore an active consumer reading on the channel.
 			// This can happen if the initiator's websocket connection isn't anymore active.
 			select {
@@ -87,7 +102,7 @@ func sessionJoinPost(sh *service.Handler) func(state state.State, r *http.Reques
 // Also compares each service's daemon version between the joiner and initiator.
 func validateIntent(ctx context.Context, sh *service.Handler, intent types.SessionJoinPost) error {
 	// Reject any peers that are missing our services.
-	for _, service := range sh.Services {
+	for _, service := range sh.Services() {
 		intentVersion, ok := intent.Services[service.Type()]
 		if !ok {
 			return fmt.Errorf("Rejecting peer %q due to missing services (%s)", intent.Name, string(service.Type()))
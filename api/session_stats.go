@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/v2/rest"
+	"github.com/canonical/microcluster/v2/state"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+	"github.com/canonical/microcloud/microcloud/service"
+)
+
+// SessionStatsCmd represents the /1.0/session/stats API on MicroCloud.
+var SessionStatsCmd = func(sh *service.Handler) rest.Endpoint {
+	return rest.Endpoint{
+		AllowedBeforeInit: true,
+		Name:              "session/stats",
+		Path:              "session/stats",
+
+		Get: rest.EndpointAction{Handler: authHandlerMTLS(sh, sessionStatsGet(sh))},
+	}
+}
+
+// sessionStatsGet returns the counters of the lookups received by the discovery responders of the active session.
+func sessionStatsGet(sh *service.Handler) endpointHandler {
+	return func(state state.State, r *http.Request) response.Response {
+		var stats types.DiscoveryStats
+		err := sh.SessionTransaction(true, func(session *service.Session) error {
+			stats = session.DiscoveryStats()
+			return nil
+		})
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		return response.SyncResponse(true, stats)
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/v2/rest"
+	"github.com/canonical/microcluster/v2/state"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+	"github.com/canonical/microcloud/microcloud/service"
+)
+
+// SessionCmd represents the /1.0/session API on MicroCloud.
+var SessionCmd = func(sh *service.Handler) rest.Endpoint {
+	return rest.Endpoint{
+		AllowedBeforeInit: true,
+		Name:              "session",
+		Path:              "session",
+
+		Get:    rest.EndpointAction{Handler: authHandlerMTLS(sh, sessionStatusGet(sh))},
+		Delete: rest.EndpointAction{Handler: authHandlerMTLS(sh, sessionDelete(sh))},
+	}
+}
+
+// sessionStatusGet returns the status of the active trust establishment session.
+func sessionStatusGet(sh *service.Handler) endpointHandler {
+	return func(state state.State, r *http.Request) response.Response {
+		var status types.SessionStatus
+		err := sh.SessionTransaction(true, func(session *service.Session) error {
+			status = session.Status()
+			return nil
+		})
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		return response.SyncResponse(true, status)
+	}
+}
+
+// sessionDelete aborts the active trust establishment session.
+// The client holding the session is notified about the abort.
+func sessionDelete(sh *service.Handler) endpointHandler {
+	return func(state state.State, r *http.Request) response.Response {
+		if !sh.ActiveSession() {
+			return response.BadRequest(errors.New("No active session"))
+		}
+
+		err := sh.StopSession(errors.New("Session got aborted"))
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		return response.EmptySyncResponse
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd/shared/ws"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+	cloudClient "github.com/canonical/microcloud/microcloud/client"
+	"github.com/canonical/microcloud/microcloud/multicast"
+	"github.com/canonical/microcloud/microcloud/service"
+)
+
+type sessionSuite struct {
+	suite.Suite
+}
+
+func TestSessionSuite(t *testing.T) {
+	suite.Run(t, new(sessionSuite))
+}
+
+// joiningSession returns a joining session whose gateway is connected to the returned client connection.
+// The given start message is sent by the client and received by the gateway, so that it learns the client's protocol version.
+func (s *sessionSuite) joiningSession(ctx context.Context, start any) (*service.Session, *cloudClient.WebsocketGateway, *websocket.Conn) {
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := ws.Upgrader.Upgrade(w, r, nil)
+		s.Require().NoError(err)
+		conns <- conn
+	}))
+
+	s.T().Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.DialContext(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	s.Require().NoError(err)
+	s.T().Cleanup(func() { _ = client.Close() })
+
+	gw := cloudClient.NewWebsocketGateway(ctx, <-conns)
+
+	err = client.WriteJSON(start)
+	s.Require().NoError(err)
+
+	_, err = gw.ReceiveMessage(ctx)
+	s.Require().NoError(err)
+
+	session, err := service.NewSession(types.SessionJoining, "foo bar baz qux", types.SessionAuth{}, gw)
+	s.Require().NoError(err)
+
+	return session, gw, client
+}
+
+// lookupPeers returns a closed channel containing the given peers.
+func lookupPeers(peers ...multicast.ServerInfo) <-chan multicast.ServerInfo {
+	ch := make(chan multicast.ServerInfo, len(peers))
+	for _, peer := range peers {
+		ch <- peer
+	}
+
+	close(ch)
+
+	return ch
+}
+
+// resolvePeer returns the eligible system of the given peer without contacting it.
+func resolvePeer(peer multicast.ServerInfo) *eligibleSystem {
+	return &eligibleSystem{initiator: types.SessionInitiator{Name: peer.Name, Address: peer.Address, Incompatible: peer.Incompatible}}
+}
+
+func (s *sessionSuite) Test_selectInitiatorLegacyClient() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session, gw, client := s.joiningSession(ctx, types.Session{Address: "10.0.0.1", Passphrase: "foo bar baz qux"})
+	s.Require().Equal(types.SessionLegacyVersion, gw.PeerVersion())
+
+	peers := lookupPeers(
+		multicast.ServerInfo{Name: "foo", Address: "10.0.0.2", Incompatible: "Peer only supports version 1.0"},
+		multicast.ServerInfo{Name: "bar", Address: "10.0.0.3"},
+		multicast.ServerInfo{Name: "baz", Address: "10.0.0.4"},
+	)
+
+	// The first compatible system is selected without waiting for the client.
+	selected, err := selectInitiator(ctx, gw, session, resolvePeer, peers, nil)
+	s.Require().NoError(err)
+	s.Require().Equal("bar", selected.initiator.Name)
+
+	// The client only learns about the system the join intent was sent to.
+	err = session.SendMessage(types.SessionMessageInitiatorConfirmed, selected.initiator)
+	s.Require().NoError(err)
+
+	received := types.Session{}
+	err = client.ReadJSON(&received)
+	s.Require().NoError(err)
+	s.Require().Equal(types.Session{InitiatorName: "bar", InitiatorAddress: "10.0.0.3"}, received)
+
+	// The lookup fails if only incompatible systems are found.
+	_, err = selectInitiator(ctx, gw, session, resolvePeer, lookupPeers(multicast.ServerInfo{Name: "foo", Address: "10.0.0.2", Incompatible: "Peer only supports version 1.0"}), nil)
+	s.Require().ErrorContains(err, "Failed to lookup eligible system")
+}
+
+func (s *sessionSuite) Test_selectInitiator() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start, err := types.NewSessionMessage(types.SessionMessageStart, types.Session{Address: "10.0.0.1", Passphrase: "foo bar baz qux"})
+	s.Require().NoError(err)
+
+	session, gw, client := s.joiningSession(ctx, start)
+	s.Require().Equal(types.SessionProtocolVersion, gw.PeerVersion())
+
+	peers := lookupPeers(
+		multicast.ServerInfo{Name: "foo", Address: "10.0.0.2"},
+		multicast.ServerInfo{Name: "bar", Address: "10.0.0.3"},
+	)
+
+	go func() {
+		// The client selects the second system it got forwarded.
+		for i := 0; i < 2; i++ {
+			msg := types.SessionMessage{}
+			err := client.ReadJSON(&msg)
+			if err != nil || msg.Type != types.SessionMessageInitiator {
+				return
+			}
+		}
+
+		selection, err := types.NewSessionMessage(types.SessionMessageSelection, types.SessionSelection{InitiatorAddress: "10.0.0.3"})
+		if err != nil {
+			return
+		}
+
+		_ = client.WriteJSON(selection)
+	}()
+
+	selected, err := selectInitiator(ctx, gw, session, resolvePeer, peers, nil)
+	s.Require().NoError(err)
+	s.Require().Equal("bar", selected.initiator.Name)
+}
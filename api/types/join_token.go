@@ -0,0 +1,71 @@
+package types
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// JoinToken represents an out-of-band token which allows a single system to join the cluster
+// without a trust establishment session.
+// It's redeemed by the system which issued it.
+type JoinToken struct {
+	// ID identifies the token on the system which issued it.
+	ID string `json:"id"`
+
+	// Address is the address of the system which issued the token.
+	Address string `json:"address"`
+
+	// Fingerprint is the fingerprint of the cluster certificate presented by the system which issued the token.
+	Fingerprint string `json:"fingerprint"`
+
+	// Secret is used as the passphrase to authenticate the join intent of the system redeeming the token.
+	Secret string `json:"secret"`
+
+	// ExpiresAt is the time after which the token cannot be redeemed anymore.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// JoinTokensPost represents a request to issue a new join token.
+type JoinTokensPost struct {
+	// Expiry is the duration for which the token can be redeemed. Zero uses the default.
+	Expiry time.Duration `json:"expiry"`
+}
+
+// JoinTokenStatus represents the redemption state of a join token reported to the system redeeming it.
+type JoinTokenStatus struct {
+	// Pending is set while the system redeeming the token joins the cluster.
+	Pending bool `json:"pending"`
+
+	// Error is the reason why the last redemption of the token failed.
+	Error string `json:"error,omitempty"`
+}
+
+// String returns the encoded token which is handed to the joining system.
+func (t JoinToken) String() string {
+	// Marshalling the token cannot fail as it only contains strings and a timestamp.
+	data, _ := json.Marshal(t)
+
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// DecodeJoinToken decodes the given token and returns an error if any of its fields are missing.
+func DecodeJoinToken(token string) (*JoinToken, error) {
+	data, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode join token: %w", err)
+	}
+
+	joinToken := JoinToken{}
+	err = json.Unmarshal(data, &joinToken)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse join token: %w", err)
+	}
+
+	if joinToken.ID == "" || joinToken.Address == "" || joinToken.Fingerprint == "" || joinToken.Secret == "" || joinToken.ExpiresAt.IsZero() {
+		return nil, fmt.Errorf("Join token is incomplete")
+	}
+
+	return &joinToken, nil
+}
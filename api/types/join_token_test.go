@@ -0,0 +1,38 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type joinTokenSuite struct {
+	suite.Suite
+}
+
+func TestJoinTokenSuite(t *testing.T) {
+	suite.Run(t, new(joinTokenSuite))
+}
+
+func (s *joinTokenSuite) Test_DecodeJoinToken() {
+	token := JoinToken{
+		ID:          "0123456789abcdef",
+		Address:     "10.0.0.1",
+		Fingerprint: "5b3e1a0c2d4f",
+		Secret:      "foo",
+		ExpiresAt:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	decoded, err := DecodeJoinToken(token.String())
+	s.Require().NoError(err)
+	s.Require().Equal(token, *decoded)
+
+	_, err = DecodeJoinToken("not a token")
+	s.Require().ErrorContains(err, "Failed to decode join token")
+
+	incomplete := token
+	incomplete.Secret = ""
+	_, err = DecodeJoinToken(incomplete.String())
+	s.Require().EqualError(err, "Join token is incomplete")
+}
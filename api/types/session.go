@@ -15,23 +15,103 @@ const (
 	SessionJoining SessionRole = "joining"
 )
 
-// Session represents the websocket protocol used during trust establishment between the client and server.
+// Session represents the start message of a trust establishment session.
+// Legacy clients use it for all the messages exchanged with the server, see SessionMessage.
 // Empty fields are omitted to require sending only the necessary information.
 type Session struct {
+	SessionID            string                 `json:"session_id,omitempty"`
 	Address              string                 `json:"address,omitempty"`
 	InitiatorAddress     string                 `json:"initiator_address,omitempty"`
 	InitiatorName        string                 `json:"initiator_name,omitempty"`
 	InitiatorFingerprint string                 `json:"initiator_fingerprint,omitempty"`
 	Interface            string                 `json:"interface,omitempty"`
+	Interfaces           []string               `json:"interfaces,omitempty"`
 	Passphrase           string                 `json:"passphrase,omitempty"`
+	Auth                 SessionAuth            `json:"auth,omitempty"`
+	Keepalive            SessionKeepalive       `json:"keepalive,omitempty"`
 	Services             map[ServiceType]string `json:"services,omitempty"`
 	Intent               SessionJoinPost        `json:"intent,omitempty"`
+	IntentCapabilities   *Capabilities          `json:"intent_capabilities,omitempty"`
 	ConfirmedIntents     []SessionJoinPost      `json:"confirmed_intents,omitempty"`
 	Accepted             bool                   `json:"accepted,omitempty"`
 	LookupTimeout        time.Duration          `json:"lookup_timeout,omitempty"`
+	LookupBackend        string                 `json:"lookup_backend,omitempty"`
+	LookupSeeds          []string               `json:"lookup_seeds,omitempty"`
+	LookupSubnets        []string               `json:"lookup_subnets,omitempty"`
+	ApprovedJoiners      []ApprovedJoiner       `json:"approved_joiners,omitempty"`
+	BannedAddress        string                 `json:"banned_address,omitempty"`
+	JoinToken            string                 `json:"join_token,omitempty"`
 	Error                string                 `json:"error,omitempty"`
 }
 
+// SessionStatus represents the status of the active trust establishment session.
+type SessionStatus struct {
+	ID             string                `json:"id" yaml:"id"`
+	Role           SessionRole           `json:"role" yaml:"role"`
+	Connected      bool                  `json:"connected" yaml:"connected"`
+	StartedAt      time.Time             `json:"started_at" yaml:"started_at"`
+	Timeout        time.Duration         `json:"timeout" yaml:"timeout"`
+	Intents        []SessionIntent       `json:"intents" yaml:"intents"`
+	FailedAttempts int                   `json:"failed_attempts" yaml:"failed_attempts"`
+	FailedSources  []SessionFailedSource `json:"failed_sources" yaml:"failed_sources"`
+}
+
+// SessionIntent represents a join intent registered during a trust establishment session.
+type SessionIntent struct {
+	Name        string `json:"name" yaml:"name"`
+	Address     string `json:"address" yaml:"address"`
+	Fingerprint string `json:"fingerprint" yaml:"fingerprint"`
+}
+
+// SessionFailedSource represents the failed join attempts of a single source address during a trust establishment session.
+type SessionFailedSource struct {
+	Address      string    `json:"address" yaml:"address"`
+	Attempts     int       `json:"attempts" yaml:"attempts"`
+	BlockedUntil time.Time `json:"blocked_until" yaml:"blocked_until"`
+}
+
+// SessionAuth represents the parameters used to generate the passphrase of a session and to derive the HMAC key from it.
+// Zero values use the defaults.
+// The Argon2 parameters have to be the same on all systems taking part in the session.
+type SessionAuth struct {
+	// PassphraseWords is the number of words of a generated passphrase.
+	PassphraseWords int `json:"passphrase_words,omitempty"`
+
+	// Wordlist contains the words a generated passphrase is chosen from.
+	Wordlist []string `json:"wordlist,omitempty"`
+
+	// Argon2Time is the number of Argon2 iterations.
+	Argon2Time uint32 `json:"argon2_time,omitempty"`
+
+	// Argon2Memory is the amount of memory in KiB used by Argon2.
+	Argon2Memory uint32 `json:"argon2_memory,omitempty"`
+}
+
+// SessionKeepalive represents the settings used to detect unresponsive peers during a trust establishment session.
+// Zero values use the defaults.
+type SessionKeepalive struct {
+	// Interval is the time between two pings of the websocket connection and probes of the other systems.
+	Interval time.Duration `json:"interval,omitempty"`
+
+	// Timeout is the time after which a peer which didn't respond is considered unresponsive.
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// ApprovedJoiner represents a system which is allowed to join a session without interactive confirmation.
+// The fingerprint can also be a prefix of the system's certificate fingerprint.
+// If the name is empty, the system can use any name.
+type ApprovedJoiner struct {
+	Fingerprint string `json:"fingerprint" yaml:"fingerprint"`
+	Name        string `json:"name,omitempty" yaml:"name"`
+}
+
+// DiscoveryStats contains the counters of the lookups received by the discovery responders of a session.
+type DiscoveryStats struct {
+	Received uint64 `json:"received"`
+	Answered uint64 `json:"answered"`
+	Dropped  uint64 `json:"dropped"`
+}
+
 // SessionJoinPost represents a request made to join an active session.
 type SessionJoinPost struct {
 	Name        string                 `json:"name" yaml:"name"`
@@ -40,3 +120,75 @@ type SessionJoinPost struct {
 	Certificate string                 `json:"certificate" yaml:"certificate"`
 	Services    map[ServiceType]string `json:"services" yaml:"services"`
 }
+
+// SessionInitiator represents an initiator found by a joiner when looking up systems.
+type SessionInitiator struct {
+	Name         string        `json:"name"`
+	Address      string        `json:"address"`
+	Fingerprint  string        `json:"fingerprint"`
+	Interface    string        `json:"interface"`
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+
+	// Version is the highest discovery version supported by both systems.
+	Version string `json:"version,omitempty"`
+
+	// Incompatible is the reason why the system cannot be joined as it doesn't support any common discovery version.
+	Incompatible string `json:"incompatible,omitempty"`
+}
+
+// Capabilities is a compact summary of a system's resources.
+// It's shared with the other systems of a trust establishment session before trust is established.
+type Capabilities struct {
+	Disks            int                    `json:"disks"`
+	UplinkInterfaces []string               `json:"uplink_interfaces"`
+	CPUs             uint64                 `json:"cpus"`
+	Memory           uint64                 `json:"memory"`
+	Services         map[ServiceType]string `json:"services"`
+}
+
+// SessionCapabilities represents the capabilities of a system signed using the session passphrase.
+type SessionCapabilities struct {
+	Capabilities Capabilities `json:"capabilities"`
+	HMAC         string       `json:"hmac"`
+}
+
+// SessionEventType is the type of a lifecycle event of a trust establishment session.
+type SessionEventType string
+
+const (
+	// SessionEventStarted is recorded when a session starts.
+	SessionEventStarted SessionEventType = "started"
+
+	// SessionEventIntentReceived is recorded when a join intent is received.
+	SessionEventIntentReceived SessionEventType = "intent-received"
+
+	// SessionEventIntentConfirmed is recorded when the initiator confirms a join intent.
+	SessionEventIntentConfirmed SessionEventType = "intent-confirmed"
+
+	// SessionEventIntentRejected is recorded when a join intent is rejected.
+	SessionEventIntentRejected SessionEventType = "intent-rejected"
+
+	// SessionEventAddressBanned is recorded when a source address got banned after too many join attempts with an invalid HMAC.
+	// Individual failed attempts aren't recorded so that unauthenticated requests cannot grow the history.
+	SessionEventAddressBanned SessionEventType = "address-banned"
+
+	// SessionEventJoinCompleted is recorded when a system joined the cluster.
+	SessionEventJoinCompleted SessionEventType = "join-completed"
+
+	// SessionEventStopped is recorded when a session stops. Its details contain the cause if the session failed.
+	SessionEventStopped SessionEventType = "stopped"
+)
+
+// SessionEvent represents a lifecycle event of a trust establishment session.
+// Joins using an out-of-band join token use the token's ID as the session ID.
+type SessionEvent struct {
+	SessionID   string           `json:"session_id" yaml:"session_id"`
+	Member      string           `json:"member" yaml:"member"`
+	Role        SessionRole      `json:"role" yaml:"role"`
+	Type        SessionEventType `json:"type" yaml:"type"`
+	Name        string           `json:"name" yaml:"name"`
+	Address     string           `json:"address" yaml:"address"`
+	Fingerprint string           `json:"fingerprint" yaml:"fingerprint"`
+	Details     string           `json:"details" yaml:"details"`
+	CreatedAt   time.Time        `json:"created_at" yaml:"created_at"`
+}
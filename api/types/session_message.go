@@ -0,0 +1,274 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SessionProtocolVersion is the version of the message protocol used during trust establishment sessions.
+// Peers negotiate the lowest version supported by both sides based on the messages they receive.
+const SessionProtocolVersion = 2
+
+// SessionLegacyVersion is the protocol version of peers exchanging plain Session messages without envelope.
+const SessionLegacyVersion = 0
+
+// SessionMessageType is the type of a message exchanged during a trust establishment session.
+type SessionMessageType string
+
+const (
+	// SessionMessageStart starts a session. Its payload is a Session.
+	SessionMessageStart SessionMessageType = "start"
+
+	// SessionMessageDetails is the initiator's reply to the start message. Its payload is a SessionDetails.
+	SessionMessageDetails SessionMessageType = "details"
+
+	// SessionMessageState restores the state on a client resuming a session. Its payload is a SessionState.
+	SessionMessageState SessionMessageType = "state"
+
+	// SessionMessageIntent forwards a join intent to the client. Its payload is a SessionJoinIntent.
+	SessionMessageIntent SessionMessageType = "intent"
+
+	// SessionMessageConfirmedIntents contains the join intents confirmed on the initiator. Its payload is a SessionConfirmedIntents.
+	SessionMessageConfirmedIntents SessionMessageType = "confirmed-intents"
+
+	// SessionMessageAccepted indicates that all confirmed systems accepted the initiator's join intent. It doesn't have a payload.
+	SessionMessageAccepted SessionMessageType = "accepted"
+
+	// SessionMessageInitiator forwards an eligible system found by the joiner. Its payload is a SessionInitiator.
+	SessionMessageInitiator SessionMessageType = "initiator"
+
+	// SessionMessageSelection contains the system selected by the joiner's client. Its payload is a SessionSelection.
+	SessionMessageSelection SessionMessageType = "selection"
+
+	// SessionMessageInitiatorConfirmed contains the system the joiner sent its join intent to. Its payload is a SessionInitiator.
+	SessionMessageInitiatorConfirmed SessionMessageType = "initiator-confirmed"
+
+	// SessionMessageBannedAddress reports an address banned from joining the session. Its payload is a SessionBannedAddress.
+	SessionMessageBannedAddress SessionMessageType = "banned-address"
+
+	// SessionMessageResult is the final message of the joiner. Its payload is a SessionResult.
+	SessionMessageResult SessionMessageType = "result"
+
+	// SessionMessageKeepalive announces the keepalive settings applied to the websocket connection. Its payload is a SessionKeepalive.
+	SessionMessageKeepalive SessionMessageType = "keepalive"
+
+	// SessionMessagePeerLeft reports a system which stopped responding during the session. Its payload is a SessionPeer.
+	SessionMessagePeerLeft SessionMessageType = "peer-left"
+
+	// SessionMessagePeerReturned reports a system which responds again after it left the session. Its payload is a SessionPeer.
+	SessionMessagePeerReturned SessionMessageType = "peer-returned"
+)
+
+// sessionMessageSpec describes the protocol version in which a message type got introduced and its payload.
+type sessionMessageSpec struct {
+	version int
+
+	// payload returns a new instance of the message type's payload.
+	// It's nil if the message type doesn't have a payload.
+	payload func() any
+}
+
+// sessionMessageSpecs contains the known message types.
+// New message types have to be added together with a new protocol version so that they are only sent to peers supporting them.
+var sessionMessageSpecs = map[SessionMessageType]sessionMessageSpec{
+	SessionMessageStart:              {version: SessionLegacyVersion, payload: func() any { return &Session{} }},
+	SessionMessageDetails:            {version: SessionLegacyVersion, payload: func() any { return &SessionDetails{} }},
+	SessionMessageState:              {version: 1, payload: func() any { return &SessionState{} }},
+	SessionMessageIntent:             {version: SessionLegacyVersion, payload: func() any { return &SessionJoinIntent{} }},
+	SessionMessageConfirmedIntents:   {version: SessionLegacyVersion, payload: func() any { return &SessionConfirmedIntents{} }},
+	SessionMessageAccepted:           {version: SessionLegacyVersion},
+	SessionMessageInitiator:          {version: 1, payload: func() any { return &SessionInitiator{} }},
+	SessionMessageSelection:          {version: 1, payload: func() any { return &SessionSelection{} }},
+	SessionMessageInitiatorConfirmed: {version: SessionLegacyVersion, payload: func() any { return &SessionInitiator{} }},
+	SessionMessageBannedAddress:      {version: SessionLegacyVersion, payload: func() any { return &SessionBannedAddress{} }},
+	SessionMessageResult:             {version: SessionLegacyVersion, payload: func() any { return &SessionResult{} }},
+	SessionMessageKeepalive:          {version: 2, payload: func() any { return &SessionKeepalive{} }},
+	SessionMessagePeerLeft:           {version: 2, payload: func() any { return &SessionPeer{} }},
+	SessionMessagePeerReturned:       {version: 2, payload: func() any { return &SessionPeer{} }},
+}
+
+// SupportedBy returns whether or not the message type is supported by peers using the given protocol version.
+func (t SessionMessageType) SupportedBy(version int) bool {
+	spec, ok := sessionMessageSpecs[t]
+
+	return ok && spec.version <= version
+}
+
+// SessionMessage is the envelope of the messages exchanged during a trust establishment session.
+type SessionMessage struct {
+	Version int                `json:"version"`
+	Type    SessionMessageType `json:"type"`
+	Payload json.RawMessage    `json:"payload,omitempty"`
+}
+
+// SessionDetails contains the details of a started session.
+type SessionDetails struct {
+	SessionID  string      `json:"session_id"`
+	Passphrase string      `json:"passphrase"`
+	Auth       SessionAuth `json:"auth"`
+}
+
+// SessionState contains the decisions taken during a session which are replayed to a resuming client.
+type SessionState struct {
+	SessionDetails
+
+	// IntentsConfirmed is set once the initiator's client confirmed the join intents.
+	IntentsConfirmed bool              `json:"intents_confirmed,omitempty"`
+	ConfirmedIntents []SessionJoinPost `json:"confirmed_intents,omitempty"`
+	Accepted         bool              `json:"accepted,omitempty"`
+
+	// InitiatorAddress is the address of the system selected by the joiner's client.
+	InitiatorAddress string `json:"initiator_address,omitempty"`
+
+	// Initiator is set once the joiner sent its join intent to the selected system.
+	Initiator *SessionInitiator `json:"initiator,omitempty"`
+}
+
+// SessionJoinIntent contains a join intent together with the capabilities of the system which sent it.
+type SessionJoinIntent struct {
+	Intent       SessionJoinPost `json:"intent"`
+	Capabilities *Capabilities   `json:"capabilities,omitempty"`
+}
+
+// SessionConfirmedIntents contains the join intents confirmed on the initiator.
+type SessionConfirmedIntents struct {
+	Intents []SessionJoinPost `json:"intents"`
+}
+
+// SessionSelection contains the address of the system selected by the joiner's client.
+type SessionSelection struct {
+	InitiatorAddress string `json:"initiator_address"`
+}
+
+// SessionBannedAddress contains an address banned from joining the session.
+type SessionBannedAddress struct {
+	Address string `json:"address"`
+}
+
+// SessionResult contains the outcome of joining a system. The error is empty on success.
+type SessionResult struct {
+	Error string `json:"error,omitempty"`
+}
+
+// SessionPeer contains a system taking part in the session whose liveness changed.
+type SessionPeer struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// NewSessionMessage returns a message of the given type using the current protocol version.
+// The payload has to match the message type.
+func NewSessionMessage(msgType SessionMessageType, payload any) (*SessionMessage, error) {
+	msg := &SessionMessage{
+		Version: SessionProtocolVersion,
+		Type:    msgType,
+	}
+
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to marshal payload of session message %q: %w", msgType, err)
+		}
+
+		msg.Payload = data
+	}
+
+	return msg, nil
+}
+
+// Validate returns an error if the message is of an unknown type or its payload doesn't match its type.
+func (m SessionMessage) Validate() error {
+	spec, ok := sessionMessageSpecs[m.Type]
+	if !ok {
+		return fmt.Errorf("Unknown session message type %q", m.Type)
+	}
+
+	if m.Version <= SessionLegacyVersion {
+		return fmt.Errorf("Invalid protocol version %d of session message %q", m.Version, m.Type)
+	}
+
+	if spec.payload == nil {
+		return nil
+	}
+
+	if len(m.Payload) == 0 {
+		return fmt.Errorf("Session message %q is missing its payload", m.Type)
+	}
+
+	err := json.Unmarshal(m.Payload, spec.payload())
+	if err != nil {
+		return fmt.Errorf("Invalid payload of session message %q: %w", m.Type, err)
+	}
+
+	return nil
+}
+
+// Decode unmarshals the message's payload into v.
+func (m SessionMessage) Decode(v any) error {
+	err := json.Unmarshal(m.Payload, v)
+	if err != nil {
+		return fmt.Errorf("Failed to parse payload of session message %q: %w", m.Type, err)
+	}
+
+	return nil
+}
+
+// LegacySession returns the message in the format of peers exchanging plain Session messages.
+func (m SessionMessage) LegacySession() (*Session, error) {
+	spec, ok := sessionMessageSpecs[m.Type]
+	if !ok || spec.version > SessionLegacyVersion {
+		return nil, fmt.Errorf("Session message %q isn't supported by legacy peers", m.Type)
+	}
+
+	var payload any
+	if spec.payload != nil {
+		payload = spec.payload()
+		err := m.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch p := payload.(type) {
+	case *Session:
+		return p, nil
+	case *SessionDetails:
+		return &Session{SessionID: p.SessionID, Passphrase: p.Passphrase, Auth: p.Auth}, nil
+	case *SessionJoinIntent:
+		return &Session{Intent: p.Intent, IntentCapabilities: p.Capabilities}, nil
+	case *SessionConfirmedIntents:
+		return &Session{ConfirmedIntents: p.Intents}, nil
+	case *SessionBannedAddress:
+		return &Session{BannedAddress: p.Address}, nil
+	case *SessionResult:
+		return &Session{Error: p.Error}, nil
+	case *SessionInitiator:
+		return &Session{InitiatorName: p.Name, InitiatorAddress: p.Address, InitiatorFingerprint: p.Fingerprint}, nil
+	}
+
+	// Only the accepted message doesn't have a payload.
+	return &Session{Accepted: true}, nil
+}
+
+// NewLegacySessionMessage returns the message sent by a client exchanging plain Session messages.
+// Such clients only send the start message and the confirmed intents
+// so the message type is derived from the fields which are set.
+func NewLegacySessionMessage(session Session) (*SessionMessage, error) {
+	var msgType SessionMessageType
+	var payload any
+	switch {
+	case session.Address != "":
+		msgType, payload = SessionMessageStart, session
+	default:
+		msgType, payload = SessionMessageConfirmedIntents, SessionConfirmedIntents{Intents: session.ConfirmedIntents}
+	}
+
+	msg, err := NewSessionMessage(msgType, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	msg.Version = SessionLegacyVersion
+
+	return msg, nil
+}
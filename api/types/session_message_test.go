@@ -0,0 +1,146 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type sessionMessageSuite struct {
+	suite.Suite
+}
+
+func TestSessionMessageSuite(t *testing.T) {
+	suite.Run(t, new(sessionMessageSuite))
+}
+
+func (s *sessionMessageSuite) Test_Validate() {
+	msg, err := NewSessionMessage(SessionMessageSelection, SessionSelection{InitiatorAddress: "10.0.0.1"})
+	s.Require().NoError(err)
+	s.Require().NoError(msg.Validate())
+
+	selection := SessionSelection{}
+	s.Require().NoError(msg.Decode(&selection))
+	s.Require().Equal("10.0.0.1", selection.InitiatorAddress)
+
+	accepted, err := NewSessionMessage(SessionMessageAccepted, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(accepted.Validate())
+
+	s.Require().EqualError(SessionMessage{Version: 1, Type: "foo"}.Validate(), `Unknown session message type "foo"`)
+	s.Require().EqualError(SessionMessage{Version: 0, Type: SessionMessageAccepted}.Validate(), `Invalid protocol version 0 of session message "accepted"`)
+	s.Require().EqualError(SessionMessage{Version: 1, Type: SessionMessageSelection}.Validate(), `Session message "selection" is missing its payload`)
+	s.Require().ErrorContains(SessionMessage{Version: 1, Type: SessionMessageSelection, Payload: json.RawMessage(`[]`)}.Validate(), `Invalid payload of session message "selection"`)
+
+	// Messages of newer protocol versions are only sent to peers supporting them.
+	s.Require().True(SessionMessageAccepted.SupportedBy(SessionLegacyVersion))
+	s.Require().False(SessionMessageState.SupportedBy(SessionLegacyVersion))
+	s.Require().True(SessionMessageState.SupportedBy(SessionProtocolVersion))
+	s.Require().False(SessionMessageType("foo").SupportedBy(SessionProtocolVersion))
+	s.Require().False(SessionMessagePeerLeft.SupportedBy(1))
+	s.Require().True(SessionMessagePeerLeft.SupportedBy(SessionProtocolVersion))
+	s.Require().False(SessionMessageInitiator.SupportedBy(SessionLegacyVersion))
+	s.Require().False(SessionMessageSelection.SupportedBy(SessionLegacyVersion))
+}
+
+func (s *sessionMessageSuite) Test_LegacySession() {
+	cases := []struct {
+		msgType SessionMessageType
+		payload any
+		legacy  Session
+	}{
+		{
+			msgType: SessionMessageDetails,
+			payload: SessionDetails{SessionID: "abc", Passphrase: "foo bar"},
+			legacy:  Session{SessionID: "abc", Passphrase: "foo bar"},
+		},
+		{
+			msgType: SessionMessageIntent,
+			payload: SessionJoinIntent{Intent: SessionJoinPost{Name: "foo"}, Capabilities: &Capabilities{Disks: 1}},
+			legacy:  Session{Intent: SessionJoinPost{Name: "foo"}, IntentCapabilities: &Capabilities{Disks: 1}},
+		},
+		{
+			msgType: SessionMessageAccepted,
+			legacy:  Session{Accepted: true},
+		},
+		{
+			msgType: SessionMessageInitiatorConfirmed,
+			payload: SessionInitiator{Name: "foo", Address: "10.0.0.1", Fingerprint: "abc"},
+			legacy:  Session{InitiatorName: "foo", InitiatorAddress: "10.0.0.1", InitiatorFingerprint: "abc"},
+		},
+		{
+			msgType: SessionMessageBannedAddress,
+			payload: SessionBannedAddress{Address: "10.0.0.2"},
+			legacy:  Session{BannedAddress: "10.0.0.2"},
+		},
+		{
+			msgType: SessionMessageResult,
+			payload: SessionResult{Error: "failed"},
+			legacy:  Session{Error: "failed"},
+		},
+	}
+
+	for _, c := range cases {
+		msg, err := NewSessionMessage(c.msgType, c.payload)
+		s.Require().NoError(err)
+
+		legacy, err := msg.LegacySession()
+		s.Require().NoError(err)
+		s.Require().Equal(c.legacy, *legacy, c.msgType)
+	}
+
+	state, err := NewSessionMessage(SessionMessageState, SessionState{})
+	s.Require().NoError(err)
+
+	_, err = state.LegacySession()
+	s.Require().EqualError(err, `Session message "state" isn't supported by legacy peers`)
+
+	left, err := NewSessionMessage(SessionMessagePeerLeft, SessionPeer{Name: "foo", Address: "10.0.0.1"})
+	s.Require().NoError(err)
+
+	_, err = left.LegacySession()
+	s.Require().EqualError(err, `Session message "peer-left" isn't supported by legacy peers`)
+
+	// Legacy clients don't select the initiator so they aren't sent any of the eligible systems.
+	initiator, err := NewSessionMessage(SessionMessageInitiator, SessionInitiator{Name: "foo", Address: "10.0.0.1"})
+	s.Require().NoError(err)
+
+	_, err = initiator.LegacySession()
+	s.Require().EqualError(err, `Session message "initiator" isn't supported by legacy peers`)
+}
+
+func (s *sessionMessageSuite) Test_NewLegacySessionMessage() {
+	cases := []struct {
+		legacy  Session
+		msgType SessionMessageType
+		payload any
+	}{
+		{
+			legacy:  Session{Address: "10.0.0.1", Passphrase: "foo bar"},
+			msgType: SessionMessageStart,
+			payload: &Session{Address: "10.0.0.1", Passphrase: "foo bar"},
+		},
+		{
+			legacy:  Session{ConfirmedIntents: []SessionJoinPost{{Name: "foo"}}},
+			msgType: SessionMessageConfirmedIntents,
+			payload: &SessionConfirmedIntents{Intents: []SessionJoinPost{{Name: "foo"}}},
+		},
+		{
+			legacy:  Session{},
+			msgType: SessionMessageConfirmedIntents,
+			payload: &SessionConfirmedIntents{},
+		},
+	}
+
+	for _, c := range cases {
+		msg, err := NewLegacySessionMessage(c.legacy)
+		s.Require().NoError(err)
+		s.Require().Equal(SessionLegacyVersion, msg.Version)
+		s.Require().Equal(c.msgType, msg.Type)
+
+		payload := sessionMessageSpecs[c.msgType].payload()
+		s.Require().NoError(msg.Decode(payload))
+		s.Require().Equal(c.payload, payload)
+	}
+}
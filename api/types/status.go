@@ -25,4 +25,34 @@ type Status struct {
 
 	// OVNServices is a list of all ovn services running on this member.
 	OVNServices ovnTypes.Services `json:"ovn_services" yaml:"ovn_services"`
+
+	// Versions contains the version of each service installed on the member.
+	// It doesn't contain services whose version couldn't be determined.
+	Versions map[ServiceType]string `json:"versions" yaml:"versions"`
+
+	// Health is a list of issues found by checking the health of the services from this member.
+	Health []HealthFinding `json:"health" yaml:"health"`
+}
+
+// HealthSeverity is the severity of an issue found by checking the health of a service.
+type HealthSeverity string
+
+const (
+	// HealthWarning represents an issue which degrades the service.
+	HealthWarning HealthSeverity = "warning"
+
+	// HealthError represents an issue which prevents the service from working.
+	HealthError HealthSeverity = "error"
+)
+
+// HealthFinding represents an issue found by checking the health of a service.
+type HealthFinding struct {
+	// Service is the service the issue was found in.
+	Service ServiceType `json:"service" yaml:"service"`
+
+	// Severity is the severity of the issue.
+	Severity HealthSeverity `json:"severity" yaml:"severity"`
+
+	// Message describes the issue.
+	Message string `json:"message" yaml:"message"`
 }
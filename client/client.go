@@ -44,6 +44,68 @@ func StartSession(ctx context.Context, c *client.Client, role string, sessionTim
 	return conn, nil
 }
 
+// ResumeSession resumes the active session with the given ID and role whose client got disconnected
+// and returns the underlying websocket connection.
+func ResumeSession(ctx context.Context, c *client.Client, role string, id string) (*websocket.Conn, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	url := api.NewURL().Path("session", role).WithQuery("resume", id)
+	conn, err := c.Websocket(queryCtx, types.APIVersion, url)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to resume session websocket: %w", err)
+	}
+
+	return conn, nil
+}
+
+// GetSession returns the status of the active trust establishment session.
+func GetSession(ctx context.Context, c *client.Client) (*types.SessionStatus, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	status := types.SessionStatus{}
+	err := c.Query(queryCtx, "GET", types.APIVersion, api.NewURL().Path("session"), nil, &status)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get session: %w", err)
+	}
+
+	return &status, nil
+}
+
+// DeleteSession aborts the active trust establishment session.
+func DeleteSession(ctx context.Context, c *client.Client) error {
+	queryCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	err := c.Query(queryCtx, "DELETE", types.APIVersion, api.NewURL().Path("session"), nil, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to abort session: %w", err)
+	}
+
+	return nil
+}
+
+// GetSessionHistory returns the recorded lifecycle events of trust establishment sessions.
+// If a session ID is given, only the events of this session are returned.
+func GetSessionHistory(ctx context.Context, c *client.Client, sessionID string) ([]types.SessionEvent, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	path := api.NewURL().Path("session", "history")
+	if sessionID != "" {
+		path = path.WithQuery("session", sessionID)
+	}
+
+	events := []types.SessionEvent{}
+	err := c.Query(queryCtx, "GET", types.APIVersion, path, nil, &events)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get session history: %w", err)
+	}
+
+	return events, nil
+}
+
 // JoinServices sends join information to initiate the cluster join process.
 func JoinServices(ctx context.Context, c *client.Client, data types.ServicesPut) error {
 	queryCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
@@ -59,6 +121,44 @@ func JoinServices(ctx context.Context, c *client.Client, data types.ServicesPut)
 
 // JoinIntent sends the join intent to a potential cluster.
 func JoinIntent(ctx context.Context, c *client.Client, data types.SessionJoinPost) (*x509.Certificate, error) {
+	return sendJoinIntent(ctx, c, api.NewURL().Path("session", "join"), data)
+}
+
+// RedeemJoinToken sends the join intent to the system which issued the join token with the given ID.
+func RedeemJoinToken(ctx context.Context, c *client.Client, tokenID string, data types.SessionJoinPost) (*x509.Certificate, error) {
+	return sendJoinIntent(ctx, c, api.NewURL().Path("join-tokens", tokenID), data)
+}
+
+// GetJoinTokenStatus returns the redemption state of the join token with the given ID using the HMAC authorization of the client.
+func GetJoinTokenStatus(ctx context.Context, c *client.Client, tokenID string) (*types.JoinTokenStatus, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	status := types.JoinTokenStatus{}
+	err := c.Query(queryCtx, "GET", types.APIVersion, api.NewURL().Path("join-tokens", tokenID), nil, &status)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get join token status: %w", err)
+	}
+
+	return &status, nil
+}
+
+// IssueJoinToken issues a new out-of-band join token and returns it in its encoded form.
+func IssueJoinToken(ctx context.Context, c *client.Client, data types.JoinTokensPost) (string, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	var token string
+	err := c.Query(queryCtx, "POST", types.APIVersion, api.NewURL().Path("join-tokens"), data, &token)
+	if err != nil {
+		return "", fmt.Errorf("Failed to issue join token: %w", err)
+	}
+
+	return token, nil
+}
+
+// sendJoinIntent sends the join intent to the given path using the HMAC authorization of the client.
+func sendJoinIntent(ctx context.Context, c *client.Client, path *api.URL, data types.SessionJoinPost) (*x509.Certificate, error) {
 	queryCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
@@ -74,8 +174,6 @@ func JoinIntent(ctx context.Context, c *client.Client, data types.SessionJoinPos
 		return nil, fmt.Errorf("Failed to marshal join intent: %w", err)
 	}
 
-	path := api.NewURL().Path("session", "join")
-
 	// We can pass a reader to indicate to the query functions the body is already marshalled.
 	resp, err := c.QueryRaw(queryCtx, "POST", types.APIVersion, path, bytes.NewBuffer(dataBytes))
 	if err != nil {
@@ -95,6 +193,20 @@ func JoinIntent(ctx context.Context, c *client.Client, data types.SessionJoinPos
 	return resp.TLS.PeerCertificates[0], nil
 }
 
+// GetSessionCapabilities fetches the signed capabilities of a system taking part in a trust establishment session.
+func GetSessionCapabilities(ctx context.Context, c *client.Client) (*types.SessionCapabilities, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	capabilities := types.SessionCapabilities{}
+	err := c.Query(queryCtx, "GET", types.APIVersion, api.NewURL().Path("session", "capabilities"), nil, &capabilities)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get session capabilities: %w", err)
+	}
+
+	return &capabilities, nil
+}
+
 // RemoteIssueToken issues a token on the remote MicroCloud.
 func RemoteIssueToken(ctx context.Context, c *client.Client, serviceType types.ServiceType, data types.ServiceTokensPost) (string, error) {
 	queryCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
@@ -14,16 +14,65 @@ import (
 	"github.com/canonical/microcloud/microcloud/api/types"
 )
 
+// MinFingerprintLength is the minimum number of characters of a pinned certificate fingerprint.
+// It matches the length of the fingerprints displayed for comparison during trust establishment.
+const MinFingerprintLength = 12
+
 // AuthConfig is used to configure the various authentication settings during trust establishment.
 // In case of unverified mTLS, InsecureSkipVerify has to be set to true.
 // In case of partially verified mTLS, the remote servers certificate can be set using TLSServerCertificate.
+// In case of pinned mTLS, the expected fingerprint of the remote servers certificate can be set using TLSServerFingerprint.
 // Request authentication can be made by setting a valid HMAC.
 type AuthConfig struct {
 	HMAC                 string
 	TLSServerCertificate *x509.Certificate
+	TLSServerFingerprint string
 	InsecureSkipVerify   bool
 }
 
+// ValidateFingerprint returns an error if the given fingerprint cannot be used to pin a certificate.
+// Either the full fingerprint or a prefix of at least MinFingerprintLength characters is accepted.
+func ValidateFingerprint(fingerprint string) error {
+	if len(fingerprint) < MinFingerprintLength {
+		return fmt.Errorf("Fingerprint %q has to contain at least %d characters", fingerprint, MinFingerprintLength)
+	}
+
+	// A SHA-256 fingerprint is made of 64 hex characters.
+	if len(fingerprint) > 64 {
+		return fmt.Errorf("Fingerprint %q is longer than 64 characters", fingerprint)
+	}
+
+	for _, char := range strings.ToLower(fingerprint) {
+		if !strings.ContainsRune("0123456789abcdef", char) {
+			return fmt.Errorf("Fingerprint %q contains invalid character %q", fingerprint, char)
+		}
+	}
+
+	return nil
+}
+
+// MatchFingerprint returns true if the given certificate fingerprint matches the pinned one.
+func MatchFingerprint(fingerprint string, pinned string) bool {
+	return pinned != "" && strings.HasPrefix(fingerprint, strings.ToLower(pinned))
+}
+
+// verifyFingerprint returns a TLS connection verifier which ensures the servers certificate matches the pinned fingerprint.
+// It runs during the handshake so that no request is sent to a server presenting a different certificate.
+func verifyFingerprint(pinned string) func(state tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return fmt.Errorf("Server didn't present any certificate")
+		}
+
+		fingerprint := shared.CertFingerprint(state.PeerCertificates[0])
+		if !MatchFingerprint(fingerprint, pinned) {
+			return fmt.Errorf("Certificate fingerprint %q doesn't match the pinned fingerprint %q", fingerprint, pinned)
+		}
+
+		return nil
+	}
+}
+
 // UseAuthProxy takes the given microcluster client and HMAC and proxies requests to other services through the MicroCloud API.
 // The HMAC will be set in the Authorization header in lieu of mTLS authentication, if present.
 // If no HMAC is present mTLS is assumed.
@@ -39,6 +88,13 @@ func UseAuthProxy(c *client.Client, serviceType types.ServiceType, conf AuthConf
 	}
 
 	tp.TLSClientConfig.InsecureSkipVerify = conf.InsecureSkipVerify
+
+	// The certificate isn't known yet, so skip the regular verification in favor of comparing its fingerprint.
+	if conf.TLSServerFingerprint != "" {
+		tp.TLSClientConfig.InsecureSkipVerify = true
+		tp.TLSClientConfig.VerifyConnection = verifyFingerprint(conf.TLSServerFingerprint)
+	}
+
 	tp.Proxy = AuthProxy(conf.HMAC, serviceType)
 
 	c.Transport = tp
@@ -6,9 +6,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
 )
 
 // ControlClose represents a control close message to indicate an error
@@ -20,17 +25,40 @@ type ControlClose struct {
 	ControlMessage string `json:"control_message"`
 }
 
+// ErrPeerUnresponsive is the cause of a gateway's context being cancelled
+// if the other side didn't respond within the keepalive timeout.
+var ErrPeerUnresponsive = errors.New("Peer unresponsive")
+
 // WebsocketGateway represents a utility wrapper for websocket connections.
 type WebsocketGateway struct {
 	reader chan []byte
 	ctx    context.Context
+	cancel context.CancelCauseFunc
 
+	// The connection is nil while a resumable gateway waits for a new connection.
 	conn *websocket.Conn
 	// There can only be one writer on the connection at a time.
 	// In case the outer context gets cancelled there can be a situation
 	// in which writing the contexts error cause can collide
 	// with a normal write to the websocket.
 	writeLock sync.Mutex
+
+	// gracePeriod is the time a resumable gateway waits for a new connection.
+	gracePeriod time.Duration
+	attached    chan *websocket.Conn
+
+	// closing is set once our control close message is sent after which the gateway isn't resumed anymore.
+	closing bool
+
+	// peerVersion is the session protocol version of the other side learned from the messages it sent.
+	// Until then the other side is expected to use the current version.
+	peerVersion atomic.Int32
+
+	// keepalive contains the settings of the pings sent to the other side and of the read deadline.
+	// They are disabled until set using SetKeepalive or announced by the other side.
+	keepaliveLock    sync.Mutex
+	keepalive        types.SessionKeepalive
+	keepaliveChanged chan struct{}
 }
 
 // NewWebsocketGateway returns a new websocket wrapper allowing to easily write and consume
@@ -38,13 +66,31 @@ type WebsocketGateway struct {
 // It allows providing a context which is cancelled as soon as the underlying websocket connection
 // is closed by either side of the connection.
 func NewWebsocketGateway(ctx context.Context, conn *websocket.Conn) *WebsocketGateway {
+	return newWebsocketGateway(ctx, conn, 0)
+}
+
+// NewResumableWebsocketGateway returns a new websocket wrapper like NewWebsocketGateway
+// which survives the underlying websocket connection being closed without a control close message.
+// In this case it waits for the given grace period for a new connection to be attached using Attach
+// before its context gets cancelled.
+func NewResumableWebsocketGateway(ctx context.Context, conn *websocket.Conn, gracePeriod time.Duration) *WebsocketGateway {
+	return newWebsocketGateway(ctx, conn, gracePeriod)
+}
+
+func newWebsocketGateway(ctx context.Context, conn *websocket.Conn, gracePeriod time.Duration) *WebsocketGateway {
 	gw := &WebsocketGateway{
-		reader: make(chan []byte),
-		conn:   conn,
+		reader:      make(chan []byte),
+		conn:        conn,
+		gracePeriod: gracePeriod,
+		attached:    make(chan *websocket.Conn, 1),
+
+		keepaliveChanged: make(chan struct{}, 1),
 	}
 
 	gwCtx, gwCancel := context.WithCancelCause(ctx)
 	gw.ctx = gwCtx
+	gw.cancel = gwCancel
+	gw.peerVersion.Store(types.SessionProtocolVersion)
 
 	go func() {
 		<-gwCtx.Done()
@@ -54,23 +100,83 @@ func NewWebsocketGateway(ctx context.Context, conn *websocket.Conn) *WebsocketGa
 		_ = gw.WriteClose(context.Cause(gwCtx))
 
 		// Shutdown the read loop.
-		_ = gw.conn.Close()
+		gw.writeLock.Lock()
+		if gw.conn != nil {
+			_ = gw.conn.Close()
+		}
+
+		gw.writeLock.Unlock()
 	}()
 
+	// Messages are queued so that the read loop keeps answering pings
+	// while the consumer of the gateway is busy.
+	// The inner context is cancelled once all the received messages are consumed.
+	incoming := make(chan []byte)
+	var readErr error
 	go func() {
 		defer close(gw.reader)
 
+		queue := [][]byte{}
+		for {
+			var next []byte
+			var reader chan []byte
+			if len(queue) > 0 {
+				next = queue[0]
+				reader = gw.reader
+			}
+
+			select {
+			case data, ok := <-incoming:
+				if ok {
+					queue = append(queue, data)
+					continue
+				}
+
+				for _, data := range queue {
+					gw.reader <- data
+				}
+
+				// Cancel the inner context too with the respective error.
+				gwCancel(readErr)
+				return
+			case reader <- next:
+				queue = queue[1:]
+			}
+		}
+	}()
+
+	go gw.ping()
+
+	go func() {
+		defer close(incoming)
+
+		gw.watchConn(conn)
 		for {
+			_ = conn.SetReadDeadline(gw.readDeadline())
 			_, reader, err := conn.ReadMessage()
 			if err != nil {
+				// The websocket library hides the original error of the read deadline.
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					err = ErrPeerUnresponsive
+				}
+
 				// If the connection got closed due to the outer context, return this error instead.
 				if ctx.Err() != nil {
 					// Try to use the cause from the outer context if present.
 					err = context.Cause(ctx)
 				}
 
-				// Cancel the inner context too with the respective error.
-				defer gwCancel(err)
+				// Wait for the connection to be resumed unless the outer context is done.
+				if ctx.Err() == nil && gw.gracePeriod > 0 {
+					conn, err = gw.waitAttach(conn, err)
+					if err == nil {
+						gw.watchConn(conn)
+						continue
+					}
+				}
+
+				readErr = err
 				return
 			}
 
@@ -82,18 +188,224 @@ func NewWebsocketGateway(ctx context.Context, conn *websocket.Conn) *WebsocketGa
 			decoder.DisallowUnknownFields()
 			err = decoder.Decode(&controlClose)
 			if err == nil {
-				// Cancel the inner context with the respective error.
-				defer gwCancel(errors.New(controlClose.ControlMessage))
+				readErr = errors.New(controlClose.ControlMessage)
 				return
 			}
 
-			gw.reader <- reader
+			// The keepalive settings announced by the other side are applied right away.
+			if gw.applyKeepalive(reader) {
+				continue
+			}
+
+			incoming <- reader
 		}
 	}()
 
 	return gw
 }
 
+// SetKeepalive sets the interval of the pings sent to the other side and the timeout
+// after which the gateway's context is cancelled with ErrPeerUnresponsive if the other side didn't respond.
+// A zero interval disables the pings and a zero timeout the read deadline.
+func (w *WebsocketGateway) SetKeepalive(keepalive types.SessionKeepalive) {
+	w.keepaliveLock.Lock()
+	w.keepalive = keepalive
+	w.keepaliveLock.Unlock()
+
+	select {
+	case w.keepaliveChanged <- struct{}{}:
+	default:
+	}
+
+	w.writeLock.Lock()
+	if w.conn != nil {
+		_ = w.conn.SetReadDeadline(w.readDeadline())
+	}
+
+	w.writeLock.Unlock()
+}
+
+// Keepalive returns the keepalive settings of the gateway.
+func (w *WebsocketGateway) Keepalive() types.SessionKeepalive {
+	w.keepaliveLock.Lock()
+	defer w.keepaliveLock.Unlock()
+
+	return w.keepalive
+}
+
+// applyKeepalive applies the keepalive settings if the given data contains a keepalive message
+// and returns whether or not it did.
+func (w *WebsocketGateway) applyKeepalive(data []byte) bool {
+	msg := types.SessionMessage{}
+	err := json.Unmarshal(data, &msg)
+	if err != nil || msg.Type != types.SessionMessageKeepalive {
+		return false
+	}
+
+	keepalive := types.SessionKeepalive{}
+	err = msg.Decode(&keepalive)
+	if err != nil {
+		return false
+	}
+
+	w.SetKeepalive(keepalive)
+
+	return true
+}
+
+// readDeadline returns the deadline until which the other side has to send a message or respond to a ping.
+// It's zero if the timeout is disabled.
+func (w *WebsocketGateway) readDeadline() time.Time {
+	timeout := w.Keepalive().Timeout
+	if timeout == 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(timeout)
+}
+
+// watchConn extends the read deadline of the given connection whenever the other side responds to a ping.
+func (w *WebsocketGateway) watchConn(conn *websocket.Conn) {
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(w.readDeadline())
+	})
+}
+
+// ping sends pings to the other side in the keepalive interval until the gateway's context is cancelled.
+func (w *WebsocketGateway) ping() {
+	for {
+		changed := w.pingEvery(w.Keepalive().Interval)
+		if !changed {
+			return
+		}
+	}
+}
+
+// pingEvery sends pings to the other side in the given interval until the keepalive settings change
+// in which case it returns true, or the gateway's context is cancelled.
+func (w *WebsocketGateway) pingEvery(interval time.Duration) bool {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+			// Failing pings are detected by the read loop.
+			w.writeLock.Lock()
+			if w.conn != nil {
+				_ = w.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval))
+			}
+
+			w.writeLock.Unlock()
+		case <-w.keepaliveChanged:
+			return true
+		case <-w.ctx.Done():
+			return false
+		}
+	}
+}
+
+// waitAttach detaches the given closed connection from the gateway
+// and waits for a new connection to be attached within the grace period.
+// The given read error is returned right away if the gateway is already closing.
+func (w *WebsocketGateway) waitAttach(conn *websocket.Conn, readErr error) (*websocket.Conn, error) {
+	w.writeLock.Lock()
+	closing := w.closing
+	w.conn = nil
+	w.writeLock.Unlock()
+
+	_ = conn.Close()
+
+	if closing {
+		return nil, readErr
+	}
+
+	timer := time.NewTimer(w.gracePeriod)
+	defer timer.Stop()
+
+	select {
+	case conn := <-w.attached:
+		return conn, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("Client didn't resume the session within %s", w.gracePeriod)
+	case <-w.ctx.Done():
+		return nil, context.Cause(w.ctx)
+	}
+}
+
+// Detached returns true if the gateway is resumable and waits for a new connection to be attached.
+func (w *WebsocketGateway) Detached() bool {
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
+
+	return w.gracePeriod > 0 && w.conn == nil && !w.closing && w.ctx.Err() == nil
+}
+
+// Attach attaches the given connection to a resumable gateway whose previous connection got closed.
+// The messages returned by replay are written to the new connection before any other message
+// which allows restoring the state on the other side.
+// Messages written while there isn't any connection attached are dropped.
+func (w *WebsocketGateway) Attach(conn *websocket.Conn, replay func() ([]any, error)) error {
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
+
+	if w.gracePeriod == 0 {
+		return errors.New("Connection cannot be resumed")
+	}
+
+	if w.ctx.Err() != nil {
+		return context.Cause(w.ctx)
+	}
+
+	if w.closing {
+		return errors.New("Connection is closing")
+	}
+
+	if w.conn != nil {
+		return errors.New("Connection is still in use")
+	}
+
+	msgs, err := replay()
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range msgs {
+		err := conn.WriteJSON(msg)
+		if err != nil {
+			return fmt.Errorf("Failed to replay message: %w", err)
+		}
+	}
+
+	select {
+	case w.attached <- conn:
+	default:
+		return errors.New("Another connection is already getting attached")
+	}
+
+	w.conn = conn
+
+	return nil
+}
+
+// Connected returns whether or not the gateway currently has a connection.
+func (w *WebsocketGateway) Connected() bool {
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
+
+	return w.conn != nil
+}
+
+// PeerVersion returns the session protocol version of the other side learned from the messages it sent.
+// Until the other side sent a message, the current version is returned.
+func (w *WebsocketGateway) PeerVersion() int {
+	return int(w.peerVersion.Load())
+}
+
 // Receive returns the inner channel which allows reading from the websocket connection.
 // If used together with other channels ensure to also consume the gateway's context
 // in order to get informed about a potentially closed connection.
@@ -127,6 +439,7 @@ func (w *WebsocketGateway) Context() context.Context {
 }
 
 // Write writes the given data onto the websocket connection.
+// The data is dropped if a resumable gateway currently doesn't have a connection.
 func (w *WebsocketGateway) Write(v any) error {
 	w.writeLock.Lock()
 	defer w.writeLock.Unlock()
@@ -135,14 +448,143 @@ func (w *WebsocketGateway) Write(v any) error {
 		return context.Cause(w.ctx)
 	}
 
+	if w.conn == nil {
+		return nil
+	}
+
 	return w.conn.WriteJSON(v)
 }
 
+// WriteMessage writes a session message of the given type with the given payload onto the websocket connection.
+// Messages whose type isn't supported by the protocol version of the other side are dropped.
+// If the other side doesn't support the message envelope, the message is written in the legacy format.
+func (w *WebsocketGateway) WriteMessage(msgType types.SessionMessageType, payload any) error {
+	msg, err := types.NewSessionMessage(msgType, payload)
+	if err != nil {
+		return err
+	}
+
+	return w.WriteSessionMessage(*msg)
+}
+
+// WriteSessionMessage writes the given session message onto the websocket connection like WriteMessage.
+func (w *WebsocketGateway) WriteSessionMessage(msg types.SessionMessage) error {
+	version := int(w.peerVersion.Load())
+	if !msg.Type.SupportedBy(version) {
+		return nil
+	}
+
+	if version == types.SessionLegacyVersion {
+		legacy, err := msg.LegacySession()
+		if err != nil {
+			return err
+		}
+
+		return w.Write(legacy)
+	}
+
+	return w.Write(msg)
+}
+
+// ParseMessage returns the validated session message contained in the given data received from the websocket connection.
+// Messages of the other side not supporting the message envelope are converted from the legacy format.
+// The protocol version used for writing messages is lowered to the one of the other side.
+func (w *WebsocketGateway) ParseMessage(data []byte) (*types.SessionMessage, error) {
+	msg := types.SessionMessage{}
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse session message: %w", err)
+	}
+
+	// Legacy messages don't have a type.
+	if msg.Type == "" {
+		session := types.Session{}
+		err := json.Unmarshal(data, &session)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse legacy session message: %w", err)
+		}
+
+		w.peerVersion.Store(types.SessionLegacyVersion)
+
+		return types.NewLegacySessionMessage(session)
+	}
+
+	err = msg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	w.peerVersion.Store(int32(min(msg.Version, types.SessionProtocolVersion)))
+
+	return &msg, nil
+}
+
+// ReceiveMessage reads the next session message from the websocket connection.
+// It's waiting on both the websocket connection and either of the contexts and returns
+// whatever is returning/cancelled first.
+func (w *WebsocketGateway) ReceiveMessage(ctx context.Context) (*types.SessionMessage, error) {
+	select {
+	case bytes, ok := <-w.Receive():
+		// The connection got closed.
+		if !ok {
+			return nil, context.Cause(w.ctx)
+		}
+
+		return w.ParseMessage(bytes)
+	case <-w.ctx.Done():
+		return nil, context.Cause(w.ctx)
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
+
+// ReceivePayload reads the next session message from the websocket connection like ReceiveMessage.
+// The message has to be of the given type and its payload is unmarshalled into v.
+func (w *WebsocketGateway) ReceivePayload(ctx context.Context, msgType types.SessionMessageType, v any) error {
+	msg, err := w.ReceiveMessage(ctx)
+	if err != nil {
+		return err
+	}
+
+	return decodePayload(*msg, msgType, v)
+}
+
+// ParsePayload parses the session message contained in the given data like ParseMessage.
+// The message has to be of the given type and its payload is unmarshalled into v.
+func (w *WebsocketGateway) ParsePayload(data []byte, msgType types.SessionMessageType, v any) error {
+	msg, err := w.ParseMessage(data)
+	if err != nil {
+		return err
+	}
+
+	return decodePayload(*msg, msgType, v)
+}
+
+// decodePayload unmarshals the payload of the given message into v if the message is of the given type.
+func decodePayload(msg types.SessionMessage, msgType types.SessionMessageType, v any) error {
+	if msg.Type != msgType {
+		return fmt.Errorf("Received session message %q instead of %q", msg.Type, msgType)
+	}
+
+	return msg.Decode(v)
+}
+
 // WriteClose sends our websocket control close message.
 // Unlike the actual websocket control close message this supports message longer than 125 bytes
 // as well as special characters.
 // It waits for the other side to hang up or the gateway's context being cancelled.
+// If a resumable gateway currently doesn't have a connection, its context is cancelled right away.
 func (w *WebsocketGateway) WriteClose(err error) error {
+	w.writeLock.Lock()
+	w.closing = true
+	connected := w.conn != nil
+	w.writeLock.Unlock()
+
+	if !connected {
+		w.cancel(err)
+		return nil
+	}
+
 	writeErr := w.Write(ControlClose{
 		ControlMessage: err.Error(),
 	})
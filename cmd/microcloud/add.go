@@ -8,7 +8,6 @@ import (
 	"github.com/canonical/microcluster/v2/microcluster"
 	"github.com/spf13/cobra"
 
-	"github.com/canonical/microcloud/microcloud/api"
 	"github.com/canonical/microcloud/microcloud/api/types"
 	cloudClient "github.com/canonical/microcloud/microcloud/client"
 	"github.com/canonical/microcloud/microcloud/multicast"
@@ -18,7 +17,15 @@ import (
 type cmdAdd struct {
 	common *CmdControl
 
-	flagSessionTimeout int64
+	flagSessionTimeout   int64
+	flagSeeds            []string
+	flagLookupInterfaces []string
+	flagApprovedJoiners  []string
+	flagSessionAuth      sessionAuthFlags
+	flagKeepalive        keepaliveFlags
+	flagResume           string
+	flagToken            bool
+	flagTokenExpiry      int64
 }
 
 func (c *cmdAdd) Command() *cobra.Command {
@@ -29,6 +36,14 @@ func (c *cmdAdd) Command() *cobra.Command {
 	}
 
 	cmd.Flags().Int64Var(&c.flagSessionTimeout, "session-timeout", 0, "Amount of seconds to wait for the trust establishment session. Defaults: 60m")
+	cmd.Flags().StringSliceVar(&c.flagSeeds, "seed", nil, "Address or CIDR range of systems outside of the local network segment to probe (can be given multiple times)")
+	cmd.Flags().StringSliceVar(&c.flagLookupInterfaces, "lookup-interface", nil, "Additional interface on which other systems can find this one (can be given multiple times)")
+	cmd.Flags().StringSliceVar(&c.flagApprovedJoiners, "approved-joiner", nil, "Fingerprint of a system allowed to join without confirmation in the form [<name>=]<fingerprint> (can be given multiple times)")
+	c.flagSessionAuth.register(cmd)
+	c.flagKeepalive.register(cmd)
+	cmd.Flags().StringVar(&c.flagResume, "resume", "", "ID of a running trust establishment session to resume after its client got disconnected")
+	cmd.Flags().BoolVar(&c.flagToken, "token", false, "Issue a single-use join token for a system joining later with \"microcloud join <token>\" instead of starting a session")
+	cmd.Flags().Int64Var(&c.flagTokenExpiry, "token-expiry", 0, "Amount of seconds for which the join token can be redeemed. Defaults: 24h")
 
 	return cmd
 }
@@ -38,8 +53,37 @@ func (c *cmdAdd) Run(cmd *cobra.Command, args []string) error {
 		return cmd.Help()
 	}
 
+	if c.flagToken {
+		return c.issueJoinToken()
+	}
+
+	_, err := multicast.ExpandSeeds(c.flagSeeds)
+	if err != nil {
+		return err
+	}
+
+	ifaceNames, err := lookupInterfaces(c.flagLookupInterfaces, false)
+	if err != nil {
+		return err
+	}
+
+	approvedJoiners, err := parseApprovedJoiners(c.flagApprovedJoiners)
+	if err != nil {
+		return err
+	}
+
+	sessionAuth, err := c.flagSessionAuth.sessionAuth()
+	if err != nil {
+		return err
+	}
+
+	keepalive, err := c.flagKeepalive.keepalive()
+	if err != nil {
+		return err
+	}
+
 	fmt.Println("Waiting for services to start ...")
-	err := checkInitialized(c.common.FlagMicroCloudDir, true, false)
+	err = checkInitialized(c.common.FlagMicroCloudDir, true, false)
 	if err != nil {
 		return err
 	}
@@ -58,6 +102,13 @@ func (c *cmdAdd) Run(cmd *cobra.Command, args []string) error {
 		cfg.sessionTimeout = time.Duration(c.flagSessionTimeout) * time.Second
 	}
 
+	cfg.lookupSeeds = c.flagSeeds
+	cfg.lookupInterfaces = ifaceNames
+	cfg.approvedJoiners = approvedJoiners
+	cfg.sessionAuth = sessionAuth
+	cfg.keepalive = keepalive
+	cfg.resumeSession = c.flagResume
+
 	cloudApp, err := microcluster.App(microcluster.Args{StateDir: c.common.FlagMicroCloudDir})
 	if err != nil {
 		return err
@@ -75,13 +126,9 @@ func (c *cmdAdd) Run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	installedServices := []types.ServiceType{types.MicroCloud, types.LXD}
-	optionalServices := map[types.ServiceType]string{
-		types.MicroCeph: api.MicroCephDir,
-		types.MicroOVN:  api.MicroOVNDir,
-	}
+	installedServices := service.RequiredServices()
 
-	installedServices, err = cfg.askMissingServices(installedServices, optionalServices)
+	installedServices, err = cfg.askMissingServices(installedServices)
 	if err != nil {
 		return err
 	}
@@ -92,7 +139,7 @@ func (c *cmdAdd) Run(cmd *cobra.Command, args []string) error {
 	}
 
 	services := make(map[types.ServiceType]string, len(installedServices))
-	for _, s := range s.Services {
+	for _, s := range s.Services() {
 		version, err := s.GetVersion(context.Background())
 		if err != nil {
 			return err
@@ -196,3 +243,32 @@ func (c *cmdAdd) Run(cmd *cobra.Command, args []string) error {
 
 	return cfg.setupCluster(s)
 }
+
+// issueJoinToken issues an out-of-band join token on the local system.
+// The system redeeming the token joins without a session as the daemon handles the join.
+func (c *cmdAdd) issueJoinToken() error {
+	if c.flagTokenExpiry < 0 {
+		return fmt.Errorf("Join token expiry cannot be negative")
+	}
+
+	localClient, err := sessionClient(c.common)
+	if err != nil {
+		return err
+	}
+
+	encodedToken, err := cloudClient.IssueJoinToken(context.Background(), localClient, types.JoinTokensPost{Expiry: time.Duration(c.flagTokenExpiry) * time.Second})
+	if err != nil {
+		return err
+	}
+
+	token, err := types.DecodeJoinToken(encodedToken)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Issued a join token which can be redeemed once until %s.\n", token.ExpiresAt.Local().Format(time.RFC3339))
+	fmt.Println("Run the following command on the system which should join the cluster:")
+	fmt.Printf("\n microcloud join %s\n\n", encodedToken)
+
+	return nil
+}
@@ -3,11 +3,12 @@ package main
 import (
 	"context"
 	"crypto/x509"
-	"encoding/json"
 	"fmt"
 	"net"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/canonical/lxd/client"
@@ -19,9 +20,9 @@ import (
 	"github.com/canonical/lxd/shared/validate"
 	cephTypes "github.com/canonical/microceph/microceph/api/types"
 
-	cloudAPI "github.com/canonical/microcloud/microcloud/api"
 	"github.com/canonical/microcloud/microcloud/api/types"
 	cloudClient "github.com/canonical/microcloud/microcloud/client"
+	"github.com/canonical/microcloud/microcloud/cmd/tui"
 	"github.com/canonical/microcloud/microcloud/multicast"
 	"github.com/canonical/microcloud/microcloud/service"
 )
@@ -29,19 +30,14 @@ import (
 func checkInitialized(stateDir string, expectInitialized bool, preseed bool) error {
 	cfg := initConfig{autoSetup: true}
 
-	installedServices := []types.ServiceType{types.MicroCloud, types.LXD}
+	installedServices := service.RequiredServices()
 
 	// MicroCloud will automatically set up previously-uninitialized services,
 	// and incorporate already-initialized services in interactive setup,
 	// so we can ignore optional services unless using preseed.
 	if preseed {
-		optionalServices := map[types.ServiceType]string{
-			types.MicroCeph: cloudAPI.MicroCephDir,
-			types.MicroOVN:  cloudAPI.MicroOVNDir,
-		}
-
 		var err error
-		installedServices, err = cfg.askMissingServices(installedServices, optionalServices)
+		installedServices, err = cfg.askMissingServices(installedServices)
 		if err != nil {
 			return err
 		}
@@ -52,8 +48,8 @@ func checkInitialized(stateDir string, expectInitialized bool, preseed bool) err
 		return err
 	}
 
-	return s.RunConcurrent("", "", func(s service.Service) error {
-		initialized, err := s.IsInitialized(context.Background())
+	return s.RunConcurrent(context.Background(), func(ctx context.Context, s service.Service) error {
+		initialized, err := s.IsInitialized(ctx)
 		if err != nil {
 			return err
 		}
@@ -152,13 +148,13 @@ func (c *initConfig) askRetry(question string, f func() error) error {
 	}
 }
 
-func (c *initConfig) askMissingServices(services []types.ServiceType, stateDirs map[types.ServiceType]string) ([]types.ServiceType, error) {
+func (c *initConfig) askMissingServices(services []types.ServiceType) ([]types.ServiceType, error) {
 	missingServices := []string{}
-	for serviceType, stateDir := range stateDirs {
-		if service.Exists(serviceType, stateDir) {
-			services = append(services, serviceType)
+	for _, def := range service.OptionalServices() {
+		if def.Installed() {
+			services = append(services, def.Type)
 		} else {
-			missingServices = append(missingServices, string(serviceType))
+			missingServices = append(missingServices, string(def.Type))
 		}
 	}
 
@@ -356,7 +352,7 @@ func (c *initConfig) askLocalPool(sh *service.Handler) error {
 		return nil
 	}
 
-	lxd := sh.Services[types.LXD].(*service.LXDService)
+	lxd := sh.Services()[types.LXD].(*service.LXDService)
 	toWipe := map[string]string{}
 	wipeable, err := lxd.HasExtension(context.Background(), lxd.Name(), lxd.Address(), nil, "storage_pool_source_wipe")
 	if err != nil {
@@ -543,7 +539,7 @@ func (c *initConfig) validateCephInterfacesForSubnet(lxdService *service.LXDServ
 // getTargetCephNetworks fetches the Ceph network configuration from the existing Ceph cluster.
 // If the system passed as an argument is nil, we will fetch the local Ceph network configuration.
 func getTargetCephNetworks(sh *service.Handler, s *InitSystem) (publicCephNetwork *net.IPNet, internalCephNetwork *net.IPNet, err error) {
-	microCephService := sh.Services[types.MicroCeph].(*service.CephService)
+	microCephService := sh.Services()[types.MicroCeph].(*service.CephService)
 	if microCephService == nil {
 		return nil, nil, fmt.Errorf("Failed to get MicroCeph service")
 	}
@@ -587,7 +583,7 @@ func getTargetCephNetworks(sh *service.Handler, s *InitSystem) (publicCephNetwor
 
 func (c *initConfig) askRemotePool(sh *service.Handler) error {
 	// If MicroCeph is not installed, skip this block entirely.
-	if sh.Services[types.MicroCeph] == nil {
+	if sh.Services()[types.MicroCeph] == nil {
 		return nil
 	}
 
@@ -795,7 +791,7 @@ func (c *initConfig) askRemotePool(sh *service.Handler) error {
 	// If a cephfs pool has already been set up, we will extend it automatically, so no need to ask the question.
 	setupCephFS := useJoinConfigRemoteFS
 	if !useJoinConfigRemoteFS {
-		lxd := sh.Services[types.LXD].(*service.LXDService)
+		lxd := sh.Services()[types.LXD].(*service.LXDService)
 		ext := "storage_cephfs_create_missing"
 		hasCephFS, err := lxd.HasExtension(context.Background(), lxd.Name(), lxd.Address(), nil, ext)
 		if err != nil {
@@ -830,7 +826,7 @@ func (c *initConfig) askRemotePool(sh *service.Handler) error {
 	joinConfigs := map[string][]api.ClusterMemberConfigKey{}
 	finalConfigs := []api.StoragePoolsPost{}
 	targetConfigs := map[string][]api.StoragePoolsPost{}
-	lxd := sh.Services[types.LXD].(*service.LXDService)
+	lxd := sh.Services()[types.LXD].(*service.LXDService)
 	if useJoinConfigRemote {
 		for target := range askSystemsRemote {
 			if joinConfigs[target] == nil {
@@ -915,7 +911,7 @@ func (c *initConfig) askRemotePool(sh *service.Handler) error {
 }
 
 func (c *initConfig) askOVNNetwork(sh *service.Handler) error {
-	if sh.Services[types.MicroOVN] == nil {
+	if sh.Services()[types.MicroOVN] == nil {
 		return nil
 	}
 
@@ -1093,7 +1089,7 @@ func (c *initConfig) askOVNNetwork(sh *service.Handler) error {
 		}
 	}
 
-	lxd := sh.Services[types.LXD].(*service.LXDService)
+	lxd := sh.Services()[types.LXD].(*service.LXDService)
 	joinConfigs := map[string]api.ClusterMemberConfigKey{}
 	targetConfigs := map[string]api.NetworksPost{}
 	finalConfigs := []api.NetworksPost{}
@@ -1300,7 +1296,7 @@ func (c *initConfig) askNetwork(sh *service.Handler) error {
 	}
 
 	if !useFANJoinConfig {
-		lxd := sh.Services[types.LXD].(*service.LXDService)
+		lxd := sh.Services()[types.LXD].(*service.LXDService)
 		fan, err := lxd.DefaultFanNetwork()
 		if err != nil {
 			return err
@@ -1374,7 +1370,7 @@ func (c *initConfig) askCephNetwork(sh *service.Handler) error {
 		}
 	}
 
-	lxd := sh.Services[types.LXD].(*service.LXDService)
+	lxd := sh.Services()[types.LXD].(*service.LXDService)
 	if internalCephNetwork != nil {
 		if internalCephNetwork.String() != "" && internalCephNetwork.String() != c.lookupSubnet.String() {
 			err := c.validateCephInterfacesForSubnet(lxd, availableCephNetworkInterfaces, internalCephNetwork.String())
@@ -1478,7 +1474,7 @@ func (c *initConfig) askClustered(s *service.Handler, expectedServices map[types
 				}
 
 				if addOrSkip != "add" {
-					delete(s.Services, serviceType)
+					s.RemoveService(serviceType)
 				}
 
 				break
@@ -1511,7 +1507,7 @@ func (c *initConfig) askPassphrase(s *service.Handler) (string, error) {
 		return nil
 	}
 
-	cloud := s.Services[types.MicroCloud].(*service.CloudService)
+	cloud := s.Services()[types.MicroCloud].(*service.CloudService)
 	cert, err := cloud.ServerCert()
 	if err != nil {
 		return "", err
@@ -1533,13 +1529,229 @@ func (c *initConfig) askPassphrase(s *service.Handler) (string, error) {
 	return password, nil
 }
 
+// askInitiator renders the eligible systems found during lookup and returns the address of the selected one.
+// If the setup is automatic, the first system with the given name is selected.
+// If the name is empty, the first system found is selected.
+// If the initiator's fingerprint is pinned, systems using a different certificate are skipped during automatic setup.
+// Systems which don't support any common discovery version are greyed out together with the reason and cannot be selected.
+func (c *initConfig) askInitiator(gw *cloudClient.WebsocketGateway, initiatorName string) (string, error) {
+	if c.autoSetup {
+		timeout := time.After(c.lookupTimeout)
+		for {
+			select {
+			case bytes := <-gw.Receive():
+				initiator := types.SessionInitiator{}
+				err := gw.ParsePayload(bytes, types.SessionMessageInitiator, &initiator)
+				if err != nil {
+					logger.Error("Failed to read eligible system", logger.Ctx{"err": err})
+					break
+				}
+
+				if initiatorName != "" && initiator.Name != initiatorName {
+					break
+				}
+
+				// Skip systems which cannot be joined anyway due to a different certificate.
+				if c.initiatorFingerprint != "" && !cloudClient.MatchFingerprint(initiator.Fingerprint, c.initiatorFingerprint) {
+					logger.Warn("Skipping system not matching the pinned fingerprint", logger.Ctx{"name": initiator.Name, "address": initiator.Address})
+					break
+				}
+
+				if initiator.Incompatible != "" {
+					logger.Warn("Skipping incompatible system", logger.Ctx{"name": initiator.Name, "address": initiator.Address, "reason": initiator.Incompatible})
+					break
+				}
+
+				return initiator.Address, nil
+
+			case <-timeout:
+				if initiatorName != "" {
+					return "", fmt.Errorf("System %q hasn't been found", initiatorName)
+				}
+
+				return "", fmt.Errorf("No eligible system has been found")
+			case <-gw.Context().Done():
+				return "", fmt.Errorf("Failed to find an eligible system: %w", context.Cause(gw.Context()))
+			}
+		}
+	}
+
+	header := append([]string{"NAME", "ADDRESS", "INTERFACE", "FINGERPRINT", "VERSION"}, capabilitiesHeader...)
+	var table *SelectableTable
+
+	// The cells of incompatible systems are colored, so keep track of the actual address behind each rendered one.
+	var lock sync.Mutex
+	addresses := map[string]string{}
+	incompatible := map[string]types.SessionInitiator{}
+
+	rendered := make(chan error)
+
+	renderCtx, renderCancel := context.WithCancel(gw.Context())
+	defer renderCancel()
+
+	go func() {
+		for {
+			select {
+			case bytes := <-gw.Receive():
+				initiator := types.SessionInitiator{}
+				err := gw.ParsePayload(bytes, types.SessionMessageInitiator, &initiator)
+				if err != nil {
+					logger.Error("Failed to read eligible system", logger.Ctx{"err": err})
+					break
+				}
+
+				fingerprint, err := c.shortFingerprint(initiator.Fingerprint)
+				if err != nil {
+					logger.Error("Failed to shorten fingerprint", logger.Ctx{"err": err})
+				}
+
+				version := initiator.Version
+				if initiator.Incompatible != "" {
+					version = initiator.Incompatible
+				}
+
+				row := append([]string{initiator.Name, initiator.Address, initiator.Interface, fingerprint, version}, capabilitiesRow(initiator.Capabilities)...)
+				if initiator.Incompatible != "" {
+					for i := range row {
+						row[i] = tui.SetColor(tui.Grey, row[i], false)
+					}
+				}
+
+				lock.Lock()
+
+				// Skip systems replayed again after resuming the session.
+				_, ok := addresses[row[1]]
+				if ok {
+					lock.Unlock()
+					break
+				}
+
+				addresses[row[1]] = initiator.Address
+				if initiator.Incompatible != "" {
+					incompatible[initiator.Address] = initiator
+				}
+
+				lock.Unlock()
+
+				if table == nil {
+					table = NewSelectableTable(header, [][]string{row})
+					err := table.Render(table.rows)
+					if err != nil {
+						logger.Error("Failed to render table", logger.Ctx{"err": err})
+					}
+
+					rendered <- nil
+				} else {
+					table.Update(row)
+				}
+
+			case <-renderCtx.Done():
+				return
+			}
+		}
+	}()
+
+	// Wait until the table got rendered.
+	// This is important otherwise the table might not be selectable
+	// as it's being built in a go routine.
+	select {
+	case <-rendered:
+	case <-gw.Context().Done():
+		return "", fmt.Errorf("Failed to find an eligible system: %w", context.Cause(gw.Context()))
+	}
+
+	var answers []string
+	var address string
+	retry := false
+	err := c.askRetry("Retry selecting the system?", func() error {
+		defer func() {
+			retry = true
+		}()
+
+		fmt.Println("Select the system you want to join:")
+
+		if retry {
+			err := table.Render(table.rows)
+			if err != nil {
+				return fmt.Errorf("Failed to render table: %w", err)
+			}
+		}
+
+		var err error
+		answers, err = table.GetSelections()
+		if err != nil {
+			return fmt.Errorf("Failed to get system selection: %w", err)
+		}
+
+		if len(answers) != 1 {
+			return fmt.Errorf("Exactly one system has to be selected")
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		address = addresses[table.SelectionValue(answers[0], "ADDRESS")]
+		initiator, ok := incompatible[address]
+		if ok {
+			return fmt.Errorf("System %q at %q is incompatible: %s", initiator.Name, initiator.Address, initiator.Incompatible)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return address, nil
+}
+
+// capabilitiesHeader contains the table columns summarizing the capabilities of a system.
+var capabilitiesHeader = []string{"DISKS", "CPUS", "MEMORY", "UPLINKS"}
+
+// capabilitiesRow returns the values of the capabilities columns for the given capabilities.
+// The values are left empty if the capabilities are unknown.
+func capabilitiesRow(capabilities *types.Capabilities) []string {
+	if capabilities == nil {
+		return make([]string, len(capabilitiesHeader))
+	}
+
+	return []string{
+		strconv.Itoa(capabilities.Disks),
+		strconv.FormatUint(capabilities.CPUs, 10),
+		units.GetByteSizeStringIEC(int64(capabilities.Memory), 2),
+		strings.Join(capabilities.UplinkInterfaces, ","),
+	}
+}
+
 func (c *initConfig) askJoinIntents(gw *cloudClient.WebsocketGateway, expectedSystems []string) ([]types.SessionJoinPost, error) {
-	header := []string{"NAME", "ADDRESS", "FINGERPRINT"}
+	header := append([]string{"NAME", "ADDRESS", "FINGERPRINT"}, capabilitiesHeader...)
 	var table *SelectableTable
 
 	rendered := make(chan error)
 	joinIntents := make(map[string]types.SessionJoinPost)
 
+	// Banned addresses cannot be printed while the table is rendered so they are printed after the selection.
+	var bannedLock sync.Mutex
+	var banned []string
+
+	// The rows of systems which left the session are colored, so keep track of the actual name behind each rendered one.
+	var leftLock sync.Mutex
+	rows := map[string][]string{}
+	rowIndexes := map[string]int{}
+	names := map[string]string{}
+	left := map[string]bool{}
+
+	// peerLeft returns the system whose liveness is reported in the given message and whether or not it left the session.
+	peerLeft := func(msg types.SessionMessage) (string, bool, error) {
+		peer := types.SessionPeer{}
+		err := msg.Decode(&peer)
+		if err != nil {
+			return "", false, err
+		}
+
+		return peer.Name, msg.Type == types.SessionMessagePeerLeft, nil
+	}
+
 	renderCtx, renderCancel := context.WithCancel(gw.Context())
 	defer renderCancel()
 
@@ -1547,16 +1759,72 @@ func (c *initConfig) askJoinIntents(gw *cloudClient.WebsocketGateway, expectedSy
 		for {
 			select {
 			case bytes := <-gw.Receive():
-				session := types.Session{}
-				err := json.Unmarshal(bytes, &session)
+				msg, err := gw.ParseMessage(bytes)
 				if err != nil {
 					logger.Error("Failed to read join intent", logger.Ctx{"err": err})
 					break
 				}
 
-				joinIntents[session.Intent.Name] = session.Intent
+				if msg.Type == types.SessionMessageBannedAddress {
+					bannedAddress := types.SessionBannedAddress{}
+					err := msg.Decode(&bannedAddress)
+					if err != nil {
+						logger.Error("Failed to read banned address", logger.Ctx{"err": err})
+						break
+					}
 
-				remoteCert, err := shared.ParseCert([]byte(session.Intent.Certificate))
+					bannedLock.Lock()
+					banned = append(banned, bannedAddress.Address)
+					bannedLock.Unlock()
+					break
+				}
+
+				if msg.Type == types.SessionMessagePeerLeft || msg.Type == types.SessionMessagePeerReturned {
+					name, gone, err := peerLeft(*msg)
+					if err != nil {
+						logger.Error("Failed to read system liveness", logger.Ctx{"err": err})
+						break
+					}
+
+					leftLock.Lock()
+					index, ok := rowIndexes[name]
+					if !ok {
+						leftLock.Unlock()
+						break
+					}
+
+					// Grey out the systems which left the session.
+					row := append([]string{}, rows[name]...)
+					if gone {
+						for i := range row {
+							row[i] = tui.SetColor(tui.Grey, row[i], false)
+						}
+					}
+
+					left[name] = gone
+					names[row[0]] = name
+					leftLock.Unlock()
+
+					table.Replace(index, row)
+					break
+				}
+
+				intent := types.SessionJoinIntent{}
+				err = decodeIntent(*msg, &intent)
+				if err != nil {
+					logger.Error("Failed to read join intent", logger.Ctx{"err": err})
+					break
+				}
+
+				// Skip intents replayed again after resuming the session.
+				_, ok := joinIntents[intent.Intent.Name]
+				if ok {
+					break
+				}
+
+				joinIntents[intent.Intent.Name] = intent.Intent
+
+				remoteCert, err := shared.ParseCert([]byte(intent.Intent.Certificate))
 				if err != nil {
 					logger.Error("Failed to parse certificate", logger.Ctx{"err": err})
 				}
@@ -1566,8 +1834,16 @@ func (c *initConfig) askJoinIntents(gw *cloudClient.WebsocketGateway, expectedSy
 					logger.Error("Failed to shorten fingerprint", logger.Ctx{"err": err})
 				}
 
+				row := append([]string{intent.Intent.Name, intent.Intent.Address, fingerprint}, capabilitiesRow(intent.Capabilities)...)
+
+				leftLock.Lock()
+				rows[intent.Intent.Name] = row
+				rowIndexes[intent.Intent.Name] = len(rowIndexes)
+				names[intent.Intent.Name] = intent.Intent.Name
+				leftLock.Unlock()
+
 				if table == nil {
-					table = NewSelectableTable(header, [][]string{{session.Intent.Name, session.Intent.Address, fingerprint}})
+					table = NewSelectableTable(header, [][]string{row})
 					err := table.Render(table.rows)
 					if err != nil {
 						logger.Error("Failed to render table", logger.Ctx{"err": err})
@@ -1575,7 +1851,7 @@ func (c *initConfig) askJoinIntents(gw *cloudClient.WebsocketGateway, expectedSy
 
 					rendered <- nil
 				} else {
-					table.Update([]string{session.Intent.Name, session.Intent.Address, fingerprint})
+					table.Update(row)
 				}
 
 			case <-renderCtx.Done():
@@ -1588,19 +1864,50 @@ func (c *initConfig) askJoinIntents(gw *cloudClient.WebsocketGateway, expectedSy
 		for {
 			select {
 			case bytes := <-gw.Receive():
-				session := types.Session{}
-				err := json.Unmarshal(bytes, &session)
+				msg, err := gw.ParseMessage(bytes)
+				if err != nil {
+					logger.Error("Failed to read join intent", logger.Ctx{"err": err})
+					break
+				}
+
+				if msg.Type == types.SessionMessageBannedAddress {
+					bannedAddress := types.SessionBannedAddress{}
+					err := msg.Decode(&bannedAddress)
+					if err != nil {
+						logger.Error("Failed to read banned address", logger.Ctx{"err": err})
+						break
+					}
+
+					printBannedAddress(bannedAddress.Address)
+					break
+				}
+
+				if msg.Type == types.SessionMessagePeerLeft || msg.Type == types.SessionMessagePeerReturned {
+					name, gone, err := peerLeft(*msg)
+					if err != nil {
+						logger.Error("Failed to read system liveness", logger.Ctx{"err": err})
+						break
+					}
+
+					leftLock.Lock()
+					left[name] = gone
+					leftLock.Unlock()
+					break
+				}
+
+				intent := types.SessionJoinIntent{}
+				err = decodeIntent(*msg, &intent)
 				if err != nil {
 					logger.Error("Failed to read join intent", logger.Ctx{"err": err})
 					break
 				}
 
 				// Skip systems which aren't listed in the preseed.
-				if !shared.ValueInSlice(session.Intent.Name, expectedSystems) {
+				if !shared.ValueInSlice(intent.Intent.Name, expectedSystems) {
 					continue
 				}
 
-				joinIntents[session.Intent.Name] = session.Intent
+				joinIntents[intent.Intent.Name] = intent.Intent
 				if len(joinIntents) == len(expectedSystems) {
 					renderCancel()
 				}
@@ -1650,14 +1957,34 @@ func (c *initConfig) askJoinIntents(gw *cloudClient.WebsocketGateway, expectedSy
 				return fmt.Errorf("No system selected")
 			}
 
+			leftLock.Lock()
+			defer leftLock.Unlock()
+
+			for _, answer := range answers {
+				name := names[table.SelectionValue(answer, "NAME")]
+				if left[name] {
+					return fmt.Errorf("System %q left the session", name)
+				}
+			}
+
 			return nil
 		})
 		if err != nil {
 			return nil, err
 		}
 
+		bannedLock.Lock()
+		for _, address := range banned {
+			printBannedAddress(address)
+		}
+
+		bannedLock.Unlock()
+
 		for _, answer := range answers {
-			name := table.SelectionValue(answer, "NAME")
+			leftLock.Lock()
+			name := names[table.SelectionValue(answer, "NAME")]
+			leftLock.Unlock()
+
 			for intentName, intent := range joinIntents {
 				if intentName == name {
 					systems = append(systems, intent)
@@ -1672,11 +1999,18 @@ func (c *initConfig) askJoinIntents(gw *cloudClient.WebsocketGateway, expectedSy
 		case <-renderCtx.Done():
 		}
 
+		leftLock.Lock()
+		defer leftLock.Unlock()
+
 		for _, name := range expectedSystems {
 			_, ok := joinIntents[name]
 			if !ok {
 				return nil, fmt.Errorf("System %q hasn't reached out", name)
 			}
+
+			if left[name] {
+				return nil, fmt.Errorf("System %q left the session", name)
+			}
 		}
 
 		for _, intent := range joinIntents {
@@ -1687,26 +2021,85 @@ func (c *initConfig) askJoinIntents(gw *cloudClient.WebsocketGateway, expectedSy
 	return systems, nil
 }
 
+// waitApprovedIntents returns the join intents which got confirmed automatically as they are sent by approved systems.
+// Every system reaching out is printed until the confirmed intents are received.
+// When using a preseed, all the expected systems have to be part of the confirmed intents.
+func (c *initConfig) waitApprovedIntents(gw *cloudClient.WebsocketGateway, expectedSystems []string) ([]types.SessionJoinPost, error) {
+	for {
+		msg, err := receiveMessage(gw)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read approved join intents: %w", err)
+		}
+
+		if msg.Type != types.SessionMessageConfirmedIntents {
+			intent := types.SessionJoinIntent{}
+			err = decodeIntent(*msg, &intent)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read approved join intents: %w", err)
+			}
+
+			if !c.autoSetup {
+				fmt.Printf(" Approved system %q at %q reached out\n", intent.Intent.Name, intent.Intent.Address)
+			}
+
+			continue
+		}
+
+		confirmed := types.SessionConfirmedIntents{}
+		err = msg.Decode(&confirmed)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read approved join intents: %w", err)
+		}
+
+		for _, name := range expectedSystems {
+			found := false
+			for _, intent := range confirmed.Intents {
+				if intent.Name == name {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return nil, fmt.Errorf("System %q hasn't reached out", name)
+			}
+		}
+
+		return confirmed.Intents, nil
+	}
+}
+
+// decodeIntent unmarshals the join intent contained in the given message into intent.
+func decodeIntent(msg types.SessionMessage, intent *types.SessionJoinIntent) error {
+	if msg.Type != types.SessionMessageIntent {
+		return fmt.Errorf("Received session message %q instead of a join intent", msg.Type)
+	}
+
+	return msg.Decode(intent)
+}
+
 func (c *initConfig) askJoinConfirmation(gw *cloudClient.WebsocketGateway, services map[types.ServiceType]string) error {
-	session := types.Session{}
-	err := gw.ReceiveWithContext(gw.Context(), &session)
+	confirmation := types.SessionJoinIntent{}
+	err := gw.ReceivePayload(gw.Context(), types.SessionMessageIntent, &confirmation)
 	if err != nil {
 		return fmt.Errorf("Failed to read join confirmation: %w", err)
 	}
 
-	if !c.autoSetup {
-		fmt.Printf("\n Received confirmation from system %q\n\n", session.Intent.Name)
+	// The system which issued a join token completes the configuration on its own.
+	if !c.autoSetup && c.joinToken == "" {
+		fmt.Printf("\n Received confirmation from system %q\n\n", confirmation.Intent.Name)
 		fmt.Println("Do not exit out to keep the session alive.")
-		fmt.Printf("Complete the remaining configuration on %q ...\n", session.Intent.Name)
+		fmt.Printf("Complete the remaining configuration on %q ...\n", confirmation.Intent.Name)
 	}
 
-	err = gw.ReceiveWithContext(gw.Context(), &session)
+	result := types.SessionResult{}
+	err = gw.ReceivePayload(gw.Context(), types.SessionMessageResult, &result)
 	if err != nil {
 		return fmt.Errorf("Failed waiting during join: %w", err)
 	}
 
-	if session.Error != "" {
-		return fmt.Errorf("Failed to join system: %s", session.Error)
+	if result.Error != "" {
+		return fmt.Errorf("Failed to join system: %s", result.Error)
 	}
 
 	fmt.Println("Successfully joined the MicroCloud cluster and closing the session.")
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/canonical/lxd/shared/trust"
+	"github.com/spf13/cobra"
+
+	"github.com/canonical/microcloud/microcloud/multicast"
+	"github.com/canonical/microcloud/microcloud/service"
+)
+
+// DefaultDiscoverPassphrase is the passphrase used by `microcloud discover` if none is given.
+// It only allows two systems running the command to verify each other and is never used in a real session.
+const DefaultDiscoverPassphrase = "microcloud discover"
+
+type cmdDiscover struct {
+	common *CmdControl
+
+	flagInterfaces []string
+	flagDuration   int64
+	flagRespond    bool
+	flagPassphrase string
+}
+
+func (c *cmdDiscover) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "discover",
+		Short: "Test whether systems can find each other using multicast",
+		Long: `Test whether systems can find each other using multicast
+
+Every reply received during the lookup is printed together with its source address,
+including replies of systems using an incompatible discovery version or a different passphrase.
+Run the command with --respond on another system to test the reachability of both
+systems before starting a real session.`,
+		RunE: c.Run,
+	}
+
+	cmd.Flags().StringSliceVar(&c.flagInterfaces, "interface", nil, "Interface on which to find systems (can be given multiple times). Defaults: all interfaces with a global unicast address")
+	cmd.Flags().Int64Var(&c.flagDuration, "duration", 10, "Amount of seconds to look for systems")
+	cmd.Flags().BoolVar(&c.flagRespond, "respond", false, "Also respond to lookups of other systems")
+	cmd.Flags().StringVar(&c.flagPassphrase, "passphrase", DefaultDiscoverPassphrase, "Passphrase used to sign our replies and to verify the ones of other systems")
+
+	return cmd
+}
+
+func (c *cmdDiscover) Run(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return cmd.Help()
+	}
+
+	if c.flagDuration <= 0 {
+		return fmt.Errorf("Duration must be a positive amount of seconds")
+	}
+
+	ifaceNames, err := lookupInterfaces(c.flagInterfaces, len(c.flagInterfaces) == 0)
+	if err != nil {
+		return err
+	}
+
+	if len(ifaceNames) == 0 {
+		return fmt.Errorf("Found no interface with a global unicast address")
+	}
+
+	duration := time.Duration(c.flagDuration) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+
+	// The same formatter is used for all replies so that they share the same salt.
+	h, err := multicast.NewDiscoveryHMAC(c.flagPassphrase)
+	if err != nil {
+		return err
+	}
+
+	if c.flagRespond {
+		err = c.respond(ctx, ifaceNames, h)
+		if err != nil {
+			return err
+		}
+	}
+
+	verifier := multicast.NewVerifier(h)
+
+	replies := make(chan multicast.Reply)
+	var wg sync.WaitGroup
+	for _, ifaceName := range ifaceNames {
+		// Look up systems using both families as the responder uses the family of its address.
+		discovery := multicast.NewDiscovery(ifaceName, service.CloudMulticastPort, multicast.IPv4, multicast.IPv6)
+		ifaceReplies, err := discovery.LookupReplies(ctx, multicast.SupportedVersions)
+		if err != nil {
+			fmt.Printf("Skipping interface %q: %v\n", ifaceName, err)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for reply := range ifaceReplies {
+				replies <- reply
+			}
+		}()
+	}
+
+	// The lookups stop once the duration has passed.
+	go func() {
+		wg.Wait()
+		close(replies)
+	}()
+
+	fmt.Printf("Looking up systems using versions %s for %s ...\n", multicast.SupportedVersions, duration)
+
+	// Replies are repeated as the lookup is repeated every second, so only print them once.
+	printed := map[string]bool{}
+	systems := map[string]bool{}
+	for reply := range replies {
+		status := ""
+		_, err := multicast.SupportedVersions.Negotiate(reply.Info.Versions())
+		if err != nil {
+			status = fmt.Sprintf(" (incompatible: %v)", err)
+		} else {
+			// Peers only supporting versions older than multicast.SignedVersion cannot sign their replies, so they are reported as unverified.
+			err := verifier.Verify(reply.Info, reply.Source)
+			if err != nil {
+				status = fmt.Sprintf(" (unverified: %v)", err)
+			}
+		}
+
+		key := reply.Source.String() + "/" + reply.Info.Interface + "/" + reply.Info.Name + "/" + reply.Info.Versions().String() + status
+		if printed[key] {
+			continue
+		}
+
+		printed[key] = true
+		systems[reply.Source.String()] = true
+
+		fmt.Printf(" Received reply from %q on %q: system %q at %q using version %s%s\n", reply.Source.String(), reply.Info.Interface, reply.Info.Name, reply.Info.Address, reply.Info.Versions(), status)
+	}
+
+	if len(systems) == 0 {
+		fmt.Println("No replies received. Check that the systems are connected to the same network segment and that multicast traffic isn't filtered")
+		return nil
+	}
+
+	fmt.Printf("Received replies from %d addresses\n", len(systems))
+
+	return nil
+}
+
+// respond starts a temporary responder on each of the given interfaces until the context is cancelled.
+// The responder advertises the first global unicast address of each interface signed using the given formatter.
+func (c *cmdDiscover) respond(ctx context.Context, ifaceNames []string, h trust.HMACFormatter) error {
+	name, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve system hostname: %w", err)
+	}
+
+	networks, err := multicast.GetNetworkInfo()
+	if err != nil {
+		return err
+	}
+
+	for _, ifaceName := range ifaceNames {
+		address := ""
+		for _, network := range networks {
+			if network.Interface.Name == ifaceName {
+				address = network.Address
+				break
+			}
+		}
+
+		if address == "" {
+			fmt.Printf("Not responding on interface %q as it has no global unicast address\n", ifaceName)
+			continue
+		}
+
+		family, err := multicast.AddressFamily(address)
+		if err != nil {
+			return err
+		}
+
+		info := multicast.ServerInfo{
+			Version:    multicast.Version,
+			MinVersion: multicast.MinVersion,
+			Name:       name,
+			Address:    address,
+		}
+
+		err = multicast.NewDiscovery(ifaceName, service.CloudMulticastPort, family).Respond(ctx, info, h)
+		if err != nil {
+			return fmt.Errorf("Failed to respond on interface %q: %w", ifaceName, err)
+		}
+
+		fmt.Printf("Responding as %q at %q on interface %q\n", name, address, ifaceName)
+	}
+
+	return nil
+}
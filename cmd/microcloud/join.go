@@ -8,7 +8,6 @@ import (
 
 	"github.com/spf13/cobra"
 
-	"github.com/canonical/microcloud/microcloud/api"
 	"github.com/canonical/microcloud/microcloud/api/types"
 	cloudClient "github.com/canonical/microcloud/microcloud/client"
 	"github.com/canonical/microcloud/microcloud/service"
@@ -17,30 +16,69 @@ import (
 type cmdJoin struct {
 	common *CmdControl
 
-	flagLookupTimeout    int64
-	flagSessionTimeout   int64
-	flagInitiatorAddress string
+	flagLookupTimeout        int64
+	flagSessionTimeout       int64
+	flagInitiatorAddress     string
+	flagLookupInterfaces     []string
+	flagLookupAllInterfaces  bool
+	flagInitiatorFingerprint string
+	flagMaxArgon2Time        uint32
+	flagMaxArgon2Memory      uint32
+	flagKeepalive            keepaliveFlags
+	flagResume               string
 }
 
 func (c *cmdJoin) Command() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "join",
+		Use:   "join [<token>]",
 		Short: "Join an existing MicroCloud cluster",
-		RunE:  c.Run,
+		Long: `Join an existing MicroCloud cluster
+
+Without arguments, the system takes part in a trust establishment session started using "microcloud add".
+Providing a join token issued using "microcloud add --token" lets the system join without a session.`,
+		RunE: c.Run,
 	}
 
 	cmd.Flags().Int64Var(&c.flagLookupTimeout, "lookup-timeout", 0, "Amount of seconds to wait when finding systems on the network. Defaults: 60s")
 	cmd.Flags().Int64Var(&c.flagSessionTimeout, "session-timeout", 0, "Amount of seconds to wait for the trust establishment session. Defaults: 10m")
 	cmd.Flags().StringVar(&c.flagInitiatorAddress, "initiator-address", "", "Address of the trust establishment session's initiator")
+	cmd.Flags().StringSliceVar(&c.flagLookupInterfaces, "lookup-interface", nil, "Additional interface on which to find systems (can be given multiple times)")
+	cmd.Flags().BoolVar(&c.flagLookupAllInterfaces, "lookup-all-interfaces", false, "Find systems on all interfaces with a global unicast address")
+	cmd.Flags().StringVar(&c.flagInitiatorFingerprint, "initiator-fingerprint", "", "Expected fingerprint of the initiator's certificate (at least the first 12 characters)")
+	cmd.Flags().Uint32Var(&c.flagMaxArgon2Time, "max-argon2-time", 0, fmt.Sprintf("Maximum number of Argon2 iterations accepted from the initiator to derive the session key. Defaults: %d", service.DefaultArgon2Time))
+	cmd.Flags().Uint32Var(&c.flagMaxArgon2Memory, "max-argon2-memory", 0, fmt.Sprintf("Maximum amount of memory in KiB accepted from the initiator to derive the session key using Argon2. Defaults: %d", service.DefaultArgon2Memory))
+	c.flagKeepalive.register(cmd)
+	cmd.Flags().StringVar(&c.flagResume, "resume", "", "ID of a running trust establishment session to resume after its client got disconnected")
 
 	return cmd
 }
 
 func (c *cmdJoin) Run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
+	if len(args) > 1 {
 		return cmd.Help()
 	}
 
+	// The token already contains the initiator's address and fingerprint.
+	var joinToken string
+	initiatorAddress := c.flagInitiatorAddress
+	if len(args) == 1 {
+		if c.flagInitiatorAddress != "" || c.flagInitiatorFingerprint != "" || c.flagResume != "" {
+			return fmt.Errorf("A join token cannot be combined with --initiator-address, --initiator-fingerprint or --resume")
+		}
+
+		token, err := types.DecodeJoinToken(args[0])
+		if err != nil {
+			return err
+		}
+
+		if time.Now().After(token.ExpiresAt) {
+			return fmt.Errorf("Join token expired at %s", token.ExpiresAt.Local().Format(time.RFC3339))
+		}
+
+		joinToken = args[0]
+		initiatorAddress = token.Address
+	}
+
 	fmt.Println("Waiting for services to start ...")
 	err := checkInitialized(c.common.FlagMicroCloudDir, false, false)
 	if err != nil {
@@ -65,7 +103,34 @@ func (c *cmdJoin) Run(cmd *cobra.Command, args []string) error {
 		cfg.sessionTimeout = time.Duration(c.flagSessionTimeout) * time.Second
 	}
 
-	err = cfg.askAddress(c.flagInitiatorAddress)
+	cfg.lookupInterfaces, err = lookupInterfaces(c.flagLookupInterfaces, c.flagLookupAllInterfaces)
+	if err != nil {
+		return err
+	}
+
+	if c.flagInitiatorFingerprint != "" {
+		err = cloudClient.ValidateFingerprint(c.flagInitiatorFingerprint)
+		if err != nil {
+			return err
+		}
+
+		cfg.initiatorFingerprint = c.flagInitiatorFingerprint
+	}
+
+	// The initiator's Argon2 parameters are learned from its unauthenticated server info,
+	// so the session only accepts them up to the given maximum.
+	cfg.sessionAuth = types.SessionAuth{Argon2Time: c.flagMaxArgon2Time, Argon2Memory: c.flagMaxArgon2Memory}
+	err = service.ValidateSessionAuth(cfg.sessionAuth)
+	if err != nil {
+		return err
+	}
+
+	cfg.keepalive, err = c.flagKeepalive.keepalive()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.askAddress(initiatorAddress)
 	if err != nil {
 		return err
 	}
@@ -75,15 +140,11 @@ func (c *cmdJoin) Run(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("Failed to retrieve system hostname: %w", err)
 	}
 
-	installedServices := []types.ServiceType{types.MicroCloud, types.LXD}
-	optionalServices := map[types.ServiceType]string{
-		types.MicroCeph: api.MicroCephDir,
-		types.MicroOVN:  api.MicroOVNDir,
-	}
+	installedServices := service.RequiredServices()
 
 	// Enable auto setup to skip service related questions.
 	cfg.autoSetup = true
-	installedServices, err = cfg.askMissingServices(installedServices, optionalServices)
+	installedServices, err = cfg.askMissingServices(installedServices)
 	if err != nil {
 		return err
 	}
@@ -96,7 +157,7 @@ func (c *cmdJoin) Run(cmd *cobra.Command, args []string) error {
 	}
 
 	services := make(map[types.ServiceType]string, len(installedServices))
-	for _, s := range s.Services {
+	for _, s := range s.Services() {
 		version, err := s.GetVersion(context.Background())
 		if err != nil {
 			return err
@@ -105,12 +166,19 @@ func (c *cmdJoin) Run(cmd *cobra.Command, args []string) error {
 		services[s.Type()] = version
 	}
 
-	passphrase, err := cfg.askPassphrase(s)
-	if err != nil {
-		return err
+	cfg.resumeSession = c.flagResume
+	cfg.joinToken = joinToken
+
+	// A resumed session already knows its passphrase and the token's secret replaces it.
+	var passphrase string
+	if cfg.resumeSession == "" && cfg.joinToken == "" {
+		passphrase, err = cfg.askPassphrase(s)
+		if err != nil {
+			return err
+		}
 	}
 
 	return cfg.runSession(context.Background(), s, types.SessionJoining, cfg.sessionTimeout, func(gw *cloudClient.WebsocketGateway) error {
-		return cfg.joiningSession(gw, s, services, c.flagInitiatorAddress, passphrase)
+		return cfg.joiningSession(gw, s, services, initiatorAddress, "", passphrase)
 	})
 }
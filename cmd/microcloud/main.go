@@ -121,6 +121,15 @@ EOF`)
 	var cmdWaitready = cmdWaitready{common: &commonCmd}
 	app.AddCommand(cmdWaitready.Command())
 
+	var cmdDiscover = cmdDiscover{common: &commonCmd}
+	app.AddCommand(cmdDiscover.Command())
+
+	var cmdSession = cmdSession{common: &commonCmd}
+	app.AddCommand(cmdSession.Command())
+
+	var cmdUpgrade = cmdUpgrade{common: &commonCmd}
+	app.AddCommand(cmdUpgrade.Command())
+
 	app.InitDefaultHelpCmd()
 
 	app.SetErr(&tui.ColorErr{})
@@ -23,7 +23,6 @@ import (
 	ovnClient "github.com/canonical/microovn/microovn/client"
 	"github.com/spf13/cobra"
 
-	"github.com/canonical/microcloud/microcloud/api"
 	"github.com/canonical/microcloud/microcloud/api/types"
 	cloudClient "github.com/canonical/microcloud/microcloud/client"
 	"github.com/canonical/microcloud/microcloud/multicast"
@@ -100,12 +99,44 @@ type initConfig struct {
 	// lookupTimeout is the duration to wait for peers to appear during multicast system lookup.
 	lookupTimeout time.Duration
 
+	// lookupSeeds are the addresses and CIDR ranges which are probed by the initiator
+	// to find systems outside of the local network segment.
+	lookupSeeds []string
+
+	// lookupBackend is the mechanism used for system lookup.
+	// If empty, MicroCloud's own multicast discovery is used.
+	lookupBackend multicast.BackendType
+
 	// sessionTimeout is the duration to wait for the trust establishment session to complete.
 	sessionTimeout time.Duration
 
+	// initiatorFingerprint is the pinned fingerprint of the initiator's certificate when joining a session.
+	// If set, the initiator's certificate is verified before sending the join intent.
+	initiatorFingerprint string
+
+	// approvedJoiners are the systems whose join intents are confirmed without asking when initiating a session.
+	approvedJoiners []types.ApprovedJoiner
+
+	// sessionAuth are the parameters used to generate the session passphrase and to derive the HMAC key from it.
+	// When joining, they are the highest Argon2 parameters accepted from the initiator.
+	sessionAuth types.SessionAuth
+
+	// keepalive are the settings used to detect unresponsive peers during the trust establishment session.
+	keepalive types.SessionKeepalive
+
+	// resumeSession is the ID of a running session whose client got disconnected.
+	// If set, the session is resumed instead of starting a new one.
+	resumeSession string
+
+	// joinToken is the encoded out-of-band join token redeemed instead of taking part in a session.
+	joinToken string
+
 	// lookupIface is the interface used for multicast lookup.
 	lookupIface *net.Interface
 
+	// lookupInterfaces are the names of additional interfaces used for multicast lookup.
+	lookupInterfaces []string
+
 	// lookupSubnet is the subnet in which other peers are being expected.
 	// It represents the internal network used for MicroCloud.
 	lookupSubnet *net.IPNet
@@ -120,7 +151,13 @@ type initConfig struct {
 type cmdInit struct {
 	common *CmdControl
 
-	flagSessionTimeout int64
+	flagSessionTimeout   int64
+	flagSeeds            []string
+	flagLookupInterfaces []string
+	flagApprovedJoiners  []string
+	flagSessionAuth      sessionAuthFlags
+	flagKeepalive        keepaliveFlags
+	flagResume           string
 }
 
 func (c *cmdInit) Command() *cobra.Command {
@@ -132,6 +169,12 @@ func (c *cmdInit) Command() *cobra.Command {
 	}
 
 	cmd.Flags().Int64Var(&c.flagSessionTimeout, "session-timeout", 0, "Amount of seconds to wait for the trust establishment session. Defaults: 60m")
+	cmd.Flags().StringSliceVar(&c.flagSeeds, "seed", nil, "Address or CIDR range of systems outside of the local network segment to probe (can be given multiple times)")
+	cmd.Flags().StringSliceVar(&c.flagLookupInterfaces, "lookup-interface", nil, "Additional interface on which other systems can find this one (can be given multiple times)")
+	cmd.Flags().StringSliceVar(&c.flagApprovedJoiners, "approved-joiner", nil, "Fingerprint of a system allowed to join without confirmation in the form [<name>=]<fingerprint> (can be given multiple times)")
+	c.flagSessionAuth.register(cmd)
+	c.flagKeepalive.register(cmd)
+	cmd.Flags().StringVar(&c.flagResume, "resume", "", "ID of a running trust establishment session to resume after its client got disconnected")
 
 	return cmd
 }
@@ -155,9 +198,225 @@ func (c *cmdInit) Run(cmd *cobra.Command, args []string) error {
 		cfg.sessionTimeout = time.Duration(c.flagSessionTimeout) * time.Second
 	}
 
+	_, err := multicast.ExpandSeeds(c.flagSeeds)
+	if err != nil {
+		return err
+	}
+
+	cfg.lookupSeeds = c.flagSeeds
+	cfg.lookupInterfaces, err = lookupInterfaces(c.flagLookupInterfaces, false)
+	if err != nil {
+		return err
+	}
+
+	cfg.approvedJoiners, err = parseApprovedJoiners(c.flagApprovedJoiners)
+	if err != nil {
+		return err
+	}
+
+	cfg.sessionAuth, err = c.flagSessionAuth.sessionAuth()
+	if err != nil {
+		return err
+	}
+
+	cfg.keepalive, err = c.flagKeepalive.keepalive()
+	if err != nil {
+		return err
+	}
+
+	cfg.resumeSession = c.flagResume
+
 	return cfg.RunInteractive(cmd, args)
 }
 
+// sessionAuthFlags are the flags configuring the parameters of a trust establishment session.
+type sessionAuthFlags struct {
+	passphraseWords int
+	wordlist        string
+	argon2Time      uint32
+	argon2Memory    uint32
+}
+
+// register adds the flags to the given command.
+func (f *sessionAuthFlags) register(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&f.passphraseWords, "passphrase-words", 0, fmt.Sprintf("Number of words of the session passphrase. Defaults: %d", service.DefaultPassphraseWords))
+	cmd.Flags().StringVar(&f.wordlist, "wordlist", "", "File containing the words the session passphrase is chosen from (one per line)")
+	cmd.Flags().Uint32Var(&f.argon2Time, "argon2-time", 0, fmt.Sprintf("Number of Argon2 iterations used to derive the session key from the passphrase. Defaults: %d", service.DefaultArgon2Time))
+	cmd.Flags().Uint32Var(&f.argon2Memory, "argon2-memory", 0, fmt.Sprintf("Amount of memory in KiB used by Argon2 to derive the session key from the passphrase. Defaults: %d", service.DefaultArgon2Memory))
+}
+
+// sessionAuth returns the session parameters configured using the flags.
+func (f *sessionAuthFlags) sessionAuth() (types.SessionAuth, error) {
+	auth := types.SessionAuth{
+		PassphraseWords: f.passphraseWords,
+		Argon2Time:      f.argon2Time,
+		Argon2Memory:    f.argon2Memory,
+	}
+
+	if f.wordlist != "" {
+		var err error
+		auth.Wordlist, err = readWordlist(f.wordlist)
+		if err != nil {
+			return types.SessionAuth{}, err
+		}
+	}
+
+	err := service.ValidateSessionAuth(auth)
+	if err != nil {
+		return types.SessionAuth{}, fmt.Errorf("Invalid session parameters: %w", err)
+	}
+
+	return auth, nil
+}
+
+// keepaliveFlags are the flags configuring the detection of unresponsive peers during a trust establishment session.
+type keepaliveFlags struct {
+	interval int64
+	timeout  int64
+}
+
+// register adds the flags to the given command.
+func (f *keepaliveFlags) register(cmd *cobra.Command) {
+	cmd.Flags().Int64Var(&f.interval, "keepalive-interval", 0, fmt.Sprintf("Amount of seconds between two probes of the systems taking part in the trust establishment session. Defaults: %s", service.DefaultKeepaliveInterval))
+	cmd.Flags().Int64Var(&f.timeout, "keepalive-timeout", 0, fmt.Sprintf("Amount of seconds after which a system which didn't respond is considered unresponsive. Defaults: %s", service.DefaultKeepaliveTimeout))
+}
+
+// keepalive returns the keepalive settings configured using the flags.
+func (f *keepaliveFlags) keepalive() (types.SessionKeepalive, error) {
+	return sessionKeepalive(f.interval, f.timeout)
+}
+
+// sessionKeepalive returns the keepalive settings using the given amounts of seconds with the defaults applied to unset settings.
+// Sending the settings lets the server know that the client supports keepalive.
+func sessionKeepalive(interval int64, timeout int64) (types.SessionKeepalive, error) {
+	keepalive := types.SessionKeepalive{
+		Interval: time.Duration(interval) * time.Second,
+		Timeout:  time.Duration(timeout) * time.Second,
+	}
+
+	err := service.ValidateSessionKeepalive(keepalive)
+	if err != nil {
+		return types.SessionKeepalive{}, fmt.Errorf("Invalid keepalive settings: %w", err)
+	}
+
+	if keepalive.Interval == 0 {
+		keepalive.Interval = service.DefaultKeepaliveInterval
+	}
+
+	if keepalive.Timeout == 0 {
+		keepalive.Timeout = service.DefaultKeepaliveTimeout
+	}
+
+	return keepalive, nil
+}
+
+// readWordlist returns the words contained in the given file.
+// Each line contains a single word which can be prefixed by a number separated by tab as used by the EFF wordlists.
+// Empty lines are skipped.
+func readWordlist(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read wordlist: %w", err)
+	}
+
+	words := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		word := fields[len(fields)-1]
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+
+	return words, nil
+}
+
+// parseApprovedJoiners returns the approved joiners given in the form [<name>=]<fingerprint>.
+func parseApprovedJoiners(values []string) ([]types.ApprovedJoiner, error) {
+	joiners := make([]types.ApprovedJoiner, 0, len(values))
+	for _, value := range values {
+		joiner := types.ApprovedJoiner{Fingerprint: value}
+		name, fingerprint, ok := strings.Cut(value, "=")
+		if ok {
+			joiner = types.ApprovedJoiner{Name: name, Fingerprint: fingerprint}
+		}
+
+		err := cloudClient.ValidateFingerprint(joiner.Fingerprint)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid approved joiner %q: %w", value, err)
+		}
+
+		joiners = append(joiners, joiner)
+	}
+
+	return joiners, nil
+}
+
+// lookupInterfaces returns the names of the given interfaces after ensuring they exist on this system.
+// If all is set, every interface with a global unicast address is returned as well.
+func lookupInterfaces(ifaceNames []string, all bool) ([]string, error) {
+	names := make([]string, 0, len(ifaceNames))
+	for _, ifaceName := range ifaceNames {
+		_, err := net.InterfaceByName(ifaceName)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid lookup interface %q: %w", ifaceName, err)
+		}
+
+		if !shared.ValueInSlice(ifaceName, names) {
+			names = append(names, ifaceName)
+		}
+	}
+
+	if !all {
+		return names, nil
+	}
+
+	networks, err := multicast.GetNetworkInfo()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get network information: %w", err)
+	}
+
+	for _, network := range networks {
+		if !shared.ValueInSlice(network.Interface.Name, names) {
+			names = append(names, network.Interface.Name)
+		}
+	}
+
+	return names, nil
+}
+
+// lookupSubnets returns the subnets from which the initiator answers lookups.
+// Next to the subnet of MicroCloud's address, it contains the subnets of the additional lookup interfaces.
+// If the subnet of MicroCloud's address is unknown, no subnets are returned so that lookups from any subnet are answered.
+func (c *initConfig) lookupSubnets() ([]string, error) {
+	if c.lookupSubnet == nil {
+		return nil, nil
+	}
+
+	subnets := []string{c.lookupSubnet.String()}
+	if len(c.lookupInterfaces) == 0 {
+		return subnets, nil
+	}
+
+	networks, err := multicast.GetNetworkInfo()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get network information: %w", err)
+	}
+
+	for _, network := range networks {
+		if !shared.ValueInSlice(network.Interface.Name, c.lookupInterfaces) {
+			continue
+		}
+
+		subnet := net.IPNet{IP: network.Subnet.IP.Mask(network.Subnet.Mask), Mask: network.Subnet.Mask}
+		if !shared.ValueInSlice(subnet.String(), subnets) {
+			subnets = append(subnets, subnet.String())
+		}
+	}
+
+	return subnets, nil
+}
+
 func (c *initConfig) RunInteractive(cmd *cobra.Command, args []string) error {
 	fmt.Println("Waiting for services to start ...")
 	err := checkInitialized(c.common.FlagMicroCloudDir, false, false)
@@ -187,13 +446,9 @@ func (c *initConfig) RunInteractive(cmd *cobra.Command, args []string) error {
 		},
 	}
 
-	installedServices := []types.ServiceType{types.MicroCloud, types.LXD}
-	optionalServices := map[types.ServiceType]string{
-		types.MicroCeph: api.MicroCephDir,
-		types.MicroOVN:  api.MicroOVNDir,
-	}
+	installedServices := service.RequiredServices()
 
-	installedServices, err = c.askMissingServices(installedServices, optionalServices)
+	installedServices, err = c.askMissingServices(installedServices)
 	if err != nil {
 		return err
 	}
@@ -205,7 +460,7 @@ func (c *initConfig) RunInteractive(cmd *cobra.Command, args []string) error {
 	}
 
 	services := make(map[types.ServiceType]string, len(installedServices))
-	for _, s := range s.Services {
+	for _, s := range s.Services() {
 		version, err := s.GetVersion(context.Background())
 		if err != nil {
 			return err
@@ -289,13 +544,13 @@ func (c *initConfig) RunInteractive(cmd *cobra.Command, args []string) error {
 // and then waits for the request to either complete or time out.
 // If the request was successful, it additionally waits until the cluster appears in the database.
 func waitForJoin(sh *service.Handler, clusterSizes map[types.ServiceType]int, peer string, cert *x509.Certificate, cfg types.ServicesPut) error {
-	cloud := sh.Services[types.MicroCloud].(*service.CloudService)
+	cloud := sh.Services()[types.MicroCloud].(*service.CloudService)
 	err := cloud.RequestJoin(context.Background(), peer, cert, cfg)
 	if err != nil {
 		return fmt.Errorf("System %q failed to join the cluster: %w", peer, err)
 	}
 
-	clustered := make(map[types.ServiceType]bool, len(sh.Services))
+	clustered := make(map[types.ServiceType]bool, len(sh.Services()))
 	for _, tokenInfo := range cfg.Tokens {
 		clustered[tokenInfo.Service] = false
 	}
@@ -309,7 +564,7 @@ func waitForJoin(sh *service.Handler, clusterSizes map[types.ServiceType]int, pe
 
 		// Check the size of the cluster for each service.
 		for service := range clustered {
-			systems, err := sh.Services[service].ClusterMembers(context.Background())
+			systems, err := sh.Services()[service].ClusterMembers(context.Background())
 			if err != nil {
 				return err
 			}
@@ -334,7 +589,7 @@ func (c *initConfig) addPeers(sh *service.Handler) (revert.Hook, error) {
 	// Grab the systems that are clustered from the InitSystem map.
 	initializedServices := map[types.ServiceType]string{}
 	existingSystems := map[types.ServiceType]map[string]string{}
-	for serviceType := range sh.Services {
+	for serviceType := range sh.Services() {
 		for peer := range c.systems {
 			if c.state[peer].ExistingServices != nil && c.state[peer].ExistingServices[serviceType] != nil {
 				initializedServices[serviceType] = peer
@@ -371,7 +626,7 @@ func (c *initConfig) addPeers(sh *service.Handler) (revert.Hook, error) {
 	for peer := range c.systems {
 		// Only join other peers which aren't yet part of MicroCloud.
 		if peer != sh.Name && existingSystems[types.MicroCloud][peer] == "" {
-			token, err := sh.Services[types.MicroCloud].IssueToken(context.Background(), peer)
+			token, err := sh.Services()[types.MicroCloud].IssueToken(context.Background(), peer)
 			if err != nil {
 				return nil, fmt.Errorf("Failed to issue MicroCloud token for peer %q: %w", peer, err)
 			}
@@ -390,7 +645,7 @@ func (c *initConfig) addPeers(sh *service.Handler) (revert.Hook, error) {
 	// Concurrently issue a token for each joiner.
 	for peer := range c.systems {
 		mut := sync.Mutex{}
-		err := sh.RunConcurrent("", "", func(s service.Service) error {
+		err := sh.RunConcurrent(context.Background(), func(ctx context.Context, s service.Service) error {
 			// Skip MicroCloud as the cluster is already formed.
 			if s.Type() == types.MicroCloud {
 				return nil
@@ -406,13 +661,13 @@ func (c *initConfig) addPeers(sh *service.Handler) (revert.Hook, error) {
 				// If the local node is part of the pre-existing cluster, or if we are growing the cluster, issue the token locally.
 				// Otherwise, use the MicroCloud proxy to ask an existing cluster member to issue the token.
 				if clusteredSystem.ServerInfo.Name == sh.Name || clusteredSystem.ServerInfo.Name == "" {
-					token, err = s.IssueToken(context.Background(), peer)
+					token, err = s.IssueToken(ctx, peer)
 					if err != nil {
 						return fmt.Errorf("Failed to issue %s token for peer %q: %w", s.Type(), peer, err)
 					}
 				} else {
-					cloud := sh.Services[types.MicroCloud].(*service.CloudService)
-					token, err = cloud.RemoteIssueToken(context.Background(), clusteredSystem.ServerInfo.Address, peer, s.Type())
+					cloud := sh.Services()[types.MicroCloud].(*service.CloudService)
+					token, err = cloud.RemoteIssueToken(ctx, clusteredSystem.ServerInfo.Address, peer, s.Type())
 					if err != nil {
 						return err
 					}
@@ -612,7 +867,7 @@ func (c *initConfig) setupCluster(s *service.Handler) error {
 	reverter := revert.New()
 	defer reverter.Fail()
 
-	lxd := s.Services[types.LXD].(*service.LXDService)
+	lxd := s.Services()[types.LXD].(*service.LXDService)
 	lxdClient, err := lxd.Client(context.Background())
 	if err != nil {
 		return err
@@ -641,7 +896,7 @@ func (c *initConfig) setupCluster(s *service.Handler) error {
 
 	initializedServices := map[types.ServiceType]string{}
 	bootstrapSystem := c.systems[s.Name]
-	for serviceType := range s.Services {
+	for serviceType := range s.Services() {
 		for peer := range c.systems {
 			if c.state[peer].ExistingServices[serviceType] != nil {
 				initializedServices[serviceType] = peer
@@ -652,7 +907,7 @@ func (c *initConfig) setupCluster(s *service.Handler) error {
 
 	fmt.Println("Initializing new services")
 	mu := sync.Mutex{}
-	err = s.RunConcurrent(types.MicroCloud, types.LXD, func(s service.Service) error {
+	err = s.RunOrdered(context.Background(), false, func(ctx context.Context, s service.Service) error {
 		// If there's already an initialized system for this service, we don't need to bootstrap it.
 		if initializedServices[s.Type()] != "" {
 			return nil
@@ -685,7 +940,7 @@ func (c *initConfig) setupCluster(s *service.Handler) error {
 		}
 
 		// set a 2 minute timeout to bootstrap a service in case the node is slow.
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
 		defer cancel()
 
 		err := s.Bootstrap(ctx)
@@ -725,7 +980,7 @@ func (c *initConfig) setupCluster(s *service.Handler) error {
 		peer = microCeph
 	}
 
-	if s.Services[types.MicroCeph] != nil {
+	if s.Services()[types.MicroCeph] != nil {
 		for name := range c.state[peer].ExistingServices[types.MicroCeph] {
 			// There may be existing cluster members that are not a part of MicroCloud, so ignore those.
 			if c.systems[name].ServerInfo.Name == "" {
@@ -735,7 +990,7 @@ func (c *initConfig) setupCluster(s *service.Handler) error {
 			var client *client.Client
 			for _, disk := range c.systems[name].MicroCephDisks {
 				if client == nil {
-					client, err = s.Services[types.MicroCeph].(*service.CephService).Client(name)
+					client, err = s.Services()[types.MicroCeph].(*service.CephService).Client(name)
 					if err != nil {
 						return err
 					}
@@ -765,7 +1020,7 @@ func (c *initConfig) setupCluster(s *service.Handler) error {
 			}
 		}
 
-		c, err := s.Services[types.MicroCeph].(*service.CephService).Client(s.Name)
+		c, err := s.Services()[types.MicroCeph].(*service.CephService).Client(s.Name)
 		if err != nil {
 			return err
 		}
@@ -816,8 +1071,8 @@ func (c *initConfig) setupCluster(s *service.Handler) error {
 	fmt.Println("Configuring cluster-wide devices ...")
 
 	var ovnConfig string
-	if s.Services[types.MicroOVN] != nil {
-		ovn := s.Services[types.MicroOVN].(*service.OVNService)
+	if s.Services()[types.MicroOVN] != nil {
+		ovn := s.Services()[types.MicroOVN].(*service.OVNService)
 		client, err := ovn.Client()
 		if err != nil {
 			return err
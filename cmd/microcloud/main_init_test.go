@@ -226,3 +226,20 @@ func TestValidateSystemsMultiSystem(t *testing.T) {
 		t.Fatalf("sys4 with conflicting management IP and ipv6.ovn.ranges passed validation")
 	}
 }
+
+func TestLookupInterfaces(t *testing.T) {
+	// Use the loopback interface as it should always be there on any test system.
+	names, err := lookupInterfaces([]string{"lo", "lo"}, false)
+	if err != nil {
+		t.Fatalf("Valid lookup interface failed validation: %v", err)
+	}
+
+	if len(names) != 1 || names[0] != "lo" {
+		t.Fatalf("Expected lookup interfaces [lo], got %v", names)
+	}
+
+	_, err = lookupInterfaces([]string{"invalid-interface"}, false)
+	if err == nil {
+		t.Fatalf("Invalid lookup interface passed validation")
+	}
+}
@@ -20,7 +20,6 @@ import (
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 
-	"github.com/canonical/microcloud/microcloud/api"
 	"github.com/canonical/microcloud/microcloud/api/types"
 	cloudClient "github.com/canonical/microcloud/microcloud/client"
 	"github.com/canonical/microcloud/microcloud/multicast"
@@ -29,16 +28,25 @@ import (
 
 // Preseed represents the structure of the supported preseed yaml.
 type Preseed struct {
-	LookupSubnet      string        `yaml:"lookup_subnet"`
-	LookupTimeout     int64         `yaml:"lookup_timeout"`
-	SessionPassphrase string        `yaml:"session_passphrase"`
-	SessionTimeout    int64         `yaml:"session_timeout"`
-	Initiator         string        `yaml:"initiator"`
-	InitiatorAddress  string        `yaml:"initiator_address"`
-	Systems           []System      `yaml:"systems"`
-	OVN               InitNetwork   `yaml:"ovn"`
-	Ceph              CephOptions   `yaml:"ceph"`
-	Storage           StorageFilter `yaml:"storage"`
+	LookupSubnet             string                 `yaml:"lookup_subnet"`
+	LookupTimeout            int64                  `yaml:"lookup_timeout"`
+	LookupBackend            string                 `yaml:"lookup_backend"`
+	LookupSeeds              []string               `yaml:"lookup_seeds"`
+	LookupInterfaces         []string               `yaml:"lookup_interfaces"`
+	SessionPassphrase        string                 `yaml:"session_passphrase"`
+	SessionTimeout           int64                  `yaml:"session_timeout"`
+	SessionArgon2Time        uint32                 `yaml:"session_argon2_time"`
+	SessionArgon2Memory      uint32                 `yaml:"session_argon2_memory"`
+	SessionKeepaliveInterval int64                  `yaml:"session_keepalive_interval"`
+	SessionKeepaliveTimeout  int64                  `yaml:"session_keepalive_timeout"`
+	Initiator                string                 `yaml:"initiator"`
+	InitiatorAddress         string                 `yaml:"initiator_address"`
+	InitiatorFingerprint     string                 `yaml:"initiator_fingerprint"`
+	ApprovedJoiners          []types.ApprovedJoiner `yaml:"approved_joiners"`
+	Systems                  []System               `yaml:"systems"`
+	OVN                      InitNetwork            `yaml:"ovn"`
+	Ceph                     CephOptions            `yaml:"ceph"`
+	Storage                  StorageFilter          `yaml:"storage"`
 }
 
 // System represents the structure of the systems we expect to find in the preseed yaml.
@@ -206,19 +214,34 @@ func (c *initConfig) RunPreseed(cmd *cobra.Command) error {
 		c.sessionTimeout = time.Duration(config.SessionTimeout) * time.Second
 	}
 
+	c.lookupBackend = multicast.BackendType(config.LookupBackend)
+	c.lookupSeeds = config.LookupSeeds
+	c.initiatorFingerprint = config.InitiatorFingerprint
+	c.approvedJoiners = config.ApprovedJoiners
+	c.sessionAuth = types.SessionAuth{
+		Argon2Time:   config.SessionArgon2Time,
+		Argon2Memory: config.SessionArgon2Memory,
+	}
+
 	err = config.validate(hostname, c.bootstrap)
 	if err != nil {
 		return err
 	}
 
-	// Build the service handler.
-	installedServices := []types.ServiceType{types.MicroCloud, types.LXD}
-	optionalServices := map[types.ServiceType]string{
-		types.MicroCeph: api.MicroCephDir,
-		types.MicroOVN:  api.MicroOVNDir,
+	c.keepalive, err = sessionKeepalive(config.SessionKeepaliveInterval, config.SessionKeepaliveTimeout)
+	if err != nil {
+		return err
 	}
 
-	installedServices, err = c.askMissingServices(installedServices, optionalServices)
+	c.lookupInterfaces, err = lookupInterfaces(config.LookupInterfaces, false)
+	if err != nil {
+		return err
+	}
+
+	// Build the service handler.
+	installedServices := service.RequiredServices()
+
+	installedServices, err = c.askMissingServices(installedServices)
 	if err != nil {
 		return err
 	}
@@ -229,7 +252,7 @@ func (c *initConfig) RunPreseed(cmd *cobra.Command) error {
 	}
 
 	services := make(map[types.ServiceType]string, len(installedServices))
-	for _, s := range s.Services {
+	for _, s := range s.Services() {
 		version, err := s.GetVersion(context.Background())
 		if err != nil {
 			return err
@@ -258,7 +281,7 @@ func (c *initConfig) RunPreseed(cmd *cobra.Command) error {
 	}
 
 	if !c.bootstrap {
-		peers, err := s.Services[types.MicroCloud].ClusterMembers(context.Background())
+		peers, err := s.Services()[types.MicroCloud].ClusterMembers(context.Background())
 		if err != nil {
 			return err
 		}
@@ -338,6 +361,40 @@ func (p *Preseed) validate(name string, bootstrap bool) error {
 		return fmt.Errorf("Missing session passphrase")
 	}
 
+	err := multicast.ValidateBackend(multicast.BackendType(p.LookupBackend))
+	if err != nil {
+		return err
+	}
+
+	_, err = multicast.ExpandSeeds(p.LookupSeeds)
+	if err != nil {
+		return err
+	}
+
+	if p.InitiatorFingerprint != "" {
+		err = cloudClient.ValidateFingerprint(p.InitiatorFingerprint)
+		if err != nil {
+			return fmt.Errorf("Invalid initiator fingerprint: %w", err)
+		}
+	}
+
+	err = service.ValidateSessionAuth(types.SessionAuth{Argon2Time: p.SessionArgon2Time, Argon2Memory: p.SessionArgon2Memory})
+	if err != nil {
+		return fmt.Errorf("Invalid session parameters: %w", err)
+	}
+
+	_, err = sessionKeepalive(p.SessionKeepaliveInterval, p.SessionKeepaliveTimeout)
+	if err != nil {
+		return err
+	}
+
+	for _, joiner := range p.ApprovedJoiners {
+		err = cloudClient.ValidateFingerprint(joiner.Fingerprint)
+		if err != nil {
+			return fmt.Errorf("Invalid fingerprint of approved joiner %q: %w", joiner.Name, err)
+		}
+	}
+
 	systemNames := make([]string, 0, len(p.Systems))
 	for _, system := range p.Systems {
 		if system.Name == "" {
@@ -657,7 +714,7 @@ func (p *Preseed) Parse(s *service.Handler, c *initConfig, installedServices map
 
 	if !initiator {
 		err = c.runSession(context.Background(), s, types.SessionJoining, c.sessionTimeout, func(gw *cloudClient.WebsocketGateway) error {
-			return c.joiningSession(gw, s, installedServices, p.InitiatorAddress, p.SessionPassphrase)
+			return c.joiningSession(gw, s, installedServices, p.InitiatorAddress, p.Initiator, p.SessionPassphrase)
 		})
 		return nil, err
 	}
@@ -694,7 +751,7 @@ func (p *Preseed) Parse(s *service.Handler, c *initConfig, installedServices map
 			if len(cluster) > 0 {
 				fmt.Printf("Existing %s cluster is incompatible with MicroCloud, skipping %s setup\n", serviceType, serviceType)
 
-				delete(s.Services, serviceType)
+				s.RemoveService(serviceType)
 			}
 		}
 
@@ -712,7 +769,7 @@ func (p *Preseed) Parse(s *service.Handler, c *initConfig, installedServices map
 		c.systems[name] = system
 	}
 
-	lxd := s.Services[types.LXD].(*service.LXDService)
+	lxd := s.Services()[types.LXD].(*service.LXDService)
 	ifaceByPeer := map[string]string{}
 	ovnUnderlayNeeded := false
 	for _, cfg := range p.Systems {
@@ -925,7 +982,7 @@ func (p *Preseed) Parse(s *service.Handler, c *initConfig, installedServices map
 		cert := system.ServerInfo.Certificate
 
 		// Fetch system resources from LXD to find disks if we haven't directly set up disks.
-		allResources[peer], err = s.Services[types.LXD].(*service.LXDService).GetResources(context.Background(), peer, system.ServerInfo.Address, cert)
+		allResources[peer], err = s.Services()[types.LXD].(*service.LXDService).GetResources(context.Background(), peer, system.ServerInfo.Address, cert)
 		if err != nil {
 			return nil, fmt.Errorf("Failed to get system resources of peer %q: %w", peer, err)
 		}
@@ -7,6 +7,8 @@ import (
 	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/lxd/shared/units"
 	"github.com/stretchr/testify/suite"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
 )
 
 type preseedSuite struct {
@@ -71,6 +73,26 @@ func (s *preseedSuite) Test_preseedValidateInvalid() {
 			addErr: true,
 			err:    errors.New(`Missing session passphrase`),
 		},
+		{
+			desc: "Unsupported lookup backend",
+			preseed: Preseed{
+				Initiator:     "n1",
+				LookupBackend: "foo",
+				Systems:       []System{{Name: "n1"}},
+			},
+			addErr: true,
+			err:    errors.New(`Unsupported discovery backend "foo"`),
+		},
+		{
+			desc: "Invalid lookup seed",
+			preseed: Preseed{
+				Initiator:   "n1",
+				LookupSeeds: []string{"10.0.0.0/8"},
+				Systems:     []System{{Name: "n1"}},
+			},
+			addErr: true,
+			err:    errors.New(`Seed "10.0.0.0/8" contains more than 4096 addresses`),
+		},
 		{
 			desc: "Missing initiator's name or address",
 			preseed: Preseed{
@@ -293,6 +315,39 @@ func (s *preseedSuite) Test_preseedValidateInvalid() {
 			addErr: true,
 			err:    errors.New("Invalid IPv4 range (must be of the form <ip>-<ip>)"),
 		},
+		{
+			desc: "Initiator fingerprint too short",
+			preseed: Preseed{
+				SessionPassphrase:    "foo",
+				InitiatorAddress:     "1.0.0.1",
+				InitiatorFingerprint: "abcdef",
+				Systems:              []System{{Name: "n1", Address: "1.0.0.1"}, {Name: "n2", Address: "1.0.0.2"}},
+			},
+			addErr: true,
+			err:    errors.New(`Invalid initiator fingerprint: Fingerprint "abcdef" has to contain at least 12 characters`),
+		},
+		{
+			desc: "Initiator fingerprint with invalid characters",
+			preseed: Preseed{
+				SessionPassphrase:    "foo",
+				InitiatorAddress:     "1.0.0.1",
+				InitiatorFingerprint: "abcdef-12345",
+				Systems:              []System{{Name: "n1", Address: "1.0.0.1"}, {Name: "n2", Address: "1.0.0.2"}},
+			},
+			addErr: true,
+			err:    errors.New(`Invalid initiator fingerprint: Fingerprint "abcdef-12345" contains invalid character '-'`),
+		},
+		{
+			desc: "Approved joiner fingerprint too short",
+			preseed: Preseed{
+				SessionPassphrase: "foo",
+				InitiatorAddress:  "1.0.0.1",
+				ApprovedJoiners:   []types.ApprovedJoiner{{Name: "n2", Fingerprint: "abcdef"}},
+				Systems:           []System{{Name: "n1", Address: "1.0.0.1"}, {Name: "n2", Address: "1.0.0.2"}},
+			},
+			addErr: true,
+			err:    errors.New(`Invalid fingerprint of approved joiner "n2": Fingerprint "abcdef" has to contain at least 12 characters`),
+		},
 	}
 
 	s.T().Log("Preseed init missing local system")
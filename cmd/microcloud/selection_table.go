@@ -392,6 +392,19 @@ func (t *SelectableTable) SelectionValue(selectionRow string, columnName string)
 
 // Update redraws the table with the new row added at the end.
 func (t *SelectableTable) Update(row []string) {
+	t.rawRows = append(t.rawRows, row)
+	t.redraw(true)
+}
+
+// Replace redraws the table with the row at the given index replaced by the given row.
+func (t *SelectableTable) Replace(index int, row []string) {
+	t.rawRows[index] = row
+	t.redraw(false)
+}
+
+// redraw renders the raw rows again and updates the entries in the actual selection table.
+// If added is set, the last row is added to the displayed entries.
+func (t *SelectableTable) redraw(added bool) {
 	// Save the old rows so we can update the entries in the actual selection table.
 	oldRows := t.rows
 
@@ -402,7 +415,6 @@ func (t *SelectableTable) Update(row []string) {
 	t.tmpl.Header = ""
 	t.tmpl.Border = ""
 	t.writtenLines = 0
-	t.rawRows = append(t.rawRows, row)
 	t.writer.AppendBulk(t.rawRows)
 	t.writer.Render()
 
@@ -419,7 +431,9 @@ func (t *SelectableTable) Update(row []string) {
 	}
 
 	// Add the new entry to the displayed rows.
-	newEntries = append(newEntries, t.rows[len(t.rows)-1])
+	if added {
+		newEntries = append(newEntries, t.rows[len(t.rows)-1])
+	}
 
 	// Update the map of answers with new keys.
 	for i, row := range t.rows {
@@ -15,7 +15,6 @@ import (
 	"github.com/canonical/microcluster/v2/microcluster"
 	"github.com/spf13/cobra"
 
-	"github.com/canonical/microcloud/microcloud/api"
 	"github.com/canonical/microcloud/microcloud/api/types"
 	"github.com/canonical/microcloud/microcloud/multicast"
 	"github.com/canonical/microcloud/microcloud/service"
@@ -81,11 +80,7 @@ func (c *cmdServiceList) Run(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("MicroCloud is uninitialized, run 'microcloud init' first")
 	}
 
-	services := []types.ServiceType{types.MicroCloud, types.LXD}
-	optionalServices := map[types.ServiceType]string{
-		types.MicroCeph: api.MicroCephDir,
-		types.MicroOVN:  api.MicroOVNDir,
-	}
+	services := service.RequiredServices()
 
 	cfg := initConfig{
 		autoSetup: true,
@@ -99,7 +94,7 @@ func (c *cmdServiceList) Run(cmd *cobra.Command, args []string) error {
 	cfg.name = status.Name
 	cfg.address = status.Address.Addr().String()
 
-	services, err = cfg.askMissingServices(services, optionalServices)
+	services, err = cfg.askMissingServices(services)
 	if err != nil {
 		return err
 	}
@@ -113,7 +108,7 @@ func (c *cmdServiceList) Run(cmd *cobra.Command, args []string) error {
 	mu := sync.Mutex{}
 	header := []string{"NAME", "ADDRESS", "ROLE", "STATUS"}
 	allClusters := map[types.ServiceType][][]string{}
-	err = s.RunConcurrent("", "", func(s service.Service) error {
+	err = s.RunConcurrent(context.Background(), func(ctx context.Context, s service.Service) error {
 		var err error
 		var data [][]string
 		var microClient *client.Client
@@ -250,14 +245,10 @@ func (c *cmdServiceAdd) Run(cmd *cobra.Command, args []string) error {
 	}
 
 	cfg.autoSetup = false
-	installedServices := []types.ServiceType{types.MicroCloud, types.LXD}
-	optionalServices := map[types.ServiceType]string{
-		types.MicroCeph: api.MicroCephDir,
-		types.MicroOVN:  api.MicroOVNDir,
-	}
+	installedServices := service.RequiredServices()
 
 	// Set the auto flag to true so that we automatically omit any services that aren't installed.
-	installedServices, err = cfg.askMissingServices(installedServices, optionalServices)
+	installedServices, err = cfg.askMissingServices(installedServices)
 	if err != nil {
 		return err
 	}
@@ -269,7 +260,7 @@ func (c *cmdServiceAdd) Run(cmd *cobra.Command, args []string) error {
 	}
 
 	services := make(map[types.ServiceType]string, len(installedServices))
-	for _, s := range s.Services {
+	for _, s := range s.Services() {
 		version, err := s.GetVersion(context.Background())
 		if err != nil {
 			return err
@@ -8,9 +8,11 @@ import (
 	"time"
 
 	"github.com/canonical/lxd/shared"
+	"github.com/gorilla/websocket"
 
 	"github.com/canonical/microcloud/microcloud/api/types"
 	cloudClient "github.com/canonical/microcloud/microcloud/client"
+	"github.com/canonical/microcloud/microcloud/cmd/tui"
 	"github.com/canonical/microcloud/microcloud/multicast"
 	"github.com/canonical/microcloud/microcloud/service"
 )
@@ -18,8 +20,16 @@ import (
 type SessionFunc func(gw *cloudClient.WebsocketGateway) error
 
 func (c *initConfig) runSession(ctx context.Context, s *service.Handler, role types.SessionRole, timeout time.Duration, f SessionFunc) error {
-	cloud := s.Services[types.MicroCloud].(*service.CloudService)
-	conn, err := cloud.StartSession(ctx, string(role), timeout)
+	cloud := s.Services()[types.MicroCloud].(*service.CloudService)
+
+	var conn *websocket.Conn
+	var err error
+	if c.resumeSession != "" {
+		conn, err = cloud.ResumeSession(ctx, string(role), c.resumeSession)
+	} else {
+		conn, err = cloud.StartSession(ctx, string(role), timeout)
+	}
+
 	if err != nil {
 		return err
 	}
@@ -30,25 +40,45 @@ func (c *initConfig) runSession(ctx context.Context, s *service.Handler, role ty
 }
 
 func (c *initConfig) initiatingSession(gw *cloudClient.WebsocketGateway, sh *service.Handler, services map[types.ServiceType]string, passphrase string, expectedSystems []string) error {
+	lookupSubnets, err := c.lookupSubnets()
+	if err != nil {
+		return err
+	}
+
 	session := types.Session{
-		Address:    c.address,
-		Interface:  c.lookupIface.Name,
-		Services:   services,
-		Passphrase: passphrase,
+		Address:         c.address,
+		Interface:       c.lookupIface.Name,
+		Interfaces:      c.lookupInterfaces,
+		Services:        services,
+		Passphrase:      passphrase,
+		LookupBackend:   string(c.lookupBackend),
+		LookupSeeds:     c.lookupSeeds,
+		LookupSubnets:   lookupSubnets,
+		LookupTimeout:   c.lookupTimeout,
+		ApprovedJoiners: c.approvedJoiners,
+		Auth:            c.sessionAuth,
+		Keepalive:       c.keepalive,
 	}
 
-	err := gw.Write(session)
-	if err != nil {
-		return fmt.Errorf("Failed to send session start: %w", err)
+	// A resumed session replays its state instead of replying to the start message.
+	state := types.SessionState{}
+	if c.resumeSession == "" {
+		err = gw.WriteMessage(types.SessionMessageStart, session)
+		if err != nil {
+			return fmt.Errorf("Failed to send session start: %w", err)
+		}
+
+		err = gw.ReceivePayload(gw.Context(), types.SessionMessageDetails, &state.SessionDetails)
+	} else {
+		err = gw.ReceivePayload(gw.Context(), types.SessionMessageState, &state)
 	}
 
-	err = gw.ReceiveWithContext(gw.Context(), &session)
 	if err != nil {
 		return fmt.Errorf("Failed to read session reply: %w", err)
 	}
 
 	if !c.autoSetup {
-		cloud := sh.Services[types.MicroCloud].(*service.CloudService)
+		cloud := sh.Services()[types.MicroCloud].(*service.CloudService)
 
 		// If the cluster is already bootstrapped the cluster certificate is used
 		// instead for the server.
@@ -76,14 +106,28 @@ func (c *initConfig) initiatingSession(gw *cloudClient.WebsocketGateway, sh *ser
 		}
 
 		fmt.Printf("Use the following command on systems that you want to join the cluster:\n\n microcloud join\n\n")
-		fmt.Printf("When requested enter the passphrase:\n\n %s\n\n", session.Passphrase)
+		fmt.Printf("When requested enter the passphrase:\n\n %s\n\n", state.Passphrase)
 		fmt.Printf("Verify the fingerprint %q is displayed on joining systems.\n", fingerprint)
+		if state.SessionID != "" {
+			fmt.Printf("If this command gets interrupted, resume the session within %s using --resume %s\n", service.SessionResumeGracePeriod, state.SessionID)
+		}
+
 		fmt.Println("Waiting to detect systems ...")
 	}
 
-	confirmedIntents, err := c.askJoinIntents(gw, expectedSystems)
-	if err != nil {
-		return err
+	// A resumed session might have already confirmed the intents.
+	confirmedIntents := state.ConfirmedIntents
+	if !state.IntentsConfirmed {
+		// Intents of approved systems are confirmed by the server without asking.
+		if len(c.approvedJoiners) > 0 {
+			confirmedIntents, err = c.waitApprovedIntents(gw, expectedSystems)
+		} else {
+			confirmedIntents, err = c.askJoinIntents(gw, expectedSystems)
+		}
+
+		if err != nil {
+			return err
+		}
 	}
 
 	if c.autoSetup {
@@ -96,20 +140,25 @@ func (c *initConfig) initiatingSession(gw *cloudClient.WebsocketGateway, sh *ser
 		}
 	}
 
-	err = gw.Write(types.Session{
-		ConfirmedIntents: confirmedIntents,
-	})
-	if err != nil {
-		return fmt.Errorf("Failed to send join intents: %w", err)
+	if len(c.approvedJoiners) == 0 && !state.IntentsConfirmed {
+		err = gw.WriteMessage(types.SessionMessageConfirmedIntents, types.SessionConfirmedIntents{
+			Intents: confirmedIntents,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to send join intents: %w", err)
+		}
 	}
 
-	err = gw.ReceiveWithContext(gw.Context(), &session)
-	if err != nil {
-		return fmt.Errorf("Failed to read confirmation errors: %w", err)
-	}
+	// A resumed session might have already been accepted.
+	if !state.Accepted {
+		msg, err := receiveMessage(gw)
+		if err != nil {
+			return fmt.Errorf("Failed to read confirmation errors: %w", err)
+		}
 
-	if !session.Accepted {
-		return fmt.Errorf("Join confirmations didn't get accepted on all systems")
+		if msg.Type != types.SessionMessageAccepted {
+			return fmt.Errorf("Join confirmations didn't get accepted on all systems")
+		}
 	}
 
 	for _, joinIntent := range confirmedIntents {
@@ -149,41 +198,126 @@ func (c *initConfig) initiatingSession(gw *cloudClient.WebsocketGateway, sh *ser
 	return nil
 }
 
-func (c *initConfig) joiningSession(gw *cloudClient.WebsocketGateway, sh *service.Handler, services map[types.ServiceType]string, initiatorAddress string, passphrase string) error {
+// receiveMessage reads the next session message from the server.
+// Notifications about addresses banned from joining the session are printed and skipped.
+// Notifications about systems leaving or returning to the session are skipped as they aren't relevant anymore.
+func receiveMessage(gw *cloudClient.WebsocketGateway) (*types.SessionMessage, error) {
+	for {
+		msg, err := gw.ReceiveMessage(gw.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		if msg.Type == types.SessionMessagePeerLeft || msg.Type == types.SessionMessagePeerReturned {
+			continue
+		}
+
+		if msg.Type != types.SessionMessageBannedAddress {
+			return msg, nil
+		}
+
+		banned := types.SessionBannedAddress{}
+		err = msg.Decode(&banned)
+		if err != nil {
+			return nil, err
+		}
+
+		printBannedAddress(banned.Address)
+	}
+}
+
+// printBannedAddress warns about an address which got banned from joining the session after too many failed attempts.
+func printBannedAddress(address string) {
+	tui.PrintWarning(fmt.Sprintf("Banned %q from joining after too many failed attempts", address))
+}
+
+func (c *initConfig) joiningSession(gw *cloudClient.WebsocketGateway, sh *service.Handler, services map[types.ServiceType]string, initiatorAddress string, initiatorName string, passphrase string) error {
 	session := types.Session{
-		Passphrase:       passphrase,
-		Address:          sh.Address(),
-		InitiatorAddress: initiatorAddress,
-		Interface:        c.lookupIface.Name,
-		Services:         services,
-		LookupTimeout:    c.lookupTimeout,
+		Passphrase:           passphrase,
+		Address:              sh.Address(),
+		InitiatorAddress:     initiatorAddress,
+		InitiatorFingerprint: c.initiatorFingerprint,
+		Interface:            c.lookupIface.Name,
+		Interfaces:           c.lookupInterfaces,
+		Services:             services,
+		LookupTimeout:        c.lookupTimeout,
+		LookupBackend:        string(c.lookupBackend),
+		Auth:                 c.sessionAuth,
+		Keepalive:            c.keepalive,
+		JoinToken:            c.joinToken,
 	}
 
-	err := gw.Write(session)
-	if err != nil {
-		return fmt.Errorf("Failed to send session start: %w", err)
+	state := types.SessionState{}
+	if c.resumeSession == "" {
+		err := gw.WriteMessage(types.SessionMessageStart, session)
+		if err != nil {
+			return fmt.Errorf("Failed to send session start: %w", err)
+		}
+	} else {
+		// The resumed session replays its state including the system which was already selected.
+		err := gw.ReceivePayload(gw.Context(), types.SessionMessageState, &state)
+		if err != nil {
+			return fmt.Errorf("Failed to read resumed session: %w", err)
+		}
+
+		if state.InitiatorAddress != "" {
+			initiatorAddress = state.InitiatorAddress
+		}
 	}
 
-	if !c.autoSetup && initiatorAddress == "" {
-		fmt.Println("Searching for an eligible system ...")
+	var err error
+	if initiatorAddress == "" {
+		if !c.autoSetup {
+			fmt.Println("Searching for eligible systems ...")
+		}
+
+		initiatorAddress, err = c.askInitiator(gw, initiatorName)
+		if err != nil {
+			return err
+		}
+
+		err = gw.WriteMessage(types.SessionMessageSelection, types.SessionSelection{
+			InitiatorAddress: initiatorAddress,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to send the selected system: %w", err)
+		}
 	}
 
 	// The server confirms the target regardless whether or not one was provided.
-	err = gw.ReceiveWithContext(gw.Context(), &session)
-	if err != nil {
-		return fmt.Errorf("Failed to find an eligible system: %w", err)
+	// Skip any systems which were still found before the server received the selection.
+	// A resumed session might have already replayed the confirmation.
+	initiator := state.Initiator
+	for initiator == nil {
+		msg, err := gw.ReceiveMessage(gw.Context())
+		if err != nil {
+			return fmt.Errorf("Failed to find an eligible system: %w", err)
+		}
+
+		if msg.Type != types.SessionMessageInitiatorConfirmed {
+			continue
+		}
+
+		initiator = &types.SessionInitiator{}
+		err = msg.Decode(initiator)
+		if err != nil {
+			return fmt.Errorf("Failed to find an eligible system: %w", err)
+		}
 	}
 
-	if !c.autoSetup {
-		fingerprint, err := c.shortFingerprint(session.InitiatorFingerprint)
+	if c.joinToken != "" {
+		fmt.Printf("Redeemed join token of %q at %q\n", initiator.Name, initiator.Address)
+		fmt.Println("Waiting for the system to join the cluster ...")
+	} else if !c.autoSetup {
+		fingerprint, err := c.shortFingerprint(initiator.Fingerprint)
 		if err != nil {
 			return err
 		}
 
-		fmt.Printf("\n Found system %q at %q using fingerprint %q\n\n", session.InitiatorName, session.InitiatorAddress, fingerprint)
-		fmt.Printf("Select %q on %q to let it join the cluster\n", sh.Name, session.InitiatorName)
+		fmt.Printf("\n Found system %q at %q using fingerprint %q\n\n", initiator.Name, initiator.Address, fingerprint)
+		fmt.Printf("Select %q on %q to let it join the cluster\n", sh.Name, initiator.Name)
 	} else {
-		fmt.Printf("Connected to initiator %q\n", session.InitiatorName)
+		fmt.Printf("Connected to initiator %q\n", initiator.Name)
 	}
 
 	return c.askJoinConfirmation(gw, services)
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cli "github.com/canonical/lxd/shared/cmd"
+	"github.com/canonical/microcluster/v2/client"
+	"github.com/canonical/microcluster/v2/microcluster"
+	"github.com/spf13/cobra"
+
+	cloudClient "github.com/canonical/microcloud/microcloud/client"
+	"github.com/canonical/microcloud/microcloud/cmd/tui"
+)
+
+type cmdSession struct {
+	common *CmdControl
+}
+
+func (c *cmdSession) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "session",
+		Short: "Manage the active trust establishment session",
+		RunE:  c.Run,
+	}
+
+	var cmdShow = cmdSessionShow{common: c.common}
+	cmd.AddCommand(cmdShow.Command())
+
+	var cmdAbort = cmdSessionAbort{common: c.common}
+	cmd.AddCommand(cmdAbort.Command())
+
+	var cmdHistory = cmdSessionHistory{common: c.common}
+	cmd.AddCommand(cmdHistory.Command())
+
+	return cmd
+}
+
+func (c *cmdSession) Run(cmd *cobra.Command, args []string) error {
+	return cmd.Help()
+}
+
+type cmdSessionShow struct {
+	common     *CmdControl
+	flagFormat string
+}
+
+func (c *cmdSessionShow) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show the active trust establishment session",
+		RunE:  c.Run,
+	}
+
+	cmd.Flags().StringVarP(&c.flagFormat, "format", "f", cli.TableFormatTable, "Format of the tables (csv|json|table|yaml|compact)")
+
+	return cmd
+}
+
+func (c *cmdSessionShow) Run(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return cmd.Help()
+	}
+
+	localClient, err := sessionClient(c.common)
+	if err != nil {
+		return err
+	}
+
+	status, err := cloudClient.GetSession(context.Background(), localClient)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("ID: %s\n", status.ID)
+	fmt.Printf("Role: %s\n", status.Role)
+	fmt.Printf("Client connected: %t\n", status.Connected)
+	fmt.Printf("Started: %s\n", status.StartedAt.Format(time.RFC3339))
+	if status.Timeout > 0 {
+		expiresAt := status.StartedAt.Add(status.Timeout)
+		fmt.Printf("Timeout: %s (expires in %s)\n", status.Timeout, time.Until(expiresAt).Round(time.Second))
+	}
+
+	fmt.Printf("Failed attempts: %d\n", status.FailedAttempts)
+
+	if len(status.Intents) > 0 {
+		fmt.Println("\nJoin intents:")
+
+		data := make([][]string, 0, len(status.Intents))
+		for _, intent := range status.Intents {
+			fingerprint := intent.Fingerprint
+			if len(fingerprint) > 12 {
+				fingerprint = fingerprint[:12]
+			}
+
+			data = append(data, []string{intent.Name, intent.Address, fingerprint})
+		}
+
+		err = cli.RenderTable(c.flagFormat, []string{"NAME", "ADDRESS", "FINGERPRINT"}, data, status.Intents)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(status.FailedSources) > 0 {
+		fmt.Println("\nFailed sources:")
+
+		data := make([][]string, 0, len(status.FailedSources))
+		for _, source := range status.FailedSources {
+			blocked := "no"
+			if time.Now().Before(source.BlockedUntil) {
+				blocked = source.BlockedUntil.Format(time.RFC3339)
+			}
+
+			data = append(data, []string{source.Address, fmt.Sprint(source.Attempts), blocked})
+		}
+
+		err = cli.RenderTable(c.flagFormat, []string{"ADDRESS", "ATTEMPTS", "BLOCKED UNTIL"}, data, status.FailedSources)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type cmdSessionAbort struct {
+	common *CmdControl
+}
+
+func (c *cmdSessionAbort) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "abort",
+		Short: "Abort the active trust establishment session",
+		RunE:  c.Run,
+	}
+
+	return cmd
+}
+
+func (c *cmdSessionAbort) Run(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return cmd.Help()
+	}
+
+	localClient, err := sessionClient(c.common)
+	if err != nil {
+		return err
+	}
+
+	err = cloudClient.DeleteSession(context.Background(), localClient)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s Aborted the active session\n", tui.SuccessSymbol())
+
+	return nil
+}
+
+type cmdSessionHistory struct {
+	common     *CmdControl
+	flagFormat string
+}
+
+func (c *cmdSessionHistory) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history [<session-id>]",
+		Short: "Show the recorded events of past trust establishment sessions",
+		Long: `Show the recorded events of past trust establishment sessions
+
+Each member of the cluster records the events of the sessions it took part in.
+Joins using a join token are listed with the token's ID as the session ID.`,
+		RunE: c.Run,
+	}
+
+	cmd.Flags().StringVarP(&c.flagFormat, "format", "f", cli.TableFormatTable, "Format of the tables (csv|json|table|yaml|compact)")
+
+	return cmd
+}
+
+func (c *cmdSessionHistory) Run(cmd *cobra.Command, args []string) error {
+	if len(args) > 1 {
+		return cmd.Help()
+	}
+
+	var sessionID string
+	if len(args) == 1 {
+		sessionID = args[0]
+	}
+
+	localClient, err := sessionClient(c.common)
+	if err != nil {
+		return err
+	}
+
+	events, err := cloudClient.GetSessionHistory(context.Background(), localClient, sessionID)
+	if err != nil {
+		return err
+	}
+
+	data := make([][]string, 0, len(events))
+	for _, event := range events {
+		id := event.SessionID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+
+		fingerprint := event.Fingerprint
+		if len(fingerprint) > 12 {
+			fingerprint = fingerprint[:12]
+		}
+
+		data = append(data, []string{event.CreatedAt.Local().Format(time.RFC3339), id, event.Member, string(event.Role), string(event.Type), event.Name, event.Address, fingerprint, event.Details})
+	}
+
+	header := []string{"TIME", "SESSION", "MEMBER", "ROLE", "EVENT", "NAME", "ADDRESS", "FINGERPRINT", "DETAILS"}
+
+	return cli.RenderTable(c.flagFormat, header, data, events)
+}
+
+// sessionClient returns a client for the local MicroCloud daemon.
+// Sessions are also active before MicroCloud is initialized so the daemon only has to be ready.
+func sessionClient(common *CmdControl) (*client.Client, error) {
+	cloudApp, err := microcluster.App(microcluster.Args{StateDir: common.FlagMicroCloudDir})
+	if err != nil {
+		return nil, err
+	}
+
+	err = cloudApp.Ready(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to wait for MicroCloud to get ready: %w", err)
+	}
+
+	return cloudApp.LocalClient()
+}
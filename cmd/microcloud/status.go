@@ -11,7 +11,6 @@ import (
 	microTypes "github.com/canonical/microcluster/v2/rest/types"
 	"github.com/spf13/cobra"
 
-	"github.com/canonical/microcloud/microcloud/api"
 	"github.com/canonical/microcloud/microcloud/api/types"
 	"github.com/canonical/microcloud/microcloud/client"
 	"github.com/canonical/microcloud/microcloud/cmd/tui"
@@ -137,13 +136,9 @@ func (c *cmdStatus) Run(cmd *cobra.Command, args []string) error {
 	cfg.name = status.Name
 	cfg.address = status.Address.Addr().String()
 
-	services := []types.ServiceType{types.MicroCloud, types.LXD}
-	optionalServices := map[types.ServiceType]string{
-		types.MicroCeph: api.MicroCephDir,
-		types.MicroOVN:  api.MicroOVNDir,
-	}
+	services := service.RequiredServices()
 
-	services, err = cfg.askMissingServices(services, optionalServices)
+	services, err = cfg.askMissingServices(services)
 	if err != nil {
 		return err
 	}
@@ -154,7 +149,7 @@ func (c *cmdStatus) Run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	cloudClient, err := sh.Services[types.MicroCloud].(*service.CloudService).Client()
+	cloudClient, err := sh.Services()[types.MicroCloud].(*service.CloudService).Client()
 	if err != nil {
 		return err
 	}
@@ -241,11 +236,23 @@ func compileWarnings(name string, statuses []types.Status) Warnings {
 	// Systems that are offline on at least one service.
 	offlineSystems := map[string][]string{}
 
+	// Issues found by the health checks of the services.
+	// Issues concerning a whole cluster are reported by each of its members, so only the first report is kept.
+	healthFindings := []types.HealthFinding{}
+	seenFindings := map[types.HealthFinding]bool{}
+
 	osdsConfigured := false
 	clusterSize := 0
 	osdCount := 0
 
 	for _, s := range statuses {
+		for _, finding := range s.Health {
+			if !seenFindings[finding] {
+				seenFindings[finding] = true
+				healthFindings = append(healthFindings, finding)
+			}
+		}
+
 		if s.Name == name {
 			clusterSize = len(s.Clusters[types.MicroCloud])
 			for service, clusterMembers := range s.Clusters {
@@ -264,13 +271,13 @@ func compileWarnings(name string, statuses []types.Status) Warnings {
 		}
 
 		osdCount = osdCount + len(s.OSDs)
-		allServices := []types.ServiceType{types.LXD, types.MicroCeph, types.MicroOVN, types.MicroCloud}
 		cloudMembers := make(map[string]bool, len(s.Clusters[types.MicroCloud]))
 		for _, member := range s.Clusters[types.MicroCloud] {
 			cloudMembers[member.Name] = true
 		}
 
-		for _, service := range allServices {
+		for _, def := range service.Definitions() {
+			service := def.Type
 			members, ok := s.Clusters[service]
 			if !ok || len(members) == 0 {
 				if uninstalledServices[service] == nil {
@@ -375,14 +382,16 @@ func compileWarnings(name string, statuses []types.Status) Warnings {
 		warnings = append(warnings, Warning{Level: Warn, Message: msg})
 	}
 
-	for service, names := range uninstalledServices {
-		if service == types.LXD || service == types.MicroCloud {
+	for serviceType, names := range uninstalledServices {
+		// Missing required services are reported separately.
+		def, ok := service.Lookup(serviceType)
+		if !ok || !def.Optional {
 			continue
 		}
 
 		tmpl := tui.Fmt{Arg: "%s is not found on %s"}
 		msg := tui.Printf(tmpl,
-			tui.Fmt{Color: tui.Bright, Bold: true, Arg: service},
+			tui.Fmt{Color: tui.Bright, Bold: true, Arg: serviceType},
 			tui.Fmt{Color: tui.Bright, Bold: true, Arg: strings.Join(names, ", ")})
 		warnings = append(warnings, Warning{Level: Warn, Message: msg})
 	}
@@ -400,6 +409,17 @@ func compileWarnings(name string, statuses []types.Status) Warnings {
 		warnings = append(warnings, Warning{Level: Warn, Message: msg})
 	}
 
+	for _, finding := range healthFindings {
+		level := Warn
+		if finding.Severity == types.HealthError {
+			level = Error
+		}
+
+		tmpl := tui.Fmt{Arg: "%s: %s"}
+		msg := tui.Printf(tmpl, tui.Fmt{Color: tui.Bright, Bold: true, Arg: finding.Service}, tui.Fmt{Arg: finding.Message})
+		warnings = append(warnings, Warning{Level: level, Message: msg})
+	}
+
 	return warnings
 }
 
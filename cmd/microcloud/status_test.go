@@ -467,6 +467,51 @@ func (s *statusSuite) Test_statusWarnings() {
 			},
 			expectedWarnings: []Warning{},
 		},
+		{
+			desc: "3 node MicroCloud with health issues",
+			statuses: []types.Status{
+				{
+					Name:    "micro01",
+					Address: "10.0.0.101",
+					Clusters: map[types.ServiceType][]microTypes.ClusterMember{
+						types.MicroCloud: {genMember("micro01", microTypes.MemberOnline), genMember("micro02", microTypes.MemberOnline), genMember("micro03", microTypes.MemberOnline)},
+						types.MicroOVN:   {genMember("micro01", microTypes.MemberOnline), genMember("micro02", microTypes.MemberOnline), genMember("micro03", microTypes.MemberOnline)},
+						types.MicroCeph:  {genMember("micro01", microTypes.MemberOnline), genMember("micro02", microTypes.MemberOnline), genMember("micro03", microTypes.MemberOnline)},
+						types.LXD:        {genMember("micro01", microTypes.MemberOnline), genMember("micro02", microTypes.MemberOnline), genMember("micro03", microTypes.MemberOnline)},
+					},
+					OSDs:   cephTypes.Disks{{OSD: 0}},
+					Health: []types.HealthFinding{{Service: types.MicroCeph, Severity: types.HealthWarning, Message: "Only 1 monitors are running, at least 3 are required for fault tolerance"}},
+				},
+				{
+					Name:    "micro02",
+					Address: "10.0.0.102",
+					Clusters: map[types.ServiceType][]microTypes.ClusterMember{
+						types.MicroCloud: {genMember("micro01", microTypes.MemberOnline), genMember("micro02", microTypes.MemberOnline), genMember("micro03", microTypes.MemberOnline)},
+						types.MicroOVN:   {genMember("micro01", microTypes.MemberOnline), genMember("micro02", microTypes.MemberOnline), genMember("micro03", microTypes.MemberOnline)},
+						types.MicroCeph:  {genMember("micro01", microTypes.MemberOnline), genMember("micro02", microTypes.MemberOnline), genMember("micro03", microTypes.MemberOnline)},
+						types.LXD:        {genMember("micro01", microTypes.MemberOnline), genMember("micro02", microTypes.MemberOnline), genMember("micro03", microTypes.MemberOnline)},
+					},
+					OSDs:   cephTypes.Disks{{OSD: 1}},
+					Health: []types.HealthFinding{{Service: types.MicroCeph, Severity: types.HealthWarning, Message: "Only 1 monitors are running, at least 3 are required for fault tolerance"}, {Service: types.MicroOVN, Severity: types.HealthError, Message: "Northbound database is unreachable from micro02"}},
+				},
+				{
+					Name:    "micro03",
+					Address: "10.0.0.103",
+					Clusters: map[types.ServiceType][]microTypes.ClusterMember{
+						types.MicroCloud: {genMember("micro01", microTypes.MemberOnline), genMember("micro02", microTypes.MemberOnline), genMember("micro03", microTypes.MemberOnline)},
+						types.MicroOVN:   {genMember("micro01", microTypes.MemberOnline), genMember("micro02", microTypes.MemberOnline), genMember("micro03", microTypes.MemberOnline)},
+						types.MicroCeph:  {genMember("micro01", microTypes.MemberOnline), genMember("micro02", microTypes.MemberOnline), genMember("micro03", microTypes.MemberOnline)},
+						types.LXD:        {genMember("micro01", microTypes.MemberOnline), genMember("micro02", microTypes.MemberOnline), genMember("micro03", microTypes.MemberOnline)},
+					},
+					OSDs:   cephTypes.Disks{{OSD: 2}},
+					Health: []types.HealthFinding{{Service: types.MicroCeph, Severity: types.HealthWarning, Message: "Only 1 monitors are running, at least 3 are required for fault tolerance"}},
+				},
+			},
+			expectedWarnings: []Warning{
+				{Level: Warn, Message: "MicroCeph: Only 1 monitors are running, at least 3 are required for fault tolerance"},
+				{Level: Error, Message: "MicroOVN: Northbound database is unreachable from micro02"},
+			},
+		},
 	}
 
 	for i, c := range cases {
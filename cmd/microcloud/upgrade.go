@@ -0,0 +1,601 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cli "github.com/canonical/lxd/shared/cmd"
+	"github.com/canonical/microcluster/v2/client"
+	"github.com/canonical/microcluster/v2/microcluster"
+	microTypes "github.com/canonical/microcluster/v2/rest/types"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+	cloudClient "github.com/canonical/microcloud/microcloud/client"
+	"github.com/canonical/microcloud/microcloud/cmd/tui"
+	"github.com/canonical/microcloud/microcloud/service"
+)
+
+// upgradePlanFile is the name of the file in the MicroCloud state directory which contains the progress of a paused upgrade.
+const upgradePlanFile = "upgrade.yaml"
+
+// upgradePollInterval is the interval in which the status of the cluster members is checked while waiting for them to come back online.
+const upgradePollInterval = 5 * time.Second
+
+// upgradeStep is a single step of an upgrade which refreshes the snap of a service on some cluster members.
+type upgradeStep struct {
+	Service types.ServiceType `yaml:"service"`
+	Snap    string            `yaml:"snap"`
+	Channel string            `yaml:"channel,omitempty"`
+	Members []string          `yaml:"members"`
+	Done    bool              `yaml:"done"`
+}
+
+// Command returns the command which has to be run on each of the step's members.
+func (s upgradeStep) Command() string {
+	if s.Channel != "" {
+		return fmt.Sprintf("sudo snap refresh %s --channel %q --cohort=\"+\"", s.Snap, s.Channel)
+	}
+
+	return fmt.Sprintf("sudo snap refresh %s --cohort=\"+\"", s.Snap)
+}
+
+// upgradePlan contains the steps of an upgrade in the order in which they have to be performed.
+type upgradePlan struct {
+	// Member is the name of the cluster member the upgrade is run from.
+	Member string        `yaml:"member"`
+	Steps  []upgradeStep `yaml:"steps"`
+}
+
+type cmdUpgrade struct {
+	common *CmdControl
+
+	flagDryRun   bool
+	flagRestart  bool
+	flagChannels map[string]string
+	flagTimeout  time.Duration
+}
+
+func (c *cmdUpgrade) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: "Guide through refreshing the snaps of all cluster members",
+		Long: `Guide through refreshing the snaps of all cluster members
+
+The snaps are refreshed one service after another, with the dependencies of MicroCloud first and MicroCloud last.
+For each step the command to run on the affected cluster members is shown, and the upgrade waits for them to be online again before continuing.
+Until a service is refreshed on all of its members, the refreshed members may report that they need or are performing an upgrade
+while waiting for the remaining members. So only after the last step of a service, all of its members have to be online.
+
+Without a channel, members which already run a newer version of a service than the others are skipped.
+The upgrade is refused if the members of a service run different major or minor versions, unless a channel is given for its snap.
+An upgrade can be paused before each step, and is resumed from where it was paused when running the command again.`,
+		RunE: c.Run,
+	}
+
+	cmd.Flags().BoolVar(&c.flagDryRun, "dry-run", false, "Show the steps of the upgrade without performing them")
+	cmd.Flags().BoolVar(&c.flagRestart, "restart", false, "Discard the progress of a paused upgrade and plan a new one")
+	cmd.Flags().StringToStringVar(&c.flagChannels, "channel", nil, "Channel to refresh a snap to, e.g. microceph=squid/stable. Without a channel, the snap is updated within its tracked channel")
+	cmd.Flags().DurationVar(&c.flagTimeout, "timeout", 30*time.Minute, "Time to wait for the cluster members to be online again after each step")
+
+	return cmd
+}
+
+func (c *cmdUpgrade) Run(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return cmd.Help()
+	}
+
+	cloudApp, err := microcluster.App(microcluster.Args{StateDir: c.common.FlagMicroCloudDir})
+	if err != nil {
+		return err
+	}
+
+	err = cloudApp.Ready(context.Background())
+	if err != nil {
+		return fmt.Errorf("Failed to wait for MicroCloud to get ready: %w", err)
+	}
+
+	status, err := cloudApp.Status(context.Background())
+	if err != nil {
+		return fmt.Errorf("Failed to get MicroCloud status: %w", err)
+	}
+
+	if !status.Ready {
+		return fmt.Errorf("MicroCloud is uninitialized, run 'microcloud init' first")
+	}
+
+	localClient, err := cloudApp.LocalClient()
+	if err != nil {
+		return err
+	}
+
+	statuses, err := cloudClient.GetStatus(context.Background(), localClient)
+	if err != nil {
+		return err
+	}
+
+	planPath := filepath.Join(cloudApp.FileSystem.StateDir, upgradePlanFile)
+	plan, err := loadUpgradePlan(planPath)
+	if err != nil {
+		return err
+	}
+
+	if plan != nil && !c.flagRestart && len(c.flagChannels) > 0 {
+		return fmt.Errorf("Cannot change the channels of a paused upgrade, use --restart to plan a new upgrade")
+	}
+
+	if plan == nil || c.flagRestart {
+		var warnings []string
+		plan, warnings, err = planUpgrade(status.Name, statuses, c.flagChannels)
+		if err != nil {
+			return err
+		}
+
+		for _, warning := range warnings {
+			tui.PrintWarning(warning)
+		}
+	} else {
+		fmt.Printf("Resuming paused upgrade\n\n")
+	}
+
+	if c.flagDryRun {
+		return renderUpgradePlan(plan, statuses)
+	}
+
+	err = saveUpgradePlan(planPath, plan)
+	if err != nil {
+		return err
+	}
+
+	for i, step := range plan.Steps {
+		if step.Done {
+			continue
+		}
+
+		def, _ := service.Lookup(step.Service)
+		fmt.Printf("Step %d/%d: Refresh %s on %s\n", i+1, len(plan.Steps), step.Service, strings.Join(step.Members, ", "))
+		fmt.Printf("Run the following command on %s:\n\n", strings.Join(step.Members, ", "))
+		fmt.Printf("    %s\n\n", step.Command())
+		if def.ParallelUpgrade && len(step.Members) > 1 {
+			fmt.Printf("The refresh blocks until %s is refreshed on all members, so run the command on all members at the same time.\n", step.Service)
+		}
+
+		proceed, err := c.common.asker.AskBool("Continue once the command completed? Answer no to pause the upgrade (yes/no) [default=yes]: ", "yes")
+		if err != nil {
+			return err
+		}
+
+		if !proceed {
+			fmt.Println("Upgrade paused, run 'microcloud upgrade' again to resume it")
+
+			return nil
+		}
+
+		err = c.waitForUpgradeStep(plan, i, localClient)
+		if err != nil {
+			return err
+		}
+
+		plan.Steps[i].Done = true
+		err = saveUpgradePlan(planPath, plan)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s Refreshed %s on %s\n\n", tui.SuccessSymbol(), step.Service, strings.Join(step.Members, ", "))
+	}
+
+	err = os.Remove(planPath)
+	if err != nil {
+		return fmt.Errorf("Failed to remove upgrade progress: %w", err)
+	}
+
+	fmt.Printf("%s Upgrade completed, run 'microcloud status' to check the health of the cluster\n", tui.SuccessSymbol())
+
+	return nil
+}
+
+// waitForUpgradeStep waits until the status of the cluster members shows that the given step of the upgrade plan is complete.
+func (c *cmdUpgrade) waitForUpgradeStep(plan *upgradePlan, index int, localClient *client.Client) error {
+	step := plan.Steps[index]
+	ctx, cancel := context.WithTimeout(context.Background(), c.flagTimeout)
+	defer cancel()
+
+	fmt.Printf("Waiting for %s to be online on %s\n", step.Service, strings.Join(step.Members, ", "))
+	for {
+		// The status is unavailable while the local MicroCloud is being refreshed.
+		statuses, err := cloudClient.GetStatus(ctx, localClient)
+		if err == nil && upgradeStepComplete(plan, index, statuses) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Timed out waiting for %s to be online on %s", step.Service, strings.Join(step.Members, ", "))
+		case <-time.After(upgradePollInterval):
+		}
+	}
+}
+
+// upgradeOrder returns the given services in the order in which they have to be upgraded.
+// Services are upgraded after their dependencies, and MicroCloud is upgraded last as it consumes all other services.
+func upgradeOrder(services []types.ServiceType) []types.ServiceType {
+	pending := make(map[types.ServiceType]bool, len(services))
+	for _, serviceType := range services {
+		if serviceType != types.MicroCloud {
+			pending[serviceType] = true
+		}
+	}
+
+	order := make([]types.ServiceType, 0, len(services))
+	for len(pending) > 0 {
+		ready := []types.ServiceType{}
+		for serviceType := range pending {
+			def, _ := service.Lookup(serviceType)
+			blocked := false
+			for _, dependency := range def.Dependencies {
+				if pending[dependency] && dependency != serviceType {
+					blocked = true
+					break
+				}
+			}
+
+			if !blocked {
+				ready = append(ready, serviceType)
+			}
+		}
+
+		// Cyclic dependencies are rejected when running the service hooks, so just upgrade the remaining services in any order.
+		if len(ready) == 0 {
+			for serviceType := range pending {
+				ready = append(ready, serviceType)
+			}
+		}
+
+		sort.Slice(ready, func(i, j int) bool { return ready[i] < ready[j] })
+		for _, serviceType := range ready {
+			order = append(order, serviceType)
+			delete(pending, serviceType)
+		}
+	}
+
+	for _, serviceType := range services {
+		if serviceType == types.MicroCloud {
+			order = append(order, types.MicroCloud)
+			break
+		}
+	}
+
+	return order
+}
+
+// parseUpgradeVersion returns the numeric components of the first version number contained in the given version, e.g. 19.2.0.
+// It returns nil if the version doesn't contain a version number.
+func parseUpgradeVersion(version string) []int {
+	match := regexp.MustCompile(`\d+(\.\d+)*`).FindString(version)
+	if match == "" {
+		return nil
+	}
+
+	parts := strings.Split(match, ".")
+	components := make([]int, 0, len(parts))
+	for _, part := range parts {
+		component, err := strconv.Atoi(part)
+		if err != nil {
+			return nil
+		}
+
+		components = append(components, component)
+	}
+
+	return components
+}
+
+// compareUpgradeVersions compares the given version components up to the given number of components, or all of them if it's zero.
+// Missing components are treated as zero.
+func compareUpgradeVersions(a []int, b []int, components int) int {
+	if components == 0 {
+		components = max(len(a), len(b))
+	}
+
+	for i := 0; i < components; i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+
+		if i < len(b) {
+			y = b[i]
+		}
+
+		if x != y {
+			return x - y
+		}
+	}
+
+	return 0
+}
+
+// upToDateMembers returns the given members of the service which already run a newer version than the others, according to their own status.
+// Members whose version is unknown are never up to date.
+// An error is returned if the members run different major or minor versions, as refreshing them within their tracked channels won't align them.
+func upToDateMembers(serviceType types.ServiceType, members []string, statusByName map[string]types.Status) (map[string]bool, error) {
+	versions := make(map[string][]int, len(members))
+	var newest []int
+	for _, member := range members {
+		version := parseUpgradeVersion(statusByName[member].Versions[serviceType])
+		if version == nil {
+			continue
+		}
+
+		versions[member] = version
+		if newest == nil || compareUpgradeVersions(version, newest, 0) > 0 {
+			newest = version
+		}
+	}
+
+	upToDate := map[string]bool{}
+	for member, version := range versions {
+		if compareUpgradeVersions(version, newest, 2) != 0 {
+			skew := make([]string, 0, len(members))
+			for _, member := range members {
+				skew = append(skew, fmt.Sprintf("%s: %s", member, statusByName[member].Versions[serviceType]))
+			}
+
+			return nil, fmt.Errorf("Cannot upgrade while the members of %s run different versions (%s), use --channel to refresh them to the same channel", serviceType, strings.Join(skew, ", "))
+		}
+
+		if compareUpgradeVersions(version, newest, 0) == 0 {
+			upToDate[member] = true
+		}
+	}
+
+	// If all members run the same version, it's unknown whether a newer one is available.
+	if len(upToDate) == len(members) {
+		return map[string]bool{}, nil
+	}
+
+	return upToDate, nil
+}
+
+// planUpgrade returns the steps to upgrade the services of the cluster as seen from the given local member, and warnings about the plan.
+// Each service is refreshed on one member after another, the local member last, unless it has to be refreshed on all members at once.
+// The channels contain the channel to refresh each snap to, by snap name.
+// Without a channel, members which already run a newer version of a service than the others are skipped.
+func planUpgrade(localName string, statuses []types.Status, channels map[string]string) (*upgradePlan, []string, error) {
+	snaps := map[string]bool{}
+	for _, def := range service.Definitions() {
+		if def.Snap != "" {
+			snaps[def.Snap] = true
+		}
+	}
+
+	for snap := range channels {
+		if !snaps[snap] {
+			return nil, nil, fmt.Errorf("Unknown snap %q in channels", snap)
+		}
+	}
+
+	statusByName := make(map[string]types.Status, len(statuses))
+	for _, status := range statuses {
+		statusByName[status.Name] = status
+	}
+
+	localStatus, ok := statusByName[localName]
+	if !ok {
+		return nil, nil, fmt.Errorf("Failed to get the status of the local member %q", localName)
+	}
+
+	for _, member := range localStatus.Clusters[types.MicroCloud] {
+		_, ok := statusByName[member.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("Cannot upgrade while member %q is unreachable", member.Name)
+		}
+	}
+
+	services := make([]types.ServiceType, 0, len(localStatus.Clusters))
+	for serviceType, members := range localStatus.Clusters {
+		if len(members) > 0 {
+			services = append(services, serviceType)
+		}
+	}
+
+	warnings := []string{}
+	plan := &upgradePlan{Member: localName, Steps: []upgradeStep{}}
+	for _, serviceType := range upgradeOrder(services) {
+		def, ok := service.Lookup(serviceType)
+		if !ok || def.Snap == "" {
+			continue
+		}
+
+		members := make([]string, 0, len(localStatus.Clusters[serviceType]))
+		for _, member := range localStatus.Clusters[serviceType] {
+			if member.Status != microTypes.MemberOnline && member.Status != microTypes.MemberNeedsUpgrade && member.Status != microTypes.MemberUpgrading {
+				return nil, nil, fmt.Errorf("Cannot upgrade while %s on %q is %s", serviceType, member.Name, member.Status)
+			}
+
+			members = append(members, member.Name)
+		}
+
+		sort.Slice(members, func(i, j int) bool {
+			if members[i] == localName || members[j] == localName {
+				return members[j] == localName
+			}
+
+			return members[i] < members[j]
+		})
+
+		// The version of a channel isn't known, so members are only skipped when refreshing within their tracked channel.
+		upToDate := map[string]bool{}
+		if channels[def.Snap] == "" {
+			var err error
+			upToDate, err = upToDateMembers(serviceType, members, statusByName)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if len(upToDate) > 0 {
+			skipped := make([]string, 0, len(upToDate))
+			pending := make([]string, 0, len(members)-len(upToDate))
+			for _, member := range members {
+				if upToDate[member] {
+					skipped = append(skipped, member)
+				} else {
+					pending = append(pending, member)
+				}
+			}
+
+			warnings = append(warnings, fmt.Sprintf("Skipping %s on %s as it already runs a newer version than on %s", serviceType, strings.Join(skipped, ", "), strings.Join(pending, ", ")))
+			members = pending
+		}
+
+		step := upgradeStep{Service: serviceType, Snap: def.Snap, Channel: channels[def.Snap]}
+		if def.ParallelUpgrade {
+			step.Members = members
+			plan.Steps = append(plan.Steps, step)
+			continue
+		}
+
+		for _, member := range members {
+			step.Members = []string{member}
+			plan.Steps = append(plan.Steps, step)
+		}
+	}
+
+	return plan, warnings, nil
+}
+
+// upgradeStepComplete returns whether the given step of the upgrade plan is complete according to the status of the cluster members.
+// Until a service is refreshed on all members, the refreshed members may wait for the others and report that they need or are performing an upgrade.
+// So only once the last step of a service is complete, all members of the service have to be online, including those which were skipped.
+func upgradeStepComplete(plan *upgradePlan, index int, statuses []types.Status) bool {
+	var localStatus *types.Status
+	for i := range statuses {
+		if statuses[i].Name == plan.Member {
+			localStatus = &statuses[i]
+			break
+		}
+	}
+
+	if localStatus == nil {
+		return false
+	}
+
+	step := plan.Steps[index]
+	memberStatuses := make(map[string]microTypes.MemberStatus, len(localStatus.Clusters[step.Service]))
+	for _, member := range localStatus.Clusters[step.Service] {
+		memberStatuses[member.Name] = member.Status
+	}
+
+	lastStep := true
+	for i, other := range plan.Steps {
+		if other.Service == step.Service && i > index {
+			lastStep = false
+			break
+		}
+	}
+
+	// After the last step, this includes the members which were skipped as they already ran a newer version.
+	members := step.Members
+	if lastStep {
+		members = make([]string, 0, len(memberStatuses))
+		for member := range memberStatuses {
+			members = append(members, member)
+		}
+	}
+
+	for _, member := range members {
+		status, ok := memberStatuses[member]
+		if !ok {
+			return false
+		}
+
+		if status == microTypes.MemberOnline {
+			continue
+		}
+
+		if lastStep || (status != microTypes.MemberNeedsUpgrade && status != microTypes.MemberUpgrading) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// renderUpgradePlan prints the steps of the upgrade plan alongside the current versions of the services on their members.
+func renderUpgradePlan(plan *upgradePlan, statuses []types.Status) error {
+	statusByName := make(map[string]types.Status, len(statuses))
+	for _, status := range statuses {
+		statusByName[status.Name] = status
+	}
+
+	data := make([][]string, 0, len(plan.Steps))
+	for i, step := range plan.Steps {
+		versions := make([]string, 0, len(step.Members))
+		for _, member := range step.Members {
+			version := statusByName[member].Versions[step.Service]
+			if version == "" {
+				version = "unknown"
+			}
+
+			versions = append(versions, fmt.Sprintf("%s: %s", member, version))
+		}
+
+		state := "pending"
+		if step.Done {
+			state = "done"
+		}
+
+		data = append(data, []string{fmt.Sprint(i + 1), string(step.Service), strings.Join(step.Members, ", "), strings.Join(versions, ", "), step.Command(), state})
+	}
+
+	header := []string{"STEP", "SERVICE", "MEMBERS", "VERSIONS", "COMMAND", "STATE"}
+
+	return cli.RenderTable(cli.TableFormatTable, header, data, plan.Steps)
+}
+
+// loadUpgradePlan returns the upgrade plan saved at the given path, or nil if there isn't any paused upgrade.
+func loadUpgradePlan(path string) (*upgradePlan, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("Failed to read upgrade progress: %w", err)
+	}
+
+	plan := &upgradePlan{}
+	err = yaml.Unmarshal(content, plan)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse upgrade progress: %w", err)
+	}
+
+	return plan, nil
+}
+
+// saveUpgradePlan saves the upgrade plan at the given path so that the upgrade can be resumed.
+func saveUpgradePlan(path string, plan *upgradePlan) error {
+	content, err := yaml.Marshal(plan)
+	if err != nil {
+		return fmt.Errorf("Failed to encode upgrade progress: %w", err)
+	}
+
+	err = os.WriteFile(path, content, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to write upgrade progress: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	microTypes "github.com/canonical/microcluster/v2/rest/types"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+type upgradeSuite struct {
+	suite.Suite
+}
+
+func TestUpgradeSuite(t *testing.T) {
+	suite.Run(t, new(upgradeSuite))
+}
+
+// upgradeStatuses returns the statuses of a cluster of the given members running all services with the given member status.
+func upgradeStatuses(memberStatus microTypes.MemberStatus, names ...string) []types.Status {
+	members := make([]microTypes.ClusterMember, 0, len(names))
+	for _, name := range names {
+		members = append(members, microTypes.ClusterMember{ClusterMemberLocal: microTypes.ClusterMemberLocal{Name: name}, Status: memberStatus})
+	}
+
+	statuses := make([]types.Status, 0, len(names))
+	for _, name := range names {
+		statuses = append(statuses, types.Status{
+			Name: name,
+			Clusters: map[types.ServiceType][]microTypes.ClusterMember{
+				types.MicroCloud: members,
+				types.MicroCeph:  members,
+				types.MicroOVN:   members,
+				types.LXD:        members,
+			},
+		})
+	}
+
+	return statuses
+}
+
+func (s *upgradeSuite) Test_upgradeOrder() {
+	order := upgradeOrder([]types.ServiceType{types.MicroCloud, types.LXD, types.MicroOVN, types.MicroCeph})
+	s.Require().Equal([]types.ServiceType{types.MicroCeph, types.MicroOVN, types.LXD, types.MicroCloud}, order)
+
+	order = upgradeOrder([]types.ServiceType{types.LXD, types.MicroCloud})
+	s.Require().Equal([]types.ServiceType{types.LXD, types.MicroCloud}, order)
+}
+
+func (s *upgradeSuite) Test_planUpgrade() {
+	statuses := upgradeStatuses(microTypes.MemberOnline, "micro01", "micro02", "micro03")
+
+	// The local member is refreshed last, and LXD on all members at once.
+	plan, _, err := planUpgrade("micro02", statuses, map[string]string{"microceph": "squid/stable"})
+	s.Require().NoError(err)
+	s.Require().Equal("micro02", plan.Member)
+	s.Require().Equal([]upgradeStep{
+		{Service: types.MicroCeph, Snap: "microceph", Channel: "squid/stable", Members: []string{"micro01"}},
+		{Service: types.MicroCeph, Snap: "microceph", Channel: "squid/stable", Members: []string{"micro03"}},
+		{Service: types.MicroCeph, Snap: "microceph", Channel: "squid/stable", Members: []string{"micro02"}},
+		{Service: types.MicroOVN, Snap: "microovn", Members: []string{"micro01"}},
+		{Service: types.MicroOVN, Snap: "microovn", Members: []string{"micro03"}},
+		{Service: types.MicroOVN, Snap: "microovn", Members: []string{"micro02"}},
+		{Service: types.LXD, Snap: "lxd", Members: []string{"micro01", "micro03", "micro02"}},
+		{Service: types.MicroCloud, Snap: "microcloud", Members: []string{"micro01"}},
+		{Service: types.MicroCloud, Snap: "microcloud", Members: []string{"micro03"}},
+		{Service: types.MicroCloud, Snap: "microcloud", Members: []string{"micro02"}},
+	}, plan.Steps)
+	s.Require().Equal(`sudo snap refresh microceph --channel "squid/stable" --cohort="+"`, plan.Steps[0].Command())
+	s.Require().Equal(`sudo snap refresh lxd --cohort="+"`, plan.Steps[6].Command())
+
+	_, _, err = planUpgrade("micro01", statuses, map[string]string{"ceph": "squid/stable"})
+	s.Require().EqualError(err, `Unknown snap "ceph" in channels`)
+
+	_, _, err = planUpgrade("micro01", statuses[:2], nil)
+	s.Require().EqualError(err, `Cannot upgrade while member "micro03" is unreachable`)
+
+	statuses[0].Clusters[types.MicroOVN] = []microTypes.ClusterMember{{ClusterMemberLocal: microTypes.ClusterMemberLocal{Name: "micro02"}, Status: microTypes.MemberUnreachable}}
+	_, _, err = planUpgrade("micro01", statuses, nil)
+	s.Require().EqualError(err, `Cannot upgrade while MicroOVN on "micro02" is UNREACHABLE`)
+}
+
+func (s *upgradeSuite) Test_planUpgradeVersions() {
+	statuses := upgradeStatuses(microTypes.MemberOnline, "micro01", "micro02", "micro03")
+	versions := map[string]string{"micro01": "19.2.1", "micro02": "19.2.0", "micro03": "ceph version 19.2.1 (reef)"}
+	for i := range statuses {
+		statuses[i].Versions = map[types.ServiceType]string{types.MicroCeph: versions[statuses[i].Name], types.LXD: "5.21.3 LTS"}
+	}
+
+	// Members already running a newer version are skipped, while services with the same version on all members are refreshed everywhere.
+	plan, warnings, err := planUpgrade("micro01", statuses, nil)
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"Skipping MicroCeph on micro03, micro01 as it already runs a newer version than on micro02"}, warnings)
+	s.Require().Equal(upgradeStep{Service: types.MicroCeph, Snap: "microceph", Members: []string{"micro02"}}, plan.Steps[0])
+	s.Require().Equal(types.MicroOVN, plan.Steps[1].Service)
+	s.Require().Equal(upgradeStep{Service: types.LXD, Snap: "lxd", Members: []string{"micro02", "micro03", "micro01"}}, plan.Steps[4])
+
+	// Members aren't skipped when refreshing to a channel as its version isn't known.
+	plan, warnings, err = planUpgrade("micro01", statuses, map[string]string{"microceph": "squid/stable"})
+	s.Require().NoError(err)
+	s.Require().Empty(warnings)
+	s.Require().Equal([]string{"micro02"}, plan.Steps[0].Members)
+	s.Require().Equal([]string{"micro01"}, plan.Steps[2].Members)
+
+	// Different major or minor versions are only aligned by refreshing to a channel.
+	statuses[1].Versions[types.MicroCeph] = "18.2.4"
+	_, _, err = planUpgrade("micro01", statuses, nil)
+	s.Require().EqualError(err, "Cannot upgrade while the members of MicroCeph run different versions (micro02: 18.2.4, micro03: ceph version 19.2.1 (reef), micro01: 19.2.1), use --channel to refresh them to the same channel")
+
+	_, _, err = planUpgrade("micro01", statuses, map[string]string{"microceph": "squid/stable"})
+	s.Require().NoError(err)
+}
+
+func (s *upgradeSuite) Test_upgradeStepComplete() {
+	plan, _, err := planUpgrade("micro01", upgradeStatuses(microTypes.MemberOnline, "micro01", "micro02"), nil)
+	s.Require().NoError(err)
+	s.Require().Equal(types.MicroCeph, plan.Steps[1].Service)
+
+	// Refreshed members may wait for the remaining members of the service.
+	s.Require().True(upgradeStepComplete(plan, 0, upgradeStatuses(microTypes.MemberUpgrading, "micro01", "micro02")))
+	s.Require().False(upgradeStepComplete(plan, 0, upgradeStatuses(microTypes.MemberUnreachable, "micro01", "micro02")))
+
+	// Once the service is refreshed on all members, all of them have to be online.
+	s.Require().False(upgradeStepComplete(plan, 1, upgradeStatuses(microTypes.MemberNeedsUpgrade, "micro01", "micro02")))
+	s.Require().True(upgradeStepComplete(plan, 1, upgradeStatuses(microTypes.MemberOnline, "micro01", "micro02")))
+
+	// Members which were skipped have to be online after the last step too.
+	statuses := upgradeStatuses(microTypes.MemberOnline, "micro01", "micro02")
+	statuses[0].Clusters[types.MicroCeph] = []microTypes.ClusterMember{
+		{ClusterMemberLocal: microTypes.ClusterMemberLocal{Name: "micro01"}, Status: microTypes.MemberOnline},
+		{ClusterMemberLocal: microTypes.ClusterMemberLocal{Name: "micro02"}, Status: microTypes.MemberNeedsUpgrade},
+	}
+
+	skipped := &upgradePlan{Member: "micro01", Steps: []upgradeStep{{Service: types.MicroCeph, Snap: "microceph", Members: []string{"micro01"}}}}
+	s.Require().False(upgradeStepComplete(skipped, 0, statuses))
+
+	// The status of the cluster is taken from the member running the upgrade.
+	s.Require().False(upgradeStepComplete(plan, 1, upgradeStatuses(microTypes.MemberOnline, "micro02")))
+}
+
+func (s *upgradeSuite) Test_saveUpgradePlan() {
+	path := filepath.Join(s.T().TempDir(), upgradePlanFile)
+
+	plan, err := loadUpgradePlan(path)
+	s.Require().NoError(err)
+	s.Require().Nil(plan)
+
+	plan, _, err = planUpgrade("micro01", upgradeStatuses(microTypes.MemberOnline, "micro01"), nil)
+	s.Require().NoError(err)
+	plan.Steps[0].Done = true
+
+	err = saveUpgradePlan(path, plan)
+	s.Require().NoError(err)
+
+	loaded, err := loadUpgradePlan(path)
+	s.Require().NoError(err)
+	s.Require().Equal(plan, loaded)
+}
@@ -20,6 +20,7 @@ import (
 
 	"github.com/canonical/microcloud/microcloud/api"
 	"github.com/canonical/microcloud/microcloud/api/types"
+	"github.com/canonical/microcloud/microcloud/database"
 	"github.com/canonical/microcloud/microcloud/service"
 	"github.com/canonical/microcloud/microcloud/version"
 )
@@ -78,17 +79,12 @@ func (c *cmdDaemon) Run(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("Failed to retrieve system hostname: %w", err)
 	}
 
-	services := []types.ServiceType{types.MicroCloud, types.LXD}
-	optionalServices := map[types.ServiceType]string{
-		types.MicroCeph: api.MicroCephDir,
-		types.MicroOVN:  api.MicroOVNDir,
-	}
-
-	for serviceType, stateDir := range optionalServices {
-		if service.Exists(serviceType, stateDir) {
-			services = append(services, serviceType)
+	services := service.RequiredServices()
+	for _, def := range service.OptionalServices() {
+		if def.Installed() {
+			services = append(services, def.Type)
 		} else {
-			logger.Infof("Skipping %s service, could not detect state directory", serviceType)
+			logger.Infof("Skipping %s service, could not detect state directory", def.Type)
 		}
 	}
 
@@ -97,28 +93,11 @@ func (c *cmdDaemon) Run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	// Periodically check if new services have been installed.
+	// Add and remove optional services whenever they are installed or removed.
 	go func() {
-		for {
-			for serviceName, stateDir := range optionalServices {
-				if service.Exists(serviceName, stateDir) {
-					if s.Services[serviceName] != nil {
-						continue
-					}
-
-					newService, err := service.NewHandler(name, addr, c.flagMicroCloudDir, serviceName)
-					if err != nil {
-						logger.Error("Failed to create servie handler for service", logger.Ctx{"service": serviceName, "error": err})
-						break
-					}
-
-					s.Services[serviceName] = newService.Services[serviceName]
-				} else if s.Services[serviceName] != nil {
-					delete(s.Services, serviceName)
-				}
-			}
-
-			time.Sleep(1 * time.Second)
+		err := s.WatchServices(context.Background())
+		if err != nil {
+			logger.Error("Failed to watch for service changes", logger.Ctx{"error": err})
 		}
 	}()
 
@@ -127,9 +106,15 @@ func (c *cmdDaemon) Run(cmd *cobra.Command, args []string) error {
 		api.ServicesCmd(s),
 		api.ServiceTokensCmd(s),
 		api.ServicesClusterCmd(s),
+		api.SessionCmd(s),
 		api.SessionJoinCmd(s),
+		api.SessionCapabilitiesCmd(s),
+		api.SessionStatsCmd(s),
 		api.SessionInitiatingCmd(s),
 		api.SessionJoiningCmd(s),
+		api.JoinTokensCmd(s),
+		api.JoinTokenCmd(s),
+		api.SessionHistoryCmd(s),
 		api.LXDProxy(s),
 		api.CephProxy(s),
 		api.OVNProxy(s),
@@ -153,6 +138,7 @@ func (c *cmdDaemon) Run(cmd *cobra.Command, args []string) error {
 		Debug:             c.global.flagLogDebug,
 		Version:           version.RawVersion,
 		HeartbeatInterval: c.flagHeartbeatInterval,
+		ExtensionsSchema:  database.SchemaExtensions,
 
 		PreInitListenAddress: "[::]:" + strconv.FormatInt(service.CloudPort, 10),
 		Hooks: &state.Hooks{
@@ -160,6 +146,12 @@ func (c *cmdDaemon) Run(cmd *cobra.Command, args []string) error {
 				return setHandlerAddress(state.Address().URL.Host)
 			},
 			PostJoin: func(ctx context.Context, state state.State, cfg map[string]string) error {
+				// The database is open now so the events recorded during the session get persisted.
+				_ = s.SessionTransaction(true, func(session *service.Session) error {
+					session.RecordEvent(types.SessionEventJoinCompleted, types.SessionIntent{Name: state.Name(), Address: state.Address().URL.Host}, "")
+					return nil
+				})
+
 				// If the node has joined close the session.
 				// This will signal to the client to exit out gracefully
 				// and ultimately lead to the closing of the websocket connection.
@@ -172,6 +164,9 @@ func (c *cmdDaemon) Run(cmd *cobra.Command, args []string) error {
 				return setHandlerAddress(state.Address().URL.Host)
 			},
 			OnStart: func(ctx context.Context, state state.State) error {
+				// Persist the events of the sessions in the database once it's open.
+				s.SetSessionEventRecorder(api.SessionEventRecorder(state))
+
 				// If we are already initialized, there's nothing to do.
 				err := state.Database().IsOpen(ctx)
 
@@ -201,7 +196,7 @@ func (c *cmdDaemon) Run(cmd *cobra.Command, args []string) error {
 		},
 	}
 
-	return s.Services[types.MicroCloud].(*service.CloudService).StartCloud(context.Background(), dargs)
+	return s.Services()[types.MicroCloud].(*service.CloudService).StartCloud(context.Background(), dargs)
 }
 
 func main() {
@@ -44,6 +44,9 @@ var (
 
 	// Border represents the default border color used for tables.
 	Border lipgloss.TerminalColor = lipgloss.AdaptiveColor{Dark: brightBlack, Light: black}
+
+	// Grey represents the color of disabled entries, like table rows which cannot be selected.
+	Grey lipgloss.TerminalColor = lipgloss.AdaptiveColor{Dark: brightBlack, Light: white}
 )
 
 // DisableColors globally disables colors.
@@ -54,6 +57,7 @@ func DisableColors() {
 	White = lipgloss.Color("")
 	Bright = lipgloss.Color("")
 	Border = lipgloss.Color("")
+	Grey = lipgloss.Color("")
 }
 
 // SetColor applies the color to the given text.
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+)
+
+// JoinToken is an out-of-band join token issued by this cluster.
+type JoinToken struct {
+	TokenID   string
+	Secret    string
+	ExpiresAt time.Time
+
+	// RedeemedAt is the time at which the pending redemption of the token started.
+	// It's zero if the token isn't being redeemed.
+	RedeemedAt time.Time
+
+	// Error is the reason why the last redemption of the token failed.
+	Error string
+}
+
+// CreateJoinToken stores the given join token.
+func CreateJoinToken(ctx context.Context, tx *sql.Tx, token JoinToken) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO join_tokens (token_id, secret, expires_at) VALUES (?, ?, ?)", token.TokenID, token.Secret, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("Failed to create join token: %w", err)
+	}
+
+	return nil
+}
+
+// GetJoinToken returns the join token with the given ID.
+func GetJoinToken(ctx context.Context, tx *sql.Tx, tokenID string) (*JoinToken, error) {
+	token := JoinToken{}
+	var redeemedAt sql.NullTime
+	row := tx.QueryRowContext(ctx, "SELECT token_id, secret, expires_at, redeemed_at, error FROM join_tokens WHERE token_id = ?", tokenID)
+	err := row.Scan(&token.TokenID, &token.Secret, &token.ExpiresAt, &redeemedAt, &token.Error)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, api.StatusErrorf(http.StatusNotFound, "Join token not found")
+		}
+
+		return nil, fmt.Errorf("Failed to get join token: %w", err)
+	}
+
+	token.RedeemedAt = redeemedAt.Time
+
+	return &token, nil
+}
+
+// RedeemJoinToken marks the join token with the given ID as being redeemed since the given time.
+// It returns a conflict error if the token is already being redeemed, unless its redemption started before the given stale time,
+// so that a token can only be redeemed by a single system at a time.
+func RedeemJoinToken(ctx context.Context, tx *sql.Tx, tokenID string, now time.Time, staleBefore time.Time) error {
+	result, err := tx.ExecContext(ctx, "UPDATE join_tokens SET redeemed_at = ?, error = '' WHERE token_id = ? AND (redeemed_at IS NULL OR redeemed_at < ?)", now, tokenID, staleBefore)
+	if err != nil {
+		return fmt.Errorf("Failed to redeem join token: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Failed to fetch affected rows: %w", err)
+	}
+
+	if n == 0 {
+		// Distinguish between a missing token and one which is already being redeemed.
+		_, err := GetJoinToken(ctx, tx, tokenID)
+		if err != nil {
+			return err
+		}
+
+		return api.StatusErrorf(http.StatusConflict, "Join token is already being redeemed")
+	}
+
+	return nil
+}
+
+// ReleaseJoinToken ends the pending redemption of the join token with the given ID which failed with the given error.
+// The token can be redeemed again afterwards.
+func ReleaseJoinToken(ctx context.Context, tx *sql.Tx, tokenID string, redeemErr string) error {
+	_, err := tx.ExecContext(ctx, "UPDATE join_tokens SET redeemed_at = NULL, error = ? WHERE token_id = ?", redeemErr, tokenID)
+	if err != nil {
+		return fmt.Errorf("Failed to release join token: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteJoinToken deletes the join token with the given ID.
+// It returns a not found error if the token doesn't exist.
+func DeleteJoinToken(ctx context.Context, tx *sql.Tx, tokenID string) error {
+	result, err := tx.ExecContext(ctx, "DELETE FROM join_tokens WHERE token_id = ?", tokenID)
+	if err != nil {
+		return fmt.Errorf("Failed to delete join token: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Failed to fetch affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return api.StatusErrorf(http.StatusNotFound, "Join token not found")
+	}
+
+	return nil
+}
+
+// DeleteExpiredJoinTokens deletes the join tokens which expired before the given time.
+func DeleteExpiredJoinTokens(ctx context.Context, tx *sql.Tx, now time.Time) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM join_tokens WHERE expires_at < ?", now)
+	if err != nil {
+		return fmt.Errorf("Failed to delete expired join tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+// Package database provides the database access functions and schema of MicroCloud.
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/canonical/lxd/lxd/db/schema"
+)
+
+// SchemaExtensions is the list of schema updates applied after the internal schema updates of microcluster.
+// Each entry increases the database schema version by one, so entries must only ever be appended.
+var SchemaExtensions = []schema.Update{
+	schemaAppend1,
+	schemaAppend2,
+	schemaAppend3,
+}
+
+// schemaAppend1 adds the table of the out-of-band join tokens.
+func schemaAppend1(ctx context.Context, tx *sql.Tx) error {
+	stmt := `
+CREATE TABLE join_tokens (
+  id          INTEGER   PRIMARY KEY  AUTOINCREMENT  NOT NULL,
+  token_id    TEXT      NOT NULL,
+  secret      TEXT      NOT NULL,
+  expires_at  DATETIME  NOT NULL,
+  UNIQUE(token_id)
+);
+`
+
+	_, err := tx.ExecContext(ctx, stmt)
+
+	return err
+}
+
+// schemaAppend2 adds the table of the lifecycle events of trust establishment sessions.
+func schemaAppend2(ctx context.Context, tx *sql.Tx) error {
+	stmt := `
+CREATE TABLE session_events (
+  id           INTEGER   PRIMARY KEY  AUTOINCREMENT  NOT NULL,
+  session_id   TEXT      NOT NULL,
+  member       TEXT      NOT NULL,
+  role         TEXT      NOT NULL,
+  type         TEXT      NOT NULL,
+  name         TEXT      NOT NULL,
+  address      TEXT      NOT NULL,
+  fingerprint  TEXT      NOT NULL,
+  details      TEXT      NOT NULL,
+  created_at   DATETIME  NOT NULL
+);
+`
+
+	_, err := tx.ExecContext(ctx, stmt)
+
+	return err
+}
+
+// schemaAppend3 adds the redemption state of the out-of-band join tokens.
+// A token stays pending while the system redeeming it joins and keeps the error of its last failed redemption.
+func schemaAppend3(ctx context.Context, tx *sql.Tx) error {
+	stmt := `
+ALTER TABLE join_tokens ADD COLUMN redeemed_at DATETIME;
+ALTER TABLE join_tokens ADD COLUMN error TEXT NOT NULL DEFAULT '';
+`
+
+	_, err := tx.ExecContext(ctx, stmt)
+
+	return err
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+// CreateSessionEvent stores the given lifecycle event of a trust establishment session.
+func CreateSessionEvent(ctx context.Context, tx *sql.Tx, event types.SessionEvent) error {
+	stmt := "INSERT INTO session_events (session_id, member, role, type, name, address, fingerprint, details, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err := tx.ExecContext(ctx, stmt, event.SessionID, event.Member, event.Role, event.Type, event.Name, event.Address, event.Fingerprint, event.Details, event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("Failed to create session event: %w", err)
+	}
+
+	return nil
+}
+
+// PruneSessionEvents deletes the oldest lifecycle events of trust establishment sessions so that at most the given number of events is kept.
+func PruneSessionEvents(ctx context.Context, tx *sql.Tx, keep int) error {
+	stmt := "DELETE FROM session_events WHERE id NOT IN (SELECT id FROM session_events ORDER BY id DESC LIMIT ?)"
+	_, err := tx.ExecContext(ctx, stmt, keep)
+	if err != nil {
+		return fmt.Errorf("Failed to prune session events: %w", err)
+	}
+
+	return nil
+}
+
+// GetSessionEvents returns the lifecycle events of trust establishment sessions in the order they got recorded.
+// If a session ID is given, only the events of that session are returned.
+func GetSessionEvents(ctx context.Context, tx *sql.Tx, sessionID string) ([]types.SessionEvent, error) {
+	stmt := "SELECT session_id, member, role, type, name, address, fingerprint, details, created_at FROM session_events"
+	args := []any{}
+	if sessionID != "" {
+		stmt += " WHERE session_id = ?"
+		args = append(args, sessionID)
+	}
+
+	stmt += " ORDER BY created_at, id"
+
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get session events: %w", err)
+	}
+
+	defer func() { _ = rows.Close() }()
+
+	events := []types.SessionEvent{}
+	for rows.Next() {
+		event := types.SessionEvent{}
+		err := rows.Scan(&event.SessionID, &event.Member, &event.Role, &event.Type, &event.Name, &event.Address, &event.Fingerprint, &event.Details, &event.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse session event: %w", err)
+		}
+
+		events = append(events, event)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get session events: %w", err)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,199 @@
+package multicast
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canonical/lxd/shared/trust"
+)
+
+// HMACDiscovery10 is the HMAC format version used to sign the server info sent in discovery replies.
+const HMACDiscovery10 trust.HMACVersion = "MicroCloudDiscovery-1.0"
+
+// maxVerifiedSalts is the number of salts for which a Verifier keeps the derived formatter.
+// Each peer signs all of its info using the same salt for the whole session, so only a few of them are needed.
+const maxVerifiedSalts = 16
+
+// saltDerivationLimits limits how often a Verifier derives the key for a salt it doesn't know yet from a single source.
+// The key derivation is expensive and the salt is chosen by the unauthenticated sender.
+var saltDerivationLimits = ResponderLimits{
+	SourceRate:  1,
+	SourceBurst: 2,
+}
+
+// NewDiscoveryHMAC returns a new HMAC implementation using Argon2 with the default parameters
+// to sign and verify server info using the given passphrase.
+// Its salt is generated once, so it should be created once per session and used for all of the session's server info.
+func NewDiscoveryHMAC(passphrase string) (trust.HMACFormatter, error) {
+	h, err := trust.NewHMACArgon2([]byte(passphrase), nil, trust.NewDefaultHMACConf(HMACDiscovery10))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create a new HMAC instance using argon2: %w", err)
+	}
+
+	return h, nil
+}
+
+// sign returns a copy of the server info including an HMAC over its contents using the given formatter.
+func (s ServerInfo) sign(h trust.HMACFormatter) (ServerInfo, error) {
+	s.HMAC = ""
+	header, err := trust.HMACAuthorizationHeader(h, s)
+	if err != nil {
+		return ServerInfo{}, fmt.Errorf("Failed to create HMAC for server info: %w", err)
+	}
+
+	s.HMAC = header
+
+	return s, nil
+}
+
+// Verifier validates the HMAC of received server info.
+// It can be shared by all lookups of a session so that the key for each peer's salt is only derived once.
+type Verifier struct {
+	lock   sync.Mutex
+	parent trust.HMACFormatter
+
+	// formatters caches the formatters derived for each salt which verified successfully
+	// as the key derivation is expensive and peers use the same salt for all of their info.
+	formatters map[string]trust.HMACFormatter
+	salts      []string
+
+	// deriveLock serializes the key derivations for unknown salts
+	// so that at most one of them claims the CPU and memory required by Argon2 at a time.
+	deriveLock sync.Mutex
+	sources    *responderLimiter
+}
+
+// NewVerifier returns a verifier for server info signed using the passphrase of the given formatter.
+func NewVerifier(parent trust.HMACFormatter) *Verifier {
+	return &Verifier{
+		parent:     parent,
+		formatters: map[string]trust.HMACFormatter{},
+		sources:    newResponderLimiter(saltDerivationLimits),
+	}
+}
+
+// Verify returns an error if the server info received from the given source isn't signed using the verifier's passphrase.
+// The key for a salt which isn't known yet is only derived if the source doesn't exceed the rate of derivations.
+func (v *Verifier) Verify(info ServerInfo, src net.Addr) error {
+	if info.HMAC == "" {
+		return errors.New("Server info isn't signed")
+	}
+
+	// The header's prefix contains the version and salt.
+	prefix, hmacStr, _ := strings.Cut(info.HMAC, ":")
+
+	hmacFromInfo, err := hex.DecodeString(hmacStr)
+	if err != nil {
+		return fmt.Errorf("Failed to decode the HMAC: %w", err)
+	}
+
+	h, ok := v.formatter(prefix)
+	if !ok {
+		h, err = v.derive(info.HMAC, prefix, src)
+		if err != nil {
+			return err
+		}
+	}
+
+	info.HMAC = ""
+	hmacFromContent, err := h.WriteJSON(info)
+	if err != nil {
+		return fmt.Errorf("Failed to calculate HMAC from server info: %w", err)
+	}
+
+	if !hmac.Equal(hmacFromInfo, hmacFromContent) {
+		return errors.New("Invalid HMAC")
+	}
+
+	if !ok {
+		v.addFormatter(prefix, h)
+	}
+
+	return nil
+}
+
+// signLike returns a copy of the given info signed using the same salt and parameters as the given verified info.
+// This allows replying to a peer whose parameters are only known from its own info.
+func (v *Verifier) signLike(info ServerInfo, verified ServerInfo) (ServerInfo, error) {
+	prefix, _, _ := strings.Cut(verified.HMAC, ":")
+	h, ok := v.formatter(prefix)
+	if !ok {
+		return ServerInfo{}, errors.New("Server info isn't verified")
+	}
+
+	return info.sign(h)
+}
+
+// formatter returns the cached formatter for the given prefix of an HMAC header.
+func (v *Verifier) formatter(prefix string) (trust.HMACFormatter, bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	h, ok := v.formatters[prefix]
+
+	return h, ok
+}
+
+// addFormatter caches the formatter for the given prefix of an HMAC header.
+// If the cache is full, the formatter cached first gets evicted.
+func (v *Verifier) addFormatter(prefix string, h trust.HMACFormatter) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	_, ok := v.formatters[prefix]
+	if ok {
+		return
+	}
+
+	if len(v.salts) >= maxVerifiedSalts {
+		delete(v.formatters, v.salts[0])
+		v.salts = v.salts[1:]
+	}
+
+	v.formatters[prefix] = h
+	v.salts = append(v.salts, prefix)
+}
+
+// derive returns the formatter for the salt of the given HMAC header received from the given source.
+// Only a single key gets derived at a time.
+func (v *Verifier) derive(header string, prefix string, src net.Addr) (trust.HMACFormatter, error) {
+	v.deriveLock.Lock()
+	defer v.deriveLock.Unlock()
+
+	// Another reply using the same salt might have been verified while waiting.
+	h, ok := v.formatter(prefix)
+	if ok {
+		return h, nil
+	}
+
+	// The version can carry a suffix describing the parameters of the key derivation.
+	// It's checked by the parent formatter when parsing the header.
+	version, _, _ := strings.Cut(prefix, " ")
+	parentVersion := string(v.parent.Version())
+	if version != parentVersion && !strings.HasPrefix(version, parentVersion+"+") {
+		return nil, fmt.Errorf("HMAC uses version %q but expected %q", version, parentVersion)
+	}
+
+	source := ""
+	ip := sourceIP(src)
+	if ip != nil {
+		source = ip.String()
+	}
+
+	if !v.sources.allowSource(source, time.Now()) {
+		return nil, errors.New("Too many unknown HMAC salts received")
+	}
+
+	h, _, err := v.parent.ParseHTTPHeader(header)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse HMAC: %w", err)
+	}
+
+	return h, nil
+}
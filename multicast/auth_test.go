@@ -0,0 +1,109 @@
+package multicast
+
+import (
+	"fmt"
+	"net"
+)
+
+func (m *multicastSuite) Test_Verify() {
+	info := ServerInfo{
+		Version: "3.0",
+		Name:    "foo",
+		Address: "1.2.3.4",
+	}
+
+	signed, err := info.sign(m.testHMAC(testPassphrase))
+	m.Require().NoError(err)
+
+	cases := []struct {
+		desc       string
+		passphrase string
+		modifier   func(info *ServerInfo)
+		err        error
+	}{
+		{
+			desc:       "Server info signed using the same passphrase",
+			passphrase: testPassphrase,
+		},
+		{
+			desc:       "Server info signed using a different passphrase",
+			passphrase: "qux baz bar foo",
+			err:        fmt.Errorf("Invalid HMAC"),
+		},
+		{
+			desc:       "Server info modified after signing",
+			passphrase: testPassphrase,
+			modifier: func(info *ServerInfo) {
+				info.Address = "1.2.3.5"
+			},
+			err: fmt.Errorf("Invalid HMAC"),
+		},
+		{
+			desc:       "Server info without HMAC",
+			passphrase: testPassphrase,
+			modifier: func(info *ServerInfo) {
+				info.HMAC = ""
+			},
+			err: fmt.Errorf("Server info isn't signed"),
+		},
+		{
+			desc:       "Server info signed using a different HMAC version",
+			passphrase: testPassphrase,
+			modifier: func(info *ServerInfo) {
+				info.HMAC = "MicroCloud-1.0" + info.HMAC[len(HMACDiscovery10):]
+			},
+			err: fmt.Errorf(`HMAC uses version "MicroCloud-1.0" but expected "MicroCloudDiscovery-1.0"`),
+		},
+	}
+
+	src := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 9444}
+	for _, c := range cases {
+		m.T().Log(c.desc)
+
+		v := NewVerifier(m.testHMAC(c.passphrase))
+
+		received := signed
+		if c.modifier != nil {
+			c.modifier(&received)
+		}
+
+		err = v.Verify(received, src)
+		if c.err == nil {
+			m.Require().NoError(err)
+		} else {
+			m.Require().Error(err)
+			m.Require().Equal(c.err.Error(), err.Error())
+		}
+	}
+}
+
+func (m *multicastSuite) Test_VerifyUnknownSalts() {
+	v := NewVerifier(m.testHMAC(testPassphrase))
+	src := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 9444}
+	info := ServerInfo{Version: "3.0", Name: "foo", Address: "1.2.3.4"}
+
+	// Server info signed using a new salt exceeding the source's burst isn't verified.
+	for i := 0; i < saltDerivationLimits.SourceBurst; i++ {
+		signed, err := info.sign(m.testHMAC(testPassphrase))
+		m.Require().NoError(err)
+		m.Require().NoError(v.Verify(signed, src))
+	}
+
+	signed, err := info.sign(m.testHMAC(testPassphrase))
+	m.Require().NoError(err)
+	m.Require().EqualError(v.Verify(signed, src), "Too many unknown HMAC salts received")
+
+	// Other sources are still verified.
+	m.Require().NoError(v.Verify(signed, &net.UDPAddr{IP: net.ParseIP("1.2.3.5"), Port: 9444}))
+
+	// Salts which were verified before are still verified without deriving their key again.
+	m.Require().NoError(v.Verify(signed, src))
+
+	// Only a bounded number of salts is kept.
+	for i := 0; i < maxVerifiedSalts; i++ {
+		v.addFormatter(fmt.Sprintf("%s %d", HMACDiscovery10, i), m.testHMAC(testPassphrase))
+	}
+
+	m.Require().Len(v.formatters, maxVerifiedSalts)
+	m.Require().Len(v.salts, maxVerifiedSalts)
+}
@@ -0,0 +1,54 @@
+package multicast
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/canonical/lxd/shared/trust"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+// BackendType represents the mechanism used to discover peers.
+type BackendType string
+
+const (
+	// BackendMulticast discovers peers using MicroCloud's own multicast groups.
+	BackendMulticast BackendType = "multicast"
+
+	// BackendDNSSD discovers peers using DNS-SD service records advertised over mDNS.
+	BackendDNSSD BackendType = "mdns"
+)
+
+// Backend represents a discovery mechanism which allows responding to and looking up peers.
+type Backend interface {
+	// Respond advertises the given info signed using the given formatter until the context is cancelled or the responder is stopped.
+	Respond(ctx context.Context, info ServerInfo, h trust.HMACFormatter) error
+
+	// StopResponder stops advertising the info.
+	StopResponder() error
+
+	// SetLimits sets the limits applied to the replies of the responder started using Respond.
+	SetLimits(limits ResponderLimits)
+
+	// Stats returns the counters of the lookups received by the responder.
+	Stats() types.DiscoveryStats
+
+	// Lookup finds a listening peer supporting any of the given versions and verified by the verifier and returns its info.
+	Lookup(ctx context.Context, versions VersionRange, v *Verifier) (*ServerInfo, error)
+
+	// LookupAll streams the info of every distinct peer verified by the verifier until the context is cancelled.
+	// Peers which don't support any of the given versions are marked as incompatible.
+	LookupAll(ctx context.Context, versions VersionRange, v *Verifier) (<-chan ServerInfo, error)
+}
+
+// ValidateBackend returns an error if the given backend type is not supported.
+// An empty backend type is valid and represents the default backend.
+func ValidateBackend(backend BackendType) error {
+	switch backend {
+	case "", BackendMulticast, BackendDNSSD:
+		return nil
+	}
+
+	return fmt.Errorf("Unsupported discovery backend %q", backend)
+}
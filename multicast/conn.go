@@ -0,0 +1,159 @@
+package multicast
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Family represents the IP address family used for multicast discovery.
+type Family string
+
+const (
+	// IPv4 represents multicast discovery using the IPv4 group.
+	IPv4 Family = "IPv4"
+
+	// IPv6 represents multicast discovery using the IPv6 group.
+	IPv6 Family = "IPv6"
+)
+
+// AddressFamily returns the multicast discovery family matching the given address.
+func AddressFamily(address string) (Family, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return "", fmt.Errorf("Invalid address %q", address)
+	}
+
+	if ip.To4() != nil {
+		return IPv4, nil
+	}
+
+	return IPv6, nil
+}
+
+// group returns the multicast group of the family.
+func (f Family) group() net.IP {
+	if f == IPv6 {
+		// This uses an address of the site-local scope which isn't reserved for any public protocol.
+		// See https://www.iana.org/assignments/ipv6-multicast-addresses/ipv6-multicast-addresses.xhtml#site-local.
+		return net.ParseIP("ff05::100:100:100")
+	}
+
+	// This uses an address of the organization-local scope which isn't reserved for any public protocol.
+	// See https://www.iana.org/assignments/multicast-addresses/multicast-addresses.xhtml#multicast-addresses-12.
+	return net.IPv4(239, 100, 100, 100)
+}
+
+// mdnsGroup returns the well-known mDNS multicast group of the family.
+// See https://www.rfc-editor.org/rfc/rfc6762#section-3.
+func (f Family) mdnsGroup() net.IP {
+	if f == IPv6 {
+		return net.ParseIP("ff02::fb")
+	}
+
+	return net.IPv4(224, 0, 0, 251)
+}
+
+// network returns the name of the UDP network of the family.
+func (f Family) network() string {
+	if f == IPv6 {
+		return "udp6"
+	}
+
+	return "udp4"
+}
+
+// packetConn is a family agnostic wrapper around the IPv4 and IPv6 packet connections.
+type packetConn interface {
+	JoinGroup(iface *net.Interface, group net.Addr) error
+	SetMulticastInterface(iface *net.Interface) error
+	Close() error
+
+	// setControlFlags enables reporting the destination address and the receiving interface of datagrams.
+	setControlFlags() error
+
+	// readFrom reads a datagram and returns the number of bytes read,
+	// its destination address and receiving interface index if reported and its source address.
+	readFrom(b []byte) (int, net.IP, int, net.Addr, error)
+
+	// writeTo writes the datagram to the given destination.
+	writeTo(b []byte, dst net.Addr) (int, error)
+}
+
+// newPacketConn wraps the given connection into a packet connection of the given family.
+// The underlying connection gets closed when calling Close on the returned packet connection.
+func newPacketConn(family Family, conn net.PacketConn) packetConn {
+	if family == IPv6 {
+		return &ipv6PacketConn{PacketConn: ipv6.NewPacketConn(conn)}
+	}
+
+	return &ipv4PacketConn{PacketConn: ipv4.NewPacketConn(conn)}
+}
+
+type ipv4PacketConn struct {
+	*ipv4.PacketConn
+}
+
+func (c *ipv4PacketConn) setControlFlags() error {
+	err := c.SetControlMessage(ipv4.FlagDst|ipv4.FlagInterface, true)
+	if err != nil {
+		return fmt.Errorf("Failed to set IPv4 control flags for destination address and interface: %w", err)
+	}
+
+	return nil
+}
+
+func (c *ipv4PacketConn) readFrom(b []byte) (int, net.IP, int, net.Addr, error) {
+	n, cm, src, err := c.ReadFrom(b)
+	if err != nil {
+		return 0, nil, 0, nil, err
+	}
+
+	var dst net.IP
+	var ifIndex int
+	if cm != nil {
+		dst = cm.Dst
+		ifIndex = cm.IfIndex
+	}
+
+	return n, dst, ifIndex, src, nil
+}
+
+func (c *ipv4PacketConn) writeTo(b []byte, dst net.Addr) (int, error) {
+	return c.WriteTo(b, nil, dst)
+}
+
+type ipv6PacketConn struct {
+	*ipv6.PacketConn
+}
+
+func (c *ipv6PacketConn) setControlFlags() error {
+	err := c.SetControlMessage(ipv6.FlagDst|ipv6.FlagInterface, true)
+	if err != nil {
+		return fmt.Errorf("Failed to set IPv6 control flags for destination address and interface: %w", err)
+	}
+
+	return nil
+}
+
+func (c *ipv6PacketConn) readFrom(b []byte) (int, net.IP, int, net.Addr, error) {
+	n, cm, src, err := c.ReadFrom(b)
+	if err != nil {
+		return 0, nil, 0, nil, err
+	}
+
+	var dst net.IP
+	var ifIndex int
+	if cm != nil {
+		dst = cm.Dst
+		ifIndex = cm.IfIndex
+	}
+
+	return n, dst, ifIndex, src, nil
+}
+
+func (c *ipv6PacketConn) writeTo(b []byte, dst net.Addr) (int, error) {
+	return c.WriteTo(b, nil, dst)
+}
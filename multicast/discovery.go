@@ -7,243 +7,386 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"time"
+	"sync"
 
 	"github.com/canonical/lxd/shared/logger"
-	"golang.org/x/net/ipv4"
+	"github.com/canonical/lxd/shared/revert"
+	"github.com/canonical/lxd/shared/trust"
 
 	"github.com/canonical/microcloud/microcloud/api/types"
 )
 
 // ServerInfo is information about the server that is discovered using multicast.
+// Version is the highest supported version of the discovery format and MinVersion the lowest one.
 type ServerInfo struct {
 	Version     string                       `json:"version"`
+	MinVersion  string                       `json:"min_version,omitempty"`
 	Name        string                       `json:"name,omitempty"`
 	Address     string                       `json:"address,omitempty"`
 	Services    map[types.ServiceType]string `json:"services,omitempty"`
 	Certificate *x509.Certificate            `json:"certificates,omitempty"`
+	HMAC        string                       `json:"hmac,omitempty"`
+
+	// Interface is the name of the interface on which the peer was found.
+	// It's set by the lookup and not part of the exchanged info.
+	Interface string `json:"-"`
+
+	// NegotiatedVersion is the highest version supported by both the peer and the lookup.
+	// It's set by the lookup and not part of the exchanged info.
+	NegotiatedVersion string `json:"-"`
+
+	// Incompatible is the reason why the peer cannot be used, e.g. as none of its versions is supported by the lookup
+	// or as it only supports versions which don't sign the server info.
+	// It's set by the lookup and not part of the exchanged info.
+	Incompatible string `json:"-"`
+}
+
+// Versions returns the range of versions supported by the server.
+// Servers not advertising a minimum version only support their own version.
+func (s ServerInfo) Versions() VersionRange {
+	if s.MinVersion == "" {
+		return VersionRange{Min: s.Version, Max: s.Version}
+	}
+
+	return VersionRange{Min: s.MinVersion, Max: s.Version}
 }
 
 // Discovery represents the information used for discovering peers using multicast.
 type Discovery struct {
 	iface           string
 	port            int64
-	group           net.IP
-	responderConn   *ipv4.PacketConn
+	families        []Family
+	responderConns  []packetConn
 	responderCancel context.CancelFunc
+
+	// limiterLock protects the limits and the limiter as the latter gets replaced by Respond while its stats are read.
+	limiterLock sync.Mutex
+	limits      ResponderLimits
+	limiter     *responderLimiter
 }
 
 // NewDiscovery returns a new instance of Discovery which allows to lookup peers
-// and to respond on multicast queries.
-func NewDiscovery(iface string, port int64) *Discovery {
+// and to respond on multicast queries using the groups of the given address families.
+// If no family is given, IPv4 is used.
+func NewDiscovery(iface string, port int64, families ...Family) *Discovery {
+	if len(families) == 0 {
+		families = []Family{IPv4}
+	}
+
 	return &Discovery{
-		iface: iface,
-		port:  port,
-		// This uses an address of the organization-local scope which isn't reserved for any public protocol.
-		// See https://www.iana.org/assignments/multicast-addresses/multicast-addresses.xhtml#multicast-addresses-12.
-		group: net.IPv4(239, 100, 100, 100),
+		iface:    iface,
+		port:     port,
+		families: families,
 	}
 }
 
-// Respond starts a new server that listens for datagrams on the configured multicast group
+// SetLimits sets the limits applied to the replies of the responder started using Respond.
+func (d *Discovery) SetLimits(limits ResponderLimits) {
+	d.limiterLock.Lock()
+	defer d.limiterLock.Unlock()
+
+	d.limits = limits
+}
+
+// Stats returns the counters of the lookups received by the responder.
+func (d *Discovery) Stats() types.DiscoveryStats {
+	d.limiterLock.Lock()
+	defer d.limiterLock.Unlock()
+
+	if d.limiter == nil {
+		return types.DiscoveryStats{}
+	}
+
+	return d.limiter.stats()
+}
+
+// newLimiter replaces the responder's limiter with a new one applying the current limits and returns it.
+func (d *Discovery) newLimiter() *responderLimiter {
+	d.limiterLock.Lock()
+	defer d.limiterLock.Unlock()
+
+	d.limiter = newResponderLimiter(d.limits)
+
+	return d.limiter
+}
+
+// Respond starts a new server that listens for datagrams on the configured multicast groups
 // and sends the given info in response until the context is cancelled.
-func (d *Discovery) Respond(ctx context.Context, info ServerInfo) error {
-	iface, err := net.InterfaceByName(d.iface)
+// Only datagrams received on the configured interface are answered which allows
+// responding on multiple interfaces using one Discovery per interface.
+// The info is signed using the given formatter so that only peers knowing the passphrase can discover it.
+// Lookups exceeding the responder's limits are dropped.
+func (d *Discovery) Respond(ctx context.Context, info ServerInfo, h trust.HMACFormatter) error {
+	info, err := info.sign(h)
 	if err != nil {
-		return fmt.Errorf("Failed to resolve server interface %q: %w", d.iface, err)
+		return err
 	}
 
-	// The PacketConn gets closed when calling Close on the derived IPv4 PacketConn.
-	receiver, err := net.ListenPacket("udp4", fmt.Sprintf(":%d", d.port))
+	return d.respondInfo(ctx, info)
+}
+
+// respondInfo starts the responder sending the given info as is.
+func (d *Discovery) respondInfo(ctx context.Context, info ServerInfo) error {
+	iface, err := net.InterfaceByName(d.iface)
 	if err != nil {
-		return fmt.Errorf("Failed to listen on %d: %w", d.port, err)
+		return fmt.Errorf("Failed to resolve server interface %q: %w", d.iface, err)
 	}
 
+	reverter := revert.New()
+	defer reverter.Fail()
+
 	ctx, d.responderCancel = context.WithCancel(ctx)
-	d.responderConn = ipv4.NewPacketConn(receiver)
-	err = d.responderConn.JoinGroup(iface, &net.UDPAddr{IP: d.group})
-	if err != nil {
-		return fmt.Errorf("Failed to join multicast group %q: %w", d.group.String(), err)
-	}
+	reverter.Add(func() { d.responderCancel() })
+
+	conns := make([]packetConn, 0, len(d.families))
+	for _, family := range d.families {
+		// The port is shared with the responders of other interfaces.
+		// The PacketConn gets closed when calling Close on the derived family specific PacketConn.
+		listenConfig := net.ListenConfig{Control: reusePort}
+		receiver, err := listenConfig.ListenPacket(ctx, family.network(), fmt.Sprintf(":%d", d.port))
+		if err != nil {
+			return fmt.Errorf("Failed to listen on %d: %w", d.port, err)
+		}
 
-	err = d.responderConn.SetControlMessage(ipv4.FlagDst, true)
-	if err != nil {
-		return fmt.Errorf("Failed to set IPv4 control flag for destination address: %w", err)
-	}
+		conn := newPacketConn(family, receiver)
+		reverter.Add(func() { _ = conn.Close() })
 
-	// Close the network endpoint if the outer context got cancelled.
-	// This allows existing the endpoint's blocking read using ReadFrom.
-	go func() {
-		<-ctx.Done()
-		err := d.responderConn.Close()
+		group := family.group()
+		err = conn.JoinGroup(iface, &net.UDPAddr{IP: group})
 		if err != nil {
-			logger.Error("Failed to close network endpoint after context got cancelled", logger.Ctx{"err": err})
+			return fmt.Errorf("Failed to join multicast group %q: %w", group.String(), err)
 		}
-	}()
-
-	// Respond on received multicast datagrams.
-	// The routine exits if the connection gets closed.
-	go func() {
-		for {
-			// See the comment on the sender (lookup) for the reasoning about using 500.
-			b := make([]byte, 500)
-			n, cm, src, err := d.responderConn.ReadFrom(b)
-			if err != nil {
-				// Ignore "use of closed network connection" errors as this happens normally
-				// if the outer context gets cancelled in the connection closer go routine.
-				if !errors.Is(err, net.ErrClosed) {
-					logger.Error("Failed to read from network endpoint", logger.Ctx{"err": err})
-				}
 
-				return
-			}
+		err = conn.setControlFlags()
+		if err != nil {
+			return err
+		}
 
-			receivedInfo := ServerInfo{}
+		conns = append(conns, conn)
+	}
 
-			// Reslice the byte slice with the actual amount of bytes read from the datagram.
-			err = json.Unmarshal(b[:n], &receivedInfo)
-			if err != nil {
-				logger.Error("Failed to parse received multicast server info", logger.Ctx{"err": err})
-				continue
+	d.responderConns = conns
+	limiter := d.newLimiter()
+
+	for i, conn := range conns {
+		// Close the network endpoint if the outer context got cancelled.
+		// This allows existing the endpoint's blocking read using ReadFrom.
+		go func() {
+			<-ctx.Done()
+			err := conn.Close()
+			if err != nil && !errors.Is(err, net.ErrClosed) {
+				logger.Error("Failed to close network endpoint after context got cancelled", logger.Ctx{"err": err})
 			}
+		}()
 
-			// Don't respond on this request as the peer is using a different version.
-			if receivedInfo.Version != info.Version {
-				logger.Warnf("Don't respond to multicast server info from %q as its using version %q", src.String(), receivedInfo.Version)
-				continue
-			}
+		// Respond on received multicast datagrams.
+		// The routine exits if the connection gets closed.
+		go d.respond(conn, iface.Index, d.families[i].group(), info, limiter)
+	}
+
+	reverter.Success()
 
-			if cm.Dst.IsMulticast() {
-				if cm.Dst.Equal(d.group) {
-					bytes, err := json.Marshal(info)
-					if err != nil {
-						logger.Error("Failed to marshal server info", logger.Ctx{"err": err})
-						continue
-					}
-
-					// Send a unicast message back to the source.
-					_, err = d.responderConn.WriteTo(bytes, nil, src)
-					if err != nil {
-						logger.Error("Failed to send reply", logger.Ctx{"dest": src.String(), "err": err})
-						continue
-					}
-				} else {
-					logger.Warnf("Received multicast message from non recognized group %q", cm.Dst.String())
-				}
+	return nil
+}
+
+// respond answers the datagrams received on the given connection and interface for the given multicast group.
+func (d *Discovery) respond(conn packetConn, ifIndex int, group net.IP, info ServerInfo, limiter *responderLimiter) {
+	for {
+		// See the comment on the sender (lookup) for the reasoning about using 500.
+		b := make([]byte, 500)
+		n, dst, receivedIfIndex, src, err := conn.readFrom(b)
+		if err != nil {
+			// Ignore "use of closed network connection" errors as this happens normally
+			// if the outer context gets cancelled in the connection closer go routine.
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Error("Failed to read from network endpoint", logger.Ctx{"err": err})
 			}
+
+			return
 		}
-	}()
 
-	return nil
+		// The connection receives the datagrams of the group joined on any interface.
+		// Leave the ones received on other interfaces to their own responders.
+		if receivedIfIndex != 0 && receivedIfIndex != ifIndex {
+			continue
+		}
+
+		// Apply the limits before processing the datagram so that a noisy source cannot flood the logs.
+		if !limiter.acquire(src) {
+			logger.Debug("Dropping lookup exceeding the responder limits", logger.Ctx{"source": src.String()})
+			continue
+		}
+
+		receivedInfo := ServerInfo{}
+
+		// Reslice the byte slice with the actual amount of bytes read from the datagram.
+		err = json.Unmarshal(b[:n], &receivedInfo)
+		if err != nil {
+			logger.Error("Failed to parse received multicast server info", logger.Ctx{"err": err})
+			limiter.release(false)
+			continue
+		}
+
+		// Still respond if the peer doesn't support any of our versions.
+		// It can report the incompatibility instead of not finding us at all.
+		_, err = info.Versions().Negotiate(receivedInfo.Versions())
+		if err != nil {
+			logger.Warn("Responding to lookup of incompatible peer", logger.Ctx{"source": src.String(), "err": err})
+		}
+
+		if !dst.IsMulticast() {
+			limiter.release(false)
+			continue
+		}
+
+		if !dst.Equal(group) {
+			logger.Warnf("Received multicast message from non recognized group %q", dst.String())
+			limiter.release(false)
+			continue
+		}
+
+		bytes, err := json.Marshal(info)
+		if err != nil {
+			logger.Error("Failed to marshal server info", logger.Ctx{"err": err})
+			limiter.release(false)
+			continue
+		}
+
+		// Send a unicast message back to the source.
+		// The number of replies sent at the same time is bound by the limiter.
+		go func() {
+			_, err := conn.writeTo(bytes, src)
+			if err != nil {
+				logger.Error("Failed to send reply", logger.Ctx{"dest": src.String(), "err": err})
+			}
+
+			limiter.release(err == nil)
+		}()
+	}
 }
 
 // StopResponder stops the responder server and cancels it's inner context.
 func (d *Discovery) StopResponder() error {
-	// Check if this instance of discovery has an active responder server connection.
-	if d.responderConn != nil {
-		err := d.responderConn.Close()
+	// Check if this instance of discovery has active responder server connections.
+	for _, conn := range d.responderConns {
+		err := conn.Close()
 		// Ignore errors if the connection is already closed.
 		// This can happen if the responders context already got cancelled
 		// which also triggers a close of the connection.
 		if err != nil && !errors.Is(err, net.ErrClosed) {
 			return fmt.Errorf("Failed to stop responder: %w", err)
 		}
+	}
 
-		// Cancel the inner context too and release all routines of the responder.
-		if d.responderCancel != nil {
-			d.responderCancel()
-		}
+	// Cancel the inner context too and release all routines of the responder.
+	if d.responderCancel != nil {
+		d.responderCancel()
 	}
 
 	return nil
 }
 
-// Lookup finds a listening peer matching the given version and returns its info.
-func (d *Discovery) Lookup(ctx context.Context, version string) (*ServerInfo, error) {
-	// Use a random port for sending the multicast message.
-	// The PacketConn gets closed when calling Close on the derived IPv4 PacketConn.
-	sender, err := net.ListenPacket("udp4", ":0")
+// Lookup finds a listening peer supporting any of the given versions and returns its info.
+// Replies which cannot be verified using the given verifier are discarded.
+// If multiple address families are configured, the lookup is performed over all of them
+// and the first peer responding on either of the groups is returned.
+func (d *Discovery) Lookup(ctx context.Context, versions VersionRange, v *Verifier) (*ServerInfo, error) {
+	// The inner context gets cancelled as soon as the lookup returns
+	// which stops sending any further multicast messages.
+	lookupCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	peers, err := d.LookupAll(lookupCtx, versions, v)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to listen: %w", err)
+		return nil, err
 	}
 
-	iface, err := net.InterfaceByName(d.iface)
+	return firstPeer(ctx, peers, "multicast")
+}
+
+// LookupAll streams the info of every distinct peer until the context is cancelled.
+// Peers which don't support any of the given versions are marked as incompatible.
+// Replies which cannot be verified using the given verifier are discarded, except for the unsigned replies
+// of peers only supporting versions older than SignedVersion which are marked as incompatible as they could be forged.
+// If multiple address families are configured, the lookup is performed over all of them.
+func (d *Discovery) LookupAll(ctx context.Context, versions VersionRange, v *Verifier) (<-chan ServerInfo, error) {
+	req, err := d.lookupRequest(versions)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to resolve lookup interface %q: %w", d.iface, err)
+		return nil, err
 	}
 
-	senderP := ipv4.NewPacketConn(sender)
-	err = senderP.SetMulticastInterface(iface)
+	return streamLookup(ctx, *req, versions, v)
+}
+
+// LookupReplies streams every reply received until the context is cancelled
+// regardless of the version and signature of the contained info.
+// It allows diagnosing why peers cannot be found using LookupAll.
+func (d *Discovery) LookupReplies(ctx context.Context, versions VersionRange) (<-chan Reply, error) {
+	req, err := d.lookupRequest(versions)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to set multicast interface %q: %w", iface.Name, err)
+		return nil, err
 	}
 
-	lookupInfo := ServerInfo{
-		Version: version,
-	}
+	return streamReplies(ctx, *req)
+}
 
-	lookupInfoBytes, err := json.Marshal(lookupInfo)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to marshal lookup info: %w", err)
-	}
-
-	go func() {
-		dst := &net.UDPAddr{IP: d.group, Port: int(d.port)}
-
-		for {
-			select {
-			case <-ctx.Done():
-				// Close the network endpoint if the lookup context got cancelled.
-				senderP.Close()
-				return
-			default:
-				// Repeatedly send multicast message with our lookup info containing only our protocol version.
-				// The response contains the name, address and version which we use to validate if we want to join this peer.
-				_, err = senderP.WriteTo(lookupInfoBytes, nil, dst)
-				if err != nil {
-					logger.Error("Failed to send multicast message", logger.Ctx{"err": err})
-				}
-
-				time.Sleep(time.Second)
-			}
-		}
-	}()
-
-	// 500 bytes should always make it through the network regardless of the MTU setting
-	// as Internet Protocol requires hosts to be able to process datagrams of at least 576 bytes.
-	// Subtracting the maximum IP header of size 60 bytes and the UDP header of size 8 bytes we are
-	// left with 508 bytes for the actual payload.
-	// We expect a response that contains the name, address and version.
-	// As the name correlates to the peers hostname, 255 may be occupied by it which leaves another
-	// 245 bytes for the address (IPv4 or IPv6) and used multicast discovery version (including some JSON formatting).
-	b := make([]byte, 500)
-
-	// Block until the read succeeds or the connection is closed.
-	// The latter happens in case the context gets cancelled.
-	n, _, _, err := senderP.ReadFrom(b)
+// lookupRequest returns the request for looking up peers supporting any of the given versions.
+func (d *Discovery) lookupRequest(versions VersionRange) (*lookupRequest, error) {
+	iface, err := net.InterfaceByName(d.iface)
 	if err != nil {
-		// In case the connection got closed due to a cancelled context,
-		// try to return the cause from the context instead.
-		ctxErr := context.Cause(ctx)
-		if errors.Is(err, net.ErrClosed) && ctxErr != nil {
-			err = ctxErr
+		return nil, fmt.Errorf("Failed to resolve lookup interface %q: %w", d.iface, err)
+	}
+
+	// Repeatedly send multicast message with our lookup info containing only our supported protocol versions.
+	// The response contains the name, address and versions which we use to validate if we want to join this peer.
+	lookupInfos := []ServerInfo{{Version: versions.Max, MinVersion: versions.Min}}
+
+	// Peers using version 2.0 only respond to lookups using exactly their version.
+	// Their replies aren't signed.
+	if versions.Min != versions.Max {
+		lookupInfos = append(lookupInfos, ServerInfo{Version: versions.Min})
+	}
+
+	queries := make([][]byte, 0, len(lookupInfos))
+	for _, lookupInfo := range lookupInfos {
+		lookupInfoBytes, err := json.Marshal(lookupInfo)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to marshal lookup info: %w", err)
 		}
 
-		return nil, fmt.Errorf("Failed to read from multicast network endpoint: %w", err)
+		queries = append(queries, lookupInfoBytes)
 	}
 
-	receivedInfo := ServerInfo{}
+	req := &lookupRequest{
+		iface:    iface,
+		families: d.families,
+		queries:  queries,
+		destinations: func(family Family) []net.Addr {
+			return []net.Addr{&net.UDPAddr{IP: family.group(), Port: int(d.port)}}
+		},
+		// 500 bytes should always make it through the network regardless of the MTU setting
+		// as Internet Protocol requires hosts to be able to process datagrams of at least 576 bytes.
+		// Subtracting the maximum IP header of size 60 bytes and the UDP header of size 8 bytes we are
+		// left with 508 bytes for the actual payload.
+		// We expect a response that contains the name, address, version and HMAC.
+		// As the name correlates to the peers hostname, 255 may be occupied by it which leaves another
+		// 245 bytes for the address (IPv4 or IPv6), the used multicast discovery version and the HMAC of around
+		// 120 bytes (including some JSON formatting).
+		bufferSize:     500,
+		parse:          parseServerInfo,
+		unsignedLegacy: true,
+	}
+
+	return req, nil
+}
 
-	// Reslice the byte slice with the actual amount of bytes read from the datagram.
-	err = json.Unmarshal(b[:n], &receivedInfo)
+// parseServerInfo returns the server info contained in the given datagram.
+func parseServerInfo(b []byte) (*ServerInfo, error) {
+	receivedInfo := ServerInfo{}
+	err := json.Unmarshal(b, &receivedInfo)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to parse received multicast server info: %w", err)
 	}
 
-	// Exit if peer has mismatched version.
-	if receivedInfo.Version != version {
-		return nil, fmt.Errorf("System %q (version %q) has a version mismatch: Expected %q", receivedInfo.Name, receivedInfo.Version, version)
-	}
-
 	return &receivedInfo, nil
 }
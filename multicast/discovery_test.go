@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/canonical/lxd/shared/trust"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -14,26 +15,43 @@ type multicastSuite struct {
 	suite.Suite
 }
 
+// testPassphrase is the passphrase used by the test responders.
+const testPassphrase = "foo bar baz qux"
+
+// testHMAC returns a new formatter for signing and verifying server info using the given passphrase.
+func (m *multicastSuite) testHMAC(passphrase string) trust.HMACFormatter {
+	h, err := NewDiscoveryHMAC(passphrase)
+	m.Require().NoError(err)
+
+	return h
+}
+
 func TestMulticastSuite(t *testing.T) {
 	suite.Run(t, new(multicastSuite))
 }
 
 func (m *multicastSuite) Test_Lookup() {
 	cases := []struct {
-		desc          string
-		lookupVersion string
-		lookupIface   string
-		lookupPort    int64
-		responseInfo  ServerInfo
-		lookupErr     error
-		lookupTimeout time.Duration
-		modifier      func(server *Discovery)
+		desc             string
+		lookupVersions   VersionRange
+		negotiated       string
+		lookupPassphrase string
+		lookupIface      string
+		lookupPort       int64
+		lookupFamilies   []Family
+		responseFamily   Family
+		responseInfo     ServerInfo
+		responseUnsigned bool
+		lookupErr        error
+		lookupTimeout    time.Duration
+		modifier         func(server *Discovery)
 	}{
 		{
-			desc:          "System with matching version can be looked up",
-			lookupVersion: "2.0",
-			lookupIface:   "lo",
-			lookupPort:    9444,
+			desc:           "System with matching version can be looked up",
+			lookupVersions: VersionRange{Min: "2.0", Max: "2.0"},
+			negotiated:     "2.0",
+			lookupIface:    "lo",
+			lookupPort:     9444,
 			responseInfo: ServerInfo{
 				Version: "2.0",
 				Name:    "foo",
@@ -41,26 +59,70 @@ func (m *multicastSuite) Test_Lookup() {
 			},
 		},
 		{
-			desc:          "System with maximum allowed server name length, IPv6 address and high version number can be looked up",
-			lookupVersion: "142.0",
-			lookupIface:   "lo",
-			lookupPort:    9444,
+			desc:           "System with maximum allowed server name length, IPv6 address and high version number can be looked up",
+			lookupVersions: VersionRange{Min: "142.0", Max: "142.0"},
+			negotiated:     "142.0",
+			lookupIface:    "lo",
+			lookupPort:     9444,
 			responseInfo: ServerInfo{
 				Version: "142.0",
 				Name:    strings.Repeat("a", 255),
 				Address: "fd42:c4cc:2e1d:132d:a216:3eff:fecd:9d15",
 			},
 		},
+		{
+			desc:           "System responding using IPv4 can be looked up using both families",
+			lookupVersions: VersionRange{Min: "2.0", Max: "2.0"},
+			negotiated:     "2.0",
+			lookupIface:    "lo",
+			lookupPort:     9444,
+			lookupFamilies: []Family{IPv4, IPv6},
+			responseFamily: IPv4,
+			responseInfo: ServerInfo{
+				Version: "2.0",
+				Name:    "foo",
+				Address: "1.2.3.4",
+			},
+		},
+		{
+			desc:           "Cannot lookup system using IPv4 if the responder uses IPv6",
+			lookupVersions: VersionRange{Min: "2.0", Max: "2.0"},
+			lookupIface:    "lo",
+			lookupPort:     9444,
+			lookupFamilies: []Family{IPv4},
+			responseFamily: IPv6,
+			responseInfo: ServerInfo{
+				Version: "2.0",
+				Name:    "foo",
+				Address: "fd42:c4cc:2e1d:132d:a216:3eff:fecd:9d15",
+			},
+			lookupTimeout: 1500 * time.Millisecond,
+			lookupErr:     fmt.Errorf("Failed to read from multicast network endpoint: Timeout exceeded"),
+		},
+		{
+			desc:             "Cannot lookup system if the responder uses a different passphrase",
+			lookupVersions:   VersionRange{Min: "2.0", Max: "3.0"},
+			lookupPassphrase: "qux baz bar foo",
+			lookupIface:      "lo",
+			lookupPort:       9444,
+			responseInfo: ServerInfo{
+				Version: "3.0",
+				Name:    "foo",
+				Address: "1.2.3.4",
+			},
+			lookupTimeout: 1500 * time.Millisecond,
+			lookupErr:     fmt.Errorf("Failed to read from multicast network endpoint: Timeout exceeded"),
+		},
 		{
 			desc:        "Cannot lookup system if invalid interface is given",
 			lookupIface: "invalid-interface",
 			lookupErr:   fmt.Errorf(`Failed to resolve lookup interface "invalid-interface": route ip+net: no such network interface`),
 		},
 		{
-			desc:          "Cannot lookup system if the responder is offline",
-			lookupVersion: "2.0",
-			lookupIface:   "lo",
-			lookupPort:    9444,
+			desc:           "Cannot lookup system if the responder is offline",
+			lookupVersions: VersionRange{Min: "2.0", Max: "2.0"},
+			lookupIface:    "lo",
+			lookupPort:     9444,
 			responseInfo: ServerInfo{
 				Version: "2.0",
 				Name:    "foo",
@@ -73,10 +135,64 @@ func (m *multicastSuite) Test_Lookup() {
 			lookupErr: fmt.Errorf("Failed to read from multicast network endpoint: Timeout exceeded"),
 		},
 		{
-			desc:          "Cannot lookup system if the responder uses a different version",
-			lookupVersion: "3.0",
-			lookupIface:   "lo",
-			lookupPort:    9444,
+			desc:           "System supporting an overlapping range of versions is looked up using the highest common version",
+			lookupVersions: VersionRange{Min: "2.0", Max: "3.0"},
+			negotiated:     "3.0",
+			lookupIface:    "lo",
+			lookupPort:     9444,
+			responseInfo: ServerInfo{
+				Version:    "4.0",
+				MinVersion: "3.0",
+				Name:       "foo",
+				Address:    "1.2.3.4",
+			},
+		},
+		{
+			desc:           "System only supporting the oldest version of the range can be looked up",
+			lookupVersions: VersionRange{Min: "2.0", Max: "3.0"},
+			negotiated:     "2.0",
+			lookupIface:    "lo",
+			lookupPort:     9444,
+			responseInfo: ServerInfo{
+				Version: "2.0",
+				Name:    "foo",
+				Address: "1.2.3.4",
+			},
+		},
+		{
+			desc:           "Cannot lookup system using a forged unsigned reply of a legacy version",
+			lookupVersions: VersionRange{Min: "2.0", Max: "3.0"},
+			lookupIface:    "lo",
+			lookupPort:     9444,
+			responseInfo: ServerInfo{
+				Version: "2.0",
+				Name:    "foo",
+				Address: "1.2.3.4",
+			},
+			responseUnsigned: true,
+			lookupTimeout:    1500 * time.Millisecond,
+			lookupErr:        fmt.Errorf("Failed to read from multicast network endpoint: Timeout exceeded"),
+		},
+		{
+			desc:           "Cannot lookup system using a signed version without signing its reply",
+			lookupVersions: VersionRange{Min: "2.0", Max: "3.0"},
+			lookupIface:    "lo",
+			lookupPort:     9444,
+			responseInfo: ServerInfo{
+				Version:    "3.0",
+				MinVersion: "2.0",
+				Name:       "foo",
+				Address:    "1.2.3.4",
+			},
+			responseUnsigned: true,
+			lookupTimeout:    1500 * time.Millisecond,
+			lookupErr:        fmt.Errorf("Failed to read from multicast network endpoint: Timeout exceeded"),
+		},
+		{
+			desc:           "Cannot lookup system if the responder uses a different version",
+			lookupVersions: VersionRange{Min: "3.0", Max: "3.0"},
+			lookupIface:    "lo",
+			lookupPort:     9444,
 			responseInfo: ServerInfo{
 				Version: "2.0",
 				Name:    "foo",
@@ -92,15 +208,25 @@ func (m *multicastSuite) Test_Lookup() {
 
 		// Use the loopback interface as it should always be there on any test system.
 		discovery := NewDiscovery("lo", 9444)
+		if c.responseFamily != "" {
+			discovery = NewDiscovery("lo", 9444, c.responseFamily)
+		}
+
+		var err error
+		if c.responseUnsigned {
+			// Respond like a system which doesn't sign its replies.
+			err = discovery.respondInfo(context.Background(), c.responseInfo)
+		} else {
+			err = discovery.Respond(context.Background(), c.responseInfo, m.testHMAC(testPassphrase))
+		}
 
-		err := discovery.Respond(context.Background(), c.responseInfo)
 		m.Require().NoError(err)
 
 		if c.modifier != nil {
 			c.modifier(discovery)
 		}
 
-		testDiscovery := NewDiscovery(c.lookupIface, c.lookupPort)
+		testDiscovery := NewDiscovery(c.lookupIface, c.lookupPort, c.lookupFamilies...)
 
 		ctx := context.Background()
 		var cancel context.CancelFunc
@@ -108,9 +234,26 @@ func (m *multicastSuite) Test_Lookup() {
 			ctx, cancel = context.WithTimeoutCause(ctx, c.lookupTimeout, fmt.Errorf("Timeout exceeded"))
 		}
 
-		receivedInfo, err := testDiscovery.Lookup(ctx, c.lookupVersion)
+		lookupPassphrase := testPassphrase
+		if c.lookupPassphrase != "" {
+			lookupPassphrase = c.lookupPassphrase
+		}
+
+		receivedInfo, err := testDiscovery.Lookup(ctx, c.lookupVersions, NewVerifier(m.testHMAC(lookupPassphrase)))
 		if c.lookupErr == nil {
 			m.Require().NoError(err)
+
+			// The received info is signed by the responder.
+			m.Require().NotEmpty(receivedInfo.HMAC)
+			receivedInfo.HMAC = ""
+
+			// The received info contains the interface on which the peer was found.
+			m.Require().Equal(c.lookupIface, receivedInfo.Interface)
+			receivedInfo.Interface = ""
+
+			// The received info contains the highest version supported by both sides.
+			m.Require().Equal(c.negotiated, receivedInfo.NegotiatedVersion)
+			receivedInfo.NegotiatedVersion = ""
 			m.Require().Equal(&c.responseInfo, receivedInfo)
 		} else {
 			m.Require().Error(err)
@@ -127,3 +270,236 @@ func (m *multicastSuite) Test_Lookup() {
 		m.Require().NoError(err)
 	}
 }
+
+func (m *multicastSuite) Test_LookupAll() {
+	responses := []ServerInfo{
+		{
+			Version: "2.0",
+			Name:    "foo",
+			Address: "1.2.3.4",
+		},
+		{
+			Version: "2.0",
+			Name:    "bar",
+			Address: "1.2.3.5",
+		},
+		{
+			Version: "4.0",
+			Name:    "baz",
+			Address: "1.2.3.6",
+		},
+		{
+			Version: "1.0",
+			Name:    "qux",
+			Address: "1.2.3.7",
+		},
+		{
+			Version: "2.0",
+			Name:    "quux",
+			Address: "1.2.3.8",
+		},
+	}
+
+	// Systems using versions older than SignedVersion don't sign their replies.
+	// Anyone could forge such a reply, so they are only reported as incompatible.
+	unsigned := map[string]bool{"qux": true, "quux": true}
+
+	// Multiple responders can share the discovery port.
+	for _, info := range responses {
+		responder := NewDiscovery("lo", 9444)
+
+		var err error
+		if unsigned[info.Name] {
+			err = responder.respondInfo(context.Background(), info)
+		} else {
+			err = responder.Respond(context.Background(), info, m.testHMAC(testPassphrase))
+		}
+
+		m.Require().NoError(err)
+
+		defer func() {
+			err := responder.StopResponder()
+			m.Require().NoError(err)
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	peers, err := NewDiscovery("lo", 9444).LookupAll(ctx, VersionRange{Min: "2.0", Max: "3.0"}, NewVerifier(m.testHMAC(testPassphrase)))
+	m.Require().NoError(err)
+
+	found := map[string]ServerInfo{}
+	for peer := range peers {
+		_, ok := found[peer.Name]
+		m.Require().False(ok)
+		m.Require().Equal("lo", peer.Interface)
+
+		peer.HMAC = ""
+		peer.Interface = ""
+		found[peer.Name] = peer
+	}
+
+	// Incompatible peers are still reported together with the reason.
+	expected := map[string]ServerInfo{"foo": responses[0], "bar": responses[1], "baz": responses[2], "qux": responses[3], "quux": responses[4]}
+	for _, name := range []string{"foo", "bar"} {
+		info := expected[name]
+		info.NegotiatedVersion = "2.0"
+		expected[name] = info
+	}
+
+	baz := expected["baz"]
+	baz.Incompatible = "Supports version 4.0 but expected 2.0 to 3.0"
+	expected["baz"] = baz
+
+	qux := expected["qux"]
+	qux.Incompatible = "Supports version 1.0 but expected 2.0 to 3.0"
+	expected["qux"] = qux
+
+	quux := expected["quux"]
+	quux.Incompatible = "Supports version 2.0 which doesn't sign its replies so it cannot be verified"
+	expected["quux"] = quux
+
+	m.Require().Equal(expected, found)
+}
+
+func (m *multicastSuite) Test_LookupReplies() {
+	info := ServerInfo{
+		Version: "2.0",
+		Name:    "foo",
+		Address: "1.2.3.4",
+	}
+
+	responder := NewDiscovery("lo", 9444)
+	err := responder.Respond(context.Background(), info, m.testHMAC(testPassphrase))
+	m.Require().NoError(err)
+
+	defer func() {
+		err := responder.StopResponder()
+		m.Require().NoError(err)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// The responder replies although the lookup is using a different version.
+	replies, err := NewDiscovery("lo", 9444).LookupReplies(ctx, VersionRange{Min: "3.0", Max: "3.0"})
+	m.Require().NoError(err)
+
+	reply, ok := <-replies
+	m.Require().True(ok)
+	m.Require().NotNil(reply.Source)
+	m.Require().Equal("lo", reply.Info.Interface)
+	m.Require().Equal("2.0", reply.Info.Version)
+
+	// The reply is still signed so it can be verified.
+	v := NewVerifier(m.testHMAC(testPassphrase))
+	m.Require().NoError(v.Verify(reply.Info, reply.Source))
+
+	cancel()
+	for range replies {
+	}
+}
+
+func (m *multicastSuite) Test_Negotiate() {
+	cases := []struct {
+		desc       string
+		versions   VersionRange
+		other      VersionRange
+		negotiated string
+		err        error
+	}{
+		{
+			desc:       "Matching versions",
+			versions:   VersionRange{Min: "2.0", Max: "2.0"},
+			other:      VersionRange{Min: "2.0", Max: "2.0"},
+			negotiated: "2.0",
+		},
+		{
+			desc:       "Highest common version of overlapping ranges",
+			versions:   VersionRange{Min: "2.0", Max: "3.0"},
+			other:      VersionRange{Min: "2.1", Max: "4.0"},
+			negotiated: "3.0",
+		},
+		{
+			desc:       "Versions are compared numerically",
+			versions:   VersionRange{Min: "2.0", Max: "2.10"},
+			other:      VersionRange{Min: "2.9", Max: "2.9"},
+			negotiated: "2.9",
+		},
+		{
+			desc:     "Older peer outside of the range",
+			versions: VersionRange{Min: "3.0", Max: "4.0"},
+			other:    VersionRange{Min: "2.0", Max: "2.0"},
+			err:      fmt.Errorf("Supports version 2.0 but expected 3.0 to 4.0"),
+		},
+		{
+			desc:     "Newer peer outside of the range",
+			versions: VersionRange{Min: "2.0", Max: "3.0"},
+			other:    VersionRange{Min: "4.0", Max: "5.0"},
+			err:      fmt.Errorf("Supports version 4.0 to 5.0 but expected 2.0 to 3.0"),
+		},
+		{
+			desc:     "Invalid version",
+			versions: VersionRange{Min: "2.0", Max: "3.0"},
+			other:    VersionRange{Min: "2", Max: "2"},
+			err:      fmt.Errorf(`Invalid version "2"`),
+		},
+	}
+
+	for _, c := range cases {
+		m.T().Log(c.desc)
+
+		negotiated, err := c.versions.Negotiate(c.other)
+		if c.err == nil {
+			m.Require().NoError(err)
+			m.Require().Equal(c.negotiated, negotiated)
+		} else {
+			m.Require().Error(err)
+			m.Require().Equal(c.err.Error(), err.Error())
+		}
+	}
+}
+
+func (m *multicastSuite) Test_AddressFamily() {
+	cases := []struct {
+		desc    string
+		address string
+		family  Family
+		err     error
+	}{
+		{
+			desc:    "IPv4 address",
+			address: "10.0.0.1",
+			family:  IPv4,
+		},
+		{
+			desc:    "IPv6 address",
+			address: "fd42:c4cc:2e1d:132d:a216:3eff:fecd:9d15",
+			family:  IPv6,
+		},
+		{
+			desc:    "IPv4-mapped IPv6 address",
+			address: "::ffff:10.0.0.1",
+			family:  IPv4,
+		},
+		{
+			desc:    "Invalid address",
+			address: "foo",
+			err:     fmt.Errorf(`Invalid address "foo"`),
+		},
+	}
+
+	for _, c := range cases {
+		m.T().Log(c.desc)
+
+		family, err := AddressFamily(c.address)
+		if c.err == nil {
+			m.Require().NoError(err)
+			m.Require().Equal(c.family, family)
+		} else {
+			m.Require().Error(err)
+			m.Require().Equal(c.err.Error(), err.Error())
+		}
+	}
+}
@@ -0,0 +1,664 @@
+package multicast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/canonical/lxd/shared/revert"
+	"github.com/canonical/lxd/shared/trust"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/sys/unix"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+// DNSSDService is the DNS-SD service type under which MicroCloud is advertised.
+const DNSSDService = "_microcloud._tcp"
+
+// mdnsPort is the well-known port used for mDNS.
+const mdnsPort = 5353
+
+// mdnsMaxMessageSize is the maximum size of an mDNS message.
+// See https://www.rfc-editor.org/rfc/rfc6762#section-17.
+const mdnsMaxMessageSize = 9000
+
+// dnssdTTL is the time to live in seconds of the advertised resource records.
+const dnssdTTL = 120
+
+// dnssdServicesEnumeration is the name used by DNS-SD clients to browse all available service types.
+// See https://www.rfc-editor.org/rfc/rfc6763#section-9.
+const dnssdServicesEnumeration = "_services._dns-sd._udp.local."
+
+// dnssdServiceTXTPrefix is the prefix of TXT record keys carrying the version of an installed service.
+const dnssdServiceTXTPrefix = "service."
+
+// DNSSD represents the information used for advertising and discovering peers using DNS-SD over mDNS.
+type DNSSD struct {
+	iface           string
+	port            int64
+	families        []Family
+	responderConns  []packetConn
+	responderCancel context.CancelFunc
+
+	// limiterLock protects the limits and the limiter as the latter gets replaced by Respond while its stats are read.
+	limiterLock sync.Mutex
+	limits      ResponderLimits
+	limiter     *responderLimiter
+}
+
+// NewDNSSD returns a new instance of DNSSD which allows to lookup peers
+// and to advertise the MicroCloud API listening on the given port using mDNS.
+// If no family is given, IPv4 is used.
+func NewDNSSD(iface string, port int64, families ...Family) *DNSSD {
+	if len(families) == 0 {
+		families = []Family{IPv4}
+	}
+
+	return &DNSSD{
+		iface:    iface,
+		port:     port,
+		families: families,
+	}
+}
+
+// SetLimits sets the limits applied to the replies of the responder started using Respond.
+func (d *DNSSD) SetLimits(limits ResponderLimits) {
+	d.limiterLock.Lock()
+	defer d.limiterLock.Unlock()
+
+	d.limits = limits
+}
+
+// Stats returns the counters of the queries for the MicroCloud service received by the responder.
+func (d *DNSSD) Stats() types.DiscoveryStats {
+	d.limiterLock.Lock()
+	defer d.limiterLock.Unlock()
+
+	if d.limiter == nil {
+		return types.DiscoveryStats{}
+	}
+
+	return d.limiter.stats()
+}
+
+// newLimiter replaces the responder's limiter with a new one applying the current limits and returns it.
+func (d *DNSSD) newLimiter() *responderLimiter {
+	d.limiterLock.Lock()
+	defer d.limiterLock.Unlock()
+
+	d.limiter = newResponderLimiter(d.limits)
+
+	return d.limiter
+}
+
+// Respond starts a new mDNS responder which advertises the given info as a DNS-SD service
+// until the context is cancelled.
+// Queries exceeding the responder's limits are dropped.
+// The responder shares the mDNS port with other responders like avahi-daemon running on the same system.
+// The info is signed using the given formatter so that only peers knowing the passphrase can discover it.
+func (d *DNSSD) Respond(ctx context.Context, info ServerInfo, h trust.HMACFormatter) error {
+	info, err := info.sign(h)
+	if err != nil {
+		return err
+	}
+
+	records, err := newDNSSDRecords(info, d.port)
+	if err != nil {
+		return err
+	}
+
+	iface, err := net.InterfaceByName(d.iface)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve server interface %q: %w", d.iface, err)
+	}
+
+	reverter := revert.New()
+	defer reverter.Fail()
+
+	ctx, d.responderCancel = context.WithCancel(ctx)
+	reverter.Add(func() { d.responderCancel() })
+
+	conns := make([]packetConn, 0, len(d.families))
+	for _, family := range d.families {
+		listenConfig := net.ListenConfig{Control: reusePort}
+		receiver, err := listenConfig.ListenPacket(ctx, family.network(), fmt.Sprintf(":%d", mdnsPort))
+		if err != nil {
+			return fmt.Errorf("Failed to listen on %d: %w", mdnsPort, err)
+		}
+
+		conn := newPacketConn(family, receiver)
+		reverter.Add(func() { _ = conn.Close() })
+
+		group := family.mdnsGroup()
+		err = conn.JoinGroup(iface, &net.UDPAddr{IP: group})
+		if err != nil {
+			return fmt.Errorf("Failed to join multicast group %q: %w", group.String(), err)
+		}
+
+		err = conn.SetMulticastInterface(iface)
+		if err != nil {
+			return fmt.Errorf("Failed to set multicast interface %q: %w", iface.Name, err)
+		}
+
+		err = conn.setControlFlags()
+		if err != nil {
+			return err
+		}
+
+		conns = append(conns, conn)
+	}
+
+	d.responderConns = conns
+	limiter := d.newLimiter()
+
+	for i, conn := range conns {
+		// Close the network endpoint if the outer context got cancelled.
+		// This allows existing the endpoint's blocking read using ReadFrom.
+		go func() {
+			<-ctx.Done()
+			err := conn.Close()
+			if err != nil && !errors.Is(err, net.ErrClosed) {
+				logger.Error("Failed to close network endpoint after context got cancelled", logger.Ctx{"err": err})
+			}
+		}()
+
+		go d.respond(conn, iface.Index, d.families[i], records, limiter)
+	}
+
+	reverter.Success()
+
+	return nil
+}
+
+// respond answers the mDNS queries received on the given connection and interface which ask for the given records.
+func (d *DNSSD) respond(conn packetConn, ifIndex int, family Family, records *dnssdRecords, limiter *responderLimiter) {
+	for {
+		b := make([]byte, mdnsMaxMessageSize)
+		n, _, receivedIfIndex, src, err := conn.readFrom(b)
+		if err != nil {
+			// Ignore "use of closed network connection" errors as this happens normally
+			// if the outer context gets cancelled in the connection closer go routine.
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Error("Failed to read from network endpoint", logger.Ctx{"err": err})
+			}
+
+			return
+		}
+
+		// The connection receives the queries sent to the group joined on any interface.
+		// Leave the ones received on other interfaces to their own responders.
+		if receivedIfIndex != 0 && receivedIfIndex != ifIndex {
+			continue
+		}
+
+		var p dnsmessage.Parser
+		header, err := p.Start(b[:n])
+		if err != nil {
+			logger.Debug("Failed to parse received mDNS message", logger.Ctx{"source": src.String(), "err": err})
+			continue
+		}
+
+		// Ignore responses including our own ones looped back to us.
+		if header.Response {
+			continue
+		}
+
+		questions, err := p.AllQuestions()
+		if err != nil {
+			logger.Debug("Failed to parse received mDNS questions", logger.Ctx{"source": src.String(), "err": err})
+			continue
+		}
+
+		if !records.answers(questions) {
+			continue
+		}
+
+		// Only queries for the MicroCloud service are subject to the limits
+		// as the mDNS group is shared with all other services on the network.
+		if !limiter.acquire(src) {
+			logger.Debug("Dropping query exceeding the responder limits", logger.Ctx{"source": src.String()})
+			continue
+		}
+
+		// Queries not originating from the mDNS port are sent by simple resolvers
+		// and have to be answered using unicast.
+		// See https://www.rfc-editor.org/rfc/rfc6762#section-6.7.
+		dst := src
+		legacyUnicast := true
+		udpSrc, ok := src.(*net.UDPAddr)
+		if ok && udpSrc.Port == mdnsPort {
+			dst = &net.UDPAddr{IP: family.mdnsGroup(), Port: mdnsPort}
+			legacyUnicast = false
+		}
+
+		reply, err := records.reply(header.ID, questions, legacyUnicast)
+		if err != nil {
+			logger.Error("Failed to build mDNS reply", logger.Ctx{"err": err})
+			limiter.release(false)
+			continue
+		}
+
+		// The number of replies sent at the same time is bound by the limiter.
+		go func() {
+			_, err := conn.writeTo(reply, dst)
+			if err != nil {
+				logger.Error("Failed to send reply", logger.Ctx{"dest": dst.String(), "err": err})
+			}
+
+			limiter.release(err == nil)
+		}()
+	}
+}
+
+// StopResponder stops the responder server and cancels it's inner context.
+func (d *DNSSD) StopResponder() error {
+	for _, conn := range d.responderConns {
+		err := conn.Close()
+		// Ignore errors if the connection is already closed.
+		// This can happen if the responders context already got cancelled
+		// which also triggers a close of the connection.
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			return fmt.Errorf("Failed to stop responder: %w", err)
+		}
+	}
+
+	// Cancel the inner context too and release all routines of the responder.
+	if d.responderCancel != nil {
+		d.responderCancel()
+	}
+
+	return nil
+}
+
+// Lookup finds a peer advertising the MicroCloud DNS-SD service supporting any of the given versions and returns its info.
+// Advertisements of incompatible peers or which cannot be verified using the given verifier are ignored.
+func (d *DNSSD) Lookup(ctx context.Context, versions VersionRange, v *Verifier) (*ServerInfo, error) {
+	// The inner context gets cancelled as soon as the lookup returns
+	// which stops sending any further queries.
+	lookupCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	peers, err := d.LookupAll(lookupCtx, versions, v)
+	if err != nil {
+		return nil, err
+	}
+
+	return firstPeer(ctx, peers, "mDNS")
+}
+
+// LookupAll streams the info of every distinct peer advertising the MicroCloud DNS-SD service until the context is cancelled.
+// Peers which don't support any of the given versions are marked as incompatible.
+// Advertisements which cannot be verified using the given verifier are ignored.
+func (d *DNSSD) LookupAll(ctx context.Context, versions VersionRange, v *Verifier) (<-chan ServerInfo, error) {
+	iface, err := net.InterfaceByName(d.iface)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to resolve lookup interface %q: %w", d.iface, err)
+	}
+
+	query, err := dnssdQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	req := lookupRequest{
+		iface:    iface,
+		families: d.families,
+		// Sending the query from a random port requests a unicast response.
+		queries: [][]byte{query},
+		destinations: func(family Family) []net.Addr {
+			return []net.Addr{&net.UDPAddr{IP: family.mdnsGroup(), Port: mdnsPort}}
+		},
+		bufferSize: mdnsMaxMessageSize,
+		parse:      parseDNSSDResponse,
+	}
+
+	return streamLookup(ctx, req, versions, v)
+}
+
+// reusePort allows sharing the mDNS port with other responders on the same system.
+func reusePort(network string, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+		if sockErr != nil {
+			return
+		}
+
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
+
+// dnssdRecords represents the resource records advertised for a single MicroCloud.
+type dnssdRecords struct {
+	service  dnsmessage.Name
+	instance dnsmessage.Name
+	host     dnsmessage.Name
+	port     uint16
+	txt      []string
+	ip       net.IP
+}
+
+// newDNSSDRecords returns the resource records advertising the given info.
+func newDNSSDRecords(info ServerInfo, port int64) (*dnssdRecords, error) {
+	// The name is used as a single label within the domain names.
+	label := strings.ReplaceAll(info.Name, ".", "-")
+	if label == "" {
+		return nil, fmt.Errorf("Server info is missing a name")
+	}
+
+	if len(label) > 63 {
+		label = label[:63]
+	}
+
+	service, err := dnsmessage.NewName(DNSSDService + ".local.")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create service name: %w", err)
+	}
+
+	instance, err := dnsmessage.NewName(label + "." + DNSSDService + ".local.")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create service instance name: %w", err)
+	}
+
+	host, err := dnsmessage.NewName(label + ".local.")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create host name: %w", err)
+	}
+
+	txt := []string{
+		"version=" + info.Version,
+		"name=" + info.Name,
+		"address=" + info.Address,
+		"hmac=" + info.HMAC,
+	}
+
+	if info.MinVersion != "" {
+		txt = append(txt, "min_version="+info.MinVersion)
+	}
+
+	serviceTXT := make([]string, 0, len(info.Services))
+	for serviceType, version := range info.Services {
+		serviceTXT = append(serviceTXT, dnssdServiceTXTPrefix+string(serviceType)+"="+version)
+	}
+
+	sort.Strings(serviceTXT)
+	txt = append(txt, serviceTXT...)
+
+	for _, entry := range txt {
+		// Each character string within a TXT record is prefixed with a single length byte.
+		if len(entry) > 255 {
+			return nil, fmt.Errorf("TXT record entry %q exceeds the maximum length of 255 bytes", entry)
+		}
+	}
+
+	return &dnssdRecords{
+		service:  service,
+		instance: instance,
+		host:     host,
+		port:     uint16(port),
+		txt:      txt,
+		ip:       net.ParseIP(info.Address),
+	}, nil
+}
+
+// answers returns true if any of the given questions asks for one of the records.
+func (r *dnssdRecords) answers(questions []dnsmessage.Question) bool {
+	for _, q := range questions {
+		name := strings.ToLower(q.Name.String())
+		switch name {
+		case dnssdServicesEnumeration, strings.ToLower(r.service.String()):
+			if q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL {
+				return true
+			}
+
+		case strings.ToLower(r.instance.String()):
+			if q.Type == dnsmessage.TypeSRV || q.Type == dnsmessage.TypeTXT || q.Type == dnsmessage.TypeALL {
+				return true
+			}
+
+		case strings.ToLower(r.host.String()):
+			if q.Type == dnsmessage.TypeA || q.Type == dnsmessage.TypeAAAA || q.Type == dnsmessage.TypeALL {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// reply returns an mDNS response containing all records.
+// Responses to legacy unicast queries have to repeat the query's ID and questions.
+func (r *dnssdRecords) reply(id uint16, questions []dnsmessage.Question, legacyUnicast bool) ([]byte, error) {
+	header := dnsmessage.Header{Response: true, Authoritative: true}
+	if legacyUnicast {
+		header.ID = id
+	}
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), header)
+	b.EnableCompression()
+
+	err := b.StartQuestions()
+	if err != nil {
+		return nil, err
+	}
+
+	if legacyUnicast {
+		for _, q := range questions {
+			// Strip the unicast response bit from the class.
+			q.Class &= 0x7fff
+			err = b.Question(q)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = b.StartAnswers()
+	if err != nil {
+		return nil, err
+	}
+
+	resourceHeader := func(name dnsmessage.Name) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: dnssdTTL}
+	}
+
+	for _, q := range questions {
+		if strings.ToLower(q.Name.String()) == dnssdServicesEnumeration {
+			enumeration, err := dnsmessage.NewName(dnssdServicesEnumeration)
+			if err != nil {
+				return nil, err
+			}
+
+			err = b.PTRResource(resourceHeader(enumeration), dnsmessage.PTRResource{PTR: r.service})
+			if err != nil {
+				return nil, err
+			}
+
+			break
+		}
+	}
+
+	err = b.PTRResource(resourceHeader(r.service), dnsmessage.PTRResource{PTR: r.instance})
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.SRVResource(resourceHeader(r.instance), dnsmessage.SRVResource{Target: r.host, Port: r.port})
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.TXTResource(resourceHeader(r.instance), dnsmessage.TXTResource{TXT: r.txt})
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.StartAdditionals()
+	if err != nil {
+		return nil, err
+	}
+
+	if r.ip.To4() != nil {
+		var a [4]byte
+		copy(a[:], r.ip.To4())
+		err = b.AResource(resourceHeader(r.host), dnsmessage.AResource{A: a})
+	} else if r.ip != nil {
+		var aaaa [16]byte
+		copy(aaaa[:], r.ip.To16())
+		err = b.AAAAResource(resourceHeader(r.host), dnsmessage.AAAAResource{AAAA: aaaa})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Finish()
+}
+
+// dnssdQuery returns an mDNS query asking for all instances of the MicroCloud service.
+func dnssdQuery() ([]byte, error) {
+	service, err := dnsmessage.NewName(DNSSDService + ".local.")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create service name: %w", err)
+	}
+
+	msg := dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: service, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}
+
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create mDNS query: %w", err)
+	}
+
+	return query, nil
+}
+
+// parseDNSSDResponse returns the info of the MicroCloud service advertised in the given mDNS response.
+func parseDNSSDResponse(msg []byte) (*ServerInfo, error) {
+	var p dnsmessage.Parser
+	header, err := p.Start(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if !header.Response {
+		return nil, fmt.Errorf("Message is not a response")
+	}
+
+	err = p.SkipAllQuestions()
+	if err != nil {
+		return nil, err
+	}
+
+	// The TXT record is usually part of the answers but might also be provided
+	// as an additional record by some responders.
+	var txt []string
+	for txt == nil {
+		h, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if h.Type != dnsmessage.TypeTXT || !strings.HasSuffix(strings.ToLower(h.Name.String()), "."+DNSSDService+".local.") {
+			err = p.SkipAnswer()
+			if err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		r, err := p.TXTResource()
+		if err != nil {
+			return nil, err
+		}
+
+		txt = r.TXT
+	}
+
+	if txt == nil {
+		err = p.SkipAllAuthorities()
+		if err != nil {
+			return nil, err
+		}
+
+		for txt == nil {
+			h, err := p.AdditionalHeader()
+			if errors.Is(err, dnsmessage.ErrSectionDone) {
+				break
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			if h.Type != dnsmessage.TypeTXT || !strings.HasSuffix(strings.ToLower(h.Name.String()), "."+DNSSDService+".local.") {
+				err = p.SkipAdditional()
+				if err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+
+			r, err := p.TXTResource()
+			if err != nil {
+				return nil, err
+			}
+
+			txt = r.TXT
+		}
+	}
+
+	if txt == nil {
+		return nil, fmt.Errorf("Response doesn't contain a %s TXT record", DNSSDService)
+	}
+
+	info := ServerInfo{}
+	for _, entry := range txt {
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "version":
+			info.Version = value
+		case "min_version":
+			info.MinVersion = value
+		case "name":
+			info.Name = value
+		case "address":
+			info.Address = value
+		case "hmac":
+			info.HMAC = value
+		default:
+			if len(key) > len(dnssdServiceTXTPrefix) && strings.EqualFold(key[:len(dnssdServiceTXTPrefix)], dnssdServiceTXTPrefix) {
+				if info.Services == nil {
+					info.Services = map[types.ServiceType]string{}
+				}
+
+				info.Services[types.ServiceType(key[len(dnssdServiceTXTPrefix):])] = value
+			}
+		}
+	}
+
+	return &info, nil
+}
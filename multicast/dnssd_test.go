@@ -0,0 +1,189 @@
+package multicast
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+func (m *multicastSuite) Test_DNSSDLookup() {
+	cases := []struct {
+		desc          string
+		lookupVersion VersionRange
+		lookupIface   string
+		responseInfo  ServerInfo
+		respondErr    error
+		lookupErr     error
+		lookupTimeout time.Duration
+	}{
+		{
+			desc:          "System with matching version can be looked up",
+			lookupVersion: VersionRange{Min: "2.0", Max: "2.0"},
+			lookupIface:   "lo",
+			responseInfo: ServerInfo{
+				Version: "2.0",
+				Name:    "foo",
+				Address: "1.2.3.4",
+				Services: map[types.ServiceType]string{
+					types.MicroCloud: "2.0.0",
+					types.LXD:        "5.21",
+				},
+			},
+		},
+		{
+			desc:          "System with IPv6 address can be looked up",
+			lookupVersion: VersionRange{Min: "2.0", Max: "2.0"},
+			lookupIface:   "lo",
+			responseInfo: ServerInfo{
+				Version: "2.0",
+				Name:    "foo",
+				Address: "fd42:c4cc:2e1d:132d:a216:3eff:fecd:9d15",
+			},
+		},
+		{
+			desc:          "Cannot lookup system if the responder uses a different version",
+			lookupVersion: VersionRange{Min: "3.0", Max: "3.0"},
+			lookupIface:   "lo",
+			responseInfo: ServerInfo{
+				Version: "2.0",
+				Name:    "foo",
+				Address: "1.2.3.4",
+			},
+			lookupTimeout: 500 * time.Millisecond,
+			lookupErr:     fmt.Errorf("Failed to read from mDNS network endpoint: Timeout exceeded"),
+		},
+		{
+			desc:        "Cannot lookup system if invalid interface is given",
+			lookupIface: "invalid-interface",
+			responseInfo: ServerInfo{
+				Version: "2.0",
+				Name:    "foo",
+				Address: "1.2.3.4",
+			},
+			lookupErr: fmt.Errorf(`Failed to resolve lookup interface "invalid-interface": route ip+net: no such network interface`),
+		},
+		{
+			desc: "Cannot respond if a TXT record entry is too long",
+			responseInfo: ServerInfo{
+				Version: "2.0",
+				Name:    strings.Repeat("a", 255),
+				Address: "1.2.3.4",
+			},
+			respondErr: fmt.Errorf("TXT record entry %q exceeds the maximum length of 255 bytes", "name="+strings.Repeat("a", 255)),
+		},
+	}
+
+	for _, c := range cases {
+		m.T().Log(c.desc)
+
+		// Use the loopback interface as it should always be there on any test system.
+		responder := NewDNSSD("lo", 9443)
+		err := responder.Respond(context.Background(), c.responseInfo, m.testHMAC(testPassphrase))
+		if c.respondErr != nil {
+			m.Require().Error(err)
+			m.Require().Equal(c.respondErr.Error(), err.Error())
+			continue
+		}
+
+		m.Require().NoError(err)
+
+		ctx := context.Background()
+		var cancel context.CancelFunc
+		if c.lookupTimeout > 0 {
+			ctx, cancel = context.WithTimeoutCause(ctx, c.lookupTimeout, fmt.Errorf("Timeout exceeded"))
+		}
+
+		receivedInfo, err := NewDNSSD(c.lookupIface, 9443).Lookup(ctx, c.lookupVersion, NewVerifier(m.testHMAC(testPassphrase)))
+		if c.lookupErr == nil {
+			m.Require().NoError(err)
+
+			// The received info is signed by the responder.
+			m.Require().NotEmpty(receivedInfo.HMAC)
+			receivedInfo.HMAC = ""
+			m.Require().Equal(c.lookupIface, receivedInfo.Interface)
+			receivedInfo.Interface = ""
+			m.Require().Equal(c.lookupVersion.Max, receivedInfo.NegotiatedVersion)
+			receivedInfo.NegotiatedVersion = ""
+			m.Require().Equal(&c.responseInfo, receivedInfo)
+		} else {
+			m.Require().Error(err)
+			m.Require().Equal(c.lookupErr.Error(), err.Error())
+		}
+
+		// Cancel the timeout to avoid leaking the context.
+		if cancel != nil {
+			cancel()
+		}
+
+		// Stop the responder.
+		err = responder.StopResponder()
+		m.Require().NoError(err)
+	}
+}
+
+func (m *multicastSuite) Test_DNSSDLookupAll() {
+	responses := []ServerInfo{
+		{
+			Version: "2.0",
+			Name:    "foo",
+			Address: "1.2.3.4",
+		},
+		{
+			Version: "2.0",
+			Name:    "bar",
+			Address: "1.2.3.5",
+		},
+		{
+			Version:    "3.0",
+			MinVersion: "2.1",
+			Name:       "baz",
+			Address:    "1.2.3.6",
+		},
+	}
+
+	// Multiple responders can share the mDNS port.
+	for _, info := range responses {
+		responder := NewDNSSD("lo", 9443)
+		err := responder.Respond(context.Background(), info, m.testHMAC(testPassphrase))
+		m.Require().NoError(err)
+
+		defer func() {
+			err := responder.StopResponder()
+			m.Require().NoError(err)
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	peers, err := NewDNSSD("lo", 9443).LookupAll(ctx, VersionRange{Min: "2.0", Max: "2.0"}, NewVerifier(m.testHMAC(testPassphrase)))
+	m.Require().NoError(err)
+
+	// Each responder is reported only once although the queries are repeated.
+	found := map[string]ServerInfo{}
+	for peer := range peers {
+		_, ok := found[peer.Name]
+		m.Require().False(ok)
+
+		peer.HMAC = ""
+		peer.Interface = ""
+		found[peer.Name] = peer
+	}
+
+	// Incompatible peers are still reported together with the reason.
+	expected := map[string]ServerInfo{"foo": responses[0], "bar": responses[1], "baz": responses[2]}
+	for _, name := range []string{"foo", "bar"} {
+		info := expected[name]
+		info.NegotiatedVersion = "2.0"
+		expected[name] = info
+	}
+
+	baz := expected["baz"]
+	baz.Incompatible = "Supports version 2.1 to 3.0 but expected 2.0"
+	expected["baz"] = baz
+
+	m.Require().Equal(expected, found)
+}
@@ -0,0 +1,195 @@
+package multicast
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+// maxTrackedSources is the maximum number of sources for which the responder keeps track of the sent replies.
+// Replies to new sources are dropped if this limit is reached and none of the tracked sources can be forgotten.
+const maxTrackedSources = 4096
+
+// DefaultResponderLimits are the limits applied to the responders of a trust establishment session.
+// A joiner sends up to two lookups per second on each interface and address family.
+var DefaultResponderLimits = ResponderLimits{
+	SourceRate:           4,
+	SourceBurst:          8,
+	MaxConcurrentReplies: 32,
+}
+
+// ResponderLimits protects a responder from being used as a reflector or from flooding the logs.
+// The zero value doesn't apply any limits.
+type ResponderLimits struct {
+	// SourceRate is the number of replies per second which can be sent to a single source address.
+	// A rate of zero doesn't limit the replies per source.
+	SourceRate float64
+
+	// SourceBurst is the number of replies which can be sent to a single source address at once.
+	SourceBurst int
+
+	// MaxConcurrentReplies is the number of replies which can be in flight at the same time.
+	// Zero allows any number of concurrent replies.
+	MaxConcurrentReplies int
+
+	// AllowedSubnets are the subnets of the source addresses which are answered.
+	// If empty, any source is answered.
+	AllowedSubnets []*net.IPNet
+}
+
+// sourceBucket tracks the replies sent to a single source address.
+type sourceBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// responderLimiter applies the responder limits and counts the received, answered and dropped lookups.
+type responderLimiter struct {
+	limits  ResponderLimits
+	replies chan struct{}
+
+	lock    sync.Mutex
+	sources map[string]*sourceBucket
+
+	received atomic.Uint64
+	answered atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+// newResponderLimiter returns a limiter applying the given limits.
+func newResponderLimiter(limits ResponderLimits) *responderLimiter {
+	l := &responderLimiter{
+		limits:  limits,
+		sources: map[string]*sourceBucket{},
+	}
+
+	if limits.MaxConcurrentReplies > 0 {
+		l.replies = make(chan struct{}, limits.MaxConcurrentReplies)
+	}
+
+	return l
+}
+
+// acquire records a lookup received from the given source and returns whether or not it can be answered.
+// If true is returned, release has to be called once the reply got sent.
+func (l *responderLimiter) acquire(src net.Addr) bool {
+	l.received.Add(1)
+
+	ip := sourceIP(src)
+	if ip == nil || !l.allowedSubnet(ip) || !l.allowSource(ip.String(), time.Now()) {
+		l.dropped.Add(1)
+		return false
+	}
+
+	if l.replies != nil {
+		select {
+		case l.replies <- struct{}{}:
+		default:
+			l.dropped.Add(1)
+			return false
+		}
+	}
+
+	return true
+}
+
+// release frees the slot of a reply acquired using acquire and counts it as answered if it got sent.
+func (l *responderLimiter) release(sent bool) {
+	if l.replies != nil {
+		<-l.replies
+	}
+
+	if sent {
+		l.answered.Add(1)
+	} else {
+		l.dropped.Add(1)
+	}
+}
+
+// drop counts a received lookup which isn't answered for reasons other than the limits.
+func (l *responderLimiter) drop() {
+	l.received.Add(1)
+	l.dropped.Add(1)
+}
+
+// stats returns the current counters.
+func (l *responderLimiter) stats() types.DiscoveryStats {
+	return types.DiscoveryStats{
+		Received: l.received.Load(),
+		Answered: l.answered.Load(),
+		Dropped:  l.dropped.Load(),
+	}
+}
+
+// allowedSubnet returns whether or not the given source address is within one of the allowed subnets.
+func (l *responderLimiter) allowedSubnet(ip net.IP) bool {
+	if len(l.limits.AllowedSubnets) == 0 {
+		return true
+	}
+
+	for _, subnet := range l.limits.AllowedSubnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowSource returns whether or not another reply can be sent to the given source address at the given time.
+// Each source gets a bucket of SourceBurst tokens which is refilled using the SourceRate.
+func (l *responderLimiter) allowSource(source string, now time.Time) bool {
+	if l.limits.SourceRate <= 0 {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	burst := float64(max(l.limits.SourceBurst, 1))
+	bucket, ok := l.sources[source]
+	if !ok {
+		if len(l.sources) >= maxTrackedSources {
+			l.forgetSources(now, burst)
+		}
+
+		if len(l.sources) >= maxTrackedSources {
+			return false
+		}
+
+		bucket = &sourceBucket{tokens: burst, last: now}
+		l.sources[source] = bucket
+	}
+
+	bucket.tokens = min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.limits.SourceRate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+
+	bucket.tokens--
+
+	return true
+}
+
+// forgetSources removes the sources whose bucket is refilled completely at the given time.
+func (l *responderLimiter) forgetSources(now time.Time, burst float64) {
+	for source, bucket := range l.sources {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.limits.SourceRate >= burst {
+			delete(l.sources, source)
+		}
+	}
+}
+
+// sourceIP returns the IP address of the given source.
+func sourceIP(src net.Addr) net.IP {
+	udpAddr, ok := src.(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+
+	return udpAddr.IP
+}
@@ -0,0 +1,109 @@
+package multicast
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+func (m *multicastSuite) Test_ResponderLimiter() {
+	_, subnet, err := net.ParseCIDR("10.0.0.0/24")
+	m.Require().NoError(err)
+
+	l := newResponderLimiter(ResponderLimits{
+		SourceRate:           1,
+		SourceBurst:          2,
+		MaxConcurrentReplies: 3,
+		AllowedSubnets:       []*net.IPNet{subnet},
+	})
+
+	source := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 9444}
+	other := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 9444}
+
+	// Sources outside of the allowed subnets are dropped.
+	m.Require().False(l.acquire(&net.UDPAddr{IP: net.ParseIP("10.0.1.1"), Port: 9444}))
+
+	// Each source can receive a burst of replies.
+	m.Require().True(l.acquire(source))
+	m.Require().True(l.acquire(source))
+	m.Require().False(l.acquire(source))
+
+	// Other sources are limited separately but the number of concurrent replies is bound.
+	m.Require().True(l.acquire(other))
+	m.Require().False(l.acquire(other))
+
+	l.release(true)
+	l.release(true)
+	l.release(false)
+	m.Require().True(l.acquire(&net.UDPAddr{IP: net.ParseIP("10.0.0.3"), Port: 9444}))
+	l.release(true)
+
+	m.Require().Equal(types.DiscoveryStats{Received: 7, Answered: 3, Dropped: 4}, l.stats())
+
+	// The bucket of a source is refilled over time.
+	now := time.Now()
+	m.Require().False(l.allowSource("10.0.0.1", now))
+	m.Require().True(l.allowSource("10.0.0.1", now.Add(time.Second)))
+	m.Require().False(l.allowSource("10.0.0.1", now.Add(time.Second)))
+}
+
+func (m *multicastSuite) Test_ResponderLimits() {
+	_, subnet, err := net.ParseCIDR("10.0.0.0/24")
+	m.Require().NoError(err)
+
+	responder := NewDiscovery("lo", 9444)
+	responder.SetLimits(ResponderLimits{AllowedSubnets: []*net.IPNet{subnet}})
+
+	err = responder.Respond(context.Background(), ServerInfo{Version: "2.0", Name: "foo", Address: "1.2.3.4"}, m.testHMAC(testPassphrase))
+	m.Require().NoError(err)
+
+	defer func() {
+		err := responder.StopResponder()
+		m.Require().NoError(err)
+	}()
+
+	ctx, cancel := context.WithTimeoutCause(context.Background(), 1500*time.Millisecond, fmt.Errorf("Timeout exceeded"))
+	defer cancel()
+
+	// The lookup is sent from the loopback address which isn't allowed.
+	_, err = NewDiscovery("lo", 9444).Lookup(ctx, VersionRange{Min: "2.0", Max: "2.0"}, NewVerifier(m.testHMAC(testPassphrase)))
+	m.Require().EqualError(err, "Failed to read from multicast network endpoint: Timeout exceeded")
+
+	stats := responder.Stats()
+	m.Require().NotZero(stats.Received)
+	m.Require().Equal(stats.Received, stats.Dropped)
+	m.Require().Zero(stats.Answered)
+}
+
+func (m *multicastSuite) Test_StatsWhileResponding() {
+	responder := NewDiscovery("lo", 9444)
+	h := m.testHMAC(testPassphrase)
+
+	// The stats can be read while the responder gets started.
+	stop := make(chan struct{})
+	received := make(chan uint64)
+	go func() {
+		var total uint64
+		for {
+			select {
+			case <-stop:
+				received <- total
+				return
+			default:
+				total += responder.Stats().Received
+			}
+		}
+	}()
+
+	err := responder.Respond(context.Background(), ServerInfo{Version: "3.0", Name: "foo", Address: "1.2.3.4"}, h)
+	m.Require().NoError(err)
+
+	close(stop)
+	<-received
+
+	err = responder.StopResponder()
+	m.Require().NoError(err)
+}
@@ -0,0 +1,270 @@
+package multicast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/canonical/lxd/shared/logger"
+)
+
+// lookupRequest represents the backend specific parts of a lookup.
+type lookupRequest struct {
+	// iface is the interface used for sending the query to multicast destinations.
+	// It's nil if the query is sent to unicast destinations.
+	iface *net.Interface
+
+	// families are the address families used for sending the query.
+	families []Family
+
+	// queries are the datagrams repeatedly sent to the destinations of each family.
+	queries [][]byte
+
+	// destinations returns the addresses to which the query is sent for the given family.
+	destinations func(family Family) []net.Addr
+
+	// bufferSize is the maximum size of a reply.
+	bufferSize int
+
+	// parse returns the info contained in a reply.
+	parse func(b []byte) (*ServerInfo, error)
+
+	// unsignedLegacy reports unsigned replies of peers only supporting versions older than SignedVersion instead of discarding them.
+	// Such peers cannot sign their info, so they are reported as incompatible to explain why they cannot be used.
+	unsignedLegacy bool
+}
+
+// Reply represents a reply received during a lookup.
+type Reply struct {
+	// Source is the address from which the reply was received.
+	Source net.Addr
+
+	// Info is the info contained in the reply.
+	Info ServerInfo
+}
+
+// streamReplies sends the request's query using each family until the context is cancelled
+// and streams every reply which can be parsed.
+// For multicast lookups the info contains the name of the interface on which the reply was received.
+// The returned channel is closed once the context is cancelled.
+func streamReplies(ctx context.Context, req lookupRequest) (<-chan Reply, error) {
+	// The inner context gets cancelled if none of the readers is left
+	// which stops sending any further messages.
+	lookupCtx, cancel := context.WithCancel(ctx)
+
+	senders := make([]packetConn, 0, len(req.families))
+	for _, family := range req.families {
+		sender, err := lookupSender(req.iface, family)
+		if err != nil {
+			// Only fail if there isn't any other family to fall back to.
+			if len(req.families) == 1 {
+				cancel()
+				return nil, err
+			}
+
+			logger.Warn("Skipping lookup", logger.Ctx{"family": family, "err": err})
+			continue
+		}
+
+		senders = append(senders, sender)
+
+		go func() {
+			dsts := req.destinations(family)
+
+			for {
+				select {
+				case <-lookupCtx.Done():
+					// Close the network endpoint if the lookup context got cancelled.
+					// This allows exiting the reader's blocking read.
+					_ = sender.Close()
+					return
+				default:
+					for _, dst := range dsts {
+						for _, query := range req.queries {
+							_, err := sender.writeTo(query, dst)
+							if err != nil {
+								logger.Error("Failed to send lookup message", logger.Ctx{"family": family, "dest": dst.String(), "err": err})
+							}
+						}
+					}
+
+					time.Sleep(time.Second)
+				}
+			}
+		}()
+	}
+
+	if len(senders) == 0 {
+		cancel()
+		if req.iface == nil {
+			return nil, fmt.Errorf("Failed to lookup peers using any address family")
+		}
+
+		return nil, fmt.Errorf("Failed to lookup peers on interface %q using any address family", req.iface.Name)
+	}
+
+	replies := make(chan Reply)
+
+	var wg sync.WaitGroup
+	for _, sender := range senders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				b := make([]byte, req.bufferSize)
+
+				// Block until the read succeeds or the connection is closed.
+				// The latter happens in case the context gets cancelled.
+				n, _, _, src, err := sender.readFrom(b)
+				if err != nil {
+					if !errors.Is(err, net.ErrClosed) {
+						logger.Error("Failed to read from lookup network endpoint", logger.Ctx{"err": err})
+					}
+
+					return
+				}
+
+				info, err := req.parse(b[:n])
+				if err != nil {
+					logger.Debug("Ignoring lookup reply", logger.Ctx{"source": src.String(), "err": err})
+					continue
+				}
+
+				if req.iface != nil {
+					info.Interface = req.iface.Name
+				}
+
+				select {
+				case replies <- Reply{Source: src, Info: *info}:
+				case <-lookupCtx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		cancel()
+		close(replies)
+	}()
+
+	return replies, nil
+}
+
+// streamLookup sends the request's queries using each family until the context is cancelled
+// and streams the info of every distinct peer.
+// The info contains the highest version supported by both the peer and the given versions,
+// or the reason why the peer is incompatible if there isn't any such version.
+// Replies which cannot be verified using the given verifier are discarded,
+// or reported as incompatible if they are unsigned replies of legacy peers and the request allows them.
+// Peers are distinguished by their address.
+// For multicast lookups the info contains the name of the interface on which the peer was found.
+// The returned channel is closed once the context is cancelled.
+func streamLookup(ctx context.Context, req lookupRequest, versions VersionRange, v *Verifier) (<-chan ServerInfo, error) {
+	replies, err := streamReplies(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make(chan ServerInfo)
+	go func() {
+		defer close(peers)
+
+		seen := map[string]bool{}
+		seenLegacy := map[string]bool{}
+		for reply := range replies {
+			unsigned := req.unsignedLegacy && reply.Info.HMAC == "" && !reply.Info.Versions().Signed()
+			if unsigned {
+				// Unsigned replies are tracked separately so that a forged one cannot hide a peer which signs its info.
+				if seen[reply.Info.Address] || seenLegacy[reply.Info.Address] {
+					continue
+				}
+
+				seenLegacy[reply.Info.Address] = true
+			} else {
+				// Only mark the peer as seen after verification.
+				// Otherwise a forged reply could hide the actual peer.
+				err := v.Verify(reply.Info, reply.Source)
+				if err != nil {
+					logger.Warn("Ignoring unverified lookup reply", logger.Ctx{"source": reply.Source.String(), "err": err})
+					continue
+				}
+
+				if seen[reply.Info.Address] {
+					continue
+				}
+
+				seen[reply.Info.Address] = true
+			}
+
+			var err error
+			reply.Info.NegotiatedVersion, err = versions.Negotiate(reply.Info.Versions())
+			if err == nil && unsigned {
+				// Anyone can forge an unsigned reply, so a legacy peer is only reported but cannot be used.
+				reply.Info.NegotiatedVersion = ""
+				err = fmt.Errorf("Supports version %s which doesn't sign its replies so it cannot be verified", reply.Info.Versions())
+			}
+
+			if err != nil {
+				logger.Warn("Found incompatible peer", logger.Ctx{"source": reply.Source.String(), "err": err})
+				reply.Info.Incompatible = err.Error()
+			}
+
+			select {
+			case peers <- reply.Info:
+			case <-ctx.Done():
+				// Keep draining the replies until the lookup stops.
+			}
+		}
+	}()
+
+	return peers, nil
+}
+
+// firstPeer returns the first compatible peer received on the given channel.
+// The given context has to be the one used for the lookup so that its cause can be returned
+// in case the lookup ends before any peer is found.
+func firstPeer(ctx context.Context, peers <-chan ServerInfo, endpoint string) (*ServerInfo, error) {
+	for peer := range peers {
+		if peer.Incompatible != "" {
+			continue
+		}
+
+		return &peer, nil
+	}
+
+	err := context.Cause(ctx)
+	if err == nil {
+		err = net.ErrClosed
+	}
+
+	return nil, fmt.Errorf("Failed to read from %s network endpoint: %w", endpoint, err)
+}
+
+// lookupSender returns a connection using a random port which sends messages of the given family.
+// If an interface is given, multicast messages are sent on this interface.
+func lookupSender(iface *net.Interface, family Family) (packetConn, error) {
+	// The PacketConn gets closed when calling Close on the derived family specific PacketConn.
+	conn, err := net.ListenPacket(family.network(), ":0")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to listen: %w", err)
+	}
+
+	sender := newPacketConn(family, conn)
+	if iface == nil {
+		return sender, nil
+	}
+
+	err = sender.SetMulticastInterface(iface)
+	if err != nil {
+		_ = sender.Close()
+		return nil, fmt.Errorf("Failed to set multicast interface %q: %w", iface.Name, err)
+	}
+
+	return sender, nil
+}
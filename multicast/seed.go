@@ -0,0 +1,239 @@
+package multicast
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/canonical/lxd/shared/trust"
+)
+
+// MaxSeedAddresses is the maximum number of addresses which can be probed using seeds.
+const MaxSeedAddresses = 4096
+
+// ExpandSeeds returns the distinct addresses contained in the given seeds.
+// A seed is either a single address or a CIDR range.
+func ExpandSeeds(seeds []string) ([]net.IP, error) {
+	addresses := []net.IP{}
+	seen := map[string]bool{}
+	for _, seed := range seeds {
+		var ips []net.IP
+		if strings.Contains(seed, "/") {
+			_, subnet, err := net.ParseCIDR(seed)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid seed %q: %w", seed, err)
+			}
+
+			ones, bits := subnet.Mask.Size()
+			if bits-ones >= 63 || 1<<(bits-ones) > MaxSeedAddresses {
+				return nil, fmt.Errorf("Seed %q contains more than %d addresses", seed, MaxSeedAddresses)
+			}
+
+			for ip := subnet.IP; subnet.Contains(ip); ip = nextIP(ip) {
+				ips = append(ips, ip)
+			}
+		} else {
+			ip := net.ParseIP(seed)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid seed %q", seed)
+			}
+
+			ips = append(ips, ip)
+		}
+
+		for _, ip := range ips {
+			if seen[ip.String()] {
+				continue
+			}
+
+			seen[ip.String()] = true
+			addresses = append(addresses, ip)
+		}
+
+		if len(addresses) > MaxSeedAddresses {
+			return nil, fmt.Errorf("Seeds contain more than %d addresses", MaxSeedAddresses)
+		}
+	}
+
+	return addresses, nil
+}
+
+// nextIP returns the address following the given one.
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+
+	return next
+}
+
+// SeedProber discovers peers outside of the local network segment by sending unicast probes to a list of seed addresses.
+type SeedProber struct {
+	seeds []net.IP
+	port  int64
+}
+
+// NewSeedProber returns a new instance of SeedProber which probes the given port
+// of every address contained in the given seeds.
+func NewSeedProber(seeds []string, port int64) (*SeedProber, error) {
+	addresses, err := ExpandSeeds(seeds)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SeedProber{
+		seeds: addresses,
+		port:  port,
+	}, nil
+}
+
+// Probe repeatedly sends the given info signed using the given formatter to every seed until the context is cancelled.
+// The info of every distinct peer whose reply is verified by the verifier is streamed on the returned channel.
+// Peers which don't support any of the versions of the given info are marked as incompatible.
+// The probed peers learn about the given info which allows them to reach out to us.
+func (p *SeedProber) Probe(ctx context.Context, info ServerInfo, h trust.HMACFormatter, v *Verifier) (<-chan ServerInfo, error) {
+	info, err := info.sign(h)
+	if err != nil {
+		return nil, err
+	}
+
+	probe, err := json.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal probe info: %w", err)
+	}
+
+	destinations := map[Family][]net.Addr{}
+	for _, seed := range p.seeds {
+		family := IPv6
+		if seed.To4() != nil {
+			family = IPv4
+		}
+
+		destinations[family] = append(destinations[family], &net.UDPAddr{IP: seed, Port: int(p.port)})
+	}
+
+	families := make([]Family, 0, len(destinations))
+	for _, family := range []Family{IPv4, IPv6} {
+		if len(destinations[family]) > 0 {
+			families = append(families, family)
+		}
+	}
+
+	if len(families) == 0 {
+		return nil, errors.New("No seeds to probe")
+	}
+
+	req := lookupRequest{
+		families: families,
+		queries:  [][]byte{probe},
+		destinations: func(family Family) []net.Addr {
+			return destinations[family]
+		},
+		// See the comment in Discovery's lookup for the reasoning about using 500.
+		bufferSize: 500,
+		parse:      parseServerInfo,
+	}
+
+	return streamLookup(ctx, req, info.Versions(), v)
+}
+
+// RespondProbes answers the probes sent by a SeedProber to the given port until the context is cancelled.
+// Only probes verified by the verifier are answered by sending the info signed using the salt and parameters of the probe,
+// so that the prober can verify the reply using the parameters of its session.
+// The info of every distinct peer which sent such a probe is streamed on the returned channel.
+// Peers which don't support any of the versions of the given info are marked as incompatible.
+func RespondProbes(ctx context.Context, port int64, info ServerInfo, v *Verifier) (<-chan ServerInfo, error) {
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to listen on %d: %w", port, err)
+	}
+
+	// Close the network endpoint if the context got cancelled.
+	// This allows exiting the endpoint's blocking read using ReadFrom.
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	peers := make(chan ServerInfo)
+	go func() {
+		defer close(peers)
+
+		seen := map[string]bool{}
+		for {
+			// See the comment in Discovery's lookup for the reasoning about using 500.
+			b := make([]byte, 500)
+			n, src, err := conn.ReadFrom(b)
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					logger.Error("Failed to read from network endpoint", logger.Ctx{"err": err})
+				}
+
+				return
+			}
+
+			probeInfo, err := parseServerInfo(b[:n])
+			if err != nil {
+				logger.Debug("Ignoring probe", logger.Ctx{"source": src.String(), "err": err})
+				continue
+			}
+
+			// Seed probes were introduced after SignedVersion, so every probe has to be signed.
+			err = v.Verify(*probeInfo, src)
+			if err != nil {
+				logger.Warn("Ignoring unverified probe", logger.Ctx{"source": src.String(), "err": err})
+				continue
+			}
+
+			// Still respond if the peer doesn't support any of our versions.
+			// It can report the incompatibility instead of not finding us at all.
+			probeInfo.NegotiatedVersion, err = info.Versions().Negotiate(probeInfo.Versions())
+			if err != nil {
+				logger.Warn("Received probe of incompatible peer", logger.Ctx{"source": src.String(), "err": err})
+				probeInfo.Incompatible = err.Error()
+			}
+
+			signedInfo, err := v.signLike(info, *probeInfo)
+			if err != nil {
+				logger.Error("Failed to sign reply", logger.Ctx{"dest": src.String(), "err": err})
+				continue
+			}
+
+			reply, err := json.Marshal(signedInfo)
+			if err != nil {
+				logger.Error("Failed to marshal server info", logger.Ctx{"dest": src.String(), "err": err})
+				continue
+			}
+
+			_, err = conn.WriteTo(reply, src)
+			if err != nil {
+				logger.Error("Failed to send reply", logger.Ctx{"dest": src.String(), "err": err})
+				continue
+			}
+
+			if seen[probeInfo.Address] {
+				continue
+			}
+
+			seen[probeInfo.Address] = true
+
+			select {
+			case peers <- *probeInfo:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return peers, nil
+}
@@ -0,0 +1,160 @@
+package multicast
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+func (m *multicastSuite) Test_ExpandSeeds() {
+	cases := []struct {
+		desc      string
+		seeds     []string
+		addresses []string
+		err       error
+	}{
+		{
+			desc:      "Single addresses",
+			seeds:     []string{"10.0.0.1", "fd42::1"},
+			addresses: []string{"10.0.0.1", "fd42::1"},
+		},
+		{
+			desc:      "CIDR ranges",
+			seeds:     []string{"10.0.0.0/30", "fd42::/127"},
+			addresses: []string{"10.0.0.0", "10.0.0.1", "10.0.0.2", "10.0.0.3", "fd42::", "fd42::1"},
+		},
+		{
+			desc:      "Overlapping seeds",
+			seeds:     []string{"10.0.0.1", "10.0.0.0/31"},
+			addresses: []string{"10.0.0.1", "10.0.0.0"},
+		},
+		{
+			desc:  "Invalid address",
+			seeds: []string{"foo"},
+			err:   fmt.Errorf(`Invalid seed "foo"`),
+		},
+		{
+			desc:  "Invalid CIDR range",
+			seeds: []string{"10.0.0.0/33"},
+			err:   fmt.Errorf(`Invalid seed "10.0.0.0/33": invalid CIDR address: 10.0.0.0/33`),
+		},
+		{
+			desc:  "CIDR range exceeding the maximum number of addresses",
+			seeds: []string{"fd42::/64"},
+			err:   fmt.Errorf(`Seed "fd42::/64" contains more than 4096 addresses`),
+		},
+		{
+			desc:  "Seeds exceeding the maximum number of addresses",
+			seeds: []string{"10.0.0.0/20", "10.1.0.1"},
+			err:   fmt.Errorf("Seeds contain more than 4096 addresses"),
+		},
+	}
+
+	for _, c := range cases {
+		m.T().Log(c.desc)
+
+		addresses, err := ExpandSeeds(c.seeds)
+		if c.err == nil {
+			m.Require().NoError(err)
+
+			expected := make([]net.IP, 0, len(c.addresses))
+			for _, address := range c.addresses {
+				expected = append(expected, net.ParseIP(address))
+			}
+
+			m.Require().Len(addresses, len(expected))
+			for i := range expected {
+				m.Require().True(expected[i].Equal(addresses[i]))
+			}
+		} else {
+			m.Require().Error(err)
+			m.Require().Equal(c.err.Error(), err.Error())
+		}
+	}
+}
+
+func (m *multicastSuite) Test_Probe() {
+	cases := []struct {
+		desc            string
+		probePassphrase string
+		probeVersion    string
+		probeMinVersion string
+		found           bool
+		incompatible    bool
+	}{
+		{
+			desc:            "Peer with matching version and passphrase is found",
+			probePassphrase: testPassphrase,
+			probeVersion:    "2.0",
+			found:           true,
+		},
+		{
+			desc:            "Peer using a different passphrase isn't found",
+			probePassphrase: "qux baz bar foo",
+			probeVersion:    "2.0",
+		},
+		{
+			desc:            "Peer supporting a range of versions is found using the highest common version",
+			probePassphrase: testPassphrase,
+			probeVersion:    "3.0",
+			probeMinVersion: "2.0",
+			found:           true,
+		},
+		{
+			desc:            "Peer using a different version is found as incompatible",
+			probePassphrase: testPassphrase,
+			probeVersion:    "3.0",
+			found:           true,
+			incompatible:    true,
+		},
+	}
+
+	for _, c := range cases {
+		m.T().Log(c.desc)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+
+		responderInfo := ServerInfo{Version: "2.0", Name: "foo", Address: "1.2.3.4"}
+		probers, err := RespondProbes(ctx, 9445, responderInfo, NewVerifier(m.testHMAC(testPassphrase)))
+		m.Require().NoError(err)
+
+		prober, err := NewSeedProber([]string{"127.0.0.1"}, 9445)
+		m.Require().NoError(err)
+
+		proberInfo := ServerInfo{Version: c.probeVersion, MinVersion: c.probeMinVersion, Name: "bar", Address: "1.2.3.5"}
+		proberHMAC := m.testHMAC(c.probePassphrase)
+		peers, err := prober.Probe(ctx, proberInfo, proberHMAC, NewVerifier(proberHMAC))
+		m.Require().NoError(err)
+
+		if c.found {
+			// Both sides learn about each other.
+			peer := <-peers
+			peer.HMAC = ""
+			m.Require().Equal(c.incompatible, peer.Incompatible != "")
+			peer.Incompatible = ""
+			if !c.incompatible {
+				m.Require().Equal("2.0", peer.NegotiatedVersion)
+			}
+
+			peer.NegotiatedVersion = ""
+			m.Require().Equal(responderInfo, peer)
+
+			peer = <-probers
+			peer.HMAC = ""
+			m.Require().Equal(c.incompatible, peer.Incompatible != "")
+			peer.Incompatible = ""
+			peer.NegotiatedVersion = ""
+			m.Require().Equal(proberInfo, peer)
+		} else {
+			_, ok := <-peers
+			m.Require().False(ok)
+		}
+
+		cancel()
+
+		// Wait for the responder to release its port.
+		for range probers {
+		}
+	}
+}
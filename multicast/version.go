@@ -1,4 +1,125 @@
 package multicast
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 // Version is the current version of the multicast discovery format.
-const Version = "2.0"
+// Starting with version 3.0 the server info is signed using the session passphrase, and the systems taking part
+// in a session also serve a signed summary of their capabilities using the MicroCloud API as it doesn't fit into a discovery datagram.
+const Version = "3.0"
+
+// SignedVersion is the first version of the multicast discovery format whose server info is signed.
+// Peers only supporting older versions cannot sign their server info.
+const SignedVersion = "3.0"
+
+// MinVersion is the oldest version of the multicast discovery format which is still supported.
+const MinVersion = "2.0"
+
+// SupportedVersions is the range of versions of the multicast discovery format supported by this system.
+var SupportedVersions = VersionRange{Min: MinVersion, Max: Version}
+
+// VersionRange represents a range of versions of the multicast discovery format.
+// Versions are of the form `<major>.<minor>`.
+type VersionRange struct {
+	Min string
+	Max string
+}
+
+// String returns the range in human readable form.
+func (r VersionRange) String() string {
+	if r.Min == r.Max {
+		return r.Max
+	}
+
+	return r.Min + " to " + r.Max
+}
+
+// Negotiate returns the highest version within both ranges.
+// It returns an error describing the incompatibility if the ranges don't overlap.
+func (r VersionRange) Negotiate(other VersionRange) (string, error) {
+	highest := r.Max
+	cmp, err := compareVersions(other.Max, r.Max)
+	if err != nil {
+		return "", err
+	}
+
+	if cmp < 0 {
+		highest = other.Max
+	}
+
+	for _, lowest := range []string{r.Min, other.Min} {
+		cmp, err := compareVersions(highest, lowest)
+		if err != nil {
+			return "", err
+		}
+
+		if cmp < 0 {
+			return "", fmt.Errorf("Supports version %s but expected %s", other, r)
+		}
+	}
+
+	return highest, nil
+}
+
+// Signed returns whether or not peers supporting the range sign their server info.
+// Peers only supporting versions older than SignedVersion cannot sign it.
+func (r VersionRange) Signed() bool {
+	cmp, err := compareVersions(r.Max, SignedVersion)
+
+	// Require a signature from peers using an invalid version.
+	return err != nil || cmp >= 0
+}
+
+// compareVersions returns -1, 0 or 1 depending on whether a is lower, equal to or higher than b.
+func compareVersions(a string, b string) (int, error) {
+	aMajor, aMinor, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+
+	bMajor, bMinor, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	if aMajor != bMajor {
+		if aMajor < bMajor {
+			return -1, nil
+		}
+
+		return 1, nil
+	}
+
+	if aMinor != bMinor {
+		if aMinor < bMinor {
+			return -1, nil
+		}
+
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+// parseVersion returns the major and minor part of the given version.
+func parseVersion(version string) (int, int, error) {
+	majorStr, minorStr, ok := strings.Cut(version, ".")
+	if !ok {
+		return 0, 0, fmt.Errorf("Invalid version %q", version)
+	}
+
+	major, err := strconv.Atoi(majorStr)
+	if err != nil || major < 0 {
+		return 0, 0, fmt.Errorf("Invalid version %q", version)
+	}
+
+	minor, err := strconv.Atoi(minorStr)
+	if err != nil || minor < 0 {
+		return 0, 0, fmt.Errorf("Invalid version %q", version)
+	}
+
+	return major, minor, nil
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+// ServiceErrors contains the errors of the services for which running a hook failed.
+type ServiceErrors map[types.ServiceType]error
+
+// Error returns the errors of all services ordered by the service type.
+// Each error is prefixed with its service if more than one service failed.
+func (e ServiceErrors) Error() string {
+	serviceTypes := e.types()
+	if len(serviceTypes) == 1 {
+		return e[serviceTypes[0]].Error()
+	}
+
+	msgs := make([]string, 0, len(serviceTypes))
+	for _, serviceType := range serviceTypes {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", serviceType, e[serviceType]))
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the errors of all services ordered by the service type.
+func (e ServiceErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, serviceType := range e.types() {
+		errs = append(errs, e[serviceType])
+	}
+
+	return errs
+}
+
+// types returns the sorted types of the failed services.
+func (e ServiceErrors) types() []types.ServiceType {
+	serviceTypes := make([]types.ServiceType, 0, len(e))
+	for serviceType := range e {
+		serviceTypes = append(serviceTypes, serviceType)
+	}
+
+	sort.Slice(serviceTypes, func(i, j int) bool { return serviceTypes[i] < serviceTypes[j] })
+
+	return serviceTypes
+}
+
+// serviceResult is the result of running a hook for a single service.
+type serviceResult struct {
+	serviceType types.ServiceType
+	err         error
+}
+
+// runGraph runs the given hook for each of the given services once the hook completed for all of its dependencies.
+// Services whose dependencies are complete run concurrently.
+// Dependencies on services which aren't part of the given services are ignored.
+// A service isn't run if the hook failed for any of its dependencies, or if the context is cancelled before it's its turn.
+// The errors of all failed services are returned as ServiceErrors.
+func runGraph(ctx context.Context, services map[types.ServiceType]Service, dependencies map[types.ServiceType][]types.ServiceType, f func(ctx context.Context, s Service) error) error {
+	pending := make(map[types.ServiceType]int, len(services))
+	dependents := make(map[types.ServiceType][]types.ServiceType, len(services))
+	for serviceType := range services {
+		pending[serviceType] = 0
+		for _, dependency := range dependencies[serviceType] {
+			_, ok := services[dependency]
+			if !ok || dependency == serviceType {
+				continue
+			}
+
+			pending[serviceType]++
+			dependents[dependency] = append(dependents[dependency], serviceType)
+		}
+	}
+
+	err := checkCycles(pending, dependents)
+	if err != nil {
+		return err
+	}
+
+	results := make(chan serviceResult, len(services))
+	errs := ServiceErrors{}
+	running := 0
+	start := func(serviceType types.ServiceType) {
+		if ctx.Err() != nil {
+			errs[serviceType] = ctx.Err()
+			return
+		}
+
+		running++
+		go func() {
+			results <- serviceResult{serviceType: serviceType, err: f(ctx, services[serviceType])}
+		}()
+	}
+
+	for serviceType, count := range pending {
+		if count == 0 {
+			start(serviceType)
+		}
+	}
+
+	for running > 0 {
+		result := <-results
+		running--
+
+		// The dependents of a failed service are skipped.
+		if result.err != nil {
+			errs[result.serviceType] = result.err
+			continue
+		}
+
+		for _, dependent := range dependents[result.serviceType] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				start(dependent)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// checkCycles returns an error if the dependencies of the services contain a cycle.
+// It takes the number of dependencies of each service and the dependents of each service.
+func checkCycles(pending map[types.ServiceType]int, dependents map[types.ServiceType][]types.ServiceType) error {
+	remaining := make(map[types.ServiceType]int, len(pending))
+	ready := []types.ServiceType{}
+	for serviceType, count := range pending {
+		remaining[serviceType] = count
+		if count == 0 {
+			ready = append(ready, serviceType)
+		}
+	}
+
+	visited := 0
+	for len(ready) > 0 {
+		serviceType := ready[0]
+		ready = ready[1:]
+		visited++
+
+		for _, dependent := range dependents[serviceType] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if visited == len(pending) {
+		return nil
+	}
+
+	cyclic := []string{}
+	for serviceType, count := range remaining {
+		if count > 0 {
+			cyclic = append(cyclic, string(serviceType))
+		}
+	}
+
+	sort.Strings(cyclic)
+
+	return fmt.Errorf("Services have cyclic dependencies: %s", strings.Join(cyclic, ", "))
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+	cephTypes "github.com/canonical/microceph/microceph/api/types"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+// CertificateExpiryWarning is the time before a certificate expires from which on its expiry is reported by health checks.
+const CertificateExpiryWarning = 30 * 24 * time.Hour
+
+// certificateHealth returns the issues of the given kind of certificate of a service's member at the given time.
+func certificateHealth(serviceType types.ServiceType, member string, kind string, cert *x509.Certificate, now time.Time) []types.HealthFinding {
+	if now.After(cert.NotAfter) {
+		return []types.HealthFinding{{
+			Service:  serviceType,
+			Severity: types.HealthError,
+			Message:  fmt.Sprintf("The %s certificate of %s expired on %s", kind, member, cert.NotAfter.Format(time.DateOnly)),
+		}}
+	}
+
+	if cert.NotAfter.Sub(now) < CertificateExpiryWarning {
+		return []types.HealthFinding{{
+			Service:  serviceType,
+			Severity: types.HealthWarning,
+			Message:  fmt.Sprintf("The %s certificate of %s expires on %s", kind, member, cert.NotAfter.Format(time.DateOnly)),
+		}}
+	}
+
+	return nil
+}
+
+// cephHealth returns the issues of a MicroCeph cluster with the given number of members, disks, pools and services.
+// MicroCeph doesn't expose the health of Ceph so it's derived from the cluster's configuration instead.
+func cephHealth(members int, disks cephTypes.Disks, pools []cephTypes.Pool, services cephTypes.Services) []types.HealthFinding {
+	findings := []types.HealthFinding{}
+
+	// Missing disks are reported separately.
+	if len(disks) > 0 {
+		for _, pool := range pools {
+			if pool.MinSize > int64(len(disks)) {
+				findings = append(findings, types.HealthFinding{
+					Service:  types.MicroCeph,
+					Severity: types.HealthError,
+					Message:  fmt.Sprintf("Pool %q requires at least %d OSDs to be available but there are only %d", pool.Pool, pool.MinSize, len(disks)),
+				})
+			} else if pool.Size > int64(len(disks)) {
+				findings = append(findings, types.HealthFinding{
+					Service:  types.MicroCeph,
+					Severity: types.HealthWarning,
+					Message:  fmt.Sprintf("Pool %q replicates its data %d times but there are only %d OSDs", pool.Pool, pool.Size, len(disks)),
+				})
+			}
+		}
+	}
+
+	monitors := 0
+	for _, service := range services {
+		if service.Service == "mon" {
+			monitors++
+		}
+	}
+
+	if members >= 3 && monitors < 3 {
+		findings = append(findings, types.HealthFinding{
+			Service:  types.MicroCeph,
+			Severity: types.HealthWarning,
+			Message:  fmt.Sprintf("Only %d monitors are running, at least 3 are required for fault tolerance", monitors),
+		})
+	}
+
+	return findings
+}
+
+// lxdHealth returns the issues of LXD with the given cluster members, storage pools and networks.
+// The cluster members are empty if LXD isn't clustered.
+func lxdHealth(members []api.ClusterMember, pools []api.StoragePool, networks []api.Network) []types.HealthFinding {
+	findings := []types.HealthFinding{}
+
+	// A cluster of at least three members should have three online database members to tolerate the loss of one.
+	if len(members) >= 3 {
+		databases := 0
+		for _, member := range members {
+			if member.Database && member.Status == "Online" {
+				databases++
+			}
+		}
+
+		if databases < 3 {
+			findings = append(findings, types.HealthFinding{
+				Service:  types.LXD,
+				Severity: types.HealthWarning,
+				Message:  fmt.Sprintf("Cluster is degraded as only %d database members are online", databases),
+			})
+		}
+	}
+
+	// Pending storage pools and networks aren't yet created on all cluster members.
+	for _, pool := range pools {
+		if pool.Status == api.StoragePoolStatusCreated {
+			continue
+		}
+
+		severity := types.HealthError
+		if pool.Status == api.StoragePoolStatusPending {
+			severity = types.HealthWarning
+		}
+
+		findings = append(findings, types.HealthFinding{
+			Service:  types.LXD,
+			Severity: severity,
+			Message:  fmt.Sprintf("Storage pool %q is %s", pool.Name, strings.ToLower(pool.Status)),
+		})
+	}
+
+	for _, network := range networks {
+		if !network.Managed || network.Status == api.NetworkStatusCreated {
+			continue
+		}
+
+		severity := types.HealthError
+		if network.Status == api.NetworkStatusPending {
+			severity = types.HealthWarning
+		}
+
+		findings = append(findings, types.HealthFinding{
+			Service:  types.LXD,
+			Severity: severity,
+			Message:  fmt.Sprintf("Network %q is %s", network.Name, strings.ToLower(network.Status)),
+		})
+	}
+
+	return findings
+}
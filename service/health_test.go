@@ -0,0 +1,84 @@
+package service
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+	cephTypes "github.com/canonical/microceph/microceph/api/types"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+type healthSuite struct {
+	suite.Suite
+}
+
+func TestHealthSuite(t *testing.T) {
+	suite.Run(t, new(healthSuite))
+}
+
+func (s *healthSuite) Test_certificateHealth() {
+	now := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	findings := certificateHealth(types.MicroCloud, "micro01", "server", &x509.Certificate{NotAfter: now.Add(365 * 24 * time.Hour)}, now)
+	s.Require().Empty(findings)
+
+	findings = certificateHealth(types.MicroCloud, "micro01", "server", &x509.Certificate{NotAfter: now.Add(7 * 24 * time.Hour)}, now)
+	s.Require().Equal([]types.HealthFinding{{Service: types.MicroCloud, Severity: types.HealthWarning, Message: "The server certificate of micro01 expires on 2024-11-08"}}, findings)
+
+	findings = certificateHealth(types.MicroCloud, "micro01", "cluster", &x509.Certificate{NotAfter: now.Add(-24 * time.Hour)}, now)
+	s.Require().Equal([]types.HealthFinding{{Service: types.MicroCloud, Severity: types.HealthError, Message: "The cluster certificate of micro01 expired on 2024-10-31"}}, findings)
+}
+
+func (s *healthSuite) Test_cephHealth() {
+	disks := cephTypes.Disks{{OSD: 0}, {OSD: 1}}
+	mons := cephTypes.Services{{Service: "mon", Location: "micro01"}, {Service: "mon", Location: "micro02"}, {Service: "mon", Location: "micro03"}}
+
+	// Missing disks are reported separately.
+	findings := cephHealth(3, nil, []cephTypes.Pool{{Pool: "rbd", Size: 3, MinSize: 2}}, mons)
+	s.Require().Empty(findings)
+
+	findings = cephHealth(3, disks, []cephTypes.Pool{{Pool: "rbd", Size: 2, MinSize: 1}}, mons)
+	s.Require().Empty(findings)
+
+	findings = cephHealth(3, disks, []cephTypes.Pool{{Pool: "rbd", Size: 3, MinSize: 2}, {Pool: "fs", Size: 3, MinSize: 3}}, mons[:1])
+	s.Require().Equal([]types.HealthFinding{
+		{Service: types.MicroCeph, Severity: types.HealthWarning, Message: `Pool "rbd" replicates its data 3 times but there are only 2 OSDs`},
+		{Service: types.MicroCeph, Severity: types.HealthError, Message: `Pool "fs" requires at least 3 OSDs to be available but there are only 2`},
+		{Service: types.MicroCeph, Severity: types.HealthWarning, Message: "Only 1 monitors are running, at least 3 are required for fault tolerance"},
+	}, findings)
+
+	// Small clusters cannot run enough monitors.
+	findings = cephHealth(2, disks, nil, mons[:2])
+	s.Require().Empty(findings)
+}
+
+func (s *healthSuite) Test_lxdHealth() {
+	member := func(name string, database bool, status string) api.ClusterMember {
+		return api.ClusterMember{ServerName: name, Database: database, Status: status}
+	}
+
+	pools := []api.StoragePool{{Name: "local", Status: api.StoragePoolStatusCreated}}
+	networks := []api.Network{{Name: "lxdbr0", Managed: true, Status: api.NetworkStatusCreated}, {Name: "eth0", Managed: false}}
+
+	findings := lxdHealth(nil, pools, networks)
+	s.Require().Empty(findings)
+
+	findings = lxdHealth([]api.ClusterMember{member("micro01", true, "Online"), member("micro02", true, "Online"), member("micro03", true, "Online")}, pools, networks)
+	s.Require().Empty(findings)
+
+	findings = lxdHealth(
+		[]api.ClusterMember{member("micro01", true, "Online"), member("micro02", true, "Offline"), member("micro03", true, "Online")},
+		[]api.StoragePool{{Name: "remote", Status: api.StoragePoolStatusErrored}, {Name: "local", Status: api.StoragePoolStatusPending}},
+		[]api.Network{{Name: "default", Managed: true, Status: api.NetworkStatusErrored}},
+	)
+	s.Require().Equal([]types.HealthFinding{
+		{Service: types.LXD, Severity: types.HealthWarning, Message: "Cluster is degraded as only 2 database members are online"},
+		{Service: types.LXD, Severity: types.HealthError, Message: `Storage pool "remote" is errored`},
+		{Service: types.LXD, Severity: types.HealthWarning, Message: `Storage pool "local" is pending`},
+		{Service: types.LXD, Severity: types.HealthError, Message: `Network "default" is errored`},
+	}, findings)
+}
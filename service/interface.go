@@ -29,4 +29,8 @@ type Service interface {
 	SupportsFeature(ctx context.Context, feature string) (bool, error)
 	GetVersion(ctx context.Context) (string, error)
 	IsInitialized(ctx context.Context) (bool, error)
+
+	// HealthCheck returns the issues found in the service.
+	// It doesn't return any issues if the service isn't initialized yet.
+	HealthCheck(ctx context.Context) ([]types.HealthFinding, error)
 }
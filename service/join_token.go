@@ -0,0 +1,128 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/canonical/lxd/shared/logger"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+// DefaultJoinTokenExpiry is the default duration for which an out-of-band join token can be redeemed.
+const DefaultJoinTokenExpiry = 24 * time.Hour
+
+// MaxJoinTokenExpiry is the maximum duration for which an out-of-band join token can be redeemed.
+const MaxJoinTokenExpiry = 30 * 24 * time.Hour
+
+// NewJoinToken returns a new out-of-band join token for the system at the given address
+// presenting a certificate with the given fingerprint.
+// The token expires after the given duration or after DefaultJoinTokenExpiry if it's zero.
+func NewJoinToken(address string, fingerprint string, expiry time.Duration) (*types.JoinToken, error) {
+	if expiry == 0 {
+		expiry = DefaultJoinTokenExpiry
+	}
+
+	if expiry < 0 || expiry > MaxJoinTokenExpiry {
+		return nil, fmt.Errorf("Join token expiry has to be between 1s and %s", MaxJoinTokenExpiry)
+	}
+
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate join token ID: %w", err)
+	}
+
+	// The secret is used as the passphrase of the join intent so it has far more entropy than a generated passphrase.
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate join token secret: %w", err)
+	}
+
+	return &types.JoinToken{
+		ID:          hex.EncodeToString(id),
+		Address:     address,
+		Fingerprint: fingerprint,
+		Secret:      hex.EncodeToString(secret),
+		ExpiresAt:   time.Now().Add(expiry).UTC(),
+	}, nil
+}
+
+// JoinTokenAttempts tracks the failed attempts to redeem join tokens per source address.
+// Like during a session, sources are blocked for an exponentially growing duration after each failed attempt and banned afterwards.
+// Unlike a session, the overall number of failed attempts isn't limited as join tokens can be redeemed for a long time.
+// Its zero value is ready to use.
+type JoinTokenAttempts struct {
+	lock    sync.Mutex
+	sources map[string]*failedSource
+}
+
+// CheckSource returns an error if the given source address has to wait before attempting to redeem a join token again
+// due to its previous failed attempts.
+func (a *JoinTokenAttempts) CheckSource(source string) error {
+	return a.checkSource(source, time.Now())
+}
+
+// checkSource returns an error if the given source address is blocked at the given time.
+func (a *JoinTokenAttempts) checkSource(source string, now time.Time) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	failed, ok := a.sources[source]
+	if !ok {
+		return nil
+	}
+
+	blocked, banned := failed.blocked(now)
+	if !blocked {
+		return nil
+	}
+
+	if banned {
+		return fmt.Errorf("Address %q is banned from redeeming join tokens until %s", source, failed.blockedUntil.Format(time.RFC3339))
+	}
+
+	return fmt.Errorf("Address %q has to wait %s before attempting to redeem a join token again", source, failed.blockedUntil.Sub(now).Round(time.Second))
+}
+
+// RegisterFailedAttempt registers a failed attempt of the given source address trying to redeem a join token
+// and returns whether or not the source address got banned.
+func (a *JoinTokenAttempts) RegisterFailedAttempt(source string) bool {
+	banned := a.registerFailedAttempt(source, time.Now())
+	if banned {
+		logger.Warn("Banned address from redeeming join tokens after too many failed attempts", logger.Ctx{"address": source, "duration": FailedAttemptBan})
+	}
+
+	return banned
+}
+
+// registerFailedAttempt registers a failed attempt of the given source address at the given time
+// and returns whether or not the source address got banned.
+// Sources which aren't blocked anymore for longer than FailedAttemptBan are forgotten
+// so that the tracked sources don't grow without bounds.
+func (a *JoinTokenAttempts) registerFailedAttempt(source string, now time.Time) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.sources == nil {
+		a.sources = map[string]*failedSource{}
+	}
+
+	for address, failed := range a.sources {
+		if now.Sub(failed.blockedUntil) > FailedAttemptBan {
+			delete(a.sources, address)
+		}
+	}
+
+	failed, ok := a.sources[source]
+	if !ok {
+		failed = &failedSource{}
+		a.sources[source] = failed
+	}
+
+	return failed.register(now)
+}
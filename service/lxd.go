@@ -8,6 +8,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/canonical/lxd/client"
@@ -633,6 +634,7 @@ func (s *LXDService) ValidateCephInterfaces(cephNetworkSubnetStr string, peerInt
 }
 
 // GetVersion gets the installed daemon version of the service, and returns an error if the version is not supported.
+// The installed version is also returned alongside the error if it is not supported.
 func (s LXDService) GetVersion(ctx context.Context) (string, error) {
 	client, err := s.Client(ctx)
 	if err != nil {
@@ -646,7 +648,7 @@ func (s LXDService) GetVersion(ctx context.Context) (string, error) {
 
 	err = validateVersion(s.Type(), server.Environment.ServerVersion)
 	if err != nil {
-		return "", err
+		return server.Environment.ServerVersion, err
 	}
 
 	return server.Environment.ServerVersion, nil
@@ -811,3 +813,116 @@ func (s LXDService) SupportsFeature(ctx context.Context, feature string) (bool,
 
 	return shared.ValueInSlice(feature, server.APIExtensions), nil
 }
+
+// lxdStatus returns the members of the LXD cluster in their microcluster representation.
+// There aren't any cluster members if LXD isn't clustered.
+func lxdStatus(ctx context.Context, s Service, status *types.Status) ([]microTypes.ClusterMember, error) {
+	lxdClient, err := s.(*LXDService).Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	server, _, err := lxdClient.GetServer()
+	if err != nil {
+		return nil, err
+	}
+
+	var microMembers []microTypes.ClusterMember
+	if server.Environment.ServerClustered {
+		clusterMembers, err := lxdClient.GetClusterMembers()
+		if err != nil {
+			return nil, err
+		}
+
+		certs, err := lxdClient.GetCertificates()
+		if err != nil {
+			return nil, err
+		}
+
+		microMembers = make([]microTypes.ClusterMember, 0, len(clusterMembers))
+		for _, member := range clusterMembers {
+			url, err := url.Parse(member.URL)
+			if err != nil {
+				return nil, err
+			}
+
+			addrPort, err := microTypes.ParseAddrPort(util.CanonicalNetworkAddress(url.Host, LXDPort))
+			if err != nil {
+				return nil, err
+			}
+
+			// Microcluster requires a certificate to be specified in types.ClusterMemberLocal.
+			var serverCert *microTypes.X509Certificate
+			for _, cert := range certs {
+				if cert.Type == "server" && cert.Name == member.ServerName {
+					serverCert, err = microTypes.ParseX509Certificate(cert.Certificate)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+
+			microMember := microTypes.ClusterMember{
+				ClusterMemberLocal: microTypes.ClusterMemberLocal{
+					Name:        member.ServerName,
+					Address:     addrPort,
+					Certificate: *serverCert,
+				},
+				Role:       strings.Join(member.Roles, ","),
+				Status:     microTypes.MemberStatus(member.Status),
+				Extensions: []string{},
+			}
+
+			// If the status is Online, use the microcluster representation, all other cluster states will be considered invalid and be treated like an offline state.
+			if member.Status == "Online" {
+				microMember.Status = microTypes.MemberOnline
+			}
+
+			microMembers = append(microMembers, microMember)
+		}
+	}
+
+	return microMembers, nil
+}
+
+// HealthCheck returns the issues found in LXD, which are a degraded cluster database and storage pools or networks which aren't created.
+func (s LXDService) HealthCheck(ctx context.Context) ([]types.HealthFinding, error) {
+	c, err := s.Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	initialized, err := s.isInitialized(c)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to check LXD initialization: %w", err)
+	}
+
+	if !initialized {
+		return nil, nil
+	}
+
+	server, _, err := c.GetServer()
+	if err != nil {
+		return nil, err
+	}
+
+	var members []api.ClusterMember
+	if server.Environment.ServerClustered {
+		members, err = c.GetClusterMembers()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get LXD cluster members: %w", err)
+		}
+	}
+
+	pools, err := c.GetStoragePools()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get LXD storage pools: %w", err)
+	}
+
+	networks, err := c.GetNetworks()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get LXD networks: %w", err)
+	}
+
+	return lxdHealth(members, pools, networks), nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/pem"
 	"fmt"
+	"net/http"
 
 	"github.com/canonical/lxd/lxd/util"
 	"github.com/canonical/lxd/shared"
@@ -63,3 +64,38 @@ func (s *LXDService) configFromToken(token string) (*api.ClusterPut, error) {
 
 	return config, nil
 }
+
+// TokenJoinConfig returns the member specific configuration of a system joining the cluster using an out-of-band join token.
+// As there isn't anyone choosing the joining system's disks and interfaces, the remote storage pools are joined using their defaults
+// and the OVN uplink network uses the same parent interface as this system. The local storage pool uses LXD's defaults.
+func (s LXDService) TokenJoinConfig(ctx context.Context) ([]api.ClusterMemberConfigKey, error) {
+	client, err := s.Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pools, err := client.GetStoragePoolNames()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get storage pools: %w", err)
+	}
+
+	joinConfig := []api.ClusterMemberConfigKey{}
+	if shared.ValueInSlice(DefaultCephPool, pools) {
+		joinConfig = append(joinConfig, s.DefaultCephStoragePoolJoinConfig())
+	}
+
+	if shared.ValueInSlice(DefaultCephFSPool, pools) {
+		joinConfig = append(joinConfig, s.DefaultCephFSStoragePoolJoinConfig())
+	}
+
+	uplink, _, err := client.UseTarget(s.Name()).GetNetwork(DefaultUplinkNetwork)
+	if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
+		return nil, fmt.Errorf("Failed to get network %q: %w", DefaultUplinkNetwork, err)
+	}
+
+	if err == nil && uplink.Config["parent"] != "" {
+		joinConfig = append(joinConfig, s.DefaultOVNNetworkJoinConfig(uplink.Config["parent"]))
+	}
+
+	return joinConfig, nil
+}
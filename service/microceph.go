@@ -17,6 +17,7 @@ import (
 	cephClient "github.com/canonical/microceph/microceph/client"
 	"github.com/canonical/microcluster/v2/client"
 	"github.com/canonical/microcluster/v2/microcluster"
+	microTypes "github.com/canonical/microcluster/v2/rest/types"
 
 	"github.com/canonical/microcloud/microcloud/api/types"
 	cloudClient "github.com/canonical/microcloud/microcloud/client"
@@ -264,6 +265,7 @@ func (s CephService) Port() int64 {
 }
 
 // GetVersion gets the installed daemon version of the service, and returns an error if the version is not supported.
+// The installed version is also returned alongside the error if it is not supported.
 func (s CephService) GetVersion(ctx context.Context) (string, error) {
 	status, err := s.m.Status(ctx)
 	if err != nil && api.StatusErrorCheck(err, http.StatusNotFound) {
@@ -276,7 +278,7 @@ func (s CephService) GetVersion(ctx context.Context) (string, error) {
 
 	err = validateVersion(s.Type(), status.Version)
 	if err != nil {
-		return "", err
+		return status.Version, err
 	}
 
 	return status.Version, nil
@@ -326,3 +328,85 @@ func (s *CephService) SupportsFeature(ctx context.Context, feature string) (bool
 
 	return server.Extensions.HasExtension(feature), nil
 }
+
+// cephStatus returns the cluster members of MicroCeph and sets the disks and services of the local system.
+func cephStatus(ctx context.Context, s Service, status *types.Status) ([]microTypes.ClusterMember, error) {
+	c, err := s.(*CephService).Client("")
+	if err != nil {
+		return nil, err
+	}
+
+	clusterMembers, err := microStatus(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	disks, err := cephClient.GetDisks(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	osds := []cephTypes.Disk{}
+	for _, disk := range disks {
+		if disk.Location == s.Name() {
+			osds = append(osds, disk)
+		}
+	}
+
+	services, err := cephClient.GetServices(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	cephServices := []cephTypes.Service{}
+	for _, service := range services {
+		if service.Location == s.Name() {
+			cephServices = append(cephServices, service)
+		}
+	}
+
+	status.OSDs = osds
+	status.CephServices = cephServices
+
+	return clusterMembers, nil
+}
+
+// HealthCheck returns the issues found in MicroCeph.
+// As MicroCeph doesn't expose the health of Ceph, the issues are derived from the configuration of its disks, pools and monitors.
+func (s CephService) HealthCheck(ctx context.Context) ([]types.HealthFinding, error) {
+	status, err := s.m.Status(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get %s status: %w", s.Type(), err)
+	}
+
+	if !status.Ready {
+		return nil, nil
+	}
+
+	c, err := s.Client("")
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := c.GetClusterMembers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get %s cluster members: %w", s.Type(), err)
+	}
+
+	disks, err := cephClient.GetDisks(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get %s disks: %w", s.Type(), err)
+	}
+
+	pools, err := cephClient.GetPools(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get %s pools: %w", s.Type(), err)
+	}
+
+	services, err := cephClient.GetServices(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get %s services: %w", s.Type(), err)
+	}
+
+	return cephHealth(len(members), disks, pools, services), nil
+}
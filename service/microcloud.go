@@ -14,6 +14,7 @@ import (
 	cephTypes "github.com/canonical/microceph/microceph/api/types"
 	microClient "github.com/canonical/microcluster/v2/client"
 	"github.com/canonical/microcluster/v2/microcluster"
+	microTypes "github.com/canonical/microcluster/v2/rest/types"
 	"github.com/gorilla/websocket"
 
 	"github.com/canonical/microcloud/microcloud/api/types"
@@ -208,6 +209,120 @@ func (s CloudService) RequestJoinIntent(ctx context.Context, clusterAddress stri
 	return client.JoinIntent(ctx, c, intent)
 }
 
+// RedeemJoinToken sends the intent to join the cluster to the system at the given address which issued the join token with the given ID.
+func (s CloudService) RedeemJoinToken(ctx context.Context, address string, conf cloudClient.AuthConfig, tokenID string, intent types.SessionJoinPost) (*x509.Certificate, error) {
+	c, err := s.client.RemoteClientWithCert(util.CanonicalNetworkAddress(address, CloudPort), conf.TLSServerCertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err = cloudClient.UseAuthProxy(c, types.MicroCloud, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.RedeemJoinToken(ctx, c, tokenID, intent)
+}
+
+// JoinTokenStatus returns the redemption state of the join token with the given ID on the system at the given address which issued it.
+func (s CloudService) JoinTokenStatus(ctx context.Context, address string, conf cloudClient.AuthConfig, tokenID string) (*types.JoinTokenStatus, error) {
+	c, err := s.client.RemoteClientWithCert(util.CanonicalNetworkAddress(address, CloudPort), conf.TLSServerCertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err = cloudClient.UseAuthProxy(c, types.MicroCloud, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GetJoinTokenStatus(ctx, c, tokenID)
+}
+
+// RemoteCertificate returns the unverified certificate presented by the MicroCloud at the given address.
+func (s CloudService) RemoteCertificate(address string) (*x509.Certificate, error) {
+	cert, err := shared.GetRemoteCertificate("https://"+util.CanonicalNetworkAddress(address, CloudPort), "")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get certificate of %q: %w", address, err)
+	}
+
+	return cert, nil
+}
+
+// RemoteCapabilities returns the capabilities of the system at the given address taking part in the current trust establishment session.
+// The given certificate is used to verify the remote and the capabilities have to be signed using the given passphrase and session parameters.
+func (s CloudService) RemoteCapabilities(ctx context.Context, cert *x509.Certificate, address string, passphrase string, auth types.SessionAuth) (*types.Capabilities, error) {
+	c, err := s.client.RemoteClientWithCert(util.CanonicalNetworkAddress(address, CloudPort), cert)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err = cloudClient.UseAuthProxy(c, types.MicroCloud, cloudClient.AuthConfig{})
+	if err != nil {
+		return nil, err
+	}
+
+	capabilities, err := client.GetSessionCapabilities(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = VerifyCapabilities(*capabilities, passphrase, auth)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to verify capabilities of %q: %w", address, err)
+	}
+
+	return &capabilities.Capabilities, nil
+}
+
+// RemoteSessionAuth returns the session parameters of the system at the given address taking part in the current trust establishment session.
+// They are taken from its capabilities which have to be signed using the given passphrase and parameters up to the given maximum.
+// The given certificate is used to verify the remote.
+func (s CloudService) RemoteSessionAuth(ctx context.Context, cert *x509.Certificate, address string, passphrase string, maxAuth types.SessionAuth) (types.SessionAuth, error) {
+	c, err := s.client.RemoteClientWithCert(util.CanonicalNetworkAddress(address, CloudPort), cert)
+	if err != nil {
+		return types.SessionAuth{}, err
+	}
+
+	c, err = cloudClient.UseAuthProxy(c, types.MicroCloud, cloudClient.AuthConfig{})
+	if err != nil {
+		return types.SessionAuth{}, err
+	}
+
+	capabilities, err := client.GetSessionCapabilities(ctx, c)
+	if err != nil {
+		return types.SessionAuth{}, err
+	}
+
+	auth, err := NegotiateCapabilities(*capabilities, passphrase, maxAuth)
+	if err != nil {
+		return types.SessionAuth{}, fmt.Errorf("Failed to verify capabilities of %q: %w", address, err)
+	}
+
+	return auth, nil
+}
+
+// ProbeSession returns an error if the system at the given address doesn't take part in a trust establishment session anymore.
+// The given certificate is used to verify the remote. Its capabilities aren't verified as only their presence matters.
+func (s CloudService) ProbeSession(ctx context.Context, cert *x509.Certificate, address string) error {
+	c, err := s.remoteClient(cert, address)
+	if err != nil {
+		return err
+	}
+
+	c, err = cloudClient.UseAuthProxy(c, types.MicroCloud, cloudClient.AuthConfig{})
+	if err != nil {
+		return err
+	}
+
+	_, err = client.GetSessionCapabilities(ctx, c)
+	if err != nil {
+		return fmt.Errorf("Failed to probe session of %q: %w", address, err)
+	}
+
+	return nil
+}
+
 // RemoteClusterMembers returns a map of cluster member names and addresses from the MicroCloud at the given address.
 // Provide the certificate of the remote server for mTLS.
 func (s CloudService) RemoteClusterMembers(ctx context.Context, cert *x509.Certificate, address string) (map[string]string, error) {
@@ -374,3 +489,57 @@ func (s *CloudService) StartSession(ctx context.Context, role string, sessionTim
 
 	return client.StartSession(ctx, c, role, sessionTimeout)
 }
+
+// ResumeSession resumes the trust establishment session with the given ID via the unix socket.
+func (s *CloudService) ResumeSession(ctx context.Context, role string, id string) (*websocket.Conn, error) {
+	c, err := s.client.LocalClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return client.ResumeSession(ctx, c, role, id)
+}
+
+// cloudStatus returns the cluster members of MicroCloud.
+func cloudStatus(ctx context.Context, s Service, status *types.Status) ([]microTypes.ClusterMember, error) {
+	c, err := s.(*CloudService).Client()
+	if err != nil {
+		return nil, err
+	}
+
+	return microStatus(ctx, c)
+}
+
+// HealthCheck returns the issues found in MicroCloud, which are certificates that are about to expire.
+func (s CloudService) HealthCheck(ctx context.Context) ([]types.HealthFinding, error) {
+	status, err := s.client.Status(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get %s status: %w", s.Type(), err)
+	}
+
+	if !status.Ready {
+		return nil, nil
+	}
+
+	certs := map[string]func() (*shared.CertInfo, error){
+		"server":  s.ServerCert,
+		"cluster": s.ClusterCert,
+	}
+
+	findings := []types.HealthFinding{}
+	for kind, getCert := range certs {
+		certInfo, err := getCert()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get %s certificate: %w", kind, err)
+		}
+
+		cert, err := certInfo.PublicKeyX509()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse %s certificate: %w", kind, err)
+		}
+
+		findings = append(findings, certificateHealth(s.Type(), s.name, kind, cert, time.Now())...)
+	}
+
+	return findings, nil
+}
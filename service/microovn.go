@@ -15,6 +15,10 @@ import (
 	"github.com/canonical/lxd/shared/logger"
 	"github.com/canonical/microcluster/v2/client"
 	"github.com/canonical/microcluster/v2/microcluster"
+	microTypes "github.com/canonical/microcluster/v2/rest/types"
+	ovnTypes "github.com/canonical/microovn/microovn/api/types"
+	ovnClient "github.com/canonical/microovn/microovn/client"
+	ovnCmd "github.com/canonical/microovn/microovn/ovn/cmd"
 
 	"github.com/canonical/microcloud/microcloud/api/types"
 	cloudClient "github.com/canonical/microcloud/microcloud/client"
@@ -187,6 +191,7 @@ func (s OVNService) Port() int64 {
 }
 
 // GetVersion gets the installed daemon version of the service, and returns an error if the version is not supported.
+// The installed version is also returned alongside the error if it is not supported.
 func (s OVNService) GetVersion(ctx context.Context) (string, error) {
 	status, err := s.m.Status(ctx)
 	if err != nil && api.StatusErrorCheck(err, http.StatusNotFound) {
@@ -199,7 +204,7 @@ func (s OVNService) GetVersion(ctx context.Context) (string, error) {
 
 	err = validateVersion(s.Type(), status.Version)
 	if err != nil {
-		return "", err
+		return status.Version, err
 	}
 
 	return status.Version, nil
@@ -249,3 +254,69 @@ func (s *OVNService) SupportsFeature(ctx context.Context, feature string) (bool,
 
 	return server.Extensions.HasExtension(feature), nil
 }
+
+// ovnStatus returns the cluster members of MicroOVN and sets the services of the local system.
+func ovnStatus(ctx context.Context, s Service, status *types.Status) ([]microTypes.ClusterMember, error) {
+	c, err := s.(*OVNService).Client()
+	if err != nil {
+		return nil, err
+	}
+
+	clusterMembers, err := microStatus(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	services, err := ovnClient.GetServices(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	ovnServices := []ovnTypes.Service{}
+	for _, service := range services {
+		if service.Location == s.Name() {
+			ovnServices = append(ovnServices, service)
+		}
+	}
+
+	status.OVNServices = ovnServices
+
+	return clusterMembers, nil
+}
+
+// HealthCheck returns the issues found in MicroOVN, which are OVN databases that cannot be reached.
+func (s OVNService) HealthCheck(ctx context.Context) ([]types.HealthFinding, error) {
+	status, err := s.m.Status(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get %s status: %w", s.Type(), err)
+	}
+
+	if !status.Ready {
+		return nil, nil
+	}
+
+	c, err := s.Client()
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []types.HealthFinding{}
+	for _, dbType := range []ovnCmd.OvsdbType{ovnCmd.OvsdbTypeNBLocal, ovnCmd.OvsdbTypeSBLocal} {
+		dbSpec, err := ovnCmd.NewOvsdbSpec(dbType)
+		if err != nil {
+			return nil, err
+		}
+
+		// Older versions of MicroOVN don't support querying the database schema.
+		_, fetchErr := ovnClient.GetActiveOvsdbSchemaVersion(ctx, c, dbSpec)
+		if fetchErr == ovnTypes.OvsdbSchemaFetchErrorGeneric {
+			findings = append(findings, types.HealthFinding{
+				Service:  s.Type(),
+				Severity: types.HealthError,
+				Message:  fmt.Sprintf("%s database is unreachable from %s", dbSpec.FriendlyName, s.name),
+			})
+		}
+	}
+
+	return findings, nil
+}
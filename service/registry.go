@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/v2/client"
+	microTypes "github.com/canonical/microcluster/v2/rest/types"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+// MicroCephDir is the path to the state directory of the MicroCeph snap.
+const MicroCephDir = "/var/snap/microceph/common/state"
+
+// MicroOVNDir is the path to the state directory of the MicroOVN snap.
+const MicroOVNDir = "/var/snap/microovn/common/state"
+
+// LXDDir is the path to the state directory of the LXD snap.
+const LXDDir = "/var/snap/lxd/common/lxd"
+
+// Definition declares how a service is integrated into MicroCloud.
+type Definition struct {
+	// Type is the type of the service.
+	Type types.ServiceType
+
+	// Optional services are only set up if they are installed on the system.
+	Optional bool
+
+	// StateDir is the state directory of the service.
+	// It's empty if the service is always available, like MicroCloud itself.
+	StateDir string
+
+	// Socket is the name of the unix socket in the state directory whose existence indicates that the service is installed.
+	Socket string
+
+	// Snap is the name of the snap providing the service.
+	Snap string
+
+	// ParallelUpgrade services have to be refreshed on all cluster members at the same time,
+	// as the refresh on each member blocks until all members run the new version.
+	ParallelUpgrade bool
+
+	// Dependencies are the services which have to be bootstrapped or joined before the service.
+	// Removing a system from the services happens in reverse order.
+	Dependencies []types.ServiceType
+
+	// New creates the service using the name, address and state directory of MicroCloud.
+	New func(name string, addr string, cloudDir string) (Service, error)
+
+	// Status returns the cluster members of the service and sets the service specific fields of the given status.
+	// The status is collected for all services concurrently so it must only set the fields belonging to the service.
+	Status func(ctx context.Context, s Service, status *types.Status) ([]microTypes.ClusterMember, error)
+}
+
+// Installed returns whether the service is installed on the system.
+func (d Definition) Installed() bool {
+	if d.StateDir == "" {
+		return true
+	}
+
+	_, err := os.Stat(filepath.Join(d.StateDir, d.Socket))
+
+	return err == nil
+}
+
+var registryMu sync.RWMutex
+
+// registry contains the definitions of the services supported by MicroCloud.
+var registry = map[types.ServiceType]Definition{
+	types.MicroCloud: {
+		Type: types.MicroCloud,
+		Snap: "microcloud",
+		New: func(name string, addr string, cloudDir string) (Service, error) {
+			return NewCloudService(name, addr, cloudDir)
+		},
+		Status: cloudStatus,
+	},
+	types.MicroCeph: {
+		Type:         types.MicroCeph,
+		Optional:     true,
+		StateDir:     MicroCephDir,
+		Socket:       "control.socket",
+		Snap:         "microceph",
+		Dependencies: []types.ServiceType{types.MicroCloud},
+		New: func(name string, addr string, cloudDir string) (Service, error) {
+			return NewCephService(name, addr, cloudDir)
+		},
+		Status: cephStatus,
+	},
+	types.MicroOVN: {
+		Type:         types.MicroOVN,
+		Optional:     true,
+		StateDir:     MicroOVNDir,
+		Socket:       "control.socket",
+		Snap:         "microovn",
+		Dependencies: []types.ServiceType{types.MicroCloud},
+		New: func(name string, addr string, cloudDir string) (Service, error) {
+			return NewOVNService(name, addr, cloudDir)
+		},
+		Status: ovnStatus,
+	},
+	types.LXD: {
+		Type:            types.LXD,
+		StateDir:        LXDDir,
+		Socket:          "unix.socket",
+		Snap:            "lxd",
+		ParallelUpgrade: true,
+		// LXD uses the storage and networks of MicroCeph and MicroOVN.
+		Dependencies: []types.ServiceType{types.MicroCloud, types.MicroCeph, types.MicroOVN},
+		New: func(name string, addr string, cloudDir string) (Service, error) {
+			return NewLXDService(name, addr, cloudDir)
+		},
+		Status: lxdStatus,
+	},
+}
+
+// Register adds the definition of an additional service to the registry.
+func Register(def Definition) error {
+	if def.Type == "" {
+		return fmt.Errorf("Service type cannot be empty")
+	}
+
+	if def.New == nil {
+		return fmt.Errorf("Service %q has no constructor", def.Type)
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	_, ok := registry[def.Type]
+	if ok {
+		return fmt.Errorf("Service %q is already registered", def.Type)
+	}
+
+	registry[def.Type] = def
+
+	return nil
+}
+
+// Lookup returns the definition of the given service type.
+func Lookup(serviceType types.ServiceType) (Definition, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	def, ok := registry[serviceType]
+
+	return def, ok
+}
+
+// Definitions returns the definitions of all registered services ordered by their type.
+func Definitions() []Definition {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	defs := make([]Definition, 0, len(registry))
+	for _, def := range registry {
+		defs = append(defs, def)
+	}
+
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Type < defs[j].Type })
+
+	return defs
+}
+
+// RequiredServices returns the types of the services which aren't optional.
+func RequiredServices() []types.ServiceType {
+	services := []types.ServiceType{}
+	for _, def := range Definitions() {
+		if !def.Optional {
+			services = append(services, def.Type)
+		}
+	}
+
+	return services
+}
+
+// OptionalServices returns the definitions of the services which are only set up if they are installed.
+func OptionalServices() []Definition {
+	defs := []Definition{}
+	for _, def := range Definitions() {
+		if def.Optional {
+			defs = append(defs, def)
+		}
+	}
+
+	return defs
+}
+
+// InstalledServices returns the types of the required services and of the optional services installed on the system.
+func InstalledServices() []types.ServiceType {
+	services := []types.ServiceType{}
+	for _, def := range Definitions() {
+		if !def.Optional || def.Installed() {
+			services = append(services, def.Type)
+		}
+	}
+
+	return services
+}
+
+// microStatus returns the cluster members of a microcluster based service.
+// If the service isn't yet initialized, there aren't any cluster members.
+func microStatus(ctx context.Context, microClient *client.Client) ([]microTypes.ClusterMember, error) {
+	clusterMembers, err := microClient.GetClusterMembers(ctx)
+	if err != nil && !api.StatusErrorCheck(err, http.StatusServiceUnavailable) {
+		return nil, err
+	}
+
+	return clusterMembers, nil
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+type registrySuite struct {
+	suite.Suite
+}
+
+func TestRegistrySuite(t *testing.T) {
+	suite.Run(t, new(registrySuite))
+}
+
+// fakeService is a service which only implements the methods needed to run hooks across services.
+type fakeService struct {
+	Service
+
+	serviceType types.ServiceType
+	name        string
+}
+
+func (s fakeService) Type() types.ServiceType {
+	return s.serviceType
+}
+
+// registerFake registers a fake service of the given type and dependencies which is removed from the registry after the test.
+func (s *registrySuite) registerFake(serviceType types.ServiceType, dependencies []types.ServiceType, stateDir string) {
+	err := Register(Definition{
+		Type:         serviceType,
+		Optional:     true,
+		StateDir:     stateDir,
+		Socket:       "control.socket",
+		Dependencies: dependencies,
+		New: func(name string, addr string, cloudDir string) (Service, error) {
+			return fakeService{serviceType: serviceType, name: name}, nil
+		},
+	})
+	s.Require().NoError(err)
+
+	s.T().Cleanup(func() {
+		registryMu.Lock()
+		delete(registry, serviceType)
+		registryMu.Unlock()
+	})
+}
+
+func (s *registrySuite) Test_Register() {
+	stateDir := s.T().TempDir()
+	s.registerFake("fake", nil, stateDir)
+
+	err := Register(Definition{Type: "fake", New: func(string, string, string) (Service, error) { return nil, nil }})
+	s.Require().EqualError(err, `Service "fake" is already registered`)
+
+	err = Register(Definition{Type: "other"})
+	s.Require().EqualError(err, `Service "other" has no constructor`)
+
+	def, ok := Lookup("fake")
+	s.Require().True(ok)
+	s.Require().True(def.Optional)
+
+	optional := []types.ServiceType{}
+	for _, def := range OptionalServices() {
+		optional = append(optional, def.Type)
+	}
+
+	s.Require().Equal([]types.ServiceType{types.MicroCeph, types.MicroOVN, "fake"}, optional)
+	s.Require().NotContains(RequiredServices(), types.ServiceType("fake"))
+
+	// The service is only installed once its socket exists.
+	s.Require().False(def.Installed())
+	s.Require().NotContains(InstalledServices(), types.ServiceType("fake"))
+
+	err = os.WriteFile(filepath.Join(stateDir, "control.socket"), nil, 0600)
+	s.Require().NoError(err)
+	s.Require().True(def.Installed())
+	s.Require().Contains(InstalledServices(), types.ServiceType("fake"))
+
+	handler, err := NewHandler("micro01", "10.0.0.1", "", "fake")
+	s.Require().NoError(err)
+	s.Require().Equal(fakeService{serviceType: "fake", name: "micro01"}, handler.Services()["fake"])
+
+	_, err = NewHandler("micro01", "10.0.0.1", "", "unknown")
+	s.Require().EqualError(err, `Unknown service "unknown"`)
+}
+
+func (s *registrySuite) Test_RunOrdered() {
+	s.registerFake("fake-first", nil, "")
+	s.registerFake("fake-a", []types.ServiceType{"fake-first"}, "")
+	s.registerFake("fake-b", []types.ServiceType{"fake-first", "fake-missing"}, "")
+	s.registerFake("fake-last", []types.ServiceType{"fake-a", "fake-b"}, "")
+
+	handler, err := NewHandler("micro01", "10.0.0.1", "", "fake-last", "fake-b", "fake-first", "fake-a")
+	s.Require().NoError(err)
+
+	run := func(reverse bool, failing types.ServiceType) ([]types.ServiceType, error) {
+		var mu sync.Mutex
+		order := []types.ServiceType{}
+		err := handler.RunOrdered(context.Background(), reverse, func(ctx context.Context, s Service) error {
+			if s.Type() == failing {
+				return fmt.Errorf("Failed %s", s.Type())
+			}
+
+			mu.Lock()
+			order = append(order, s.Type())
+			mu.Unlock()
+
+			return nil
+		})
+
+		return order, err
+	}
+
+	// Independent services run concurrently so their order is undefined.
+	// Dependencies on services which aren't part of the handler are ignored.
+	order, err := run(false, "")
+	s.Require().NoError(err)
+	s.Require().Len(order, 4)
+	s.Require().Equal(types.ServiceType("fake-first"), order[0])
+	s.Require().ElementsMatch([]types.ServiceType{"fake-a", "fake-b"}, order[1:3])
+	s.Require().Equal(types.ServiceType("fake-last"), order[3])
+
+	order, err = run(true, "")
+	s.Require().NoError(err)
+	s.Require().Len(order, 4)
+	s.Require().Equal(types.ServiceType("fake-last"), order[0])
+	s.Require().ElementsMatch([]types.ServiceType{"fake-a", "fake-b"}, order[1:3])
+	s.Require().Equal(types.ServiceType("fake-first"), order[3])
+
+	// Dependents of a failed service are skipped while independent services still run.
+	order, err = run(false, "fake-a")
+	s.Require().EqualError(err, "Failed fake-a")
+	s.Require().ElementsMatch([]types.ServiceType{"fake-first", "fake-b"}, order)
+
+	serviceErrs := ServiceErrors{}
+	s.Require().ErrorAs(err, &serviceErrs)
+	s.Require().Len(serviceErrs, 1)
+	s.Require().EqualError(serviceErrs["fake-a"], "Failed fake-a")
+}
+
+func (s *registrySuite) Test_RunConcurrent() {
+	s.registerFake("fake-a", nil, "")
+	s.registerFake("fake-b", nil, "")
+	s.registerFake("fake-c", nil, "")
+
+	handler, err := NewHandler("micro01", "10.0.0.1", "", "fake-a", "fake-b", "fake-c")
+	s.Require().NoError(err)
+
+	// The errors of all services are returned.
+	err = handler.RunConcurrent(context.Background(), func(ctx context.Context, s Service) error {
+		if s.Type() == "fake-b" {
+			return nil
+		}
+
+		return fmt.Errorf("Failed %s", s.Type())
+	})
+	s.Require().EqualError(err, "fake-a: Failed fake-a; fake-c: Failed fake-c")
+
+	// Services aren't run anymore once the context is cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var ran atomic.Bool
+	err = handler.RunConcurrent(ctx, func(ctx context.Context, _ Service) error {
+		ran.Store(true)
+		return nil
+	})
+	s.Require().ErrorIs(err, context.Canceled)
+	s.Require().False(ran.Load())
+}
+
+func (s *registrySuite) Test_RunOrderedCycle() {
+	s.registerFake("fake-a", []types.ServiceType{"fake-b"}, "")
+	s.registerFake("fake-b", []types.ServiceType{"fake-a"}, "")
+	s.registerFake("fake-c", nil, "")
+
+	handler, err := NewHandler("micro01", "10.0.0.1", "", "fake-a", "fake-b", "fake-c")
+	s.Require().NoError(err)
+
+	err = handler.RunOrdered(context.Background(), false, func(ctx context.Context, _ Service) error {
+		s.Fail("Service ran despite cyclic dependencies")
+		return nil
+	})
+	s.Require().EqualError(err, "Services have cyclic dependencies: fake-a, fake-b")
+}
+
+func (s *registrySuite) Test_WatchServices() {
+	snapDir := filepath.Join(s.T().TempDir(), "fake")
+	stateDir := filepath.Join(snapDir, "common", "state")
+	s.registerFake("fake", nil, stateDir)
+
+	handler, err := NewHandler("micro01", "10.0.0.1", "")
+	s.Require().NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- handler.WatchServices(ctx) }()
+	s.T().Cleanup(func() {
+		cancel()
+		s.Require().NoError(<-done)
+	})
+
+	expectService := func(exists bool) {
+		s.Require().Eventually(func() bool {
+			_, ok := handler.Services()["fake"]
+			return ok == exists
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	// The service is added once the state directory and its socket are created, even if the snap didn't exist before.
+	err = os.MkdirAll(stateDir, 0700)
+	s.Require().NoError(err)
+	err = os.WriteFile(filepath.Join(stateDir, "control.socket"), nil, 0600)
+	s.Require().NoError(err)
+	expectService(true)
+	s.Require().Equal(fakeService{serviceType: "fake", name: "micro01"}, handler.Services()["fake"])
+
+	err = os.RemoveAll(snapDir)
+	s.Require().NoError(err)
+	expectService(false)
+
+	err = os.MkdirAll(stateDir, 0700)
+	s.Require().NoError(err)
+	err = os.WriteFile(filepath.Join(stateDir, "control.socket"), nil, 0600)
+	s.Require().NoError(err)
+	expectService(true)
+}
@@ -1,14 +1,14 @@
 package service
 
 import (
+	"context"
 	"crypto/x509"
 	"fmt"
 	"net/http"
-	"os"
-	"path/filepath"
 	"sync"
 
 	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/lxd/shared/logger"
 
 	"github.com/canonical/microcloud/microcloud/api/types"
 	cloudClient "github.com/canonical/microcloud/microcloud/client"
@@ -33,34 +33,35 @@ const (
 
 // Handler holds a set of stateful services.
 type Handler struct {
-	Services map[types.ServiceType]Service
-	Name     string
-	Port     int64
+	Name string
+	Port int64
 
-	sessionLock sync.RWMutex
-	Session     *Session
+	servicesMu sync.RWMutex
+	services   map[types.ServiceType]Service
+	stateDir   string
+
+	sessionLock   sync.RWMutex
+	Session       *Session
+	eventRecorder SessionEventRecorder
+
+	// TokenAttempts tracks the failed attempts to redeem the join tokens issued by this system.
+	TokenAttempts JoinTokenAttempts
 
 	initMu  sync.RWMutex
 	address string
 }
 
 // NewHandler creates a new Handler with a client for each of the given services.
+// The services have to be registered, see Register.
 func NewHandler(name string, addr string, stateDir string, services ...types.ServiceType) (*Handler, error) {
 	servicesMap := make(map[types.ServiceType]Service, len(services))
 	for _, serviceType := range services {
-		var service Service
-		var err error
-		switch serviceType {
-		case types.MicroCloud:
-			service, err = NewCloudService(name, addr, stateDir)
-		case types.MicroCeph:
-			service, err = NewCephService(name, addr, stateDir)
-		case types.MicroOVN:
-			service, err = NewOVNService(name, addr, stateDir)
-		case types.LXD:
-			service, err = NewLXDService(name, addr, stateDir)
+		def, ok := Lookup(serviceType)
+		if !ok {
+			return nil, fmt.Errorf("Unknown service %q", serviceType)
 		}
 
+		service, err := def.New(name, addr, stateDir)
 		if err != nil {
 			return nil, fmt.Errorf("Failed to create %q service: %w", serviceType, err)
 		}
@@ -69,79 +70,128 @@ func NewHandler(name string, addr string, stateDir string, services ...types.Ser
 	}
 
 	return &Handler{
-		Services: servicesMap,
 		Name:     name,
-		address:  addr,
 		Port:     CloudPort,
+		services: servicesMap,
+		stateDir: stateDir,
+		address:  addr,
 	}, nil
 }
 
-// RunConcurrent runs the given hook concurrently across all services.
-// If firstService or lastService are empty strings, they will be ignored and all services will run concurrently.
-func (s *Handler) RunConcurrent(firstService types.ServiceType, lastService types.ServiceType, f func(s Service) error) error {
-	errors := make([]error, 0, len(s.Services))
-	mut := sync.Mutex{}
-	wg := sync.WaitGroup{}
+// Services returns a copy of the services of the handler.
+// Services can be added or removed at runtime so the copy is only a snapshot of the current services.
+func (s *Handler) Services() map[types.ServiceType]Service {
+	s.servicesMu.RLock()
+	defer s.servicesMu.RUnlock()
 
-	first, ok := s.Services[firstService]
-	if ok {
-		err := f(first)
-		if err != nil {
-			return err
-		}
+	services := make(map[types.ServiceType]Service, len(s.services))
+	for serviceType, service := range s.services {
+		services[serviceType] = service
 	}
 
-	for _, s := range s.Services {
-		if s.Type() == firstService || s.Type() == lastService {
-			continue
-		}
+	return services
+}
 
-		wg.Add(1)
-		go func(s Service) {
-			defer wg.Done()
-			err := f(s)
-			if err != nil {
-				mut.Lock()
-				errors = append(errors, err)
-				mut.Unlock()
-				return
-			}
-		}(s)
+// AddService creates a client for the given service and adds it to the handler.
+// If the handler already has the service it's a no-op. Otherwise the change is logged.
+func (s *Handler) AddService(serviceType types.ServiceType) error {
+	def, ok := Lookup(serviceType)
+	if !ok {
+		return fmt.Errorf("Unknown service %q", serviceType)
 	}
 
-	// Wait for all queries to complete and check for any errors.
-	wg.Wait()
-	for _, err := range errors {
-		if err != nil {
-			return err
-		}
+	s.servicesMu.Lock()
+	_, ok = s.services[serviceType]
+	if ok {
+		s.servicesMu.Unlock()
+		return nil
+	}
+
+	service, err := def.New(s.Name, s.Address(), s.stateDir)
+	if err != nil {
+		s.servicesMu.Unlock()
+		return fmt.Errorf("Failed to create %q service: %w", serviceType, err)
 	}
 
-	last, ok := s.Services[lastService]
+	s.services[serviceType] = service
+	s.servicesMu.Unlock()
+
+	logger.Info("Added service", logger.Ctx{"service": serviceType})
+
+	return nil
+}
+
+// RemoveService removes the given service from the handler.
+// If the handler doesn't have the service it's a no-op. Otherwise the change is logged.
+func (s *Handler) RemoveService(serviceType types.ServiceType) {
+	s.servicesMu.Lock()
+	_, ok := s.services[serviceType]
+	delete(s.services, serviceType)
+	s.servicesMu.Unlock()
+
 	if ok {
-		err := f(last)
-		if err != nil {
-			return err
+		logger.Info("Removed service", logger.Ctx{"service": serviceType})
+	}
+}
+
+// RunConcurrent runs the given hook concurrently across all services.
+// Services aren't run anymore once the context is cancelled.
+// The errors of all failed services are returned as ServiceErrors.
+func (s *Handler) RunConcurrent(ctx context.Context, f func(ctx context.Context, s Service) error) error {
+	return runGraph(ctx, s.Services(), nil, f)
+}
+
+// RunOrdered runs the given hook across all services in the order of the dependencies they are registered with.
+// Each service runs once the hook completed for all of its dependencies, and independent services run concurrently.
+// If reverse is true, each service runs once the hook completed for all services depending on it, e.g. to remove a system from the services.
+// Services aren't run anymore if the hook failed for any of their dependencies or once the context is cancelled.
+// The errors of all failed services are returned as ServiceErrors.
+func (s *Handler) RunOrdered(ctx context.Context, reverse bool, f func(ctx context.Context, s Service) error) error {
+	services := s.Services()
+	dependencies := make(map[types.ServiceType][]types.ServiceType, len(services))
+	for serviceType := range services {
+		def, ok := Lookup(serviceType)
+		if !ok {
+			return fmt.Errorf("Unknown service %q", serviceType)
+		}
+
+		for _, dependency := range def.Dependencies {
+			if reverse {
+				dependencies[dependency] = append(dependencies[dependency], serviceType)
+			} else {
+				dependencies[serviceType] = append(dependencies[serviceType], dependency)
+			}
 		}
 	}
 
-	return nil
+	return runGraph(ctx, services, dependencies, f)
 }
 
-// StartSession starts a new local trust establishment session.
-func (s *Handler) StartSession(role types.SessionRole, passphrase string, gw *cloudClient.WebsocketGateway) error {
-	session, err := NewSession(role, passphrase, gw)
+// StartSession starts a new local trust establishment session using the given session parameters.
+func (s *Handler) StartSession(role types.SessionRole, passphrase string, auth types.SessionAuth, gw *cloudClient.WebsocketGateway) error {
+	session, err := NewSession(role, passphrase, auth, gw)
 	if err != nil {
 		return err
 	}
 
 	s.sessionLock.Lock()
+	session.recorder = s.eventRecorder
 	s.Session = session
 	s.sessionLock.Unlock()
 
+	session.RecordEvent(types.SessionEventStarted, types.SessionIntent{}, "")
+
 	return nil
 }
 
+// SetSessionEventRecorder sets the recorder used to persist the lifecycle events of the sessions started afterwards.
+func (s *Handler) SetSessionEventRecorder(recorder SessionEventRecorder) {
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+
+	s.eventRecorder = recorder
+}
+
 // StopSession stops the current session started on this handler.
 // If there isn't an active session it's a no-op.
 func (s *Handler) StopSession(cause error) error {
@@ -202,18 +252,6 @@ func (s *Handler) TemporaryTrustStore() map[string]x509.Certificate {
 	return trustStore
 }
 
-// Exists returns true if we can stat the unix socket in the state directory of the given service.
-func Exists(service types.ServiceType, stateDir string) bool {
-	socketPath := filepath.Join(stateDir, "control.socket")
-	if service == types.LXD {
-		socketPath = filepath.Join(stateDir, "unix.socket")
-	}
-
-	_, err := os.Stat(socketPath)
-
-	return err == nil
-}
-
 // Address gets the address used for the MicroCloud API.
 func (s *Handler) Address() string {
 	s.initMu.RLock()
@@ -1,86 +1,185 @@
 package service
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/x509"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"math/big"
+	"net"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/canonical/lxd/shared"
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/canonical/lxd/shared/trust"
+	"github.com/gorilla/websocket"
 
 	"github.com/canonical/microcloud/microcloud/api/types"
 	cloudClient "github.com/canonical/microcloud/microcloud/client"
 	"github.com/canonical/microcloud/microcloud/multicast"
 )
 
-// AllowedFailedJoinAttempts contains the number of allowed failed session join attempts.
+// AllowedFailedJoinAttempts contains the number of allowed failed session join attempts across all source addresses.
 const AllowedFailedJoinAttempts uint8 = 50
 
+// AllowedFailedSourceAttempts contains the number of allowed failed session join attempts
+// from a single source address before it gets banned from the session.
+const AllowedFailedSourceAttempts = 5
+
+// FailedAttemptBackoff is the duration a source address has to wait after its first failed session join attempt.
+// It doubles with each further failed attempt.
+const FailedAttemptBackoff = time.Second
+
+// FailedAttemptBan is the duration for which a source address is banned after exceeding AllowedFailedSourceAttempts.
+const FailedAttemptBan = 10 * time.Minute
+
+// SessionResumeGracePeriod is the duration a session waits for its client to resume it after the client's connection got lost.
+const SessionResumeGracePeriod = 5 * time.Minute
+
+// HMACCapabilities10 is the HMAC format version used to sign the capabilities shared during a session.
+const HMACCapabilities10 trust.HMACVersion = "MicroCloudCapabilities-1.0"
+
 // Session represents a local trust establishment session.
 type Session struct {
 	lock           sync.RWMutex
+	id             string
 	passphrase     string
+	auth           types.SessionAuth
+	maxAuth        types.SessionAuth
 	trustStore     map[string]x509.Certificate
 	failedAttempts uint8
+	failedSources  map[string]*failedSource
 	gw             *cloudClient.WebsocketGateway
 	role           types.SessionRole
-	discovery      *multicast.Discovery
+	discoveries    []multicast.Backend
+
+	capabilitiesLock sync.Mutex
+	capabilities     *types.SessionCapabilities
+
+	discoveryLock     sync.Mutex
+	discoveryHMAC     trust.HMACFormatter
+	discoveryVerifier *multicast.Verifier
+
+	// events contains the lifecycle events which aren't yet persisted by the recorder.
+	// flushing is set while they are persisted in the background.
+	eventsLock sync.Mutex
+	events     []types.SessionEvent
+	flushing   bool
+	recorder   SessionEventRecorder
+
+	startedAt time.Time
+	timeout   time.Duration
+
+	// keepalive contains the settings requested by the client. It's empty if the client doesn't support keepalive.
+	keepalive types.SessionKeepalive
 
+	approvedJoiners        []types.ApprovedJoiner
 	joinIntentFingerprints []string
-	joinIntents            chan types.SessionJoinPost
-	exit                   chan bool
+	registeredIntents      []types.SessionIntent
+
+	// progress contains the decisions taken during the session and the pending messages
+	// sent to the client since the last decision. Both are replayed to a resuming client.
+	progress types.SessionState
+	pending  []types.SessionMessage
+
+	joinIntents chan types.SessionJoinPost
+	exit        chan bool
 }
 
-// generatePassphrase returns four random words chosen from wordlist.
-// The words are separated by space.
-func generatePassphrase() (string, error) {
-	splitWordlist := strings.Split(wordlist, "\n")
-	wordlistLength := int64(len(splitWordlist))
+// failedSource tracks the failed join attempts of a single source address.
+type failedSource struct {
+	attempts     int
+	blockedUntil time.Time
+}
 
-	var randomWords = make([]string, 4)
-	for i := 0; i < 4; i++ {
-		randomNumber, err := rand.Int(rand.Reader, big.NewInt(wordlistLength))
-		if err != nil {
-			return "", fmt.Errorf("Failed to get random number: %w", err)
-		}
+// register registers a failed attempt at the given time and returns whether or not the source got banned.
+// The source is blocked for an exponentially growing duration after each failed attempt
+// and banned once it exceeded AllowedFailedSourceAttempts.
+func (f *failedSource) register(now time.Time) bool {
+	f.attempts++
+	if f.attempts >= AllowedFailedSourceAttempts {
+		f.blockedUntil = now.Add(FailedAttemptBan)
+		return true
+	}
 
-		splitLine := strings.Split(splitWordlist[randomNumber.Int64()], "\t")
-		splitLineLength := len(splitLine)
-		if splitLineLength != 2 {
-			return "", fmt.Errorf("Invalid wordlist line: %q", splitWordlist[randomNumber.Int64()])
-		}
+	f.blockedUntil = now.Add(FailedAttemptBackoff << (f.attempts - 1))
+
+	return false
+}
 
-		randomWords[i] = splitLine[1]
+// blocked returns whether or not the source is blocked at the given time and whether or not it's banned.
+func (f *failedSource) blocked(now time.Time) (blocked bool, banned bool) {
+	if !now.Before(f.blockedUntil) {
+		return false, false
 	}
 
-	return strings.Join(randomWords, " "), nil
+	return true, f.attempts >= AllowedFailedSourceAttempts
 }
 
 // NewSession returns a new local trust establishment session.
-func NewSession(role types.SessionRole, passphrase string, gw *cloudClient.WebsocketGateway) (*Session, error) {
-	var err error
+// If no passphrase is given, a new one is generated using the given session parameters.
+func NewSession(role types.SessionRole, passphrase string, auth types.SessionAuth, gw *cloudClient.WebsocketGateway) (*Session, error) {
+	err := ValidateSessionAuth(auth)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid session parameters: %w", err)
+	}
 
+	auth = sessionAuthDefaults(auth)
 	if passphrase == "" {
-		passphrase, err = generatePassphrase()
+		passphrase, err = generatePassphrase(auth)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	// The wordlist isn't required anymore after generating the passphrase.
+	auth.Wordlist = nil
+
+	// The ID allows the client to resume the session.
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate session ID: %w", err)
+	}
+
+	// The session lasts as long as its websocket connection.
+	startedAt := time.Now()
+	var timeout time.Duration
+	if gw != nil {
+		deadline, ok := gw.Context().Deadline()
+		if ok {
+			timeout = deadline.Sub(startedAt).Round(time.Second)
+		}
+	}
+
 	return &Session{
+		id:         hex.EncodeToString(id),
 		passphrase: passphrase,
+		auth:       auth,
+		maxAuth:    auth,
 		trustStore: make(map[string]x509.Certificate),
 		gw:         gw,
 		role:       role,
+		startedAt:  startedAt,
+		timeout:    timeout,
 
 		joinIntents: make(chan types.SessionJoinPost),
 		exit:        make(chan bool),
 	}, nil
 }
 
+// ID returns the identifier of the current trust establishment session.
+func (s *Session) ID() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.id
+}
+
 // Passphrase returns the passphrase of the current trust establishment session.
 func (s *Session) Passphrase() string {
 	s.lock.RLock()
@@ -89,6 +188,14 @@ func (s *Session) Passphrase() string {
 	return s.passphrase
 }
 
+// Auth returns the parameters used to derive the HMAC key from the passphrase of the current trust establishment session.
+func (s *Session) Auth() types.SessionAuth {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.auth
+}
+
 // Role returns the role of the current trust establishment session.
 func (s *Session) Role() types.SessionRole {
 	s.lock.RLock()
@@ -97,20 +204,277 @@ func (s *Session) Role() types.SessionRole {
 	return s.role
 }
 
-// MulticastDiscovery starts a new multicast discovery listener in the current trust establishment session.
-func (s *Session) MulticastDiscovery(name string, address string, ifaceName string) error {
+// MaxAuth returns the highest parameters a joining system accepts from the initiator to derive the HMAC key.
+// They are the parameters the session got started with, so they default to the default parameters.
+func (s *Session) MaxAuth() types.SessionAuth {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.maxAuth
+}
+
+// SetAuth replaces the parameters used to derive the HMAC key from the passphrase of the current trust establishment session.
+// It allows a joining system to use the parameters of the initiator it found.
+// Capabilities signed using the previous parameters are signed again once requested.
+func (s *Session) SetAuth(auth types.SessionAuth) error {
+	err := ValidateSessionAuth(auth)
+	if err != nil {
+		return fmt.Errorf("Invalid session parameters: %w", err)
+	}
+
+	auth = sessionAuthDefaults(auth)
+	maxAuth := s.MaxAuth()
+	if auth.Argon2Time > maxAuth.Argon2Time || auth.Argon2Memory > maxAuth.Argon2Memory {
+		return fmt.Errorf("Argon2 parameters of %d iterations and %d KiB exceed the accepted maximum of %d iterations and %d KiB", auth.Argon2Time, auth.Argon2Memory, maxAuth.Argon2Time, maxAuth.Argon2Memory)
+	}
+
+	s.lock.Lock()
+	s.auth.Argon2Time = auth.Argon2Time
+	s.auth.Argon2Memory = auth.Argon2Memory
+	s.lock.Unlock()
+
+	s.capabilitiesLock.Lock()
+	s.capabilities = nil
+	s.capabilitiesLock.Unlock()
+
+	return nil
+}
+
+// DiscoveryAuth returns the formatter used to sign the server info sent during the current trust establishment session
+// and the verifier for the server info received from other systems.
+// Both are created only once per session so that all of the session's server info is signed using the same salt
+// and the key for each of the other systems' salts is derived only once.
+// The initiator uses the session parameters, whereas a joining system accepts the parameters of any initiator
+// up to the session's maximum as it learns them from the initiator's server info.
+func (s *Session) DiscoveryAuth() (trust.HMACFormatter, *multicast.Verifier, error) {
+	s.discoveryLock.Lock()
+	defer s.discoveryLock.Unlock()
+
+	if s.discoveryHMAC == nil {
+		var h trust.HMACFormatter
+		var err error
+		if s.Role() == types.SessionJoining {
+			h, err = NewNegotiatedSessionHMAC(s.Passphrase(), multicast.HMACDiscovery10, s.MaxAuth())
+		} else {
+			h, err = NewSessionHMAC(s.Passphrase(), multicast.HMACDiscovery10, s.Auth())
+		}
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to create a new HMAC instance using argon2: %w", err)
+		}
+
+		s.discoveryHMAC = h
+		s.discoveryVerifier = multicast.NewVerifier(h)
+	}
+
+	return s.discoveryHMAC, s.discoveryVerifier, nil
+}
+
+// NewDiscoveryBackend returns the discovery backend of the given type using the given interface and address families.
+// If no type is given, MicroCloud's own multicast discovery is used.
+func NewDiscoveryBackend(backend multicast.BackendType, ifaceName string, families ...multicast.Family) (multicast.Backend, error) {
+	switch backend {
+	case "", multicast.BackendMulticast:
+		return multicast.NewDiscovery(ifaceName, CloudMulticastPort, families...), nil
+	case multicast.BackendDNSSD:
+		// DNS-SD advertises the port of the MicroCloud API.
+		return multicast.NewDNSSD(ifaceName, CloudPort, families...), nil
+	}
+
+	return nil, multicast.ValidateBackend(backend)
+}
+
+// MulticastDiscovery starts a new discovery listener of the given backend on each of the given interfaces
+// in the current trust establishment session.
+// The multicast group is chosen based on the address family of the given address.
+// The listeners apply the default responder limits and only answer lookups from the given subnets if any.
+func (s *Session) MulticastDiscovery(name string, address string, ifaceNames []string, backend multicast.BackendType, allowedSubnets []*net.IPNet) error {
 	info := multicast.ServerInfo{
-		Version: multicast.Version,
-		Name:    name,
-		Address: address,
+		Version:    multicast.Version,
+		MinVersion: multicast.MinVersion,
+		Name:       name,
+		Address:    address,
 	}
 
-	s.discovery = multicast.NewDiscovery(ifaceName, CloudMulticastPort)
-	err := s.discovery.Respond(s.gw.Context(), info)
+	family, err := multicast.AddressFamily(address)
 	if err != nil {
 		return err
 	}
 
+	h, _, err := s.DiscoveryAuth()
+	if err != nil {
+		return err
+	}
+
+	limits := multicast.DefaultResponderLimits
+	limits.AllowedSubnets = allowedSubnets
+
+	for _, ifaceName := range ifaceNames {
+		discovery, err := NewDiscoveryBackend(backend, ifaceName, family)
+		if err != nil {
+			return err
+		}
+
+		discovery.SetLimits(limits)
+
+		err = discovery.Respond(s.gw.Context(), info, h)
+		if err != nil {
+			return fmt.Errorf("Failed to respond on interface %q: %w", ifaceName, err)
+		}
+
+		// Track the responder right away so that it gets stopped together with the session
+		// even if responding on one of the other interfaces fails.
+		s.lock.Lock()
+		s.discoveries = append(s.discoveries, discovery)
+		s.lock.Unlock()
+	}
+
+	return nil
+}
+
+// DiscoveryStats returns the counters of the lookups received by all discovery listeners of the session.
+func (s *Session) DiscoveryStats() types.DiscoveryStats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.discoveryStats()
+}
+
+// discoveryStats returns the sum of the counters of the session's discovery listeners.
+// The caller has to hold the session's lock.
+func (s *Session) discoveryStats() types.DiscoveryStats {
+	stats := types.DiscoveryStats{}
+	for _, discovery := range s.discoveries {
+		discoveryStats := discovery.Stats()
+		stats.Received += discoveryStats.Received
+		stats.Answered += discoveryStats.Answered
+		stats.Dropped += discoveryStats.Dropped
+	}
+
+	return stats
+}
+
+// SeedDiscovery starts probing the given seeds in the current trust establishment session.
+// This allows systems outside of the local network segment to discover the initiator.
+func (s *Session) SeedDiscovery(name string, address string, seeds []string) error {
+	info := multicast.ServerInfo{
+		Version:    multicast.Version,
+		MinVersion: multicast.MinVersion,
+		Name:       name,
+		Address:    address,
+	}
+
+	prober, err := multicast.NewSeedProber(seeds, CloudMulticastPort)
+	if err != nil {
+		return err
+	}
+
+	h, v, err := s.DiscoveryAuth()
+	if err != nil {
+		return err
+	}
+
+	peers, err := prober.Probe(s.gw.Context(), info, h, v)
+	if err != nil {
+		return err
+	}
+
+	// The probed systems reach out to us on their own so the peers are only logged.
+	go func() {
+		for peer := range peers {
+			if peer.Incompatible != "" {
+				logger.Warn("Found incompatible system using seeds", logger.Ctx{"name": peer.Name, "address": peer.Address, "reason": peer.Incompatible})
+				continue
+			}
+
+			logger.Info("Found system using seeds", logger.Ctx{"name": peer.Name, "address": peer.Address})
+		}
+	}()
+
+	return nil
+}
+
+// Capabilities returns the capabilities returned by the given function signed using the session passphrase and parameters.
+// They are collected and signed only once per session as the key derivation is expensive
+// and the capabilities can be requested by any system on the network.
+func (s *Session) Capabilities(collect func() (*types.Capabilities, error)) (*types.SessionCapabilities, error) {
+	s.capabilitiesLock.Lock()
+	defer s.capabilitiesLock.Unlock()
+
+	if s.capabilities != nil {
+		return s.capabilities, nil
+	}
+
+	capabilities, err := collect()
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := NewSessionHMAC(s.Passphrase(), HMACCapabilities10, s.Auth())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create a new HMAC instance using argon2: %w", err)
+	}
+
+	header, err := trust.HMACAuthorizationHeader(h, capabilities)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create HMAC for capabilities: %w", err)
+	}
+
+	s.capabilities = &types.SessionCapabilities{
+		Capabilities: *capabilities,
+		HMAC:         header,
+	}
+
+	return s.capabilities, nil
+}
+
+// VerifyCapabilities returns an error if the given capabilities aren't signed using the given passphrase and session parameters.
+func VerifyCapabilities(capabilities types.SessionCapabilities, passphrase string, auth types.SessionAuth) error {
+	h, err := NewSessionHMAC(passphrase, HMACCapabilities10, auth)
+	if err != nil {
+		return fmt.Errorf("Failed to create a new HMAC instance using argon2: %w", err)
+	}
+
+	version, _, _ := strings.Cut(capabilities.HMAC, " ")
+	if trust.HMACVersion(version) != h.Version() {
+		return fmt.Errorf("HMAC uses version %q but expected %q", version, h.Version())
+	}
+
+	return verifyCapabilities(capabilities, h)
+}
+
+// NegotiateCapabilities returns the session parameters used to sign the given capabilities.
+// It returns an error if the capabilities aren't signed using the given passphrase and valid session parameters up to the given maximum.
+func NegotiateCapabilities(capabilities types.SessionCapabilities, passphrase string, maxAuth types.SessionAuth) (types.SessionAuth, error) {
+	h, err := NewNegotiatedSessionHMAC(passphrase, HMACCapabilities10, maxAuth)
+	if err != nil {
+		return types.SessionAuth{}, fmt.Errorf("Failed to create a new HMAC instance using argon2: %w", err)
+	}
+
+	err = verifyCapabilities(capabilities, h)
+	if err != nil {
+		return types.SessionAuth{}, err
+	}
+
+	return SessionAuthFromHMAC(capabilities.HMAC, HMACCapabilities10)
+}
+
+// verifyCapabilities returns an error if the given capabilities cannot be verified using the given parent formatter.
+func verifyCapabilities(capabilities types.SessionCapabilities, h trust.HMACFormatter) error {
+	hFromHeader, hmacFromHeader, err := h.ParseHTTPHeader(capabilities.HMAC)
+	if err != nil {
+		return fmt.Errorf("Failed to parse HMAC: %w", err)
+	}
+
+	hmacFromContent, err := hFromHeader.WriteJSON(capabilities.Capabilities)
+	if err != nil {
+		return fmt.Errorf("Failed to calculate HMAC from capabilities: %w", err)
+	}
+
+	if !hmac.Equal(hmacFromHeader, hmacFromContent) {
+		return errors.New("Invalid HMAC")
+	}
+
 	return nil
 }
 
@@ -137,8 +501,8 @@ func (s *Session) TemporaryTrustStore() map[string]x509.Certificate {
 }
 
 // RegisterIntent registers the intention to join during the current trust establishment session
-// for the given fingerprint.
-func (s *Session) RegisterIntent(fingerprint string) error {
+// for the given intent using the certificate with the given fingerprint.
+func (s *Session) RegisterIntent(intent types.SessionJoinPost, fingerprint string) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
@@ -147,20 +511,292 @@ func (s *Session) RegisterIntent(fingerprint string) error {
 	}
 
 	s.joinIntentFingerprints = append(s.joinIntentFingerprints, fingerprint)
+	s.registeredIntents = append(s.registeredIntents, types.SessionIntent{
+		Name:        intent.Name,
+		Address:     intent.Address,
+		Fingerprint: fingerprint,
+	})
+
+	return nil
+}
+
+// Status returns the status of the current trust establishment session.
+func (s *Session) Status() types.SessionStatus {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	status := types.SessionStatus{
+		ID:             s.id,
+		Role:           s.role,
+		Connected:      s.gw != nil && s.gw.Connected(),
+		StartedAt:      s.startedAt,
+		Timeout:        s.timeout,
+		Intents:        append([]types.SessionIntent{}, s.registeredIntents...),
+		FailedAttempts: int(s.failedAttempts),
+		FailedSources:  make([]types.SessionFailedSource, 0, len(s.failedSources)),
+	}
+
+	for source, failed := range s.failedSources {
+		status.FailedSources = append(status.FailedSources, types.SessionFailedSource{
+			Address:      source,
+			Attempts:     failed.attempts,
+			BlockedUntil: failed.blockedUntil,
+		})
+	}
+
+	sort.Slice(status.FailedSources, func(i, j int) bool {
+		return status.FailedSources[i].Address < status.FailedSources[j].Address
+	})
+
+	return status
+}
+
+// SendMessage writes a message of the given type with the given payload to the client of the session
+// and records it so that it can be replayed if the client resumes the session.
+func (s *Session) SendMessage(msgType types.SessionMessageType, payload any) error {
+	msg, err := types.NewSessionMessage(msgType, payload)
+	if err != nil {
+		return err
+	}
+
+	s.RecordMessage(*msg)
+
+	return s.gw.WriteSessionMessage(*msg)
+}
+
+// RecordMessage records the given message sent to the client of the session
+// so that it can be replayed if the client resumes the session.
+// It has to be called before writing the message so that it doesn't get lost
+// if the client resumes the session in between.
+func (s *Session) RecordMessage(msg types.SessionMessage) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pending = append(s.pending, msg)
+}
+
+// RecordProgress applies the given update to the decisions taken during the session.
+// The recorded messages are discarded as they were only relevant for taking the decision.
+func (s *Session) RecordProgress(update func(progress *types.SessionState)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	update(&s.progress)
+	s.pending = nil
+}
+
+// Replay returns the messages which restore the state of the session on a resuming client.
+// The first message contains the session's details and the decisions taken so far
+// followed by the messages recorded since the last decision.
+// If the client requested keepalive settings, they are announced up front.
+func (s *Session) Replay() ([]types.SessionMessage, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	state := s.progress
+	state.SessionDetails = types.SessionDetails{
+		SessionID:  s.id,
+		Passphrase: s.passphrase,
+		Auth:       s.auth,
+	}
+
+	msg, err := types.NewSessionMessage(types.SessionMessageState, state)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := []types.SessionMessage{*msg}
+	if s.keepalive != (types.SessionKeepalive{}) {
+		keepalive, err := types.NewSessionMessage(types.SessionMessageKeepalive, s.keepalive)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs = append([]types.SessionMessage{*keepalive}, msgs...)
+	}
+
+	return append(msgs, s.pending...), nil
+}
+
+// Detached returns true if the session's client got disconnected and the session waits for it to resume.
+func (s *Session) Detached() bool {
+	return s.gw != nil && s.gw.Detached()
+}
+
+// Resume attaches the given connection of a resuming client to the session
+// and replays the session's state onto it.
+func (s *Session) Resume(conn *websocket.Conn) error {
+	if s.gw == nil {
+		return errors.New("Session doesn't have a client")
+	}
+
+	return s.gw.Attach(conn, func() ([]any, error) {
+		replay, err := s.Replay()
+		if err != nil {
+			return nil, err
+		}
+
+		msgs := make([]any, 0, len(replay))
+		for _, msg := range replay {
+			msgs = append(msgs, msg)
+		}
+
+		return msgs, nil
+	})
+}
+
+// SetKeepalive applies the given keepalive settings requested by the client to the session's websocket connection
+// and announces them to the client. If the client doesn't request any settings, the websocket connection isn't kept alive.
+func (s *Session) SetKeepalive(keepalive types.SessionKeepalive) error {
+	if keepalive == (types.SessionKeepalive{}) || s.gw == nil {
+		return nil
+	}
+
+	keepalive = sessionKeepaliveDefaults(keepalive)
+
+	s.lock.Lock()
+	s.keepalive = keepalive
+	s.lock.Unlock()
+
+	s.gw.SetKeepalive(keepalive)
+
+	err := s.gw.WriteMessage(types.SessionMessageKeepalive, keepalive)
+	if err != nil {
+		return fmt.Errorf("Failed to announce keepalive: %w", err)
+	}
+
+	return nil
+}
+
+// Keepalive returns the keepalive settings used to probe the other systems taking part in the session.
+// The defaults are used if the client didn't request any settings.
+func (s *Session) Keepalive() types.SessionKeepalive {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return sessionKeepaliveDefaults(s.keepalive)
+}
+
+// SetApprovedJoiners sets the systems whose join intents are confirmed without interactive confirmation.
+// Once set, the join intents of any other system are rejected.
+func (s *Session) SetApprovedJoiners(joiners []types.ApprovedJoiner) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.approvedJoiners = joiners
+}
+
+// ApprovedJoiners returns the systems whose join intents are confirmed without interactive confirmation.
+func (s *Session) ApprovedJoiners() []types.ApprovedJoiner {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.approvedJoiners
+}
+
+// ApproveIntent returns an error if the session only accepts approved joiners
+// and the given join intent using the certificate with the given fingerprint doesn't match any of them.
+func (s *Session) ApproveIntent(intent types.SessionJoinPost, fingerprint string) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if len(s.approvedJoiners) == 0 {
+		return nil
+	}
+
+	for _, joiner := range s.approvedJoiners {
+		if joiner.Name != "" && joiner.Name != intent.Name {
+			continue
+		}
+
+		if cloudClient.MatchFingerprint(fingerprint, joiner.Fingerprint) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("System %q using fingerprint %q isn't approved to join", intent.Name, fingerprint)
+}
+
+// CheckSource returns an error if the given source address has to wait before attempting to join
+// the current trust establishment session again due to its previous failed attempts.
+func (s *Session) CheckSource(source string) error {
+	return s.checkSource(source, time.Now())
+}
+
+// checkSource returns an error if the given source address is blocked at the given time.
+func (s *Session) checkSource(source string, now time.Time) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	failed, ok := s.failedSources[source]
+	if !ok {
+		return nil
+	}
+
+	blocked, banned := failed.blocked(now)
+	if !blocked {
+		return nil
+	}
+
+	if banned {
+		return fmt.Errorf("Address %q is banned from joining the session until %s", source, failed.blockedUntil.Format(time.RFC3339))
+	}
+
+	return fmt.Errorf("Address %q has to wait %s before attempting to join the session again", source, failed.blockedUntil.Sub(now).Round(time.Second))
+}
+
+// RegisterFailedAttempt registers a failed attempt of the given source address trying to join the current trust establishment session.
+// The source address is blocked for an exponentially growing duration after each failed attempt
+// and banned once it exceeded AllowedFailedSourceAttempts, which is reported to the client of the session.
+// An error is returned once the session exceeded the overall number of AllowedFailedJoinAttempts.
+func (s *Session) RegisterFailedAttempt(source string) error {
+	banned, err := s.registerFailedAttempt(source, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if banned {
+		logger.Warn("Banned address from joining the session after too many failed attempts", logger.Ctx{"address": source, "duration": FailedAttemptBan})
+
+		// Only the ban is recorded instead of each failed attempt so that unauthenticated requests cannot flood the history.
+		s.RecordEvent(types.SessionEventAddressBanned, types.SessionIntent{Address: source}, fmt.Sprintf("Banned for %s after %d failed attempts", FailedAttemptBan, AllowedFailedSourceAttempts))
+
+		// Only the initiator's client can act on the banned address.
+		// Failing to report it must not stop the session.
+		if s.Role() == types.SessionInitiating && s.gw != nil {
+			err := s.gw.WriteMessage(types.SessionMessageBannedAddress, types.SessionBannedAddress{Address: source})
+			if err != nil {
+				logger.Error("Failed to report banned address", logger.Ctx{"address": source, "err": err})
+			}
+		}
+	}
+
 	return nil
 }
 
-// RegisterFailedAttempt registers a failed attempt trying to join the current trust establishment session.
-func (s *Session) RegisterFailedAttempt() error {
+// registerFailedAttempt registers a failed attempt of the given source address at the given time
+// and returns whether or not the source address got banned.
+func (s *Session) registerFailedAttempt(source string, now time.Time) (bool, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
 	if s.failedAttempts == AllowedFailedJoinAttempts {
-		return errors.New("Exceeded the number of failed session join attempts")
+		return false, errors.New("Exceeded the number of failed session join attempts")
 	}
 
 	s.failedAttempts++
-	return nil
+
+	if s.failedSources == nil {
+		s.failedSources = map[string]*failedSource{}
+	}
+
+	failed, ok := s.failedSources[source]
+	if !ok {
+		failed = &failedSource{}
+		s.failedSources[source] = failed
+	}
+
+	return failed.register(now), nil
 }
 
 // IntentCh returns a channel which allows publishing and consuming join intents.
@@ -175,29 +811,44 @@ func (s *Session) ExitCh() chan bool {
 
 // Stop stops the current trust establishment session.
 func (s *Session) Stop(cause error) error {
-	s.lock.Lock()
-	defer s.lock.Unlock()
-
 	// If a cause is provided also write it onto the session's websocket
 	// to notify the client.
+	// The session isn't locked yet as resuming the session reads its state while holding the websocket.
 	if cause != nil {
+		s.RecordStop(cause)
+
 		err := s.gw.WriteClose(cause)
 		if err != nil {
 			return fmt.Errorf("Failed to write session stop cause to websocket: %w", err)
 		}
 	}
 
-	if s.discovery != nil {
-		err := s.discovery.StopResponder()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, discovery := range s.discoveries {
+		err := discovery.StopResponder()
 		if err != nil {
 			return fmt.Errorf("Failed to stop multicast discovery: %w", err)
 		}
 	}
 
+	if len(s.discoveries) > 0 {
+		stats := s.discoveryStats()
+		logger.Info("Stopped multicast discovery", logger.Ctx{"received": stats.Received, "answered": stats.Answered, "dropped": stats.Dropped})
+	}
+
+	s.discoveries = nil
+
 	s.passphrase = ""
 	s.trustStore = make(map[string]x509.Certificate, 0)
 	s.joinIntentFingerprints = []string{}
+	s.registeredIntents = nil
 	s.failedAttempts = 0
+	s.failedSources = nil
+	s.progress = types.SessionState{}
+	s.pending = nil
+	s.keepalive = types.SessionKeepalive{}
 
 	// For idempotency don't try to close the channels twice.
 	select {
@@ -0,0 +1,306 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/canonical/lxd/shared/trust"
+	"golang.org/x/crypto/argon2"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+// DefaultPassphraseWords is the default number of words of a generated session passphrase.
+const DefaultPassphraseWords = 4
+
+// MaxPassphraseWords is the maximum number of words of a generated session passphrase.
+const MaxPassphraseWords = 16
+
+// MinPassphraseEntropy is the minimum entropy in bits of a generated session passphrase.
+// The default of four words chosen from the embedded wordlist has around 41 bits.
+const MinPassphraseEntropy = 40
+
+// DefaultArgon2Time is the default number of Argon2 iterations used to derive the HMAC key from a session's passphrase.
+// The Argon2 defaults follow the second recommended option of https://www.rfc-editor.org/rfc/rfc9106#section-4-6.2.
+const DefaultArgon2Time uint32 = 3
+
+// DefaultArgon2Memory is the default amount of memory in KiB used by Argon2.
+const DefaultArgon2Memory uint32 = 64 * 1024
+
+// MaxArgon2Time is the maximum number of Argon2 iterations.
+const MaxArgon2Time uint32 = 16
+
+// MaxArgon2Memory is the maximum amount of memory in KiB used by Argon2.
+// It limits the resources a session can claim on each of the systems taking part in it.
+const MaxArgon2Memory uint32 = 4 * 1024 * 1024
+
+// argon2Threads is the number of Argon2 lanes.
+const argon2Threads uint8 = 4
+
+// argon2KeyLen is the length of the derived HMAC key.
+const argon2KeyLen uint32 = 32
+
+// ValidateSessionAuth returns an error if the given session parameters are invalid.
+func ValidateSessionAuth(auth types.SessionAuth) error {
+	if auth.PassphraseWords < 0 || auth.PassphraseWords > MaxPassphraseWords {
+		return fmt.Errorf("Passphrase has to contain between 1 and %d words", MaxPassphraseWords)
+	}
+
+	seen := make(map[string]bool, len(auth.Wordlist))
+	for _, word := range auth.Wordlist {
+		if word == "" || strings.ContainsAny(word, " \t\n") {
+			return fmt.Errorf("Invalid word %q in wordlist", word)
+		}
+
+		if seen[word] {
+			return fmt.Errorf("Word %q appears multiple times in wordlist", word)
+		}
+
+		seen[word] = true
+	}
+
+	auth = sessionAuthDefaults(auth)
+	wordlistLength := len(auth.Wordlist)
+	if wordlistLength == 0 {
+		wordlistLength = len(strings.Split(wordlist, "\n"))
+	}
+
+	entropy := float64(auth.PassphraseWords) * math.Log2(float64(wordlistLength))
+	if entropy < MinPassphraseEntropy {
+		return fmt.Errorf("Passphrase of %d words chosen from %d words has less than %d bits of entropy", auth.PassphraseWords, wordlistLength, MinPassphraseEntropy)
+	}
+
+	if auth.Argon2Time > MaxArgon2Time {
+		return fmt.Errorf("Argon2 time has to be between 1 and %d", MaxArgon2Time)
+	}
+
+	// Argon2 requires at least 8 KiB of memory per lane.
+	if auth.Argon2Memory < 8*uint32(argon2Threads) || auth.Argon2Memory > MaxArgon2Memory {
+		return fmt.Errorf("Argon2 memory has to be between %d and %d KiB", 8*uint32(argon2Threads), MaxArgon2Memory)
+	}
+
+	return nil
+}
+
+// sessionAuthDefaults returns the given session parameters with the defaults applied to unset parameters.
+func sessionAuthDefaults(auth types.SessionAuth) types.SessionAuth {
+	if auth.PassphraseWords == 0 {
+		auth.PassphraseWords = DefaultPassphraseWords
+	}
+
+	if auth.Argon2Time == 0 {
+		auth.Argon2Time = DefaultArgon2Time
+	}
+
+	if auth.Argon2Memory == 0 {
+		auth.Argon2Memory = DefaultArgon2Memory
+	}
+
+	return auth
+}
+
+// generatePassphrase returns random words chosen from the wordlist of the given session parameters.
+// If the parameters don't contain a wordlist, the embedded wordlist is used.
+// The words are separated by space.
+func generatePassphrase(auth types.SessionAuth) (string, error) {
+	words := auth.Wordlist
+	if len(words) == 0 {
+		for _, line := range strings.Split(wordlist, "\n") {
+			splitLine := strings.Split(line, "\t")
+			if len(splitLine) != 2 {
+				return "", fmt.Errorf("Invalid wordlist line: %q", line)
+			}
+
+			words = append(words, splitLine[1])
+		}
+	}
+
+	randomWords := make([]string, auth.PassphraseWords)
+	for i := range randomWords {
+		randomNumber, err := rand.Int(rand.Reader, big.NewInt(int64(len(words))))
+		if err != nil {
+			return "", fmt.Errorf("Failed to get random number: %w", err)
+		}
+
+		randomWords[i] = words[randomNumber.Int64()]
+	}
+
+	return strings.Join(randomWords, " "), nil
+}
+
+// NewSessionHMAC returns a new HMAC implementation using Argon2 with the parameters of the given session
+// to derive the key from the passphrase.
+// Non default parameters are appended to the given version so that systems using different parameters
+// detect the mismatch instead of reporting an invalid HMAC.
+// Using the default parameters, it's compatible with trust.NewHMACArgon2.
+func NewSessionHMAC(passphrase string, version trust.HMACVersion, auth types.SessionAuth) (trust.HMACFormatter, error) {
+	auth = sessionAuthDefaults(auth)
+
+	return newArgon2HMAC([]byte(passphrase), nil, sessionHMACVersion(version, auth), auth.Argon2Time, auth.Argon2Memory)
+}
+
+// NewNegotiatedSessionHMAC returns a new HMAC implementation using Argon2 which parses HMACs created by NewSessionHMAC
+// using session parameters up to the given maximum. The parameters are taken from the version of each parsed header.
+// It allows systems joining a session to verify the initiator's HMACs before knowing its parameters.
+// As the header isn't authenticated before deriving the key, headers exceeding the maximum are rejected without deriving it.
+// Unset maximum parameters default to the default parameters. Its own HMACs use the default parameters.
+func NewNegotiatedSessionHMAC(passphrase string, version trust.HMACVersion, maxAuth types.SessionAuth) (trust.HMACFormatter, error) {
+	h, err := newArgon2HMAC([]byte(passphrase), nil, version, DefaultArgon2Time, DefaultArgon2Memory)
+	if err != nil {
+		return nil, err
+	}
+
+	maxAuth = sessionAuthDefaults(maxAuth)
+	h.negotiate = true
+	h.maxTime = maxAuth.Argon2Time
+	h.maxMemory = maxAuth.Argon2Memory
+
+	return h, nil
+}
+
+// SessionAuthFromHMAC returns the session parameters used to create the given HMAC header of the given version.
+// Only the parameters deriving the key are set.
+func SessionAuthFromHMAC(header string, version trust.HMACVersion) (types.SessionAuth, error) {
+	headerVersion, _, _ := strings.Cut(header, " ")
+
+	return sessionAuthFromVersion(trust.HMACVersion(headerVersion), version)
+}
+
+// sessionHMACVersion returns the given version with the non default Argon2 parameters of the given session appended.
+func sessionHMACVersion(version trust.HMACVersion, auth types.SessionAuth) trust.HMACVersion {
+	if auth.Argon2Time == DefaultArgon2Time && auth.Argon2Memory == DefaultArgon2Memory {
+		return version
+	}
+
+	return trust.HMACVersion(fmt.Sprintf("%s+argon2-t%d-m%d", version, auth.Argon2Time, auth.Argon2Memory))
+}
+
+// sessionAuthFromVersion returns the session parameters appended to the given base version by sessionHMACVersion.
+// It returns an error if the version doesn't match the base version or the parameters are invalid.
+func sessionAuthFromVersion(version trust.HMACVersion, base trust.HMACVersion) (types.SessionAuth, error) {
+	auth := types.SessionAuth{Argon2Time: DefaultArgon2Time, Argon2Memory: DefaultArgon2Memory}
+	if version == base {
+		return auth, nil
+	}
+
+	suffix, ok := strings.CutPrefix(string(version), string(base)+"+")
+	if !ok {
+		return types.SessionAuth{}, fmt.Errorf("HMAC uses version %q but expected %q", version, base)
+	}
+
+	_, err := fmt.Sscanf(suffix, "argon2-t%d-m%d", &auth.Argon2Time, &auth.Argon2Memory)
+	if err != nil || sessionHMACVersion(base, auth) != version {
+		return types.SessionAuth{}, fmt.Errorf("Invalid Argon2 parameters in HMAC version %q", version)
+	}
+
+	if auth.Argon2Time == 0 {
+		return types.SessionAuth{}, fmt.Errorf("Argon2 time has to be between 1 and %d", MaxArgon2Time)
+	}
+
+	err = ValidateSessionAuth(auth)
+	if err != nil {
+		return types.SessionAuth{}, err
+	}
+
+	return auth, nil
+}
+
+// argon2HMAC represents the tooling for creating and validating HMACs
+// bundled with the key derivation function Argon2 using configurable parameters.
+type argon2HMAC struct {
+	trust.HMACFormatter
+
+	version  trust.HMACVersion
+	time     uint32
+	memory   uint32
+	salt     []byte
+	password []byte
+
+	// negotiate takes the parameters from the version of parsed headers instead of using the same ones.
+	// The parameters taken from a header cannot exceed maxTime and maxMemory.
+	negotiate bool
+	maxTime   uint32
+	maxMemory uint32
+}
+
+// newArgon2HMAC returns a new HMAC implementation using Argon2 with the given parameters.
+// If the salt is nil a random one gets generated.
+func newArgon2HMAC(password []byte, salt []byte, version trust.HMACVersion, time uint32, memory uint32) (*argon2HMAC, error) {
+	if salt == nil {
+		// 128 bit salt.
+		salt = make([]byte, 16)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create salt: %w", err)
+		}
+	}
+
+	key := argon2.IDKey(password, salt, time, memory, argon2Threads, argon2KeyLen)
+
+	return &argon2HMAC{
+		HMACFormatter: trust.NewHMAC(key, trust.NewDefaultHMACConf(version)),
+
+		version:  version,
+		time:     time,
+		memory:   memory,
+		salt:     salt,
+		password: password,
+	}, nil
+}
+
+// HTTPHeader returns the actual HMAC alongside its salt together with the used version.
+func (h *argon2HMAC) HTTPHeader(hmac []byte) string {
+	return fmt.Sprintf("%s %s:%s", h.version, hex.EncodeToString(h.salt), hex.EncodeToString(hmac))
+}
+
+// ParseHTTPHeader parses the given header and returns a new instance of the Argon2 formatter
+// using the salt from the header together with the actual HMAC.
+// It's using the parent formatter's password and parameters.
+// If the parent negotiates the parameters, they are taken from the header's version instead unless they exceed the parent's maximum.
+func (h *argon2HMAC) ParseHTTPHeader(header string) (trust.HMACFormatter, []byte, error) {
+	version, hmacStr, ok := strings.Cut(header, " ")
+	if !ok || version == "" || hmacStr == "" {
+		return nil, nil, errors.New("Version or HMAC is missing")
+	}
+
+	saltStr, hmacStr, ok := strings.Cut(hmacStr, ":")
+	if !ok || saltStr == "" || hmacStr == "" {
+		return nil, nil, errors.New("Argon2 salt or HMAC is missing")
+	}
+
+	saltFromHeader, err := hex.DecodeString(saltStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to decode the argon2 salt: %w", err)
+	}
+
+	hmacFromHeader, err := hex.DecodeString(hmacStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to decode the argon2 HMAC: %w", err)
+	}
+
+	argon2Time, argon2Memory := h.time, h.memory
+	if h.negotiate {
+		auth, err := sessionAuthFromVersion(trust.HMACVersion(version), h.version)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if auth.Argon2Time > h.maxTime || auth.Argon2Memory > h.maxMemory {
+			return nil, nil, fmt.Errorf("Argon2 parameters of HMAC version %q exceed the accepted maximum of %d iterations and %d KiB", version, h.maxTime, h.maxMemory)
+		}
+
+		argon2Time, argon2Memory = auth.Argon2Time, auth.Argon2Memory
+	}
+
+	hNew, err := newArgon2HMAC(h.password, saltFromHeader, trust.HMACVersion(version), argon2Time, argon2Memory)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return hNew, hmacFromHeader, nil
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/canonical/lxd/shared/logger"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+// SessionEventRecorder persists the given lifecycle events of trust establishment sessions.
+type SessionEventRecorder func(ctx context.Context, events []types.SessionEvent) error
+
+// RecordEvent records a lifecycle event of the session concerning the given system and persists it using the session's recorder.
+// The system is empty if the event doesn't concern a specific system.
+// Events recorded after the session stopped are ignored.
+// The events are persisted in the background so that recording them doesn't wait for the database.
+func (s *Session) RecordEvent(eventType types.SessionEventType, system types.SessionIntent, details string) {
+	s.lock.RLock()
+	stopped := s.passphrase == ""
+	id := s.id
+	role := s.role
+	s.lock.RUnlock()
+
+	if stopped {
+		return
+	}
+
+	s.eventsLock.Lock()
+	s.events = append(s.events, types.SessionEvent{
+		SessionID:   id,
+		Role:        role,
+		Type:        eventType,
+		Name:        system.Name,
+		Address:     system.Address,
+		Fingerprint: system.Fingerprint,
+		Details:     details,
+		CreatedAt:   time.Now().UTC(),
+	})
+
+	flushing := s.flushing
+	s.flushing = true
+	s.eventsLock.Unlock()
+
+	// A running flush also persists the event recorded just now.
+	if !flushing {
+		go s.flushEvents()
+	}
+}
+
+// RecordStop records that the session stopped because of the given cause.
+// The cause is nil if the session completed successfully.
+func (s *Session) RecordStop(cause error) {
+	var details string
+	if cause != nil {
+		details = cause.Error()
+	}
+
+	s.RecordEvent(types.SessionEventStopped, types.SessionIntent{}, details)
+}
+
+// flushEvents persists the recorded events which aren't yet persisted until none are left.
+// If they cannot be persisted yet, e.g. because a joining system's database isn't open before it joined,
+// they are kept and persisted together with the next event.
+// The recorder is called without holding the lock so that recording further events doesn't wait for the database.
+func (s *Session) flushEvents() {
+	for {
+		s.eventsLock.Lock()
+		events := s.events
+		if s.recorder == nil || len(events) == 0 {
+			s.flushing = false
+			s.eventsLock.Unlock()
+			return
+		}
+
+		s.events = nil
+		s.eventsLock.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := s.recorder(ctx, events)
+		cancel()
+		if err != nil {
+			s.eventsLock.Lock()
+			s.events = append(events, s.events...)
+			s.flushing = false
+			s.eventsLock.Unlock()
+
+			if events[len(events)-1].Type == types.SessionEventStopped {
+				logger.Warn("Failed to persist session events", logger.Ctx{"session": events[0].SessionID, "err": err})
+			} else {
+				logger.Debug("Deferring persisting session events", logger.Ctx{"session": events[0].SessionID, "err": err})
+			}
+
+			return
+		}
+	}
+}
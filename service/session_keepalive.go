@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+// DefaultKeepaliveInterval is the default time between two pings of a session's websocket connection
+// and probes of the other systems taking part in the session.
+const DefaultKeepaliveInterval = 10 * time.Second
+
+// DefaultKeepaliveTimeout is the default time after which a peer which didn't respond is considered unresponsive.
+const DefaultKeepaliveTimeout = 30 * time.Second
+
+// MinKeepaliveInterval is the minimum time between two pings or probes.
+// It limits the load a session can put on the systems taking part in it.
+const MinKeepaliveInterval = time.Second
+
+// ValidateSessionKeepalive returns an error if the given keepalive settings are invalid.
+func ValidateSessionKeepalive(keepalive types.SessionKeepalive) error {
+	if keepalive.Interval < 0 || keepalive.Timeout < 0 {
+		return fmt.Errorf("Keepalive interval and timeout cannot be negative")
+	}
+
+	keepalive = sessionKeepaliveDefaults(keepalive)
+	if keepalive.Interval < MinKeepaliveInterval {
+		return fmt.Errorf("Keepalive interval has to be at least %s", MinKeepaliveInterval)
+	}
+
+	if keepalive.Timeout <= keepalive.Interval {
+		return fmt.Errorf("Keepalive timeout %s has to be longer than the interval %s", keepalive.Timeout, keepalive.Interval)
+	}
+
+	return nil
+}
+
+// sessionKeepaliveDefaults returns the given keepalive settings with the defaults applied to unset settings.
+func sessionKeepaliveDefaults(keepalive types.SessionKeepalive) types.SessionKeepalive {
+	if keepalive.Interval == 0 {
+		keepalive.Interval = DefaultKeepaliveInterval
+	}
+
+	if keepalive.Timeout == 0 {
+		keepalive.Timeout = DefaultKeepaliveTimeout
+	}
+
+	return keepalive
+}
+
+// WatchPeer probes a peer using the given function in the keepalive interval until the context is cancelled.
+// The returned channel receives false once the peer didn't respond within the keepalive timeout
+// and true once it responds again. Each probe is bound by the keepalive interval.
+func WatchPeer(ctx context.Context, keepalive types.SessionKeepalive, probe func(ctx context.Context) error) <-chan bool {
+	keepalive = sessionKeepaliveDefaults(keepalive)
+	changes := make(chan bool)
+
+	go func() {
+		defer close(changes)
+
+		ticker := time.NewTicker(keepalive.Interval)
+		defer ticker.Stop()
+
+		alive := true
+		lastSeen := time.Now()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			probeCtx, cancel := context.WithTimeout(ctx, keepalive.Interval)
+			err := probe(probeCtx)
+			cancel()
+
+			if err == nil {
+				lastSeen = time.Now()
+			}
+
+			// Only report changes of the peer's liveness.
+			responsive := err == nil || time.Since(lastSeen) < keepalive.Timeout
+			if responsive == alive {
+				continue
+			}
+
+			alive = responsive
+			select {
+			case changes <- alive:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return changes
+}
@@ -0,0 +1,647 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd/shared/trust"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+type sessionSuite struct {
+	suite.Suite
+}
+
+func TestSessionSuite(t *testing.T) {
+	suite.Run(t, new(sessionSuite))
+}
+
+func (s *sessionSuite) Test_Capabilities() {
+	session, err := NewSession(types.SessionInitiating, "foo bar baz qux", types.SessionAuth{}, nil)
+	s.Require().NoError(err)
+
+	capabilities := types.Capabilities{
+		Disks:            2,
+		UplinkInterfaces: []string{"enp6s0"},
+		CPUs:             4,
+		Memory:           8 * 1024 * 1024 * 1024,
+		Services:         map[types.ServiceType]string{types.MicroCloud: "2.1.0"},
+	}
+
+	collected := 0
+	collect := func() (*types.Capabilities, error) {
+		collected++
+		return &capabilities, nil
+	}
+
+	signed, err := session.Capabilities(collect)
+	s.Require().NoError(err)
+	s.Require().Equal(capabilities, signed.Capabilities)
+
+	// The signed capabilities are cached for the rest of the session.
+	cached, err := session.Capabilities(collect)
+	s.Require().NoError(err)
+	s.Require().Equal(signed, cached)
+	s.Require().Equal(1, collected)
+
+	err = VerifyCapabilities(*signed, "foo bar baz qux", types.SessionAuth{})
+	s.Require().NoError(err)
+
+	err = VerifyCapabilities(*signed, "qux baz bar foo", types.SessionAuth{})
+	s.Require().EqualError(err, "Invalid HMAC")
+
+	tampered := *signed
+	tampered.Capabilities.Disks = 3
+	err = VerifyCapabilities(tampered, "foo bar baz qux", types.SessionAuth{})
+	s.Require().EqualError(err, "Invalid HMAC")
+
+	tampered = *signed
+	tampered.HMAC = ""
+	err = VerifyCapabilities(tampered, "foo bar baz qux", types.SessionAuth{})
+	s.Require().Error(err)
+}
+
+func (s *sessionSuite) Test_NegotiateCapabilities() {
+	auth := types.SessionAuth{Argon2Time: 1, Argon2Memory: 1024}
+	session, err := NewSession(types.SessionInitiating, "foo bar baz qux", auth, nil)
+	s.Require().NoError(err)
+
+	collect := func() (*types.Capabilities, error) {
+		return &types.Capabilities{CPUs: 4}, nil
+	}
+
+	signed, err := session.Capabilities(collect)
+	s.Require().NoError(err)
+
+	// The joining system learns the parameters from the signed capabilities.
+	negotiated, err := NegotiateCapabilities(*signed, "foo bar baz qux", types.SessionAuth{})
+	s.Require().NoError(err)
+	s.Require().Equal(uint32(1), negotiated.Argon2Time)
+	s.Require().Equal(uint32(1024), negotiated.Argon2Memory)
+
+	_, err = NegotiateCapabilities(*signed, "qux baz bar foo", types.SessionAuth{})
+	s.Require().EqualError(err, "Invalid HMAC")
+
+	// Verification requires the same parameters.
+	err = VerifyCapabilities(*signed, "foo bar baz qux", negotiated)
+	s.Require().NoError(err)
+
+	err = VerifyCapabilities(*signed, "foo bar baz qux", types.SessionAuth{})
+	s.Require().EqualError(err, `HMAC uses version "MicroCloudCapabilities-1.0+argon2-t1-m1024" but expected "MicroCloudCapabilities-1.0"`)
+
+	// Changing the parameters signs the capabilities again.
+	joining, err := NewSession(types.SessionJoining, "foo bar baz qux", types.SessionAuth{}, nil)
+	s.Require().NoError(err)
+
+	err = joining.SetAuth(negotiated)
+	s.Require().NoError(err)
+
+	signed, err = joining.Capabilities(collect)
+	s.Require().NoError(err)
+
+	err = VerifyCapabilities(*signed, "foo bar baz qux", auth)
+	s.Require().NoError(err)
+
+	err = joining.SetAuth(types.SessionAuth{Argon2Time: 17})
+	s.Require().EqualError(err, "Invalid session parameters: Argon2 time has to be between 1 and 16")
+
+	// Parameters above the maximum the joining system accepts are rejected without deriving the key.
+	err = joining.SetAuth(types.SessionAuth{Argon2Time: 4})
+	s.Require().EqualError(err, "Argon2 parameters of 4 iterations and 65536 KiB exceed the accepted maximum of 3 iterations and 65536 KiB")
+
+	costly, err := NewSession(types.SessionInitiating, "foo bar baz qux", types.SessionAuth{Argon2Time: 4, Argon2Memory: 1024}, nil)
+	s.Require().NoError(err)
+
+	signed, err = costly.Capabilities(collect)
+	s.Require().NoError(err)
+
+	_, err = NegotiateCapabilities(*signed, "foo bar baz qux", types.SessionAuth{})
+	s.Require().EqualError(err, `Failed to parse HMAC: Argon2 parameters of HMAC version "MicroCloudCapabilities-1.0+argon2-t4-m1024" exceed the accepted maximum of 3 iterations and 65536 KiB`)
+
+	negotiated, err = NegotiateCapabilities(*signed, "foo bar baz qux", types.SessionAuth{Argon2Time: 4})
+	s.Require().NoError(err)
+	s.Require().Equal(uint32(4), negotiated.Argon2Time)
+}
+
+func (s *sessionSuite) Test_SessionAuthFromHMAC() {
+	cases := []struct {
+		desc   string
+		header string
+		auth   types.SessionAuth
+		err    string
+	}{
+		{
+			desc:   "Default parameters",
+			header: "MicroCloud-1.0 abcd:abcd",
+			auth:   types.SessionAuth{Argon2Time: DefaultArgon2Time, Argon2Memory: DefaultArgon2Memory},
+		},
+		{
+			desc:   "Custom parameters",
+			header: "MicroCloud-1.0+argon2-t4-m131072 abcd:abcd",
+			auth:   types.SessionAuth{Argon2Time: 4, Argon2Memory: 131072},
+		},
+		{
+			desc:   "Different version",
+			header: "MicroCloudDiscovery-1.0 abcd:abcd",
+			err:    `HMAC uses version "MicroCloudDiscovery-1.0" but expected "MicroCloud-1.0"`,
+		},
+		{
+			desc:   "Malformed parameters",
+			header: "MicroCloud-1.0+argon2-t4-m131072x abcd:abcd",
+			err:    `Invalid Argon2 parameters in HMAC version "MicroCloud-1.0+argon2-t4-m131072x"`,
+		},
+		{
+			desc:   "Parameters exceeding the limits",
+			header: "MicroCloud-1.0+argon2-t4-m8388608 abcd:abcd",
+			err:    "Argon2 memory has to be between 32 and 4194304 KiB",
+		},
+	}
+
+	for _, c := range cases {
+		s.T().Log(c.desc)
+
+		auth, err := SessionAuthFromHMAC(c.header, "MicroCloud-1.0")
+		if c.err == "" {
+			s.Require().NoError(err)
+			s.Require().Equal(c.auth, auth)
+		} else {
+			s.Require().EqualError(err, c.err)
+		}
+	}
+}
+
+func (s *sessionSuite) Test_CapabilitiesCollectError() {
+	session, err := NewSession(types.SessionJoining, "foo bar baz qux", types.SessionAuth{}, nil)
+	s.Require().NoError(err)
+
+	_, err = session.Capabilities(func() (*types.Capabilities, error) {
+		return nil, errors.New("Failed to get system resources")
+	})
+	s.Require().EqualError(err, "Failed to get system resources")
+}
+
+func (s *sessionSuite) Test_ApproveIntent() {
+	session, err := NewSession(types.SessionInitiating, "foo bar baz qux", types.SessionAuth{}, nil)
+	s.Require().NoError(err)
+
+	fingerprint := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	other := "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+
+	// Any system can send its intent if no joiners are approved.
+	err = session.ApproveIntent(types.SessionJoinPost{Name: "foo"}, other)
+	s.Require().NoError(err)
+
+	session.SetApprovedJoiners([]types.ApprovedJoiner{{Fingerprint: "0123456789AB"}, {Name: "bar", Fingerprint: other}})
+
+	err = session.ApproveIntent(types.SessionJoinPost{Name: "foo"}, fingerprint)
+	s.Require().NoError(err)
+
+	err = session.ApproveIntent(types.SessionJoinPost{Name: "bar"}, other)
+	s.Require().NoError(err)
+
+	err = session.ApproveIntent(types.SessionJoinPost{Name: "foo"}, other)
+	s.Require().EqualError(err, `System "foo" using fingerprint "`+other+`" isn't approved to join`)
+}
+
+func (s *sessionSuite) Test_RegisterFailedAttempt() {
+	session, err := NewSession(types.SessionInitiating, "foo bar baz qux", types.SessionAuth{}, nil)
+	s.Require().NoError(err)
+
+	now := time.Now()
+	s.Require().NoError(session.checkSource("10.0.0.1", now))
+
+	// Each failed attempt doubles the backoff of the source.
+	banned, err := session.registerFailedAttempt("10.0.0.1", now)
+	s.Require().NoError(err)
+	s.Require().False(banned)
+	s.Require().EqualError(session.checkSource("10.0.0.1", now), `Address "10.0.0.1" has to wait 1s before attempting to join the session again`)
+	s.Require().NoError(session.checkSource("10.0.0.1", now.Add(FailedAttemptBackoff)))
+
+	banned, err = session.registerFailedAttempt("10.0.0.1", now)
+	s.Require().NoError(err)
+	s.Require().False(banned)
+	s.Require().EqualError(session.checkSource("10.0.0.1", now), `Address "10.0.0.1" has to wait 2s before attempting to join the session again`)
+
+	// Other sources aren't affected.
+	s.Require().NoError(session.checkSource("10.0.0.2", now))
+
+	for i := 2; i < AllowedFailedSourceAttempts-1; i++ {
+		banned, err = session.registerFailedAttempt("10.0.0.1", now)
+		s.Require().NoError(err)
+		s.Require().False(banned)
+	}
+
+	banned, err = session.registerFailedAttempt("10.0.0.1", now)
+	s.Require().NoError(err)
+	s.Require().True(banned)
+	s.Require().EqualError(session.checkSource("10.0.0.1", now.Add(FailedAttemptBan/2)), `Address "10.0.0.1" is banned from joining the session until `+now.Add(FailedAttemptBan).Format(time.RFC3339))
+	s.Require().NoError(session.checkSource("10.0.0.1", now.Add(FailedAttemptBan)))
+
+	// The session is only stopped once the overall number of failed attempts is exceeded.
+	for i := AllowedFailedSourceAttempts; i < int(AllowedFailedJoinAttempts); i++ {
+		_, err = session.registerFailedAttempt("10.0.1.1", now)
+		s.Require().NoError(err)
+	}
+
+	_, err = session.registerFailedAttempt("10.0.0.2", now)
+	s.Require().EqualError(err, "Exceeded the number of failed session join attempts")
+}
+
+func (s *sessionSuite) Test_ValidateSessionAuth() {
+	cases := []struct {
+		desc string
+		auth types.SessionAuth
+		err  string
+	}{
+		{
+			desc: "Defaults",
+		},
+		{
+			desc: "More words and higher cost",
+			auth: types.SessionAuth{PassphraseWords: 6, Argon2Time: 4, Argon2Memory: 128 * 1024},
+		},
+		{
+			desc: "Too few words",
+			auth: types.SessionAuth{PassphraseWords: 3},
+			err:  "Passphrase of 3 words chosen from 1296 words has less than 40 bits of entropy",
+		},
+		{
+			desc: "Too many words",
+			auth: types.SessionAuth{PassphraseWords: 17},
+			err:  "Passphrase has to contain between 1 and 16 words",
+		},
+		{
+			desc: "Large wordlist",
+			auth: types.SessionAuth{PassphraseWords: 3, Wordlist: wordlistOfSize(1 << 14)},
+		},
+		{
+			desc: "Small wordlist",
+			auth: types.SessionAuth{Wordlist: []string{"foo", "bar"}},
+			err:  "Passphrase of 4 words chosen from 2 words has less than 40 bits of entropy",
+		},
+		{
+			desc: "Duplicate words",
+			auth: types.SessionAuth{Wordlist: []string{"foo", "foo"}},
+			err:  `Word "foo" appears multiple times in wordlist`,
+		},
+		{
+			desc: "Word with whitespace",
+			auth: types.SessionAuth{Wordlist: []string{"foo bar"}},
+			err:  `Invalid word "foo bar" in wordlist`,
+		},
+		{
+			desc: "Too many iterations",
+			auth: types.SessionAuth{Argon2Time: 17},
+			err:  "Argon2 time has to be between 1 and 16",
+		},
+		{
+			desc: "Too little memory",
+			auth: types.SessionAuth{Argon2Memory: 16},
+			err:  "Argon2 memory has to be between 32 and 4194304 KiB",
+		},
+	}
+
+	for _, c := range cases {
+		s.T().Log(c.desc)
+
+		err := ValidateSessionAuth(c.auth)
+		if c.err == "" {
+			s.NoError(err)
+		} else {
+			s.EqualError(err, c.err)
+		}
+	}
+}
+
+func (s *sessionSuite) Test_GeneratePassphrase() {
+	passphrase, err := generatePassphrase(sessionAuthDefaults(types.SessionAuth{}))
+	s.Require().NoError(err)
+	s.Require().Len(strings.Split(passphrase, " "), DefaultPassphraseWords)
+
+	wordlist := wordlistOfSize(1 << 14)
+	passphrase, err = generatePassphrase(types.SessionAuth{PassphraseWords: 3, Wordlist: wordlist})
+	s.Require().NoError(err)
+
+	words := strings.Split(passphrase, " ")
+	s.Require().Len(words, 3)
+	for _, word := range words {
+		s.Require().Contains(wordlist, word)
+	}
+}
+
+func (s *sessionSuite) Test_SessionHMAC() {
+	payload := map[string]string{"name": "foo"}
+
+	// Using the default parameters the HMAC is compatible with the one from LXD.
+	h, err := NewSessionHMAC("foo bar baz qux", "MicroCloud-1.0", types.SessionAuth{})
+	s.Require().NoError(err)
+
+	header, err := trust.HMACAuthorizationHeader(h, payload)
+	s.Require().NoError(err)
+
+	lxdHMAC, err := trust.NewHMACArgon2([]byte("foo bar baz qux"), nil, trust.NewDefaultHMACConf("MicroCloud-1.0"))
+	s.Require().NoError(err)
+
+	hFromHeader, hmacFromHeader, err := lxdHMAC.ParseHTTPHeader(header)
+	s.Require().NoError(err)
+
+	hmacFromPayload, err := hFromHeader.WriteJSON(payload)
+	s.Require().NoError(err)
+	s.Require().True(hmac.Equal(hmacFromHeader, hmacFromPayload))
+
+	// Custom parameters are reflected in the version.
+	auth := types.SessionAuth{Argon2Time: 1, Argon2Memory: 1024}
+	h, err = NewSessionHMAC("foo bar baz qux", "MicroCloud-1.0", auth)
+	s.Require().NoError(err)
+	s.Require().Equal(trust.HMACVersion("MicroCloud-1.0+argon2-t1-m1024"), h.Version())
+
+	header, err = trust.HMACAuthorizationHeader(h, payload)
+	s.Require().NoError(err)
+
+	verifier, err := NewSessionHMAC("foo bar baz qux", "MicroCloud-1.0", auth)
+	s.Require().NoError(err)
+
+	hFromHeader, hmacFromHeader, err = verifier.ParseHTTPHeader(header)
+	s.Require().NoError(err)
+	s.Require().Equal(verifier.Version(), hFromHeader.Version())
+
+	hmacFromPayload, err = hFromHeader.WriteJSON(payload)
+	s.Require().NoError(err)
+	s.Require().True(hmac.Equal(hmacFromHeader, hmacFromPayload))
+
+	_, _, err = verifier.ParseHTTPHeader("MicroCloud-1.0 abcd")
+	s.Require().EqualError(err, "Argon2 salt or HMAC is missing")
+}
+
+// wordlistOfSize returns a wordlist containing the given number of distinct words.
+func wordlistOfSize(size int) []string {
+	words := make([]string, 0, size)
+	for i := 0; i < size; i++ {
+		words = append(words, fmt.Sprintf("word%d", i))
+	}
+
+	return words
+}
+
+func (s *sessionSuite) Test_Status() {
+	session, err := NewSession(types.SessionInitiating, "foo bar baz qux", types.SessionAuth{}, nil)
+	s.Require().NoError(err)
+
+	err = session.RegisterIntent(types.SessionJoinPost{Name: "foo", Address: "10.0.0.2"}, "abcdef")
+	s.Require().NoError(err)
+
+	err = session.RegisterIntent(types.SessionJoinPost{Name: "foo", Address: "10.0.0.2"}, "abcdef")
+	s.Require().EqualError(err, "Fingerprint already exists")
+
+	now := time.Now()
+	_, err = session.registerFailedAttempt("10.0.0.3", now)
+	s.Require().NoError(err)
+
+	status := session.Status()
+	s.Require().Equal(session.ID(), status.ID)
+	s.Require().Equal(types.SessionInitiating, status.Role)
+	s.Require().False(status.Connected)
+	s.Require().False(status.StartedAt.IsZero())
+	s.Require().Zero(status.Timeout)
+	s.Require().Equal([]types.SessionIntent{{Name: "foo", Address: "10.0.0.2", Fingerprint: "abcdef"}}, status.Intents)
+	s.Require().Equal(1, status.FailedAttempts)
+	s.Require().Equal([]types.SessionFailedSource{{Address: "10.0.0.3", Attempts: 1, BlockedUntil: now.Add(FailedAttemptBackoff)}}, status.FailedSources)
+}
+
+func (s *sessionSuite) Test_Replay() {
+	session, err := NewSession(types.SessionInitiating, "foo bar baz qux", types.SessionAuth{}, nil)
+	s.Require().NoError(err)
+	s.Require().Len(session.ID(), 32)
+
+	other, err := NewSession(types.SessionInitiating, "foo bar baz qux", types.SessionAuth{}, nil)
+	s.Require().NoError(err)
+	s.Require().NotEqual(session.ID(), other.ID())
+
+	state := types.SessionState{
+		SessionDetails: types.SessionDetails{
+			SessionID:  session.ID(),
+			Passphrase: "foo bar baz qux",
+			Auth:       session.Auth(),
+		},
+	}
+
+	stateMessage := func() types.SessionMessage {
+		msg, err := types.NewSessionMessage(types.SessionMessageState, state)
+		s.Require().NoError(err)
+
+		return *msg
+	}
+
+	replay, err := session.Replay()
+	s.Require().NoError(err)
+	s.Require().Equal([]types.SessionMessage{stateMessage()}, replay)
+
+	// Messages are replayed until a decision is taken.
+	intent, err := types.NewSessionMessage(types.SessionMessageIntent, types.SessionJoinIntent{Intent: types.SessionJoinPost{Name: "foo"}})
+	s.Require().NoError(err)
+
+	session.RecordMessage(*intent)
+	replay, err = session.Replay()
+	s.Require().NoError(err)
+	s.Require().Equal([]types.SessionMessage{stateMessage(), *intent}, replay)
+
+	confirmed := []types.SessionJoinPost{{Name: "foo"}}
+	session.RecordProgress(func(progress *types.SessionState) {
+		progress.IntentsConfirmed = true
+		progress.ConfirmedIntents = confirmed
+	})
+
+	state.IntentsConfirmed = true
+	state.ConfirmedIntents = confirmed
+	replay, err = session.Replay()
+	s.Require().NoError(err)
+	s.Require().Equal([]types.SessionMessage{stateMessage()}, replay)
+
+	// Resuming requires a client.
+	err = session.Resume(nil)
+	s.Require().EqualError(err, "Session doesn't have a client")
+
+	// Keepalive is only applied to the websocket connection of a client.
+	err = session.SetKeepalive(types.SessionKeepalive{Interval: time.Second})
+	s.Require().NoError(err)
+	s.Require().Equal(types.SessionKeepalive{Interval: DefaultKeepaliveInterval, Timeout: DefaultKeepaliveTimeout}, session.Keepalive())
+}
+
+func (s *sessionSuite) Test_ValidateSessionKeepalive() {
+	s.Require().NoError(ValidateSessionKeepalive(types.SessionKeepalive{}))
+	s.Require().NoError(ValidateSessionKeepalive(types.SessionKeepalive{Interval: 5 * time.Second, Timeout: 15 * time.Second}))
+	s.Require().NoError(ValidateSessionKeepalive(types.SessionKeepalive{Timeout: time.Minute}))
+
+	s.Require().EqualError(ValidateSessionKeepalive(types.SessionKeepalive{Interval: -time.Second}), "Keepalive interval and timeout cannot be negative")
+	s.Require().EqualError(ValidateSessionKeepalive(types.SessionKeepalive{Interval: time.Millisecond}), "Keepalive interval has to be at least 1s")
+	s.Require().EqualError(ValidateSessionKeepalive(types.SessionKeepalive{Interval: time.Minute}), "Keepalive timeout 30s has to be longer than the interval 1m0s")
+	s.Require().EqualError(ValidateSessionKeepalive(types.SessionKeepalive{Timeout: 5 * time.Second}), "Keepalive timeout 5s has to be longer than the interval 10s")
+}
+
+func (s *sessionSuite) Test_WatchPeer() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var responding atomic.Bool
+	responding.Store(true)
+
+	changes := WatchPeer(ctx, types.SessionKeepalive{Interval: 10 * time.Millisecond, Timeout: 50 * time.Millisecond}, func(ctx context.Context) error {
+		if !responding.Load() {
+			return errors.New("Connection refused")
+		}
+
+		return nil
+	})
+
+	receive := func() bool {
+		select {
+		case alive := <-changes:
+			return alive
+		case <-time.After(time.Second):
+			s.FailNow("Liveness of peer didn't change")
+		}
+
+		return false
+	}
+
+	// Only changes of the peer's liveness are reported.
+	responding.Store(false)
+	s.Require().False(receive())
+
+	responding.Store(true)
+	s.Require().True(receive())
+
+	cancel()
+	_, ok := <-changes
+	s.Require().False(ok)
+}
+
+func (s *sessionSuite) Test_NewJoinToken() {
+	token, err := NewJoinToken("10.0.0.1", "5b3e1a0c2d4f", 0)
+	s.Require().NoError(err)
+	s.Require().Equal("10.0.0.1", token.Address)
+	s.Require().Equal("5b3e1a0c2d4f", token.Fingerprint)
+	s.Require().Len(token.Secret, 64)
+	s.Require().WithinDuration(time.Now().Add(DefaultJoinTokenExpiry), token.ExpiresAt, time.Minute)
+
+	other, err := NewJoinToken("10.0.0.1", "5b3e1a0c2d4f", time.Hour)
+	s.Require().NoError(err)
+	s.Require().NotEqual(token.ID, other.ID)
+	s.Require().NotEqual(token.Secret, other.Secret)
+
+	_, err = NewJoinToken("10.0.0.1", "5b3e1a0c2d4f", MaxJoinTokenExpiry+time.Second)
+	s.Require().Error(err)
+}
+
+func (s *sessionSuite) Test_JoinTokenAttempts() {
+	attempts := JoinTokenAttempts{}
+	now := time.Now()
+	s.Require().NoError(attempts.checkSource("10.0.0.1", now))
+
+	s.Require().False(attempts.registerFailedAttempt("10.0.0.1", now))
+	s.Require().EqualError(attempts.checkSource("10.0.0.1", now), `Address "10.0.0.1" has to wait 1s before attempting to redeem a join token again`)
+	s.Require().NoError(attempts.checkSource("10.0.0.1", now.Add(FailedAttemptBackoff)))
+	s.Require().NoError(attempts.checkSource("10.0.0.2", now))
+
+	for i := 1; i < AllowedFailedSourceAttempts-1; i++ {
+		s.Require().False(attempts.registerFailedAttempt("10.0.0.1", now))
+	}
+
+	s.Require().True(attempts.registerFailedAttempt("10.0.0.1", now))
+	s.Require().EqualError(attempts.checkSource("10.0.0.1", now), `Address "10.0.0.1" is banned from redeeming join tokens until `+now.Add(FailedAttemptBan).Format(time.RFC3339))
+
+	// Sources are forgotten once they weren't blocked for a while.
+	later := now.Add(3 * FailedAttemptBan)
+	s.Require().False(attempts.registerFailedAttempt("10.0.0.2", later))
+	s.Require().Len(attempts.sources, 1)
+	s.Require().NoError(attempts.checkSource("10.0.0.1", later))
+}
+
+func (s *sessionSuite) Test_RecordEvent() {
+	session, err := NewSession(types.SessionInitiating, "foo bar baz qux", types.SessionAuth{}, nil)
+	s.Require().NoError(err)
+
+	var lock sync.Mutex
+	var recorded []types.SessionEvent
+	recordErr := errors.New("Database not open")
+	session.recorder = func(ctx context.Context, events []types.SessionEvent) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if recordErr != nil {
+			return recordErr
+		}
+
+		recorded = append(recorded, events...)
+		return nil
+	}
+
+	// waitRecorded waits until the given number of events got persisted in the background.
+	waitRecorded := func(n int) []types.SessionEvent {
+		s.Require().Eventually(func() bool {
+			lock.Lock()
+			defer lock.Unlock()
+
+			return len(recorded) == n
+		}, 5*time.Second, 10*time.Millisecond)
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		return append([]types.SessionEvent{}, recorded...)
+	}
+
+	// Events are kept until they can be persisted.
+	session.RecordEvent(types.SessionEventStarted, types.SessionIntent{}, "")
+	s.Require().Eventually(func() bool {
+		session.eventsLock.Lock()
+		defer session.eventsLock.Unlock()
+
+		return !session.flushing && len(session.events) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	recordErr = nil
+	lock.Unlock()
+
+	session.RecordEvent(types.SessionEventIntentReceived, types.SessionIntent{Name: "foo", Address: "10.0.0.2", Fingerprint: "abcdef"}, "")
+	events := waitRecorded(2)
+	s.Require().Equal(types.SessionEventStarted, events[0].Type)
+	s.Require().Equal(types.SessionEventIntentReceived, events[1].Type)
+	s.Require().Equal(session.ID(), events[1].SessionID)
+	s.Require().Equal(types.SessionInitiating, events[1].Role)
+	s.Require().Equal("foo", events[1].Name)
+	s.Require().Equal("abcdef", events[1].Fingerprint)
+	s.Require().False(events[1].CreatedAt.IsZero())
+
+	// Only the ban of a source address is recorded and not its individual failed attempts.
+	for i := 0; i < AllowedFailedSourceAttempts; i++ {
+		err = session.RegisterFailedAttempt("10.0.0.3")
+		s.Require().NoError(err)
+	}
+
+	events = waitRecorded(3)
+	s.Require().Equal(types.SessionEventAddressBanned, events[2].Type)
+	s.Require().Equal("10.0.0.3", events[2].Address)
+
+	session.RecordStop(errors.New("Aborted"))
+	events = waitRecorded(4)
+	s.Require().Equal(types.SessionEventStopped, events[3].Type)
+	s.Require().Equal("Aborted", events[3].Details)
+
+	// Events recorded after the session stopped are ignored.
+	err = session.Stop(nil)
+	s.Require().NoError(err)
+
+	session.RecordStop(nil)
+	s.Require().Len(waitRecorded(4), 4)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"sort"
 
 	"github.com/canonical/lxd/shared/api"
 
@@ -88,7 +89,7 @@ func (sh *Handler) CollectSystemInformation(ctx context.Context, connectInfo mul
 	}
 
 	var allResources *api.Resources
-	lxd := sh.Services[types.LXD].(*LXDService)
+	lxd := sh.Services()[types.LXD].(*LXDService)
 	if localSystem {
 		allResources, err = lxd.GetResources(ctx, s.ClusterName, "", nil)
 	} else {
@@ -158,7 +159,7 @@ func (sh *Handler) CollectSystemInformation(ctx context.Context, connectInfo mul
 	}
 
 	if len(s.ExistingServices[types.MicroCeph]) > 0 {
-		microceph := sh.Services[types.MicroCeph].(*CephService)
+		microceph := sh.Services()[types.MicroCeph].(*CephService)
 
 		if localSystem {
 			s.CephConfig, err = microceph.ClusterConfig(ctx, "", nil)
@@ -184,6 +185,51 @@ func (sh *Handler) CollectSystemInformation(ctx context.Context, connectInfo mul
 	return s, nil
 }
 
+// Capabilities returns a compact summary of the local system's resources.
+// Only disks without any partitions are counted.
+func (sh *Handler) Capabilities(ctx context.Context) (*types.Capabilities, error) {
+	lxd := sh.Services()[types.LXD].(*LXDService)
+	resources, err := lxd.GetResources(ctx, sh.Name, "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get system resources: %w", err)
+	}
+
+	uplinkInterfaces, _, _, err := lxd.GetNetworkInterfaces(ctx, sh.Name, "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get network interfaces: %w", err)
+	}
+
+	capabilities := &types.Capabilities{
+		UplinkInterfaces: make([]string, 0, len(uplinkInterfaces)),
+		CPUs:             resources.CPU.Total,
+		Memory:           resources.Memory.Total,
+		Services:         make(map[types.ServiceType]string, len(sh.Services())),
+	}
+
+	for _, disk := range resources.Storage.Disks {
+		if len(disk.Partitions) == 0 {
+			capabilities.Disks++
+		}
+	}
+
+	for name := range uplinkInterfaces {
+		capabilities.UplinkInterfaces = append(capabilities.UplinkInterfaces, name)
+	}
+
+	sort.Strings(capabilities.UplinkInterfaces)
+
+	for serviceType, service := range sh.Services() {
+		version, err := service.GetVersion(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get version of %s: %w", serviceType, err)
+		}
+
+		capabilities.Services[serviceType] = version
+	}
+
+	return capabilities, nil
+}
+
 // GetExistingClusters checks against the services reachable by the specified ServerInfo,
 // and returns a map of cluster members for each service supported by the Handler.
 // If a service is not clustered, its map will be nil.
@@ -191,12 +237,12 @@ func (sh *Handler) GetExistingClusters(ctx context.Context, connectInfo multicas
 	localSystem := sh.Name == connectInfo.Name
 	var err error
 	existingServices := map[types.ServiceType]map[string]string{}
-	for service := range sh.Services {
+	for service := range sh.Services() {
 		var existingCluster map[string]string
 		if localSystem {
-			existingCluster, err = sh.Services[service].ClusterMembers(ctx)
+			existingCluster, err = sh.Services()[service].ClusterMembers(ctx)
 		} else {
-			existingCluster, err = sh.Services[service].RemoteClusterMembers(ctx, connectInfo.Certificate, connectInfo.Address)
+			existingCluster, err = sh.Services()[service].RemoteClusterMembers(ctx, connectInfo.Certificate, connectInfo.Address)
 		}
 
 		if err != nil && !api.StatusErrorCheck(err, http.StatusServiceUnavailable) {
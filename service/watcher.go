@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/canonical/microcloud/microcloud/api/types"
+)
+
+// WatchServices adds and removes the optional services of the handler whenever their state directory appears or disappears.
+// Instead of polling, the nearest existing directory on the path to each service's socket is watched using inotify.
+// The services are synced once before watching, and it blocks until the context is cancelled.
+func (s *Handler) WatchServices(ctx context.Context) error {
+	// Watchers signal changes using a buffered channel so that changes happening during a sync are coalesced into the next one.
+	changed := make(chan struct{}, 1)
+	watchers := map[string]*dirWatcher{}
+	defer func() {
+		for _, watcher := range watchers {
+			_ = watcher.watcher.Close()
+		}
+	}()
+
+	s.syncServices(watchers, changed)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+			s.syncServices(watchers, changed)
+		}
+	}
+}
+
+// dirWatcher watches a single directory.
+// Each directory gets its own watcher which is closed instead of reused once the directory is removed,
+// as fsnotify can lose a new watch of a path whose previous watch is still being removed.
+type dirWatcher struct {
+	watcher *fsnotify.Watcher
+	info    os.FileInfo
+}
+
+// watchDirectory returns a watcher of the given directory which signals each of its events on the changed channel.
+func watchDirectory(dir string, changed chan<- struct{}) (*dirWatcher, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create file watcher: %w", err)
+	}
+
+	err = watcher.Add(dir)
+	if err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				// Events may have been dropped, so the services are checked again.
+				logger.Warn("Failed to watch service state directory", logger.Ctx{"path": dir, "error": err})
+			}
+
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return &dirWatcher{watcher: watcher, info: info}, nil
+}
+
+// syncServices watches the nearest existing directory of each optional service's socket,
+// and then adds the installed services which the handler doesn't have yet and removes those which aren't installed anymore.
+// Watchers of directories which got removed or which aren't the nearest existing ones anymore are closed.
+// The watches are added before checking the services so that changes in between aren't missed.
+func (s *Handler) syncServices(watchers map[string]*dirWatcher, changed chan<- struct{}) {
+	failed := map[string]bool{}
+	for {
+		dirs := map[string]types.ServiceType{}
+		for _, def := range OptionalServices() {
+			dir := watchDir(def.StateDir)
+			if dir != "" && !failed[dir] {
+				dirs[dir] = def.Type
+			}
+		}
+
+		for dir, watcher := range watchers {
+			_, ok := dirs[dir]
+			if ok {
+				// A directory which got replaced is watched again.
+				info, err := os.Stat(dir)
+				ok = err == nil && os.SameFile(info, watcher.info)
+			}
+
+			if !ok {
+				_ = watcher.watcher.Close()
+				delete(watchers, dir)
+			}
+		}
+
+		// Directories created or removed while adding the watches don't cause any events,
+		// so the nearest existing directories are looked up again until all of them are watched.
+		missing := false
+		for dir, serviceType := range dirs {
+			_, ok := watchers[dir]
+			if ok {
+				continue
+			}
+
+			missing = true
+			watcher, err := watchDirectory(dir, changed)
+			if err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					logger.Warn("Failed to watch service state directory", logger.Ctx{"service": serviceType, "path": dir, "error": err})
+					failed[dir] = true
+				}
+
+				continue
+			}
+
+			watchers[dir] = watcher
+		}
+
+		if !missing {
+			break
+		}
+	}
+
+	services := s.Services()
+	for _, def := range OptionalServices() {
+		_, exists := services[def.Type]
+		if def.Installed() && !exists {
+			err := s.AddService(def.Type)
+			if err != nil {
+				logger.Error("Failed to add service", logger.Ctx{"service": def.Type, "error": err})
+			}
+		} else if !def.Installed() && exists {
+			s.RemoveService(def.Type)
+		}
+	}
+}
+
+// watchDir returns the given directory if it exists, or otherwise its nearest existing parent directory.
+// It's empty if the directory is empty.
+func watchDir(dir string) string {
+	if dir == "" {
+		return ""
+	}
+
+	for {
+		info, err := os.Stat(dir)
+		if err == nil && info.IsDir() {
+			return dir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+
+		dir = parent
+	}
+}
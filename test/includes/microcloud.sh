@@ -224,6 +224,8 @@ capture_and_join() {
 $([ -n "${LOOKUP_IFACE}" ] && printf "select") # select the interface
 $([ -n "${LOOKUP_IFACE}" ] && printf -- "---")
 ${passphrase}                                  # the captured passphrase
+select                                         # select the first eligible system
+---
 $(true)                                        # workaround for set -e
 "
 